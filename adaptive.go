@@ -0,0 +1,70 @@
+package extcompress
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Bounds on the levels adaptive compression moves between. Zero leaves a
+// bound at the tool's default.
+type AdaptiveBounds struct {
+	Min int
+	Max int
+}
+
+// Returns a copy of the handler whose stream compression lets the tool adjust
+// its level to how fast the output is consumed, for tools with an AdaptFlag.
+// It can't be combined with WithLevel.
+func (c Filter) WithStreamingAdaptive(bounds AdaptiveBounds) ExternalHandler {
+	return c.WithOptions(Options{StreamingAdaptive: &bounds})
+}
+
+// True if stream compression runs in adaptive mode.
+func (c Filter) adaptive() bool {
+	return c.opts.StreamingAdaptive != nil && c.AdaptFlag != ""
+}
+
+// Returns the flag turning on adaptive mode with the options' bounds, or nil
+// if it isn't asked for.
+func (c Filter) adaptArgs() []string {
+	if !c.adaptive() {
+		return nil
+	}
+	b := *c.opts.StreamingAdaptive
+	var bounds []string
+	if b.Min != 0 {
+		bounds = append(bounds, fmt.Sprintf("min=%d", b.Min))
+	}
+	if b.Max != 0 {
+		bounds = append(bounds, fmt.Sprintf("max=%d", b.Max))
+	}
+	if len(bounds) == 0 {
+		return []string{c.AdaptFlag}
+	}
+	return []string{c.AdaptFlag + "=" + strings.Join(bounds, ",")}
+}
+
+// Checks the adaptive options suit the filter.
+func (c Filter) validateAdaptive(o Options) error {
+	if o.StreamingAdaptive == nil {
+		return nil
+	}
+	if c.AdaptFlag == "" {
+		return InvalidOption{c.Command, "adaptive", "adaptive compression is not supported"}
+	}
+	if o.Level != nil {
+		return InvalidOption{c.Command, "adaptive", "can't be combined with a compression level"}
+	}
+	b := *o.StreamingAdaptive
+	for _, bound := range []int{b.Min, b.Max} {
+		if bound != 0 && (bound < c.MinLevel || bound > c.MaxLevel) {
+			return InvalidOption{c.Command, "adaptive",
+				fmt.Sprintf("bound %d is outside the range %d-%d", bound, c.MinLevel, c.MaxLevel)}
+		}
+	}
+	if b.Min != 0 && b.Max != 0 && b.Min > b.Max {
+		return InvalidOption{c.Command, "adaptive",
+			fmt.Sprintf("minimum %d is above the maximum %d", b.Min, b.Max)}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package extcompress
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamingAdaptivePlan(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/zstd")
+	assert.Nil(t, err)
+	assert.True(t, h.Capabilities().Adaptive)
+
+	plan := h.WithStreamingAdaptive(AdaptiveBounds{}).Plan()
+	assert.Nil(t, plan.Err)
+	assert.True(t, plan.Adaptive)
+	assert.Equal(t, []string{"zstd", "-q", "-c", "--adapt"}, plan.CompressStream)
+	// Only stream compression is affected
+	for _, op := range Operations {
+		if op != OpCompressStream {
+			assert.NotContains(t, plan.Argv(op), "--adapt", op.String())
+		}
+	}
+
+	plan = h.WithStreamingAdaptive(AdaptiveBounds{Min: 3, Max: 12}).Plan()
+	assert.Equal(t, []string{"zstd", "-q", "-c", "--adapt=min=3,max=12"}, plan.CompressStream)
+	plan = h.WithStreamingAdaptive(AdaptiveBounds{Max: 6}).Plan()
+	assert.Equal(t, []string{"zstd", "-q", "-c", "--adapt=max=6"}, plan.CompressStream)
+
+	assert.False(t, h.Plan().Adaptive)
+}
+
+func TestStreamingAdaptiveInvalid(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/zstd")
+	assert.Nil(t, err)
+
+	plan := h.WithOptions(Options{Level: Int(3), StreamingAdaptive: &AdaptiveBounds{}}).Plan()
+	assert.IsType(t, InvalidOption{}, plan.Err)
+	assert.Contains(t, plan.Err.Error(), "compression level")
+
+	// Set one after the other, the later wins
+	plan = h.WithLevel(3).WithStreamingAdaptive(AdaptiveBounds{}).Plan()
+	assert.Nil(t, plan.Err)
+	assert.Equal(t, []string{"zstd", "-q", "-c", "--adapt"}, plan.CompressStream)
+	plan = h.WithStreamingAdaptive(AdaptiveBounds{}).WithLevel(3).Plan()
+	assert.Nil(t, plan.Err)
+	assert.Equal(t, []string{"zstd", "-q", "-c", "-3"}, plan.CompressStream)
+
+	assert.IsType(t, InvalidOption{}, h.WithStreamingAdaptive(AdaptiveBounds{Min: 0, Max: 99}).Plan().Err)
+	assert.IsType(t, InvalidOption{}, h.WithStreamingAdaptive(AdaptiveBounds{Min: 9, Max: 3}).Plan().Err)
+
+	gz, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	assert.False(t, gz.Capabilities().Adaptive)
+	assert.IsType(t, InvalidOption{}, gz.WithStreamingAdaptive(AdaptiveBounds{}).Plan().Err)
+}
+
+func TestStreamingAdaptiveRoundTrip(t *testing.T) {
+	if _, err := exec.LookPath("zstd"); err != nil {
+		t.Skip("zstd not available")
+	}
+	h, err := GetExternalHandlerFromMimeType("application/zstd")
+	assert.Nil(t, err)
+	h = h.WithStreamingAdaptive(AdaptiveBounds{Min: 1, Max: 9})
+
+	original := seekableTestData(1 << 20)
+	proc, err := h.CompressStream(bytes.NewReader(original))
+	assert.Nil(t, err)
+	compressed, err := ioutil.ReadAll(proc)
+	assert.Nil(t, err)
+	assert.Nil(t, proc.Close())
+
+	proc, err = h.DecompressStream(bytes.NewReader(compressed))
+	assert.Nil(t, err)
+	decompressed, err := ioutil.ReadAll(proc)
+	assert.Nil(t, err)
+	assert.Nil(t, proc.Close())
+	assert.Equal(t, original, decompressed)
+}
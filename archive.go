@@ -0,0 +1,89 @@
+package extcompress
+
+import (
+	"context"
+	"io"
+)
+
+// Number of decompressed bytes inspected when probing the inner content of a
+// compressed file. Enough for tar headers and most other magic numbers.
+const innerProbeSize = 64 * 1024
+
+// The result of detecting both the compression and the content of a file.
+type ArchiveInfo struct {
+	// Mimetype of the file as it is on disk. Empty if it isn't compressed.
+	CompressionMimeType string
+	// Mimetype of the content once decompressed, e.g. application/x-tar
+	InnerMimeType string
+	// Handler which decompresses the file, nil if it isn't compressed.
+	Handler ExternalHandler
+}
+
+// Detects the compression of filePath and the type of the content inside it.
+// The content is identified by decompressing only a bounded prefix, after
+// which the decompressor is killed. Files which are not compressed (or of a
+// type with no handler) have only InnerMimeType set.
+func DetectArchive(filePath string) (ArchiveInfo, error) {
+	mimetype, _, err := queryMimeType(context.Background(), mimeQuery{filePath: filePath})
+	if err != nil {
+		return ArchiveInfo{}, err
+	}
+
+	h, err := GetExternalHandlerFromMimeType(mimetype)
+	if _, unknown := err.(UnknownFileType); unknown || (err == nil && h.Capabilities().Passthrough) {
+		return ArchiveInfo{InnerMimeType: mimetype}, nil
+	}
+	if err != nil {
+		return ArchiveInfo{}, err
+	}
+
+	prefix, err := decompressPrefix(h, filePath, innerProbeSize)
+	if err != nil {
+		return ArchiveInfo{}, err
+	}
+
+	inner, err := GetBufferMimeType(prefix)
+	if err != nil {
+		return ArchiveInfo{}, err
+	}
+
+	return ArchiveInfo{
+		CompressionMimeType: mimetype,
+		InnerMimeType:       inner,
+		Handler:             h,
+	}, nil
+}
+
+// Decompresses at most n bytes from the start of filePath, then kills the
+// decompressor rather than letting it run on through the file.
+func decompressPrefix(h ExternalHandler, filePath string, n int64) ([]byte, error) {
+	proc, err := h.Decompress(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, n)
+	read, err := io.ReadFull(proc, buf)
+
+	if job, ok := proc.(*CompressionJob); ok {
+		job.kill()
+	} else {
+		proc.Close()
+	}
+
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return buf[:read], nil
+}
+
+// Returns the mimetype of the content of filePath once decompressed, e.g. to
+// tell whether a .gz holds JSON or protobuf. Only a bounded prefix is
+// decompressed. For uncompressed files this is just the file's own type.
+func DetectInnerMimeType(filePath string) (string, error) {
+	info, err := DetectArchive(filePath)
+	if err != nil {
+		return "", err
+	}
+	return info.InnerMimeType, nil
+}
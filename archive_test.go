@@ -0,0 +1,125 @@
+package extcompress
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Writes a small tarball to filePath.
+func writeTestTar(t *testing.T, filePath string) {
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	content := seekableTestData(200000)
+	assert.Nil(t, tw.WriteHeader(&tar.Header{
+		Name: "payload.txt",
+		Mode: 0644,
+		Size: int64(len(content)),
+	}))
+	_, err := tw.Write(content)
+	assert.Nil(t, err)
+	assert.Nil(t, tw.Close())
+	assert.Nil(t, ioutil.WriteFile(filePath, b.Bytes(), os.FileMode(0644)))
+}
+
+func TestDetectArchiveTarGz(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	filename := path.Join(tmpdir, "archive.tar")
+	writeTestTar(t, filename)
+	gz, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	assert.Nil(t, gz.CompressFileInPlace(filename))
+
+	info, err := DetectArchive(filename + ".gz")
+	assert.Nil(t, err)
+	assert.Equal(t, "gzip", mimeMap[info.CompressionMimeType])
+	assert.Equal(t, "application/x-tar", info.InnerMimeType)
+	assert.NotNil(t, info.Handler)
+}
+
+func TestDetectArchivePlainGz(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	filename := path.Join(tmpdir, "pipechaining")
+	gz, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	assert.Nil(t, gz.CompressFileInPlace(filename))
+
+	info, err := DetectArchive(filename + ".gz")
+	assert.Nil(t, err)
+	assert.Equal(t, "gzip", mimeMap[info.CompressionMimeType])
+	assert.Equal(t, "text/plain", info.InnerMimeType)
+}
+
+func TestDetectArchivePlainTar(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	filename := path.Join(tmpdir, "archive.tar")
+	writeTestTar(t, filename)
+
+	info, err := DetectArchive(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, "", info.CompressionMimeType)
+	assert.Equal(t, "application/x-tar", info.InnerMimeType)
+	assert.Nil(t, info.Handler)
+}
+
+func TestDetectInnerMimeType(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	gz, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	// libmagic only recognises JSON it can parse completely, so this one has
+	// to fit within the probe. The tar and binary fixtures are larger and get
+	// cut off.
+	var js bytes.Buffer
+	js.WriteString(`{"records": [`)
+	for i := 0; i < 100; i++ {
+		if i > 0 {
+			js.WriteString(",")
+		}
+		fmt.Fprintf(&js, `{"id": %d, "name": "record %d"}`, i, i)
+	}
+	js.WriteString("]}\n")
+
+	binary := make([]byte, 100000)
+	rand.New(rand.NewSource(1)).Read(binary)
+
+	tarball := path.Join(tmpdir, "archive.tar")
+	writeTestTar(t, tarball)
+	tarData, err := ioutil.ReadFile(tarball)
+	assert.Nil(t, err)
+
+	fixtures := []struct {
+		name     string
+		content  []byte
+		expected string
+	}{
+		{"data.json", js.Bytes(), "application/json"},
+		{"data.tar", tarData, "application/x-tar"},
+		{"data.bin", binary, "application/octet-stream"},
+	}
+
+	for _, f := range fixtures {
+		filename := path.Join(tmpdir, f.name)
+		assert.Nil(t, ioutil.WriteFile(filename, f.content, os.FileMode(0644)))
+		assert.Nil(t, gz.CompressFileInPlace(filename))
+
+		inner, err := DetectInnerMimeType(filename + ".gz")
+		assert.Nil(t, err)
+		assert.Equal(t, f.expected, inner, f.name)
+	}
+}
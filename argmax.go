@@ -0,0 +1,95 @@
+package extcompress
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Matched by the error from an operation whose command line and environment
+// would be too large to start the tool with, even once files are passed on
+// stdin or split across runs.
+var ErrArgvTooLong = errors.New("extcompress: arguments and environment too long")
+
+// The smallest ARG_MAX Linux allows, assumed where the system's can't be
+// found.
+const defaultArgMax = 128 * 1024
+
+// Describes a command line too large to run. Wraps ErrArgvTooLong.
+type ArgvTooLongError struct {
+	Command string
+	// Bytes the arguments and environment would take, and the most allowed.
+	// For a single argument too long by itself, its size and the limit on
+	// one argument.
+	Size  int
+	Limit int
+	// The largest argument, which is the likeliest to need trimming, or the
+	// name of the environment variable too long to pass
+	Largest string
+}
+
+func (r ArgvTooLongError) Error() string {
+	largest := r.Largest
+	if len(largest) > 64 {
+		largest = largest[:64] + "..."
+	}
+	return fmt.Sprintf("%s: %s: %d bytes, over the limit of %d, largest argument %q",
+		r.Command, ErrArgvTooLong.Error(), r.Size, r.Limit, largest)
+}
+
+func (r ArgvTooLongError) Unwrap() error {
+	return ErrArgvTooLong
+}
+
+// Returns the bytes running the tool with args takes against ARG_MAX,
+// counting the command and the environment it gets.
+func (c Filter) argvSize(args []string) int {
+	size := argSize(c.Command)
+	for _, arg := range args {
+		size += argSize(arg)
+	}
+	for _, kv := range c.childEnv() {
+		size += argSize(kv)
+	}
+	return size
+}
+
+// True if arg is too long to pass to a program by itself, whatever else it
+// is passed with.
+func argTooLong(arg string) bool {
+	return maxArgStrlen > 0 && len(arg)+1 > maxArgStrlen
+}
+
+// Checks the tool can be started with args.
+func (c Filter) checkArgv(args []string) error {
+	for _, arg := range append([]string{c.Command}, args...) {
+		if argTooLong(arg) {
+			return ArgvTooLongError{c.Command, len(arg) + 1, maxArgStrlen, arg}
+		}
+	}
+	for _, kv := range c.childEnv() {
+		if argTooLong(kv) {
+			// Only named, as its value may be a secret
+			name := strings.SplitN(kv, "=", 2)[0]
+			return ArgvTooLongError{c.Command, len(kv) + 1, maxArgStrlen, "$" + name}
+		}
+	}
+	size := c.argvSize(args)
+	if size <= batchArgMax {
+		return nil
+	}
+	var largest string
+	for _, arg := range args {
+		if len(arg) > len(largest) {
+			largest = arg
+		}
+	}
+	return ArgvTooLongError{c.Command, size, batchArgMax, largest}
+}
+
+// True if passing filePath to the tool for op would make its command line
+// too long, so the file should be streamed to it instead.
+func (c Filter) pathTooLong(op Operation, filePath string) bool {
+	args, err := c.buildArgs(op.Compresses(), c.flags(op), argPath(filePath))
+	return err == nil && c.checkArgv(args) != nil
+}
@@ -0,0 +1,27 @@
+package extcompress
+
+import (
+	"os"
+	"syscall"
+)
+
+// Longest argument or environment string Linux passes to a program, counting
+// its NUL terminator: MAX_ARG_STRLEN, 32 pages.
+var maxArgStrlen = 32 * os.Getpagesize()
+
+// Returns the bytes of arguments and environment Linux allows a program: a
+// quarter of the stack limit, between 128KiB and three quarters of 8MiB.
+func systemArgMax() int {
+	var rlim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_STACK, &rlim); err != nil {
+		return defaultArgMax
+	}
+	limit := uint64(6 << 20)
+	if rlim.Cur/4 < limit {
+		limit = rlim.Cur / 4
+	}
+	if limit < defaultArgMax {
+		return defaultArgMax
+	}
+	return int(limit)
+}
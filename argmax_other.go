@@ -0,0 +1,12 @@
+//go:build !linux
+
+package extcompress
+
+// No limit on single arguments is known beyond the overall one.
+var maxArgStrlen = 0
+
+// ARG_MAX can only be asked for through cgo elsewhere, so the smallest
+// value in common use is assumed.
+func systemArgMax() int {
+	return defaultArgMax
+}
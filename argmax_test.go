@@ -0,0 +1,154 @@
+package extcompress
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns the gzip handler with the argument limit set so that its stream
+// command line fits, with spare bytes to go.
+func gzipWithArgRoom(t *testing.T, spare int) Filter {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	f := h.(Filter)
+	args, err := f.buildArgs(false, f.DecompressStreamFlags)
+	assert.Nil(t, err)
+	setBatchArgMax(t, f.argvSize(args)+spare)
+	return f
+}
+
+func TestArgvTooLongStream(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	huge := strings.Repeat("x", batchArgMax)
+	_, err = h.WithOptions(Options{Args: []string{"-n", huge}}).CompressStream(bytes.NewReader([]byte(data)))
+	assert.True(t, errors.Is(err, ErrArgvTooLong))
+	var argvErr ArgvTooLongError
+	assert.True(t, errors.As(err, &argvErr))
+	assert.Equal(t, "gzip", argvErr.Command)
+	assert.Equal(t, huge, argvErr.Largest)
+	assert.True(t, argvErr.Size > argvErr.Limit)
+	assert.True(t, len(err.Error()) < 300)
+
+	// The environment counts too
+	f := gzipWithArgRoom(t, 100)
+	t.Setenv("EXTCOMPRESS_TEST_PADDING", strings.Repeat("y", 200))
+	_, err = f.DecompressStream(bytes.NewReader(nil))
+	assert.True(t, errors.Is(err, ErrArgvTooLong))
+}
+
+func TestArgvTooLongFileStreams(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	compressed := writeCompressed(t, tmpdir, "gzip", []byte(data))
+	// Room for the flags but not the path
+	f := gzipWithArgRoom(t, 8)
+
+	job, err := f.Decompress(compressed)
+	assert.Nil(t, err)
+	out, err := ioutil.ReadAll(job)
+	assert.Nil(t, err)
+	assert.Zero(t, job.Result())
+	assert.Equal(t, data, string(out))
+	assert.NotContains(t, job.(*CompressionJob).cmd.Args, compressed)
+
+	// In place, the package streams the file and replaces it
+	filename := path.Join(tmpdir, "input.log")
+	assert.Nil(t, ioutil.WriteFile(filename, []byte(data), os.FileMode(0644)))
+	assert.Nil(t, f.CompressFileInPlace(filename))
+	_, err = os.Stat(filename)
+	assert.True(t, os.IsNotExist(err))
+	assert.Nil(t, f.DecompressFileInPlace(filename+".gz"))
+	restored, err := ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, data, string(restored))
+}
+
+func TestArgvTooLongBatch(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	short := path.Join(tmpdir, "a")
+	long := path.Join(tmpdir, strings.Repeat("b", 200))
+	for _, p := range []string{short, long} {
+		assert.Nil(t, ioutil.WriteFile(p, []byte(data), os.FileMode(0644)))
+	}
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	f := h.(Filter)
+	args, err := f.buildArgs(true, f.CompressInPlaceFlags)
+	assert.Nil(t, err)
+	fixed := f.argvSize(args)
+
+	// The short path is batched, the long one streamed by itself
+	setBatchArgMax(t, fixed+argSize(short))
+	results, err := f.CompressFilesInPlaceResult([]string{short, long}, InPlaceOptions{})
+	assert.Nil(t, err)
+	for i, p := range []string{short, long} {
+		assert.Nil(t, results[i].Err)
+		assert.Equal(t, p+".gz", results[i].ResultPath)
+		_, err = os.Stat(p + ".gz")
+		assert.Nil(t, err)
+	}
+
+	// Nothing can run if the fixed arguments alone are too long
+	assert.Nil(t, ioutil.WriteFile(short, []byte(data), os.FileMode(0644)))
+	setBatchArgMax(t, fixed-1)
+	results, err = f.CompressFilesInPlaceResult([]string{short}, InPlaceOptions{})
+	assert.Nil(t, results)
+	assert.True(t, errors.Is(err, ErrArgvTooLong))
+}
+
+func TestArgvSystemLimit(t *testing.T) {
+	assert.True(t, systemArgMax() >= defaultArgMax)
+	if systemArgMax() < 512*1024 {
+		t.Skip("the system's limit is too small for a large environment")
+	}
+
+	// An environment over the smallest ARG_MAX doesn't stop tools running
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	t.Setenv("EXTCOMPRESS_TEST_PADDING_A", strings.Repeat("y", 75*1024))
+	t.Setenv("EXTCOMPRESS_TEST_PADDING_B", strings.Repeat("y", 75*1024))
+	compressed := compressBytes(t, h, []byte(data))
+	proc, err := h.DecompressStream(bytes.NewReader(compressed))
+	assert.Nil(t, err)
+	out, err := ioutil.ReadAll(proc)
+	assert.Nil(t, err)
+	assert.Zero(t, proc.Result())
+	assert.Equal(t, data, string(out))
+}
+
+func TestArgvSingleArgumentLimit(t *testing.T) {
+	if maxArgStrlen == 0 {
+		t.Skip("no limit on single arguments here")
+	}
+	setBatchArgMax(t, 4*maxArgStrlen)
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	// Within the overall limit, but too long for one argument
+	huge := strings.Repeat("x", maxArgStrlen)
+	_, err = h.WithOptions(Options{Args: []string{"-n", huge}}).CompressStream(bytes.NewReader([]byte(data)))
+	assert.Equal(t, ArgvTooLongError{"gzip", maxArgStrlen + 1, maxArgStrlen, huge}, err)
+
+	// Environment variables are named, not shown
+	t.Setenv("EXTCOMPRESS_TEST_PADDING", huge)
+	_, err = h.CompressStream(bytes.NewReader([]byte(data)))
+	assert.Equal(t, ArgvTooLongError{"gzip", len("EXTCOMPRESS_TEST_PADDING=") + maxArgStrlen + 1, maxArgStrlen,
+		"$EXTCOMPRESS_TEST_PADDING"}, err)
+}
+
+func TestArgvTooLongMessage(t *testing.T) {
+	err := ArgvTooLongError{"gzip", 150000, 131072, "-n"}
+	assert.Equal(t, `gzip: extcompress: arguments and environment too long: 150000 bytes, over the limit of 131072, largest argument "-n"`,
+		err.Error())
+}
@@ -0,0 +1,142 @@
+package extcompress
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"unicode"
+)
+
+// Matched by the error from an operation or registration given an argument
+// or environment entry which can't be passed to a tool.
+var ErrInvalidArgument = errors.New("extcompress: invalid argument")
+
+// Describes a string which can't be passed to a tool. Wraps
+// ErrInvalidArgument.
+type InvalidArgumentError struct {
+	Command string
+	// Which string it is, e.g. "argv[2]", "env TMPDIR" or "CompressFlags[0]"
+	Element string
+	Value   string
+	Reason  string
+}
+
+func (r InvalidArgumentError) Error() string {
+	return fmt.Sprintf("%s: invalid %s %q: %s", printable(r.Command), r.Element, r.Value, r.Reason)
+}
+
+func (r InvalidArgumentError) Unwrap() error {
+	return ErrInvalidArgument
+}
+
+// Returns why s can't be passed to a tool at all, or "".
+func execReason(s string) string {
+	if strings.IndexByte(s, 0) >= 0 {
+		return "contains a NUL byte"
+	}
+	return ""
+}
+
+// Returns why s is unacceptable as a flag or other configured value, or "".
+// File paths may hold any byte but NUL, and scripts given to sh -c need tabs
+// and newlines, but configuration holding other control characters is more
+// likely corrupt than meant.
+func configReason(s string) string {
+	return controlReason(s, "\t\n")
+}
+
+// Returns why s is unacceptable as a file name suffix, or "".
+func suffixReason(s string) string {
+	return controlReason(s, "")
+}
+
+// Returns why s can't be passed, or holds control characters other than
+// those in allowed, or "".
+func controlReason(s string, allowed string) string {
+	if reason := execReason(s); reason != "" {
+		return reason
+	}
+	for _, r := range s {
+		if unicode.IsControl(r) && !strings.ContainsRune(allowed, r) {
+			return "contains control characters"
+		}
+	}
+	return ""
+}
+
+// Checks everything cmd passes to the tool can be passed, so a bad value is
+// named rather than failing exec with EINVAL.
+func (c Filter) checkExec(cmd *exec.Cmd) error {
+	for i, arg := range cmd.Args {
+		if reason := execReason(arg); reason != "" {
+			return InvalidArgumentError{c.Command, fmt.Sprintf("argv[%d]", i), arg, reason}
+		}
+	}
+	for _, kv := range cmd.Env {
+		if reason := execReason(kv); reason != "" {
+			name := kv
+			if i := strings.IndexByte(kv, '='); i >= 0 {
+				name = kv[:i]
+			}
+			return InvalidArgumentError{c.Command, "env " + printable(name), kv, reason}
+		}
+	}
+	return nil
+}
+
+// Checks the command and flags the filter is configured with.
+func (c Filter) checkConfig() error {
+	single := []struct {
+		field string
+		value string
+	}{
+		{"Command", c.Command},
+		{"SuffixFlag", c.SuffixFlag},
+		{"RestoreNameFlag", c.RestoreNameFlag},
+		{"IgnoreNameFlag", c.IgnoreNameFlag},
+		{"LevelFlag", c.LevelFlag},
+		{"ThreadsFlag", c.ThreadsFlag},
+		{"AdaptFlag", c.AdaptFlag},
+	}
+	for _, s := range single {
+		if reason := configReason(s.value); reason != "" {
+			return InvalidArgumentError{c.Command, s.field, s.value, reason}
+		}
+	}
+	if reason := suffixReason(c.Suffix); reason != "" {
+		return InvalidArgumentError{c.Command, "Suffix", c.Suffix, reason}
+	}
+	lists := []struct {
+		field  string
+		values []string
+	}{
+		{"CompressFlags", c.CompressFlags},
+		{"DecompressFlags", c.DecompressFlags},
+		{"CompressStreamFlags", c.CompressStreamFlags},
+		{"DecompressStreamFlags", c.DecompressStreamFlags},
+		{"CompressInPlaceFlags", c.CompressInPlaceFlags},
+		{"DecompressInPlaceFlags", c.DecompressInPlaceFlags},
+		{"HermeticFlags", c.HermeticFlags},
+		{"VersionFlags", c.VersionFlags},
+		{"VerboseFlags", c.VerboseFlags},
+		{"ScrubEnv", c.ScrubEnv},
+		{"TempDirEnv", c.TempDirEnv},
+	}
+	for _, l := range lists {
+		if err := c.checkConfigList(l.field, l.values); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Checks configured values, naming the one at fault by field and index.
+func (c Filter) checkConfigList(field string, values []string) error {
+	for i, value := range values {
+		if reason := configReason(value); reason != "" {
+			return InvalidArgumentError{c.Command, fmt.Sprintf("%s[%d]", field, i), value, reason}
+		}
+	}
+	return nil
+}
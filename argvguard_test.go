@@ -0,0 +1,76 @@
+package extcompress
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInvalidArgumentFilterConfig(t *testing.T) {
+	// Through NewFilter, as a config loader would build one
+	h := NewFilter("gzip", CompressFlags("-c", "-1\x00-9"))
+	err := h.Plan().Err
+	assert.True(t, errors.Is(err, ErrInvalidArgument))
+	assert.Equal(t, InvalidArgumentError{"gzip", "CompressFlags[1]", "-1\x00-9", "contains a NUL byte"}, err)
+	_, err = h.CompressStream(ioutil.NopCloser(nil))
+	assert.True(t, errors.Is(err, ErrInvalidArgument))
+
+	h = NewFilter("gzip", Suffix(".gz\n", "-S"))
+	assert.Equal(t, InvalidArgumentError{"gzip", "Suffix", ".gz\n", "contains control characters"}, h.Plan().Err)
+
+	// Registration rejects it outright
+	unregisterFilter(t, "hostile")
+	err = RegisterFilter("hostile", Filter{Command: "gzip", DecompressFlags: []string{"-d", "-c\r"}}, "application/x-hostile")
+	assert.Equal(t, InvalidArgumentError{"gzip", "DecompressFlags[1]", "-c\r", "contains control characters"}, err)
+	assert.Equal(t, "", lookupHandlerName("application/x-hostile"))
+
+	// Scripts for sh -c keep their newlines
+	assert.Nil(t, NewFilter("sh", CompressFlags("-c", "cat\n\tcat")).Plan().Err)
+
+	// The rendered error escapes what it quotes
+	err = InvalidArgumentError{"gz\nip", "argv[1]", "\x1b[2J", "contains control characters"}
+	assert.Equal(t, `gz\nip: invalid argv[1] "\x1b[2J": contains control characters`, err.Error())
+}
+
+func TestInvalidArgumentOptions(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	_, err = h.WithOptions(Options{Args: []string{"--fast", "--rsyncable\x1b[2J"}}).
+		CompressStream(ioutil.NopCloser(nil))
+	assert.Equal(t, InvalidArgumentError{"gzip", "Args[1]", "--rsyncable\x1b[2J", "contains control characters"}, err)
+
+	_, err = h.WithTempDir("/tmp\x00/x").CompressStream(ioutil.NopCloser(nil))
+	assert.True(t, errors.Is(err, ErrInvalidArgument))
+
+	_, err = h.CompressedFileName("file", InPlaceOptions{Suffix: ".gz\t"})
+	assert.Equal(t, InvalidSuffix{".gz\t", "must not contain control characters"}, err)
+}
+
+func TestInvalidArgumentExec(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	// Built directly, so nothing was checked before the tool is run
+	h := Filter{Command: "gzip", CompressFlags: []string{"-c", "-\x009"}}
+	filename := path.Join(tmpdir, "file")
+	assert.Nil(t, ioutil.WriteFile(filename, []byte(data), os.FileMode(0644)))
+	_, err := h.Compress(filename)
+	assert.Equal(t, InvalidArgumentError{"gzip", "argv[2]", "-\x009", "contains a NUL byte"}, err)
+
+	// Paths may hold anything but NUL
+	h = Filter{Command: "gzip", CompressFlags: []string{"-c"}}
+	_, err = h.Compress(filename + "\x00.evil")
+	assert.True(t, errors.Is(err, ErrInvalidArgument))
+	newline := path.Join(tmpdir, "new\nline")
+	assert.Nil(t, ioutil.WriteFile(newline, []byte(data), os.FileMode(0644)))
+	job, err := h.Compress(newline)
+	assert.Nil(t, err)
+	_, err = ioutil.ReadAll(job)
+	assert.Nil(t, err)
+	assert.Zero(t, job.Result())
+}
@@ -0,0 +1,270 @@
+package extcompress
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Returned for a file in a batch when the tool exited without compressing it
+// but gave no error of its own, e.g. for a file it skipped with a warning.
+var ErrFileNotProcessed = errors.New("extcompress: file was not processed by the tool")
+
+// Outcome of one file of a batch in-place operation.
+type FileResult struct {
+	Path string
+	// Name of the file produced. Empty if the file failed.
+	Output string
+	Err    error
+}
+
+// Bytes of arguments and environment passed to one invocation: the system's
+// ARG_MAX where it can be found, else defaultArgMax.
+var batchArgMax = systemArgMax()
+
+// Bytes an argument or environment variable costs against ARG_MAX: the
+// string, its NUL terminator, and its pointer.
+func argSize(arg string) int {
+	return len(arg) + 1 + 8
+}
+
+// Splits paths into groups which fit in one invocation alongside the fixed
+// arguments. Each path must fit alongside them on its own.
+func chunkPaths(paths []string, fixed int) [][]string {
+	var chunks [][]string
+	var chunk []string
+	size := fixed
+	for _, p := range paths {
+		if len(chunk) > 0 && size+argSize(p) > batchArgMax {
+			chunks = append(chunks, chunk)
+			chunk, size = nil, fixed
+		}
+		chunk = append(chunk, p)
+		size += argSize(p)
+	}
+	if len(chunk) > 0 {
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+// Compresses many files in place, passing as many to each run of the tool as
+// the argument limit allows. The tools don't report which files failed, so
+// each file's result is worked out afterwards from which outputs exist. A
+// failed run doesn't stop the rest; the returned error is only for problems
+// which prevent the whole operation, like invalid options or cancellation.
+// Results are in the order of paths, so reports of repeated runs over the
+// same files can be compared directly.
+func (c Filter) CompressFilesInPlace(paths []string, opts InPlaceOptions) ([]FileResult, error) {
+	opResults, err := c.CompressFilesInPlaceResult(paths, opts)
+	if opResults == nil {
+		return nil, err
+	}
+	results := make([]FileResult, len(opResults))
+	for i, res := range opResults {
+		results[i] = FileResult{res.OriginalPath, res.ResultPath, res.Err}
+	}
+	return results, err
+}
+
+// Compresses many files in place as for CompressFilesInPlace, reporting each
+// file's sizes along with the duration, exit status and stderr of the run of
+// the tool it was part of. Options.OnSummary, if set, is given the running
+// totals while the batch runs.
+func (c Filter) CompressFilesInPlaceResult(paths []string, opts InPlaceOptions) ([]FileOpResult, error) {
+	if c.sourceReadOnly() {
+		return nil, ErrSourceProtected
+	}
+	results := make([]FileOpResult, len(paths))
+	live := c.trackSummary(len(paths))
+	defer live.stop()
+
+	var pending, linked []int
+	for i, p := range paths {
+		results[i].OriginalPath = p
+		results[i].ExitCode = -1
+		switch {
+		case isStdioPath(p):
+			results[i].Err = StdioPathError{p}
+		default:
+			var hardLinked bool
+			err := c.checkCompressedSuffix(p, opts)
+			if err == nil {
+				hardLinked, err = c.checkHardLinks(p, opts)
+			}
+			if err == nil {
+				_, err = c.CompressedFileName(p, opts)
+			}
+			var linkErr HardLinkedError
+			if errors.As(err, &linkErr) {
+				results[i].Links, results[i].HardLinks = linkErr.Links, HardLinkSkip
+			}
+			if results[i].Err = err; err == nil {
+				if hardLinked {
+					linked = append(linked, i)
+				} else {
+					pending = append(pending, i)
+				}
+				continue
+			}
+		}
+		live.add(results, i)
+	}
+
+	// Files with other hard links are dealt with by the package, one by one
+	for _, i := range linked {
+		results[i], _ = c.CompressFileInPlaceResult(paths[i], opts)
+		live.add(results, i)
+	}
+
+	if c.Passthrough || c.packageInPlace(true) || c.packageSuffix(opts) {
+		// Nothing to gain from batching when the package does the work
+		for _, i := range pending {
+			results[i], _ = c.CompressFileInPlaceResult(paths[i], opts)
+			live.add(results, i)
+		}
+		return results, c.contextErr()
+	}
+
+	flags := c.CompressInPlaceFlags
+	if opts.Suffix != "" && opts.Suffix != c.suffix() {
+		flags = withFlags(flags, c.SuffixFlag, opts.Suffix)
+	}
+	args, err := c.buildArgs(true, flags)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.checkArgv(args); err != nil {
+		return nil, err
+	}
+	fixed := c.argvSize(args)
+
+	// Paths too long to pass to the tool at all are streamed to it one by
+	// one instead
+	var pendingPaths []string
+	batched := pending[:0]
+	for _, i := range pending {
+		p := argPath(paths[i])
+		if fixed+argSize(p) > batchArgMax || argTooLong(p) {
+			results[i], _ = c.CompressFileInPlaceResult(paths[i], opts)
+			live.add(results, i)
+			continue
+		}
+		batched = append(batched, i)
+		pendingPaths = append(pendingPaths, p)
+	}
+	pending = batched
+	for _, chunk := range chunkPaths(pendingPaths, fixed) {
+		indexes := pending[:len(chunk)]
+		pending = pending[len(chunk):]
+		if err := c.compressChunk(chunk, flags, opts, indexes, results); err != nil {
+			failed := append(indexes, pending...)
+			for _, i := range failed {
+				results[i].ResultPath, results[i].OutputBytes, results[i].Ratio = "", 0, 0
+				results[i].Err = err
+			}
+			live.add(results, failed...)
+			return results, err
+		}
+		live.add(results, indexes...)
+	}
+	return results, nil
+}
+
+// Runs the tool once over chunk and fills in the results at indexes. Only
+// an error which should stop the batch is returned.
+func (c Filter) compressChunk(chunk []string, flags []string, opts InPlaceOptions, indexes []int, results []FileOpResult) error {
+	id := c.newJobID()
+	jlog := c.jobLog(id).WithFields(log.Fields{"compressCmd": printable(c.Command), "files": len(chunk)})
+	jlog.Info("External Batch Compression Command")
+
+	owners := make([]*fileOwner, len(chunk))
+	for n, p := range chunk {
+		owners[n], _ = c.sourceOwner(p)
+		if st, err := os.Stat(p); err == nil {
+			results[indexes[n]].InputBytes = st.Size()
+			results[indexes[n]].Links = linkCount(st)
+		}
+	}
+
+	args, err := c.buildArgs(true, flags, chunk...)
+	if err != nil {
+		return err
+	}
+	jlog.WithField("args", printableAll(c.redact(args))).Debug("External command arguments")
+	cmd := c.newCmd(args)
+	tail := &stderrTail{}
+	c.stderrCapture = tail
+	warnings := c.newWarningLog()
+	c.warningCapture = warnings
+	cmd.Stderr = c.stderr(id, "CompressFilesInPlace")
+
+	started := time.Now()
+	runErr := c.runCmd(jlog, cmd, "CompressFilesInPlace", id)
+	if err := c.contextErr(); err != nil {
+		return err
+	}
+	if runErr != nil {
+		jlog.WithField("error", runErr.Error()).Warn("Compression command failed.")
+	}
+
+	for n, i := range indexes {
+		res := &results[i]
+		res.Warnings = warningsAbout(warnings.list(), chunk[n], chunk)
+		output, _ := c.CompressedFileName(res.OriginalPath, opts)
+		if !compressedTo(res.OriginalPath, output) {
+			err := runErr
+			switch {
+			case c.refusedSuffixed(tail.String(), res.OriginalPath):
+				err = AlreadyCompressedNameError{res.OriginalPath, ""}
+			case err == nil:
+				err = ErrFileNotProcessed
+			}
+			res.finish("", err, started, tail)
+		} else {
+			err := owners[n].apply(output)
+			if err == nil && len(res.Warnings) > 0 && enabled(c.opts.TreatWarningsAsErrors) {
+				err = WarningsError{c.displayCommand(args), res.Warnings, id}
+			}
+			res.finish(output, err, started, tail)
+		}
+		// The run's status, even for files it did compress
+		res.ExitCode = exitCodeOf(runErr)
+	}
+	return nil
+}
+
+// Returns the warnings of a batch run which concern filePath: those naming
+// it, and those naming none of the files in the run.
+func warningsAbout(warnings []string, filePath string, chunk []string) []string {
+	var about []string
+	for _, warning := range warnings {
+		if strings.Contains(warning, filePath) {
+			about = append(about, warning)
+			continue
+		}
+		general := true
+		for _, p := range chunk {
+			if strings.Contains(warning, p) {
+				general = false
+				break
+			}
+		}
+		if general {
+			about = append(about, warning)
+		}
+	}
+	return about
+}
+
+// True if an in-place compression of src left only its output behind.
+func compressedTo(src string, output string) bool {
+	if _, err := os.Lstat(output); err != nil {
+		return false
+	}
+	_, err := os.Lstat(src)
+	return os.IsNotExist(err)
+}
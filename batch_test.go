@@ -0,0 +1,194 @@
+package extcompress
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Writes n small files into dir and returns their paths.
+func writeSmallFiles(t testing.TB, dir string, n int) []string {
+	paths := make([]string, n)
+	for i := range paths {
+		paths[i] = path.Join(dir, fmt.Sprintf("file-%05d.log", i))
+		assert.Nil(t, ioutil.WriteFile(paths[i], []byte(data), os.FileMode(0644)))
+	}
+	return paths
+}
+
+// Makes a file the tool can't read. Root can read anything, so there it is
+// removed instead.
+func makeUnreadable(t *testing.T, filePath string) {
+	if os.Geteuid() == 0 {
+		assert.Nil(t, os.Remove(filePath))
+		return
+	}
+	assert.Nil(t, os.Chmod(filePath, 0))
+}
+
+func setBatchArgMax(t *testing.T, n int) {
+	old := batchArgMax
+	batchArgMax = n
+	t.Cleanup(func() { batchArgMax = old })
+}
+
+func TestChunkPaths(t *testing.T) {
+	setBatchArgMax(t, 100)
+	paths := []string{"a", "b", "c", "d", string(make([]byte, 200)), "e"}
+	chunks := chunkPaths(paths, 60)
+	assert.Equal(t, [][]string{{"a", "b", "c", "d"}, {paths[4]}, {"e"}}, chunks)
+	assert.Nil(t, chunkPaths(nil, 60))
+}
+
+func TestCompressFilesInPlace(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	// Force several chunks, with the bad file in the first
+	paths := writeSmallFiles(t, tmpdir, 20)
+	bad := paths[2]
+	makeUnreadable(t, bad)
+	setBatchArgMax(t, envArgSize(h)+5*argSize(paths[0])+100)
+
+	results, err := h.CompressFilesInPlace(paths, InPlaceOptions{})
+	assert.Nil(t, err)
+	assert.Len(t, results, len(paths))
+	for i, res := range results {
+		assert.Equal(t, paths[i], res.Path)
+		if res.Path == bad {
+			assert.NotNil(t, res.Err)
+			assert.Empty(t, res.Output)
+			continue
+		}
+		assert.Nil(t, res.Err, res.Path)
+		assert.Equal(t, res.Path+".gz", res.Output)
+		_, err := os.Stat(res.Path)
+		assert.True(t, os.IsNotExist(err))
+
+		proc, err := h.Decompress(res.Output)
+		assert.Nil(t, err)
+		out, err := ioutil.ReadAll(proc)
+		assert.Nil(t, err)
+		assert.Zero(t, proc.Result())
+		assert.Equal(t, data, string(out))
+	}
+}
+
+func TestCompressFilesInPlaceCustomSuffix(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	// gzip applies the suffix itself, bzip2 goes file by file via the package
+	for _, mimeType := range []string{"application/gzip", "application/x-bzip2"} {
+		h, err := GetExternalHandlerFromMimeType(mimeType)
+		assert.Nil(t, err)
+		dir, err := ioutil.TempDir(tmpdir, "")
+		assert.Nil(t, err)
+
+		paths := writeSmallFiles(t, dir, 3)
+		results, err := h.CompressFilesInPlace(paths, InPlaceOptions{Suffix: ".old"})
+		assert.Nil(t, err)
+		for _, res := range results {
+			assert.Nil(t, res.Err, mimeType)
+			assert.Equal(t, res.Path+".old", res.Output)
+			_, err := os.Stat(res.Output)
+			assert.Nil(t, err)
+		}
+	}
+}
+
+func TestCompressFilesInPlaceInvalidOptions(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	paths := writeSmallFiles(t, tmpdir, 2)
+
+	_, err = h.WithLevel(42).CompressFilesInPlace(paths, InPlaceOptions{})
+	assert.IsType(t, InvalidOption{}, err)
+	for _, p := range paths {
+		_, err := os.Stat(p)
+		assert.Nil(t, err)
+	}
+}
+
+func benchmarkInPlace(b *testing.B, compress func(h ExternalHandler, paths []string)) {
+	tmpdir, err := ioutil.TempDir("", "extcompress_bench")
+	assert.Nil(b, err)
+	defer os.RemoveAll(tmpdir)
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(b, err)
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		dir, err := ioutil.TempDir(tmpdir, "")
+		assert.Nil(b, err)
+		paths := writeSmallFiles(b, dir, 200)
+		b.StartTimer()
+
+		compress(h, paths)
+	}
+}
+
+func BenchmarkCompressFilesInPlace(b *testing.B) {
+	benchmarkInPlace(b, func(h ExternalHandler, paths []string) {
+		_, err := h.CompressFilesInPlace(paths, InPlaceOptions{})
+		assert.Nil(b, err)
+	})
+}
+
+func BenchmarkCompressFileInPlaceLoop(b *testing.B) {
+	benchmarkInPlace(b, func(h ExternalHandler, paths []string) {
+		for _, p := range paths {
+			assert.Nil(b, h.CompressFileInPlace(p))
+		}
+	})
+}
+
+func TestCompressFilesInPlaceStableOrder(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	// Several runs of the tool, given the paths out of lexical order
+	report := func() string {
+		dir, err := ioutil.TempDir(tmpdir, "run")
+		assert.Nil(t, err)
+		paths := writeSmallFiles(t, dir, 12)
+		for i, j := 0, len(paths)-1; i < j; i, j = i+1, j-1 {
+			paths[i], paths[j] = paths[j], paths[i]
+		}
+		makeUnreadable(t, paths[5])
+		setBatchArgMax(t, 4*argSize(paths[0])+envArgSize(h)+100)
+
+		results, err := h.CompressFilesInPlace(paths, InPlaceOptions{})
+		assert.Nil(t, err)
+		var lines []string
+		for i, res := range results {
+			assert.Equal(t, paths[i], res.Path)
+			lines = append(lines, fmt.Sprintf("%s %s %v", path.Base(res.Path), path.Base(res.Output), res.Err != nil))
+		}
+		return strings.Join(lines, "\n")
+	}
+	assert.Equal(t, report(), report())
+}
+
+// Returns what the handler's environment costs against the argument limit.
+func envArgSize(h ExternalHandler) int {
+	size := 0
+	for _, kv := range h.(Filter).childEnv() {
+		size += argSize(kv)
+	}
+	return size
+}
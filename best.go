@@ -0,0 +1,137 @@
+package extcompress
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Which way BestCompressor leans when trading speed against size.
+type Preference int
+
+const (
+	// A good ratio at a reasonable speed
+	PreferBalanced Preference = iota
+	// The fastest compression, whatever the size
+	PreferSpeed
+	// The smallest output, however slow
+	PreferRatio
+)
+
+func (p Preference) String() string {
+	switch p {
+	case PreferSpeed:
+		return "speed"
+	case PreferRatio:
+		return "ratio"
+	default:
+		return "balanced"
+	}
+}
+
+// Handler names in order of preference for each outcome. Names which aren't
+// registered (lz4 unless the caller adds it) are passed over.
+var compressorRanking = map[Preference][]string{
+	PreferBalanced: {"zstd", "gzip", "xz", "bzip2", "lzop"},
+	PreferSpeed:    {"lz4", "zstd", "lzop", "gzip"},
+	PreferRatio:    {"xz", "zstd", "bzip2", "gzip"},
+}
+
+// What BestCompressor should pick a handler for.
+type Criteria struct {
+	Preference Preference
+	// Capabilities the handler must have. Only the fields set to true are
+	// required. Every handler streams, so streaming needn't be asked for.
+	Require Capabilities
+}
+
+// Returned by BestCompressor when no installed handler meets the criteria.
+type NoSuitableHandler struct {
+	Criteria Criteria
+	// Why each candidate was passed over
+	Reasons []string
+}
+
+func (r NoSuitableHandler) Error() string {
+	return fmt.Sprintf("no handler suits %s: %s", r.Criteria.Preference, strings.Join(r.Reasons, "; "))
+}
+
+// Returns the names of the capabilities required but missing from have.
+func (r Capabilities) missingFrom(have Capabilities) []string {
+	var missing []string
+	check := func(name string, want, got bool) {
+		if want && !got {
+			missing = append(missing, name)
+		}
+	}
+	check("passthrough", r.Passthrough, have.Passthrough)
+	check("levels", r.Levels, have.Levels)
+	check("threads", r.Threads, have.Threads)
+	check("native suffix", r.NativeSuffix, have.NativeSuffix)
+	check("stored name", r.StoredName, have.StoredName)
+	check("random access", r.RandomAccess, have.RandomAccess)
+	check("members", r.Members, have.Members)
+	check("progress", r.Progress, have.Progress)
+	check("streaming", r.Streaming, have.Streaming)
+	return missing
+}
+
+// Walks the ranking for the criteria, returning the first suitable handler
+// name (empty if none) and why each candidate before it was rejected.
+func (r Criteria) choose() (string, []string) {
+	var reasons []string
+	for _, name := range compressorRanking[r.Preference] {
+		registryMtx.RLock()
+		f, ok := filtersMap[name]
+		registryMtx.RUnlock()
+		if !ok {
+			reasons = append(reasons, name+" is not registered")
+			continue
+		}
+		if _, err := exec.LookPath(f.Command); err != nil {
+			reasons = append(reasons, name+": "+f.Command+" is not installed")
+			continue
+		}
+		if missing := r.Require.missingFrom(f.Capabilities()); len(missing) > 0 {
+			reasons = append(reasons, name+" lacks "+strings.Join(missing, ", "))
+			continue
+		}
+		return name, reasons
+	}
+	return "", reasons
+}
+
+// Describes which handler BestCompressor would pick and why the ones ranked
+// above it were passed over.
+func (r Criteria) Explain() string {
+	name, reasons := r.choose()
+	var s string
+	if name == "" {
+		s = "nothing suits " + r.Preference.String()
+	} else {
+		s = name + " is the best available for " + r.Preference.String()
+	}
+	if len(reasons) > 0 {
+		s += " (" + strings.Join(reasons, "; ") + ")"
+	}
+	return s
+}
+
+// Picks the installed handler best suited to the criteria, using a built-in
+// ranking: zstd for balanced, lz4 for speed and xz for ratio, falling back
+// down each list when tools are missing or lack a required capability.
+func BestCompressor(criteria Criteria) (ExternalHandler, error) {
+	name, reasons := criteria.choose()
+	if name == "" {
+		return nil, NoSuitableHandler{criteria, reasons}
+	}
+
+	registryMtx.RLock()
+	defer registryMtx.RUnlock()
+	handler := filtersMap[name]
+	handler.mimeType = canonicalMimeTypes[name]
+	handler.stampLookup(name, handler.mimeType, MatchNone, SelectedRanked)
+	handler.prov.Skipped = reasons
+	handler.applyRegisteredOptions(name)
+	return handler, nil
+}
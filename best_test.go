@@ -0,0 +1,96 @@
+package extcompress
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Sets PATH to a directory holding only the given tools, skipping the test
+// if any of them isn't installed.
+func pathWith(t *testing.T, tools ...string) string {
+	dir, err := ioutil.TempDir("", "extcompress_path")
+	assert.Nil(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	for _, tool := range tools {
+		real, err := exec.LookPath(tool)
+		if err != nil {
+			t.Skipf("%s not available", tool)
+		}
+		assert.Nil(t, os.Symlink(real, path.Join(dir, tool)))
+	}
+	t.Setenv("PATH", dir)
+	return dir
+}
+
+func assertBest(t *testing.T, criteria Criteria, command string) {
+	h, err := BestCompressor(criteria)
+	if !assert.Nil(t, err, criteria.Explain()) {
+		return
+	}
+	assert.Equal(t, command, h.(Filter).Command, criteria.Explain())
+}
+
+func TestBestCompressor(t *testing.T) {
+	pathWith(t, "gzip", "xz", "bzip2", "zstd")
+
+	assertBest(t, Criteria{Preference: PreferBalanced}, "zstd")
+	assertBest(t, Criteria{Preference: PreferRatio}, "xz")
+	assertBest(t, Criteria{Preference: PreferSpeed}, "zstd")
+
+	h, err := BestCompressor(Criteria{Preference: PreferRatio})
+	assert.Nil(t, err)
+	assert.Equal(t, "application/x-xz", h.MimeType())
+	assert.True(t, bytes.HasPrefix(compressBytes(t, h, []byte(data)), []byte("\xfd7zXZ")))
+}
+
+func TestBestCompressorFallsBack(t *testing.T) {
+	pathWith(t, "gzip", "bzip2")
+
+	assertBest(t, Criteria{Preference: PreferBalanced}, "gzip")
+	assertBest(t, Criteria{Preference: PreferRatio}, "bzip2")
+	assert.Equal(t, "bzip2 is the best available for ratio (xz: xz is not installed; zstd: zstd is not installed)",
+		Criteria{Preference: PreferRatio}.Explain())
+
+	// Neither has threads
+	_, err := BestCompressor(Criteria{Require: Capabilities{Threads: true}})
+	assert.IsType(t, NoSuitableHandler{}, err)
+	assert.Contains(t, err.Error(), "gzip lacks threads")
+}
+
+func TestBestCompressorRequirements(t *testing.T) {
+	pathWith(t, "gzip", "xz", "zstd")
+
+	assertBest(t, Criteria{Preference: PreferBalanced, Require: Capabilities{Members: true}}, "gzip")
+	assertBest(t, Criteria{Preference: PreferBalanced, Require: Capabilities{Members: true, Threads: true}}, "xz")
+	assertBest(t, Criteria{Preference: PreferSpeed, Require: Capabilities{StoredName: true}}, "gzip")
+}
+
+func TestBestCompressorRegisteredTool(t *testing.T) {
+	dir := pathWith(t, "gzip", "zstd")
+
+	// A stand in lz4 takes over for speed once it is registered and present
+	script := path.Join(dir, "lz4")
+	assert.Nil(t, ioutil.WriteFile(script, []byte("#!/bin/sh\nexec cat\n"), os.FileMode(0755)))
+	unregisterFilter(t, "lz4")
+	assertBest(t, Criteria{Preference: PreferSpeed}, "zstd")
+	assert.Nil(t, RegisterFilter("lz4", NewFilter("lz4"), "application/x-test-lz4"))
+	assertBest(t, Criteria{Preference: PreferSpeed}, "lz4")
+	assertBest(t, Criteria{Preference: PreferBalanced}, "zstd")
+
+	// And drops out again when it isn't installed
+	assert.Nil(t, os.Remove(script))
+	assertBest(t, Criteria{Preference: PreferSpeed}, "zstd")
+}
+
+func TestBestCompressorNothingInstalled(t *testing.T) {
+	pathWith(t)
+	_, err := BestCompressor(Criteria{})
+	assert.IsType(t, NoSuitableHandler{}, err)
+	assert.Contains(t, Criteria{}.Explain(), "nothing suits balanced")
+}
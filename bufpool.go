@@ -0,0 +1,93 @@
+package extcompress
+
+import (
+	"io"
+	"sync"
+)
+
+// Size of the buffers in the default pool, as io.Copy uses.
+const DefaultBufferSize = 32 * 1024
+
+// Supplies the buffers the package copies data through: feeding streams to
+// tools, passing on their stderr, Fanout, DrainDecompress and the other
+// copy loops. Get must return a non-empty buffer; Put is given it back once
+// the copy is finished and nothing refers to it.
+type BufferPool interface {
+	Get() []byte
+	Put(buf []byte)
+}
+
+// Buffers of a fixed size kept in a sync.Pool.
+type syncBufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+// Returns a pool of buffers of size bytes, or of DefaultBufferSize if size
+// isn't positive, which is safe for concurrent use.
+func NewBufferPool(size int) BufferPool {
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	return &syncBufferPool{size: size}
+}
+
+func (p *syncBufferPool) Get() []byte {
+	if buf, ok := p.pool.Get().(*[]byte); ok {
+		return *buf
+	}
+	return make([]byte, p.size)
+}
+
+func (p *syncBufferPool) Put(buf []byte) {
+	// Foreign buffers are left to the garbage collector
+	if cap(buf) < p.size {
+		return
+	}
+	buf = buf[:p.size]
+	p.pool.Put(&buf)
+}
+
+var (
+	bufferPoolMtx sync.RWMutex
+	defaultPool   = NewBufferPool(DefaultBufferSize)
+	bufferPool    = defaultPool
+)
+
+// Sets the pool every copy started afterwards takes its buffer from, e.g.
+// to share one between libraries or size buffers to the workload. Nil
+// restores the default pool of DefaultBufferSize buffers.
+func SetBufferPool(pool BufferPool) {
+	bufferPoolMtx.Lock()
+	defer bufferPoolMtx.Unlock()
+	if pool == nil {
+		pool = defaultPool
+	}
+	bufferPool = pool
+}
+
+func currentBufferPool() BufferPool {
+	bufferPoolMtx.RLock()
+	defer bufferPoolMtx.RUnlock()
+	return bufferPool
+}
+
+// Copies src to dst as io.Copy does, through a buffer from the pool where
+// neither of them can do the copy itself.
+func copyPooled(dst io.Writer, src io.Reader) (int64, error) {
+	pool := currentBufferPool()
+	buf := pool.Get()
+	defer pool.Put(buf)
+	return io.CopyBuffer(dst, src, buf)
+}
+
+// Has what exec copies from a child's pipe into the writer go through a
+// pooled buffer, instead of one allocated for each command.
+type pooledWriter struct {
+	io.Writer
+}
+
+func (w pooledWriter) ReadFrom(r io.Reader) (int64, error) {
+	// Hidden so the copy doesn't come straight back here
+	return copyPooled(struct{ io.Writer }{w.Writer}, r)
+}
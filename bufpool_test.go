@@ -0,0 +1,108 @@
+package extcompress
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Counts the buffers taken from and returned to the pool it wraps.
+type countingPool struct {
+	BufferPool
+	gets, puts int64
+}
+
+func (p *countingPool) Get() []byte {
+	atomic.AddInt64(&p.gets, 1)
+	return p.BufferPool.Get()
+}
+
+func (p *countingPool) Put(buf []byte) {
+	atomic.AddInt64(&p.puts, 1)
+	p.BufferPool.Put(buf)
+}
+
+// Allocates every buffer, as the package did before pooling.
+type allocatingPool struct{}
+
+func (allocatingPool) Get() []byte    { return make([]byte, DefaultBufferSize) }
+func (allocatingPool) Put(buf []byte) {}
+
+func setBufferPool(t testing.TB, pool BufferPool) {
+	SetBufferPool(pool)
+	t.Cleanup(func() { SetBufferPool(nil) })
+}
+
+func TestNewBufferPool(t *testing.T) {
+	pool := NewBufferPool(1024)
+	buf := pool.Get()
+	assert.Equal(t, 1024, len(buf))
+	pool.Put(buf)
+	// Too small to reuse, so dropped
+	pool.Put(make([]byte, 10))
+	assert.Equal(t, 1024, len(pool.Get()))
+
+	assert.Equal(t, DefaultBufferSize, len(NewBufferPool(0).Get()))
+}
+
+func TestSetBufferPool(t *testing.T) {
+	pool := &countingPool{BufferPool: NewBufferPool(4096)}
+	setBufferPool(t, pool)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	original := seekableTestData(100000)
+	proc, err := h.CompressStream(bytes.NewReader(original))
+	assert.Nil(t, err)
+	var a, b bytes.Buffer
+	assert.Nil(t, proc.(*CompressionJob).Fanout(&a, &b))
+	assert.Equal(t, a.Bytes(), b.Bytes())
+
+	// Every buffer taken was given back once the job finished
+	gets := atomic.LoadInt64(&pool.gets)
+	assert.True(t, gets >= 4, "only %d buffers taken", gets)
+	assert.Equal(t, gets, atomic.LoadInt64(&pool.puts))
+
+	proc, err = h.DecompressStream(bytes.NewReader(a.Bytes()))
+	assert.Nil(t, err)
+	out, err := ioutil.ReadAll(proc)
+	assert.Nil(t, err)
+	assert.Equal(t, original, out)
+}
+
+func benchmarkConcurrentStreamCompress(b *testing.B, pool BufferPool) {
+	setBufferPool(b, pool)
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(b, err)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var wg sync.WaitGroup
+		for n := 0; n < 200; n++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				proc, err := h.CompressStream(bytes.NewReader([]byte(data)))
+				if err != nil {
+					b.Error(err)
+					return
+				}
+				ioutil.ReadAll(proc)
+				proc.Close()
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+func BenchmarkConcurrentStreamCompress(b *testing.B) {
+	b.Run("pooled", func(b *testing.B) {
+		benchmarkConcurrentStreamCompress(b, nil)
+	})
+	b.Run("unpooled", func(b *testing.B) {
+		benchmarkConcurrentStreamCompress(b, allocatingPool{})
+	})
+}
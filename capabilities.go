@@ -0,0 +1,43 @@
+package extcompress
+
+// Describes what a handler is able to do, so callers can choose between
+// handlers without knowing the tools behind them.
+type Capabilities struct {
+	// Data is passed through untransformed
+	Passthrough bool
+	// Compression levels can be set
+	Levels bool
+	// Worker threads can be set
+	Threads bool
+	// The tool applies custom in-place suffixes itself
+	NativeSuffix bool
+	// The stored original name can be restored on decompression
+	StoredName bool
+	// OpenReaderAt is supported (given a suitably indexed file)
+	RandomAccess bool
+	// DecompressMembers is supported
+	Members bool
+	// Progress can be parsed from the tool's verbose output
+	Progress bool
+	// Output starts arriving before all the input has been read, rather
+	// than the tool storing it all first (see Latency)
+	Streaming bool
+	// Stream compression can adapt its level to the consumer (see
+	// Options.StreamingAdaptive)
+	Adaptive bool
+}
+
+func (c Filter) Capabilities() Capabilities {
+	return Capabilities{
+		Passthrough:  c.Passthrough,
+		Levels:       c.LevelFlag != "",
+		Threads:      c.ThreadsFlag != "",
+		NativeSuffix: c.SuffixFlag != "",
+		StoredName:   c.RestoreNameFlag != "",
+		RandomAccess: c.Command == "xz" || c.Command == "zstd",
+		Members:      c.Command == "gzip" || c.Command == "xz",
+		Progress:     len(c.ProgressParsers) > 0,
+		Streaming:    c.latency() == LatencyStreaming,
+		Adaptive:     c.AdaptFlag != "",
+	}
+}
@@ -0,0 +1,67 @@
+package extcompress
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTarPassthrough(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	filename := path.Join(tmpdir, "archive.tar")
+	writeTestTar(t, filename)
+	original, err := ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+
+	h, err := GetFileTypeExternalHandler(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, "application/x-tar", h.MimeType())
+	assert.True(t, h.Capabilities().Passthrough)
+
+	job, err := h.Decompress(filename)
+	assert.Nil(t, err)
+	out, err := ioutil.ReadAll(job)
+	assert.Nil(t, err)
+	assert.Zero(t, job.Result())
+	assert.Equal(t, original, out)
+
+	job, err = h.DecompressStream(ioutil.NopCloser(bytes.NewReader(original)))
+	assert.Nil(t, err)
+	out, err = ioutil.ReadAll(job)
+	assert.Nil(t, err)
+	assert.Zero(t, job.Result())
+	assert.Equal(t, original, out)
+
+	// In-place operations leave the file alone
+	assert.Nil(t, h.CompressFileInPlace(filename))
+	assert.Nil(t, h.DecompressFileInPlace(filename))
+	result, err := h.CompressFileInPlaceWithOptions(filename, InPlaceOptions{Suffix: ".old"})
+	assert.Nil(t, err)
+	assert.Equal(t, filename, result)
+	after, err := ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, original, after)
+}
+
+func TestCapabilities(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/x-xz")
+	assert.Nil(t, err)
+	assert.Equal(t, Capabilities{
+		Levels:       true,
+		Threads:      true,
+		NativeSuffix: true,
+		RandomAccess: true,
+		Members:      true,
+		Progress:     true,
+	}, h.Capabilities())
+
+	h, err = GetExternalHandlerFromMimeType("application/x-bzip2")
+	assert.Nil(t, err)
+	assert.Equal(t, Capabilities{Levels: true, Streaming: true}, h.Capabilities())
+}
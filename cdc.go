@@ -0,0 +1,179 @@
+package extcompress
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// One chunk of a stream compressed by CompressChunkedCDC.
+type CompressedChunk struct {
+	// Position of the chunk in the stream, from 0
+	Index int
+	// Bytes of input the chunk holds, and their SHA-256, which identifies
+	// the chunk for deduplication
+	Length int64
+	Hash   Checksum
+	// The chunk compressed on its own
+	Data []byte
+}
+
+// Largest average chunk size CompressChunkedCDC accepts. No chunk is more
+// than four times the average.
+const MaxCDCAverage = 1 << 30
+
+// Describes a chunk given to DecompressChunkedCDC whose length
+// CompressChunkedCDC couldn't have produced, or which doesn't match its
+// data.
+type InvalidChunkError struct {
+	Index  int
+	Length int64
+}
+
+func (r InvalidChunkError) Error() string {
+	return fmt.Sprintf("extcompress: chunk %d has invalid length %d", r.Index, r.Length)
+}
+
+// Multiplied into the rolling hash for each byte value. Generated from a
+// fixed seed, so boundaries are the same from run to run and process to
+// process.
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	seed := uint64(0x6a09e667f3bcc908)
+	for i := range table {
+		// splitmix64
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// Splits a stream at boundaries chosen by its content, so data shared by two
+// streams is split the same way in both wherever it appears.
+type cdcChunker struct {
+	rd       *bufio.Reader
+	mask     uint64
+	min, max int
+}
+
+func newCDCChunker(r io.Reader, avgChunk int) *cdcChunker {
+	// Boundaries fall where the hash's low bits are zero, so the average is
+	// rounded up to a power of two
+	bits := uint(0)
+	for 1<<bits < avgChunk {
+		bits++
+	}
+	avg := 1 << bits
+	return &cdcChunker{
+		rd:   bufio.NewReaderSize(r, 64*1024),
+		mask: uint64(avg - 1),
+		min:  avg / 4,
+		max:  avg * 4,
+	}
+}
+
+// Returns the next chunk, or io.EOF after the last.
+func (c *cdcChunker) next() ([]byte, error) {
+	var chunk []byte
+	var hash uint64
+	for len(chunk) < c.max {
+		b, err := c.rd.ReadByte()
+		if err == io.EOF && len(chunk) > 0 {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		chunk = append(chunk, b)
+		hash = hash<<1 + gearTable[b]
+		if len(chunk) >= c.min && hash&c.mask == 0 {
+			break
+		}
+	}
+	return chunk, nil
+}
+
+// Compresses r as independent chunks whose boundaries depend on the content,
+// averaging about avgChunk bytes (rounded up to a power of two, and at most
+// MaxCDCAverage), so inputs
+// sharing long runs of data produce identical chunks for them. Each chunk
+// is compressed by its own run of the tool and passed to sink, in order,
+// with its length and hash. Empty input produces no chunks. Reassemble the
+// stream with DecompressChunkedCDC.
+func CompressChunkedCDC(r io.Reader, h ExternalHandler, avgChunk int, sink func(chunk CompressedChunk) error) error {
+	if avgChunk <= 0 || avgChunk > MaxCDCAverage {
+		return InvalidOption{h.Provenance().Command, "average chunk size",
+			fmt.Sprintf("%d is outside the range 1-%d", avgChunk, MaxCDCAverage)}
+	}
+	chunker := newCDCChunker(r, avgChunk)
+	for index := 0; ; index++ {
+		data, err := chunker.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var compressed bytes.Buffer
+		if err := compressSegment(bytes.NewReader(data), &compressed, h); err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		chunk := CompressedChunk{
+			Index:  index,
+			Length: int64(len(data)),
+			Hash:   Checksum{ChecksumSHA256, sum[:]},
+			Data:   compressed.Bytes(),
+		}
+		if err := sink(chunk); err != nil {
+			return err
+		}
+	}
+}
+
+// Decompresses the chunks produced by CompressChunkedCDC into w, in order.
+// next is called until it returns io.EOF. Each chunk is checked against
+// its hash before it is written, failing with a ChecksumMismatchError.
+func DecompressChunkedCDC(w io.Writer, h ExternalHandler, next func() (CompressedChunk, error)) error {
+	for {
+		chunk, err := next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if chunk.Length < 0 || chunk.Length > 4*MaxCDCAverage {
+			return InvalidChunkError{chunk.Index, chunk.Length}
+		}
+		var out bytes.Buffer
+		// Only a hint, so kept within what is safe to allocate up front
+		if chunk.Length <= int64(maxPreallocate) {
+			out.Grow(int(chunk.Length))
+		}
+		if err := decompressSegment(ioutil.NopCloser(bytes.NewReader(chunk.Data)), &out, h); err != nil {
+			return err
+		}
+		if int64(out.Len()) != chunk.Length {
+			return InvalidChunkError{chunk.Index, chunk.Length}
+		}
+		hash, ok := chunk.Hash.Algorithm.new()
+		if !ok {
+			return InvalidOption{h.Provenance().Command, "checksum", "unsupported algorithm " + chunk.Hash.Algorithm.String()}
+		}
+		hash.Write(out.Bytes())
+		if sum := hash.Sum(nil); !bytes.Equal(sum, chunk.Hash.Sum) {
+			return ChecksumMismatchError{chunk.Hash, Checksum{chunk.Hash.Algorithm, sum}}
+		}
+		if _, err := w.Write(out.Bytes()); err != nil {
+			return err
+		}
+	}
+}
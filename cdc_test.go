@@ -0,0 +1,119 @@
+package extcompress
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Compresses input with CompressChunkedCDC, returning the chunks.
+func cdcChunks(t *testing.T, h ExternalHandler, input []byte, avgChunk int) []CompressedChunk {
+	var chunks []CompressedChunk
+	err := CompressChunkedCDC(bytes.NewReader(input), h, avgChunk, func(chunk CompressedChunk) error {
+		assert.Equal(t, len(chunks), chunk.Index)
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	assert.Nil(t, err)
+	return chunks
+}
+
+// Returns a source for DecompressChunkedCDC reading chunks.
+func chunkSource(chunks []CompressedChunk) func() (CompressedChunk, error) {
+	return func() (CompressedChunk, error) {
+		if len(chunks) == 0 {
+			return CompressedChunk{}, io.EOF
+		}
+		chunk := chunks[0]
+		chunks = chunks[1:]
+		return chunk, nil
+	}
+}
+
+func randomBytes(seed int64, n int) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(b)
+	return b
+}
+
+func TestChunkedCDCRoundTrip(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	for _, input := range [][]byte{nil, []byte("x"), seekableTestData(100000), randomBytes(1, 300000)} {
+		chunks := cdcChunks(t, h, input, 8192)
+		var total int64
+		for _, chunk := range chunks {
+			assert.True(t, chunk.Length > 0)
+			assert.True(t, chunk.Length <= 4*8192)
+			assert.Equal(t, ChecksumSHA256, chunk.Hash.Algorithm)
+			total += chunk.Length
+		}
+		assert.EqualValues(t, len(input), total)
+
+		var out bytes.Buffer
+		assert.Nil(t, DecompressChunkedCDC(&out, h, chunkSource(chunks)))
+		assert.True(t, bytes.Equal(input, out.Bytes()), "%d bytes", len(input))
+	}
+
+	// Random data averages about the size asked for
+	chunks := cdcChunks(t, h, randomBytes(2, 1<<20), 8192)
+	assert.InDelta(t, (1<<20)/8192, len(chunks), 40)
+}
+
+func TestChunkedCDCSharedMiddle(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	// Different beginnings and ends around the same large middle, shifted
+	// by an unrelated amount in each
+	middle := randomBytes(3, 400000)
+	first := append(append(randomBytes(4, 10000), middle...), randomBytes(5, 20000)...)
+	second := append(append(randomBytes(6, 37777), middle...), randomBytes(7, 5000)...)
+
+	hashes := map[string]int64{}
+	for _, chunk := range cdcChunks(t, h, first, 4096) {
+		hashes[chunk.Hash.String()] = chunk.Length
+	}
+	var shared int64
+	for _, chunk := range cdcChunks(t, h, second, 4096) {
+		if _, ok := hashes[chunk.Hash.String()]; ok {
+			shared += chunk.Length
+		}
+	}
+	// All but the chunks straddling the edges of the middle are shared
+	assert.True(t, shared > int64(len(middle))-4*4*4096, "%d of %d bytes shared", shared, len(middle))
+}
+
+func TestChunkedCDCErrors(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	assert.IsType(t, InvalidOption{}, CompressChunkedCDC(bytes.NewReader(nil), h, 0, nil))
+	assert.IsType(t, InvalidOption{}, CompressChunkedCDC(bytes.NewReader(nil), h, 1<<62+1, nil))
+
+	stop := errors.New("stop")
+	err = CompressChunkedCDC(bytes.NewReader(randomBytes(8, 100000)), h, 4096, func(CompressedChunk) error {
+		return stop
+	})
+	assert.Equal(t, stop, err)
+
+	// A chunk which doesn't match its hash is caught
+	chunks := cdcChunks(t, h, seekableTestData(50000), 4096)
+	chunks[1].Hash = chunks[0].Hash
+	err = DecompressChunkedCDC(ioutil.Discard, h, chunkSource(chunks))
+	assert.True(t, errors.Is(err, ErrChecksumMismatch))
+
+	// As are lengths which are impossible or wrong, without allocating them
+	for _, length := range []int64{-1, 1 << 62, chunks[0].Length + 1} {
+		chunks := cdcChunks(t, h, seekableTestData(50000), 4096)
+		chunks[0].Length = length
+		err = DecompressChunkedCDC(ioutil.Discard, h, chunkSource(chunks))
+		assert.Equal(t, InvalidChunkError{0, length}, err)
+	}
+}
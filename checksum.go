@@ -0,0 +1,114 @@
+package extcompress
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+)
+
+// Hash function of a Checksum.
+type ChecksumAlgorithm int
+
+const (
+	ChecksumSHA256 ChecksumAlgorithm = iota
+	ChecksumSHA512
+)
+
+func (a ChecksumAlgorithm) String() string {
+	switch a {
+	case ChecksumSHA256:
+		return "sha256"
+	case ChecksumSHA512:
+		return "sha512"
+	default:
+		return fmt.Sprintf("ChecksumAlgorithm(%d)", int(a))
+	}
+}
+
+func (a ChecksumAlgorithm) new() (hash.Hash, bool) {
+	switch a {
+	case ChecksumSHA256:
+		return sha256.New(), true
+	case ChecksumSHA512:
+		return sha512.New(), true
+	default:
+		return nil, false
+	}
+}
+
+// A digest of uncompressed content, e.g. from a manifest.
+type Checksum struct {
+	Algorithm ChecksumAlgorithm
+	Sum       []byte
+}
+
+// Formats the checksum as "sha256:<hex>".
+func (c Checksum) String() string {
+	return c.Algorithm.String() + ":" + hex.EncodeToString(c.Sum)
+}
+
+// Matched by the error from DecompressAndVerify when the output doesn't
+// match the expected checksum.
+var ErrChecksumMismatch = errors.New("extcompress: checksum mismatch")
+
+// Describes decompressed output not matching its checksum. Wraps
+// ErrChecksumMismatch.
+type ChecksumMismatchError struct {
+	Expected Checksum
+	Actual   Checksum
+}
+
+func (r ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("extcompress: checksum mismatch: expected %s, got %s", r.Expected, r.Actual)
+}
+
+func (r ChecksumMismatchError) Unwrap() error {
+	return ErrChecksumMismatch
+}
+
+// Decompresses filePath, checking the output against expected. The output
+// goes to Options.VerifiedOutput, or is discarded if that isn't set. Fails
+// as for Err if the tool fails, and with a ChecksumMismatchError if the
+// output doesn't match, even though the tool succeeded. Output forwarded to
+// VerifiedOutput has already been written by the time a mismatch is found.
+func (c Filter) DecompressAndVerify(filePath string, expected Checksum) error {
+	return c.decompressAndVerify(OpDecompress, FileInput(filePath), expected)
+}
+
+// Like DecompressAndVerify, for a compressed stream.
+func (c Filter) DecompressStreamAndVerify(r io.Reader, expected Checksum) error {
+	return c.decompressAndVerify(OpDecompressStream, StreamInput(r), expected)
+}
+
+func (c Filter) decompressAndVerify(op Operation, in Input, expected Checksum) error {
+	h, ok := expected.Algorithm.new()
+	if !ok {
+		return InvalidOption{c.Command, "checksum", fmt.Sprintf("unsupported algorithm %s", expected.Algorithm)}
+	}
+	proc, err := c.Run(op, in, nil)
+	if err != nil {
+		return err
+	}
+	out := c.opts.VerifiedOutput
+	if out == nil {
+		out = ioutil.Discard
+	}
+	if _, err := copyPooled(io.MultiWriter(h, out), proc); err != nil {
+		proc.Close()
+		return err
+	}
+	if err := processErr(proc); err != nil {
+		return err
+	}
+	actual := Checksum{expected.Algorithm, h.Sum(nil)}
+	if !bytes.Equal(actual.Sum, expected.Sum) {
+		return ChecksumMismatchError{expected, actual}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package extcompress
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecompressAndVerify(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	original := seekableTestData(300000)
+	filename := writeCompressed(t, tmpdir, "gzip", original)
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	sum256 := sha256.Sum256(original)
+	sum512 := sha512.Sum512(original)
+	assert.Nil(t, h.DecompressAndVerify(filename, Checksum{ChecksumSHA256, sum256[:]}))
+	assert.Nil(t, h.DecompressAndVerify(filename, Checksum{ChecksumSHA512, sum512[:]}))
+
+	compressed, err := ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+	var out bytes.Buffer
+	err = h.WithOptions(Options{VerifiedOutput: &out}).DecompressStreamAndVerify(bytes.NewReader(compressed), Checksum{ChecksumSHA256, sum256[:]})
+	assert.Nil(t, err)
+	assert.Equal(t, original, out.Bytes())
+}
+
+func TestDecompressAndVerifyMismatch(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	original := seekableTestData(300000)
+	filename := writeCompressed(t, tmpdir, "gzip", original)
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	// The tool succeeds, but the content isn't what was expected
+	other := sha256.Sum256([]byte("something else"))
+	expected := Checksum{ChecksumSHA256, other[:]}
+	err = h.DecompressAndVerify(filename, expected)
+	assert.True(t, errors.Is(err, ErrChecksumMismatch))
+	var mismatch ChecksumMismatchError
+	if assert.True(t, errors.As(err, &mismatch)) {
+		actual := sha256.Sum256(original)
+		assert.Equal(t, expected, mismatch.Expected)
+		assert.Equal(t, Checksum{ChecksumSHA256, actual[:]}, mismatch.Actual)
+		assert.Contains(t, err.Error(), mismatch.Actual.String())
+	}
+
+	var invalid InvalidOption
+	assert.True(t, errors.As(h.DecompressAndVerify(filename, Checksum{ChecksumAlgorithm(9), nil}), &invalid))
+}
+
+func TestDecompressAndVerifyTruncated(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	original := seekableTestData(300000)
+	filename := writeCompressed(t, tmpdir, "gzip", original)
+	compressed, err := ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+	truncated := path.Join(tmpdir, "truncated.gz")
+	assert.Nil(t, ioutil.WriteFile(truncated, compressed[:len(compressed)/2], 0644))
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	// The tool's failure is reported rather than the checksum's
+	sum := sha256.Sum256(original)
+	err = h.DecompressAndVerify(truncated, Checksum{ChecksumSHA256, sum[:]})
+	assert.True(t, errors.Is(err, ErrCorruptInput), "%v", err)
+	assert.False(t, errors.Is(err, ErrChecksumMismatch))
+}
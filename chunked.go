@@ -0,0 +1,97 @@
+package extcompress
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// Compresses r as a sequence of independent archives, each holding exactly
+// chunkSize bytes of the input (the last may hold less). A fresh compressor is
+// spawned per chunk so every segment can be decompressed on its own. newSink
+// is called with the segment index to get the destination for each segment.
+func CompressChunked(r io.Reader, chunkSize int64, newSink func(index int) (io.WriteCloser, error), h ExternalHandler) error {
+	if chunkSize <= 0 {
+		return InvalidOption{h.Provenance().Command, "chunk size", fmt.Sprintf("%d is not positive", chunkSize)}
+	}
+
+	br := bufio.NewReader(r)
+	for index := 0; ; index++ {
+		// Always emit at least one segment so empty input round-trips.
+		if _, err := br.Peek(1); err != nil {
+			if err != io.EOF {
+				return err
+			}
+			if index > 0 {
+				return nil
+			}
+		}
+
+		sink, err := newSink(index)
+		if err != nil {
+			return err
+		}
+
+		err = compressSegment(io.LimitReader(br, chunkSize), sink, h)
+		if cerr := sink.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func compressSegment(r io.Reader, w io.Writer, h ExternalHandler) error {
+	job, err := h.CompressStream(r)
+	if err != nil {
+		return err
+	}
+
+	if _, err := copyPooled(w, job); err != nil {
+		job.Close()
+		return err
+	}
+
+	if status := statusOf(job); status != 0 {
+		return ExitStatusError{h.CommandStreamCompress(), status, job.ID()}
+	}
+	return nil
+}
+
+// Decompresses the segments produced by CompressChunked into w, in order.
+// nextSegment is called with increasing indexes until it returns io.EOF.
+func DecompressChunked(w io.Writer, nextSegment func(index int) (io.ReadCloser, error), h ExternalHandler) error {
+	for index := 0; ; index++ {
+		segment, err := nextSegment(index)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		err = decompressSegment(segment, w, h)
+		segment.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func decompressSegment(r io.ReadCloser, w io.Writer, h ExternalHandler) error {
+	job, err := h.DecompressStream(r)
+	if err != nil {
+		return err
+	}
+
+	if _, err := copyPooled(w, job); err != nil {
+		job.Close()
+		return err
+	}
+
+	if status := statusOf(job); status != 0 {
+		return ExitStatusError{h.CommandStreamDecompress(), status, job.ID()}
+	}
+	return nil
+}
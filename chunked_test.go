@@ -0,0 +1,106 @@
+package extcompress
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type bufferSink struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *bufferSink) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestChunkedRoundTrip(t *testing.T) {
+	original := seekableTestData(100000)
+
+	for _, mimeType := range []string{"application/gzip", "application/x-xz"} {
+		h, err := GetExternalHandlerFromMimeType(mimeType)
+		assert.Nil(t, err)
+
+		for _, chunkSize := range []int64{1000, 4096, 33333, 100000, 500000} {
+			var segments []*bufferSink
+			err := CompressChunked(bytes.NewReader(original), chunkSize, func(index int) (io.WriteCloser, error) {
+				assert.Equal(t, len(segments), index)
+				s := &bufferSink{}
+				segments = append(segments, s)
+				return s, nil
+			}, h)
+			assert.Nil(t, err)
+
+			expected := (int64(len(original)) + chunkSize - 1) / chunkSize
+			assert.EqualValues(t, expected, len(segments), "chunk size %d", chunkSize)
+
+			// Every segment decompresses on its own to exactly its chunk
+			for i, s := range segments {
+				assert.True(t, s.closed)
+				job, err := h.DecompressStream(ioutil.NopCloser(bytes.NewReader(s.Bytes())))
+				assert.Nil(t, err)
+				out, err := ioutil.ReadAll(job)
+				assert.Nil(t, err)
+				assert.Zero(t, job.Result())
+
+				start := int64(i) * chunkSize
+				end := start + chunkSize
+				if end > int64(len(original)) {
+					end = int64(len(original))
+				}
+				assert.Equal(t, original[start:end], out, "segment %d", i)
+			}
+
+			var result bytes.Buffer
+			err = DecompressChunked(&result, func(index int) (io.ReadCloser, error) {
+				if index >= len(segments) {
+					return nil, io.EOF
+				}
+				return ioutil.NopCloser(bytes.NewReader(segments[index].Bytes())), nil
+			}, h)
+			assert.Nil(t, err)
+			assert.Equal(t, original, result.Bytes())
+		}
+	}
+}
+
+func TestChunkedEmptyInput(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	var segments []*bufferSink
+	err = CompressChunked(bytes.NewReader(nil), 1024, func(index int) (io.WriteCloser, error) {
+		s := &bufferSink{}
+		segments = append(segments, s)
+		return s, nil
+	}, h)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(segments))
+
+	var result bytes.Buffer
+	err = DecompressChunked(&result, func(index int) (io.ReadCloser, error) {
+		if index >= len(segments) {
+			return nil, io.EOF
+		}
+		return ioutil.NopCloser(bytes.NewReader(segments[index].Bytes())), nil
+	}, h)
+	assert.Nil(t, err)
+	assert.Zero(t, result.Len())
+}
+
+func TestChunkedInvalidSize(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	newSink := func(index int) (io.WriteCloser, error) {
+		t.Fatal("no segment should be started")
+		return nil, nil
+	}
+	assert.Equal(t, InvalidOption{"gzip", "chunk size", "0 is not positive"},
+		CompressChunked(bytes.NewReader([]byte(data)), 0, newSink, h))
+	assert.IsType(t, InvalidOption{}, CompressChunked(bytes.NewReader([]byte(data)), -1, newSink, h))
+}
@@ -0,0 +1,95 @@
+package extcompress
+
+import (
+	"io"
+)
+
+// Decompresses what is read from a stream with a handler, in the shape of
+// gzip.Reader.
+type compatReader struct {
+	proc CompressionProcess
+	eof  bool
+	err  error
+}
+
+// Returns a reader of r decompressed by h, as a drop-in replacement for
+// gzip.NewReader and the like. As with gzip.Reader, the tool failing, e.g.
+// on corrupt input, is reported by the Read which reaches the end of the
+// output, and again by Close. Closing before the end stops the tool, and
+// only reports a failure it had already exited with.
+func NewReader(r io.Reader, h ExternalHandler) (io.ReadCloser, error) {
+	proc, err := h.DecompressStream(r)
+	if err != nil {
+		return nil, err
+	}
+	return &compatReader{proc: proc}, nil
+}
+
+func (cr *compatReader) Read(p []byte) (int, error) {
+	if cr.eof {
+		if cr.err != nil {
+			return 0, cr.err
+		}
+		return 0, io.EOF
+	}
+	n, err := cr.proc.Read(p)
+	if err == io.EOF {
+		cr.eof = true
+		if cr.err = processErr(cr.proc); cr.err != nil {
+			err = cr.err
+		}
+	}
+	return n, err
+}
+
+func (cr *compatReader) Close() error {
+	if !cr.eof {
+		return cr.proc.Close()
+	}
+	cr.proc.Close()
+	return cr.err
+}
+
+// Compresses what is written to it with a handler, in the shape of
+// gzip.Writer.
+type compatWriter struct {
+	pw   *io.PipeWriter
+	done chan struct{}
+	err  error
+}
+
+// Returns a writer which compresses what is written to it with h and writes
+// the result to w, as a drop-in replacement for gzip.NewWriter and the like.
+// Close must be called to finish the output, and waits until all of it has
+// been written to w. It returns the tool's failure, or the error writing to
+// w, if there was one; once either happens, writes fail with it too.
+func NewWriter(w io.Writer, h ExternalHandler) (io.WriteCloser, error) {
+	pr, pw := io.Pipe()
+	proc, err := h.CompressStream(pr)
+	if err != nil {
+		return nil, err
+	}
+	cw := &compatWriter{pw: pw, done: make(chan struct{})}
+	go func() {
+		defer close(cw.done)
+		if _, err := copyPooled(w, proc); err != nil {
+			proc.Close()
+			cw.err = err
+		} else {
+			cw.err = processErr(proc)
+		}
+		// The tool reads no more, so don't leave writes waiting for it
+		pr.CloseWithError(cw.err)
+	}()
+	return cw, nil
+}
+
+func (cw *compatWriter) Write(p []byte) (int, error) {
+	return cw.pw.Write(p)
+}
+
+func (cw *compatWriter) Close() error {
+	cw.pw.Close()
+	<-cw.done
+	return cw.err
+}
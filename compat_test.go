@@ -0,0 +1,96 @@
+package extcompress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompatRoundTrip(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	original := seekableTestData(1 << 20)
+
+	var compressed bytes.Buffer
+	zw, err := NewWriter(&compressed, h)
+	assert.Nil(t, err)
+	for rest := original; len(rest) > 0; {
+		n := 10000
+		if n > len(rest) {
+			n = len(rest)
+		}
+		written, err := zw.Write(rest[:n])
+		assert.Nil(t, err)
+		assert.Equal(t, n, written)
+		rest = rest[n:]
+	}
+	assert.Nil(t, zw.Close())
+
+	// Readable by what it replaces
+	gr, err := gzip.NewReader(bytes.NewReader(compressed.Bytes()))
+	assert.Nil(t, err)
+	out, err := ioutil.ReadAll(gr)
+	assert.Nil(t, err)
+	assert.Equal(t, original, out)
+
+	zr, err := NewReader(bytes.NewReader(compressed.Bytes()), h)
+	assert.Nil(t, err)
+	out, err = ioutil.ReadAll(zr)
+	assert.Nil(t, err)
+	assert.Nil(t, zr.Close())
+	assert.Equal(t, original, out)
+}
+
+func TestCompatReaderCorrupt(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	compressed := compressBytes(t, h, seekableTestData(100000))
+
+	zr, err := NewReader(bytes.NewReader(compressed[:len(compressed)/2]), h)
+	assert.Nil(t, err)
+	_, err = ioutil.ReadAll(zr)
+	var corruptErr CorruptInputError
+	assert.True(t, errors.As(err, &corruptErr), "%v", err)
+	assert.Equal(t, err, zr.Close())
+}
+
+func TestCompatReaderEarlyClose(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	compressed := compressBytes(t, h, seekableTestData(1<<20))
+
+	zr, err := NewReader(bytes.NewReader(compressed), h)
+	assert.Nil(t, err)
+	_, err = io.ReadFull(zr, make([]byte, 100))
+	assert.Nil(t, err)
+	assert.Nil(t, zr.Close())
+}
+
+func TestCompatWriterFailures(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	// Hiding the buffer's ReadFrom, which would bypass the failure
+	zw, err := NewWriter(struct{ io.Writer }{&brokenSink{limit: 100}}, h)
+	assert.Nil(t, err)
+	zw.Write(seekableTestData(1 << 20))
+	err = zw.Close()
+	assert.True(t, errors.Is(err, errSinkBroken), "%v", err)
+	_, err = zw.Write([]byte(data))
+	assert.NotNil(t, err)
+
+	// A tool which fails without reading its input doesn't leave writes
+	// waiting
+	zw, err = NewWriter(ioutil.Discard, NewFilter("sh", CompressFlags("-c", "exit 3")))
+	assert.Nil(t, err)
+	_, err = zw.Write(seekableTestData(1 << 20))
+	assert.NotNil(t, err)
+	var statusErr ExitStatusError
+	if assert.True(t, errors.As(zw.Close(), &statusErr)) {
+		assert.Equal(t, 3, statusErr.ExitStatus)
+	}
+}
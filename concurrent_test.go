@@ -0,0 +1,79 @@
+package extcompress
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConcurrentHandlerUse(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	original := seekableTestData(100000)
+	filename := writeCompressed(t, tmpdir, "gzip", original)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	// Options which carry slices and maps, shared by every copy
+	h = h.WithLogFields(log.Fields{"shared": true}).WithOptions(Options{Args: []string{"-q"}})
+	plan := h.Plan()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Deriving handlers mustn't disturb the shared one
+			derived := h.WithLevel(1 + i%9).WithLogFields(log.Fields{"worker": i}).WithOptions(Options{Args: []string{fmt.Sprintf("-%d", 1+i%9)}})
+			assert.NotNil(t, derived.Plan().CompressStream)
+
+			switch i % 3 {
+			case 0:
+				compressed := compressBytes(t, h, original)
+				proc, err := h.DecompressStream(bytes.NewReader(compressed))
+				assert.Nil(t, err)
+				out, err := ioutil.ReadAll(proc)
+				assert.Nil(t, err)
+				assert.Zero(t, proc.Result())
+				assert.Equal(t, original, out)
+			case 1:
+				out, err := h.DecompressToBytes(filename, 0)
+				assert.Nil(t, err)
+				assert.Equal(t, original, out)
+			case 2:
+				out, err := h.DecompressPrefix(filename, 1000)
+				assert.Nil(t, err)
+				assert.Equal(t, original[:1000], out)
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, plan, h.Plan())
+}
+
+func TestHandlerOptionsCopied(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/x-xz")
+	assert.Nil(t, err)
+	level := 3
+	args := []string{"--check=crc32"}
+	fields := log.Fields{"a": 1}
+	h = h.WithOptions(Options{Level: &level, Args: args, LogFields: fields})
+	plan := h.Plan()
+
+	// Changing what was passed in, or what comes out, leaves the handler alone
+	level = 9
+	args[0] = "--check=none"
+	fields["b"] = 2
+	opts := h.Options()
+	*opts.Level = 0
+	opts.Args[0] = "--check=sha256"
+	opts.LogFields["c"] = 3
+	assert.Equal(t, plan, h.Plan())
+	assert.Equal(t, log.Fields{"a": 1}, h.Options().LogFields)
+}
@@ -0,0 +1,24 @@
+package extcompress_test
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/wrouesnel/extcompress"
+	"github.com/wrouesnel/extcompress/extcompresstest"
+)
+
+func TestConformance(t *testing.T) {
+	handlers := extcompress.InstalledHandlers(t)
+	names := make([]string, 0, len(handlers))
+	for name := range handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		t.Run(name, func(t *testing.T) {
+			extcompresstest.RunConformance(t, handlers[name], extcompresstest.ConformanceOpts{})
+		})
+	}
+}
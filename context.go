@@ -0,0 +1,66 @@
+package extcompress
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Returns a copy of the handler whose operations are cancelled when ctx is
+// done: spawning fails with ctx's error, and running tools are killed, with
+// reads from their jobs returning ctx's error. Replaces any context the
+// handler was already bound to.
+func (c Filter) WithContext(ctx context.Context) ExternalHandler {
+	c.ctx = ctx
+	return c
+}
+
+// Returns the error of the handler's context, if it's done.
+func (c Filter) contextErr() error {
+	if c.ctx == nil {
+		return nil
+	}
+	return c.ctx.Err()
+}
+
+// Kills cmd and anything it started once the handler's context is done.
+// Returns a function which stops watching, reporting false if the context had
+// already fired.
+func (c Filter) killOnDone(jlog *log.Entry, cmd *exec.Cmd, onDone func(error)) func() bool {
+	if c.ctx == nil {
+		return func() bool { return true }
+	}
+	ctx := c.ctx
+	return context.AfterFunc(ctx, func() {
+		if onDone != nil {
+			onDone(ctx.Err())
+		}
+		// Tools run in their own process group (see newCmd)
+		if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil {
+			jlog.WithField("error", err.Error()).Debug("Error killing cancelled external process")
+		}
+	})
+}
+
+// Runs cmd to completion as job id, killing it if the handler's context is
+// done first, in which case the context's error is returned. Its warnings
+// are judged by the handler's warningCapture, which should be cmd's own.
+func (c Filter) runCmd(jlog *log.Entry, cmd *exec.Cmd, operation string, id string) error {
+	span, err := c.spawn(operation, id, cmd)
+	if err != nil {
+		return err
+	}
+	stop := c.killOnDone(jlog, cmd, nil)
+	status, _, err := waitCmd(jlog, cmd, c.displayCommand(c.toolArgs(cmd)), id)
+	status, err = c.warningCapture.exited(status, err)
+	if err == nil {
+		err = c.warningCapture.failure(c.displayCommand(c.toolArgs(cmd)), id)
+	}
+	if !stop() {
+		err = c.ctx.Err()
+	}
+	span.end(status, -1, -1, err)
+	return err
+}
@@ -0,0 +1,92 @@
+package extcompress
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContextCancelledBeforeDetection(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := GetFileTypeExternalHandlerContext(ctx, path.Join(tmpdir, "pipechaining"))
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestContextCancelledBeforeSpawn(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	filename := writeLargeGzip(t, tmpdir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h, err := GetFileTypeExternalHandlerContext(ctx, filename)
+	assert.Nil(t, err)
+	cancel()
+
+	_, err = h.Decompress(filename)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, context.Canceled, h.DecompressFileInPlace(filename))
+	_, err = os.Stat(filename)
+	assert.Nil(t, err, "file should be untouched")
+
+	// An explicit context overrides the bound one
+	proc, err := h.WithContext(context.Background()).Decompress(filename)
+	assert.Nil(t, err)
+	_, err = io.Copy(ioutil.Discard, proc)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, proc.Result())
+}
+
+func TestContextCancelledMidStream(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	filename := writeLargeGzip(t, tmpdir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h, err := GetFileTypeExternalHandlerContext(ctx, filename)
+	assert.Nil(t, err)
+
+	proc, err := h.Decompress(filename)
+	assert.Nil(t, err)
+	_, err = io.ReadFull(proc, make([]byte, 4096))
+	assert.Nil(t, err)
+
+	cancel()
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(ioutil.Discard, proc)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancellation did not stop the job")
+	}
+	assert.NotEqual(t, 0, proc.Result())
+}
+
+func TestContextCancelledCompressIntoFile(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	w, err := h.WithContext(ctx).CompressIntoFile(path.Join(tmpdir, "out.gz"))
+	assert.Nil(t, err)
+	w.Write([]byte("data"))
+	cancel()
+	assert.Equal(t, context.Canceled, w.Close())
+	assertNoTempFiles(t, tmpdir)
+}
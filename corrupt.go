@@ -0,0 +1,202 @@
+package extcompress
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Matched by the error from Err when a decompressor rejected its input as
+// truncated, corrupted or not in its format.
+var ErrCorruptInput = errors.New("extcompress: corrupt input")
+
+// Describes a decompressor rejecting its input. Wraps ErrCorruptInput.
+type CorruptInputError struct {
+	Command    string
+	ExitStatus int
+	// The end of what the tool wrote to stderr
+	Stderr string
+	JobID  string
+}
+
+func (r CorruptInputError) Error() string {
+	return fmt.Sprintf("%s: corrupt input (exit status %d, job %s): %s", r.Command, r.ExitStatus, r.JobID, r.Stderr)
+}
+
+func (r CorruptInputError) Unwrap() error {
+	return ErrCorruptInput
+}
+
+// Fragments of the messages the built-in tools give for bad input, matched
+// case insensitively. Anything else is reported as an ExitStatusError.
+var corruptInputMessages = []string{
+	"unexpected end",        // gzip, xz
+	"ends unexpectedly",     // bzip2
+	"premature",             // zstd
+	"corrupt",               // xz, lzop
+	"crc error",             // gzip, lzop
+	"invalid compressed",    // gzip
+	"data integrity",        // bzip2
+	"checksum",              // zstd
+	"decoding error",        // zstd
+	"not in gzip format",    // gzip
+	"not a bzip2 file",      // bzip2
+	"not a lzop file",       // lzop
+	"format not recognized", // xz
+	"unsupported format",    // zstd
+	"unknown header",        // zstd
+	"trailing garbage",      // gzip
+	"inflate error",         // zstd reading gzip
+	"unknown method",        // gzip
+	"-- not supported",      // gzip
+	"bad table",             // gzip reading compress and pack files
+	"huffman",               // gzip reading pack files
+	"lzma error",            // zstd reading xz
+}
+
+// How much of a decompressor's stderr is kept for error reports.
+const stderrTailSize = 4096
+
+// Keeps the end of a tool's stderr so its failure can be classified, in a
+// ring of at most stderrTailSize bytes. Its memory counts towards
+// SetMaxRetainedStderr.
+type stderrTail struct {
+	mtx sync.Mutex
+	buf []byte
+	// Where the next byte goes once buf is full
+	next int
+	// Set once its job is over
+	completed bool
+	// Bytes it is counted for, and whether it has stopped being counted.
+	// Guarded by retention.mtx.
+	accounted int64
+	released  bool
+	// Set once buf has been cut down to a prefix, with whether the whole
+	// of it said the input was corrupt
+	dropped bool
+	corrupt bool
+}
+
+func (s *stderrTail) Write(p []byte) (int, error) {
+	n := len(p)
+	s.mtx.Lock()
+	if s.dropped {
+		s.mtx.Unlock()
+		return n, nil
+	}
+	before := cap(s.buf)
+	if len(p) >= stderrTailSize {
+		p = p[len(p)-stderrTailSize:]
+	}
+	if room := stderrTailSize - len(s.buf); room > 0 {
+		if grow := len(p) - (cap(s.buf) - len(s.buf)); grow > 0 {
+			size := 2 * cap(s.buf)
+			if size < len(s.buf)+len(p) {
+				size = len(s.buf) + len(p)
+			}
+			if size > stderrTailSize {
+				size = stderrTailSize
+			}
+			s.buf = append(make([]byte, 0, size), s.buf...)
+		}
+		fill := len(p)
+		if fill > room {
+			fill = room
+		}
+		s.buf = append(s.buf, p[:fill]...)
+		p = p[fill:]
+	}
+	for len(p) > 0 {
+		c := copy(s.buf[s.next:], p)
+		s.next = (s.next + c) % stderrTailSize
+		p = p[c:]
+	}
+	grown := int64(cap(s.buf) - before)
+	s.mtx.Unlock()
+
+	if grown > 0 {
+		retention.grew(s, grown)
+	}
+	return n, nil
+}
+
+// Returns the retained bytes in order. Called with mtx held.
+func (s *stderrTail) contents() []byte {
+	if s.next == 0 {
+		return s.buf
+	}
+	return append(append([]byte(nil), s.buf[s.next:]...), s.buf[:s.next]...)
+}
+
+func (s *stderrTail) String() string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	msg := strings.TrimSpace(string(s.contents()))
+	if s.dropped {
+		msg = strings.TrimSpace(msg + " ...")
+	}
+	return msg
+}
+
+// True if the tool's stderr says it rejected its input.
+func (s *stderrTail) corruptInput() bool {
+	s.mtx.Lock()
+	dropped, corrupt := s.dropped, s.corrupt
+	s.mtx.Unlock()
+	if dropped {
+		return corrupt
+	}
+	return corruptMessage(s.String())
+}
+
+func corruptMessage(msg string) bool {
+	msg = strings.ToLower(msg)
+	for _, fragment := range corruptInputMessages {
+		if strings.Contains(msg, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// Sends a decompressor's stderr where it would normally go, keeping the end
+// of it for Err. The tool runs in the C locale so its messages can be
+// recognised.
+func (c Filter) decompressorStderr(cmd *exec.Cmd, id string, operation string) *stderrTail {
+	cmd.Env = withCLocale(cmd.Env)
+	tail := &stderrTail{}
+	cmd.Stderr = pooledWriter{io.MultiWriter(c.stderr(id, operation), tail)}
+	return tail
+}
+
+// Waits for the job and returns nil if it succeeded. Otherwise returns why
+// not: a CorruptInputError if a decompressor rejected its input, the reason
+// the job was aborted, an ExitStatusError, a WarningsError if warnings are
+// errors, or the error from Wait. Output
+// read before the failure is everything the tool produced.
+func (this *CompressionJob) Err() error {
+	if _, err := this.Wait(); err != nil {
+		return err
+	}
+	return this.failure()
+}
+
+// Returns why the reaped job failed, or nil, as for Err.
+func (this *CompressionJob) failure() error {
+	if abortErr := this.aborted(); abortErr != nil {
+		return abortErr
+	}
+	if this.waitErr != nil {
+		return this.waitErr
+	}
+	if this.result == 0 {
+		return this.warnings.failure(this.command, this.id)
+	}
+	if this.stderrTail != nil && this.stderrTail.corruptInput() {
+		return CorruptInputError{this.command, this.result, this.stderrTail.String(), this.id}
+	}
+	return ExitStatusError{this.command, this.result, this.id}
+}
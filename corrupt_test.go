@@ -0,0 +1,145 @@
+package extcompress
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Ways of damaging a valid archive.
+var corruptions = map[string]func([]byte) []byte{
+	"truncated": func(b []byte) []byte {
+		return b[:len(b)/2]
+	},
+	"bit-flipped": func(b []byte) []byte {
+		b = append([]byte{}, b...)
+		b[len(b)/2] ^= 0x55
+		return b
+	},
+	"wrong magic": func(b []byte) []byte {
+		return append([]byte("XXXX"), b[4:]...)
+	},
+}
+
+// Decompresses input, failing the test if the job hangs, and returns the
+// output and the job's error.
+func decompressCorrupt(t *testing.T, h ExternalHandler, input []byte) ([]byte, error) {
+	var out []byte
+	var err error
+	assertReturns(t, "decompressing", func() {
+		proc, startErr := h.DecompressStream(ioutil.NopCloser(bytes.NewReader(input)))
+		if !assert.Nil(t, startErr) {
+			return
+		}
+		out, _ = ioutil.ReadAll(proc)
+		err = proc.(*CompressionJob).Err()
+	})
+	return out, err
+}
+
+func TestCorruptInput(t *testing.T) {
+	original := make([]byte, 256*1024)
+	for i := range original {
+		original[i] = byte(i * 7 % 251)
+	}
+
+	for name, h := range installedHandlers(t) {
+		if h.Capabilities().Passthrough {
+			continue
+		}
+		archive := compressBytes(t, h, original)
+		out, err := decompressCorrupt(t, h, archive)
+		assert.Nil(t, err, name)
+		assert.Equal(t, original, out, name)
+
+		for kind, corrupt := range corruptions {
+			input := corrupt(archive)
+			out, err := decompressCorrupt(t, h, input)
+			assert.True(t, errors.Is(err, ErrCorruptInput), "%s %s: %v", name, kind, err)
+			var corruptErr CorruptInputError
+			if assert.True(t, errors.As(err, &corruptErr)) {
+				assert.NotZero(t, corruptErr.ExitStatus)
+				assert.NotEmpty(t, corruptErr.Stderr)
+			}
+
+			// Everything the tool produced came through
+			cmd := exec.Command(h.(Filter).Command, h.(Filter).DecompressStreamFlags...)
+			cmd.Stdin = bytes.NewReader(input)
+			direct, _ := cmd.Output()
+			assert.Equal(t, len(direct), len(out), "%s %s", name, kind)
+			if kind == "truncated" {
+				assert.True(t, bytes.HasPrefix(original, out), "%s %s", name, kind)
+			}
+		}
+	}
+}
+
+func TestErrNotCorrupt(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	// Failures other than bad input aren't classified as corrupt
+	proc, err := h.Decompress(path.Join(tmpdir, "missing.gz"))
+	assert.Nil(t, err)
+	ioutil.ReadAll(proc)
+	err = proc.(*CompressionJob).Err()
+	assert.IsType(t, ExitStatusError{}, err)
+	assert.False(t, errors.Is(err, ErrCorruptInput))
+
+	proc, err = h.Compress(path.Join(tmpdir, "pipechaining"))
+	assert.Nil(t, err)
+	ioutil.ReadAll(proc)
+	assert.Nil(t, proc.(*CompressionJob).Err())
+}
+
+func TestStderrTail(t *testing.T) {
+	tail := &stderrTail{}
+	tail.Write(bytes.Repeat([]byte("a"), stderrTailSize))
+	tail.Write([]byte("the end\n"))
+	assert.Len(t, tail.String(), stderrTailSize-1)
+	assert.True(t, strings.HasSuffix(tail.String(), "aaathe end"))
+}
+
+func FuzzDecompressStream(f *testing.F) {
+	var handlers []ExternalHandler
+	for _, mimeType := range []string{"application/gzip", "application/x-bzip2", "application/x-xz", "application/zstd"} {
+		h, err := GetExternalHandlerFromMimeType(mimeType)
+		if err != nil {
+			f.Fatal(err)
+		}
+		if _, err := exec.LookPath(h.(Filter).Command); err != nil {
+			continue
+		}
+		handlers = append(handlers, h)
+	}
+	for i, h := range handlers {
+		proc, err := h.CompressStream(bytes.NewReader([]byte(data)))
+		if err != nil {
+			f.Fatal(err)
+		}
+		archive, _ := ioutil.ReadAll(proc)
+		f.Add(uint8(i), archive)
+		f.Add(uint8(i), archive[:len(archive)/2])
+	}
+
+	f.Fuzz(func(t *testing.T, which uint8, archive []byte) {
+		if len(handlers) == 0 {
+			t.Skip("no decompressors available")
+		}
+		h := handlers[int(which)%len(handlers)]
+		_, err := decompressCorrupt(t, h, archive)
+		if err != nil && !errors.Is(err, ErrCorruptInput) {
+			t.Errorf("%s: unclassified failure: %v", h.MimeType(), err)
+		}
+	})
+}
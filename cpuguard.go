@@ -0,0 +1,74 @@
+package extcompress
+
+import (
+	"errors"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Returned by a job which the CPU guard killed for using too much CPU time
+// for the output it produced.
+var ErrSuspiciousWorkload = errors.New("extcompress: job used excessive CPU for its output")
+
+// Limits the CPU time a streaming job may spend per byte of output, to catch
+// inputs crafted to burn CPU while producing little (e.g. bzip2 CPU bombs).
+// The job's CPU time is sampled periodically while it runs, so it may
+// overshoot by up to one sampling interval.
+type CPUGuard struct {
+	// CPU time allowed for each MiB of output
+	MaxCPUPerMiB time.Duration
+	// CPU time the job may use before the ratio is enforced, so slow starts
+	// and small outputs aren't penalised
+	Grace time.Duration
+}
+
+const mib = 1 << 20
+
+// Returns true if cpu is more than the guard allows for produced bytes.
+func (g CPUGuard) exceeded(cpu time.Duration, produced int64) bool {
+	if cpu <= g.Grace {
+		return false
+	}
+	allowed := float64(g.MaxCPUPerMiB) * float64(produced) / mib
+	return float64(cpu-g.Grace) > allowed
+}
+
+func (c Filter) WithCPUGuard(guard CPUGuard) ExternalHandler {
+	return c.WithOptions(Options{CPUGuard: &guard})
+}
+
+// Watches job with the filter's CPU guard, if it has one.
+func (c Filter) guardJob(job *CompressionJob) {
+	if c.opts.CPUGuard == nil {
+		return
+	}
+	guard := *c.opts.CPUGuard
+	pid := job.cmd.Process.Pid
+	jlog := job.log.WithFields(log.Fields{"compressCmd": printable(c.Command), "pid": pid})
+
+	monitor.watch(func() bool {
+		if job.isReaped() {
+			return false
+		}
+		cpu, err := processCPUTime(pid)
+		if err != nil {
+			// Unsupported platform, or the process already exited
+			return false
+		}
+		produced := atomic.LoadInt64(&job.produced)
+		if !guard.exceeded(cpu, produced) {
+			return true
+		}
+		jlog.WithField("cpu", cpu).WithField("produced", produced).
+			Warn("Killing job using excessive CPU for its output")
+		job.abort(ErrSuspiciousWorkload)
+		// The job runs in its own process group, so take any children too
+		if err := syscall.Kill(-pid, syscall.SIGKILL); err != nil {
+			jlog.WithField("error", err.Error()).Debug("Error killing external process")
+		}
+		return false
+	})
+}
@@ -0,0 +1,71 @@
+package extcompress
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setMonitorInterval(t *testing.T, d time.Duration) {
+	old := monitorInterval
+	monitorInterval = d
+	t.Cleanup(func() { monitorInterval = old })
+}
+
+func TestCPUGuardKillsBusyJob(t *testing.T) {
+	setMonitorInterval(t, 20*time.Millisecond)
+
+	// Burns CPU forever without producing any output
+	h := NewFilter("sh", CompressFlags("-c", "while :; do :; done")).
+		WithCPUGuard(CPUGuard{MaxCPUPerMiB: time.Millisecond, Grace: 100 * time.Millisecond})
+
+	job, err := h.CompressStream(bytes.NewReader(nil))
+	assert.Nil(t, err)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := ioutil.ReadAll(job)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		assert.Equal(t, ErrSuspiciousWorkload, err)
+	case <-time.After(10 * time.Second):
+		job.Close()
+		t.Fatal("CPU guard did not kill the job")
+	}
+	assert.NotEqual(t, 0, job.Result())
+}
+
+func TestCPUGuardAllowsProductiveJob(t *testing.T) {
+	setMonitorInterval(t, 20*time.Millisecond)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	h = h.WithCPUGuard(CPUGuard{MaxCPUPerMiB: time.Second, Grace: 100 * time.Millisecond})
+
+	input := seekableTestData(4 * mib)
+	job, err := h.CompressStream(bytes.NewReader(input))
+	assert.Nil(t, err)
+	_, err = ioutil.ReadAll(job)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, job.Result())
+
+	// The check is dropped once the job is reaped
+	assert.Eventually(t, func() bool { return monitor.watching() == 0 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestCPUGuardValidation(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	assert.IsType(t, InvalidOption{}, h.WithCPUGuard(CPUGuard{}).Plan().Err)
+
+	g := CPUGuard{MaxCPUPerMiB: time.Second, Grace: time.Second}
+	assert.False(t, g.exceeded(time.Second, 0))
+	assert.False(t, g.exceeded(3*time.Second, 2*mib))
+	assert.True(t, g.exceeded(3*time.Second, mib))
+}
@@ -0,0 +1,63 @@
+package extcompress
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// Describes a failed CrossCheck. Wraps the error from the failing stage, at
+// which SelfTestDecompress is the native reader.
+type CrossCheckError struct {
+	Command string
+	Stage   SelfTestStage
+	Err     error
+}
+
+func (r CrossCheckError) Error() string {
+	return fmt.Sprintf("%s: cross-check failed at %s: %v", r.Command, r.Stage, r.Err)
+}
+
+func (r CrossCheckError) Unwrap() error {
+	return r.Err
+}
+
+// Checks the handler produces its format as another implementation reads
+// it: data is compressed through the handler's stream operation and read
+// back with the reader nativeNew returns, such as gzip.NewReader. Catches
+// handlers whose flags are wrong in a way their own tool wouldn't notice
+// when decompressing. The error is a CrossCheckError naming the stage
+// which failed.
+func CrossCheck(h ExternalHandler, nativeNew func(io.Reader) (io.Reader, error), data []byte) error {
+	command := h.Provenance().Command
+	fail := func(stage SelfTestStage, err error) error {
+		return CrossCheckError{command, stage, err}
+	}
+
+	proc, err := h.CompressStream(bytes.NewReader(data))
+	if err != nil {
+		return fail(SelfTestCompress, err)
+	}
+	compressed, err := ioutil.ReadAll(proc)
+	if err != nil {
+		proc.Close()
+		return fail(SelfTestCompress, err)
+	}
+	if status := statusOf(proc); status != 0 {
+		return fail(SelfTestCompress, ExitStatusError{command, status, proc.ID()})
+	}
+
+	rd, err := nativeNew(bytes.NewReader(compressed))
+	if err != nil {
+		return fail(SelfTestDecompress, err)
+	}
+	out, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return fail(SelfTestDecompress, err)
+	}
+	if !bytes.Equal(out, data) {
+		return fail(SelfTestCompare, fmt.Errorf("%d bytes came back as %d different ones", len(data), len(out)))
+	}
+	return nil
+}
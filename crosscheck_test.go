@@ -0,0 +1,101 @@
+package extcompress
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns payloads of varying sizes, mixing random bytes, which don't
+// compress, with repeated text, which does.
+func crossCheckPayloads() [][]byte {
+	rnd := rand.New(rand.NewSource(1))
+	payloads := [][]byte{{}, {0}}
+	for _, size := range []int{100, 4095, 65537, 1 << 20} {
+		for i := 0; i < 2; i++ {
+			p := make([]byte, size)
+			rnd.Read(p)
+			for j := 0; j < size; j += 4096 {
+				// Runs of text at random offsets, up to half the payload
+				if rnd.Intn(2) == 0 {
+					copy(p[j:], data)
+				}
+			}
+			payloads = append(payloads, p)
+		}
+	}
+	return payloads
+}
+
+func gzipNewReader(rd io.Reader) (io.Reader, error) {
+	return gzip.NewReader(rd)
+}
+
+func TestCrossCheckGzip(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	for _, p := range crossCheckPayloads() {
+		// The tool's output reads with compress/gzip
+		assert.Nil(t, CrossCheck(h, gzipNewReader, p), "%d bytes", len(p))
+
+		// And compress/gzip's output decompresses through the tool
+		var b bytes.Buffer
+		w := gzip.NewWriter(&b)
+		w.Write(p)
+		assert.Nil(t, w.Close())
+		job, err := h.DecompressStream(&b)
+		assert.Nil(t, err)
+		out, err := ioutil.ReadAll(job)
+		assert.Nil(t, err)
+		assert.Zero(t, job.Result())
+		assert.True(t, bytes.Equal(p, out), "%d bytes", len(p))
+	}
+}
+
+func TestCrossCheckBzip2(t *testing.T) {
+	pathWith(t, "bzip2")
+	h, err := GetExternalHandlerFromMimeType("application/x-bzip2")
+	assert.Nil(t, err)
+
+	bzip2NewReader := func(rd io.Reader) (io.Reader, error) {
+		return bzip2.NewReader(rd), nil
+	}
+	for _, p := range crossCheckPayloads() {
+		assert.Nil(t, CrossCheck(h, bzip2NewReader, p), "%d bytes", len(p))
+	}
+}
+
+func TestCrossCheckMisconfigured(t *testing.T) {
+	input := []byte(data)
+
+	// Flags which decompress pass the data through unchanged
+	err := CrossCheck(misregisteredGzip(), gzipNewReader, input)
+	var checkErr CrossCheckError
+	assert.True(t, errors.As(err, &checkErr))
+	assert.Equal(t, "gzip", checkErr.Command)
+	assert.Equal(t, SelfTestDecompress, checkErr.Stage)
+	assert.True(t, errors.Is(err, gzip.ErrHeader))
+
+	// Valid output of the wrong data
+	wrong := NewFilter("sh", CompressFlags("-c", "cat > /dev/null; echo other | gzip -c"))
+	err = CrossCheck(wrong, gzipNewReader, input)
+	assert.True(t, errors.As(err, &checkErr))
+	assert.Equal(t, SelfTestCompare, checkErr.Stage)
+
+	// A tool which fails
+	failing := NewFilter("sh", CompressFlags("-c", "cat > /dev/null; exit 1"))
+	err = CrossCheck(failing, gzipNewReader, input)
+	assert.True(t, errors.As(err, &checkErr))
+	assert.Equal(t, SelfTestCompress, checkErr.Stage)
+	var exitErr ExitStatusError
+	assert.True(t, errors.As(err, &exitErr))
+	assert.Equal(t, 1, exitErr.ExitStatus)
+}
@@ -0,0 +1,118 @@
+package extcompress
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Returned when an atomic in-place operation is given a temp directory on a
+// different filesystem than its output, so the output couldn't appear by a
+// single rename.
+var ErrCrossDevice = errors.New("temp directory is on a different filesystem than the output")
+
+// Describes which temp directory and output were on different filesystems.
+type CrossDeviceError struct {
+	TempDir string
+	Output  string
+}
+
+func (r CrossDeviceError) Error() string {
+	return fmt.Sprintf("%s and %s: %s", r.TempDir, r.Output, ErrCrossDevice.Error())
+}
+
+func (r CrossDeviceError) Unwrap() error {
+	return ErrCrossDevice
+}
+
+// The calls which move a finished output into place, indirected so tests
+// can make them fail as they would across filesystems.
+var (
+	renameFile = os.Rename
+	linkFile   = os.Link
+)
+
+// Returns true if err is a rename or link failing because its paths are on
+// different filesystems.
+func isCrossDevice(err error) bool {
+	return errors.Is(err, syscall.EXDEV)
+}
+
+// Returns the directory temporary output for outPath is written in.
+func (c Filter) tempDirFor(outPath string) string {
+	if c.inPlace.TempDir != "" {
+		return c.inPlace.TempDir
+	}
+	return filepath.Dir(outPath)
+}
+
+// Checks an atomic operation's temp directory shares a filesystem with
+// outPath. Devices which can't be compared are assumed to match.
+func (c Filter) checkTempDir(outPath string) error {
+	if c.inPlace.TempDir == "" || !c.inPlace.Atomic {
+		return nil
+	}
+	tmpSt, err := os.Stat(c.inPlace.TempDir)
+	if err != nil {
+		return err
+	}
+	outSt, err := os.Stat(filepath.Dir(outPath))
+	if err != nil {
+		return err
+	}
+	tmpSys, ok := tmpSt.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	outSys, ok := outSt.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	if tmpSys.Dev != outSys.Dev {
+		return CrossDeviceError{c.inPlace.TempDir, outPath}
+	}
+	return nil
+}
+
+// Copies tmpPath to a new temporary file beside dstPath, keeping its mode
+// (and owner, if owners are preserved), and returns the copy's name.
+func (c Filter) copyBeside(tmpPath string, dstPath string) (string, error) {
+	src, err := os.Open(tmpPath)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	st, err := src.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	dst, err := ioutil.TempFile(filepath.Dir(dstPath), "."+filepath.Base(dstPath)+".")
+	if err != nil {
+		return "", err
+	}
+	err = func() error {
+		if _, err := copyPooled(dst, src); err != nil {
+			return err
+		}
+		if err := dst.Chmod(st.Mode().Perm()); err != nil {
+			return err
+		}
+		if err := c.ownerFor(st).applyFile(dst); err != nil {
+			return err
+		}
+		if err := c.syncOutput(dst); err != nil {
+			return err
+		}
+		return dst.Close()
+	}()
+	if err != nil {
+		dst.Close()
+		os.Remove(dst.Name())
+		return "", err
+	}
+	return dst.Name(), nil
+}
@@ -0,0 +1,121 @@
+package extcompress
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Makes renames and links between different directories fail with EXDEV,
+// as they would between filesystems.
+func mockCrossDevice(t *testing.T) {
+	oldRename, oldLink := renameFile, linkFile
+	crossDevice := func(op string, move func(string, string) error) func(string, string) error {
+		return func(oldpath, newpath string) error {
+			if filepath.Dir(oldpath) != filepath.Dir(newpath) {
+				return &os.LinkError{Op: op, Old: oldpath, New: newpath, Err: syscall.EXDEV}
+			}
+			return move(oldpath, newpath)
+		}
+	}
+	renameFile = crossDevice("rename", oldRename)
+	linkFile = crossDevice("link", oldLink)
+	t.Cleanup(func() { renameFile, linkFile = oldRename, oldLink })
+}
+
+// Returns a scratch directory on a different filesystem than dir, skipping
+// the test if there isn't one.
+func otherDeviceDir(t *testing.T, dir string) string {
+	st, err := os.Stat(dir)
+	assert.Nil(t, err)
+	shm, err := os.Stat("/dev/shm")
+	if err != nil || st.Sys().(*syscall.Stat_t).Dev == shm.Sys().(*syscall.Stat_t).Dev {
+		t.Skip("no second filesystem available")
+	}
+	other, err := ioutil.TempDir("/dev/shm", "extcompress_test")
+	if err != nil {
+		t.Skip("/dev/shm isn't writable")
+	}
+	t.Cleanup(func() { os.RemoveAll(other) })
+	return other
+}
+
+func TestInPlaceTempDir(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	mockCrossDevice(t)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	for _, hardened := range []bool{false, true} {
+		h := h.WithOptions(Options{Hardened: Bool(hardened)})
+		scratch, err := ioutil.TempDir(tmpdir, "scratch")
+		assert.Nil(t, err)
+
+		filename := path.Join(tmpdir, "app.log")
+		assert.Nil(t, ioutil.WriteFile(filename, []byte(data), os.FileMode(0640)))
+		compressed, err := h.CompressFileInPlaceWithOptions(filename, InPlaceOptions{TempDir: scratch})
+		assert.Nil(t, err, "hardened %v", hardened)
+		assert.Equal(t, filename+".gz", compressed)
+
+		// Copied into place, leaving nothing behind
+		st, err := os.Stat(compressed)
+		assert.Nil(t, err)
+		assert.Equal(t, os.FileMode(0640), st.Mode().Perm())
+		_, err = os.Stat(filename)
+		assert.True(t, os.IsNotExist(err))
+		left, err := ioutil.ReadDir(scratch)
+		assert.Nil(t, err)
+		assert.Empty(t, left)
+
+		decompressed, err := h.DecompressFileInPlaceWithOptions(compressed, InPlaceOptions{TempDir: scratch})
+		assert.Nil(t, err, "hardened %v", hardened)
+		out, err := ioutil.ReadFile(decompressed)
+		assert.Nil(t, err)
+		assert.Equal(t, data, string(out))
+		os.Remove(decompressed)
+	}
+}
+
+func TestInPlaceTempDirOtherFilesystem(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	other := otherDeviceDir(t, tmpdir)
+
+	h, err := GetExternalHandlerFromMimeType("application/x-xz")
+	assert.Nil(t, err)
+	filename := path.Join(tmpdir, "pipechaining")
+
+	// Atomic operations refuse before touching anything
+	_, err = h.CompressFileInPlaceWithOptions(filename, InPlaceOptions{TempDir: other, Atomic: true})
+	assert.True(t, errors.Is(err, ErrCrossDevice), "got %v", err)
+	var cerr CrossDeviceError
+	assert.True(t, errors.As(err, &cerr))
+	assert.Equal(t, other, cerr.TempDir)
+	_, err = os.Stat(filename)
+	assert.Nil(t, err)
+
+	// Otherwise the real EXDEV is handled by copying
+	compressed, err := h.CompressFileInPlaceWithOptions(filename, InPlaceOptions{TempDir: other})
+	assert.Nil(t, err)
+	assert.Equal(t, filename+".xz", compressed)
+	_, err = os.Stat(filename)
+	assert.True(t, os.IsNotExist(err))
+	left, err := ioutil.ReadDir(other)
+	assert.Nil(t, err)
+	assert.Empty(t, left)
+
+	// The same filesystem is fine
+	scratch, err := ioutil.TempDir(tmpdir, "scratch")
+	assert.Nil(t, err)
+	_, err = h.DecompressFileInPlaceWithOptions(compressed, InPlaceOptions{TempDir: scratch, Atomic: true})
+	assert.Nil(t, err)
+	_, err = os.Stat(filename)
+	assert.Nil(t, err)
+}
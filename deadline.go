@@ -0,0 +1,18 @@
+package extcompress
+
+import (
+	"os"
+	"time"
+)
+
+// Sets the deadline for Read calls, as on a net.Conn: a Read blocked past t,
+// including one already waiting, fails with an error wrapping
+// os.ErrDeadlineExceeded. The process is left running, so reads can carry
+// on once the deadline is extended. A zero t clears the deadline.
+func (this *CompressionJob) SetReadDeadline(t time.Time) error {
+	pipe, ok := this.pipe.(interface{ SetReadDeadline(time.Time) error })
+	if !ok {
+		return os.ErrNoDeadline
+	}
+	return pipe.SetReadDeadline(t)
+}
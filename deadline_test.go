@@ -0,0 +1,65 @@
+package extcompress
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadDeadline(t *testing.T) {
+	// cat stalls until it is given input
+	pr, pw := io.Pipe()
+	proc, err := Identity().CompressStream(pr)
+	assert.Nil(t, err)
+	job := proc.(*CompressionJob)
+
+	buf := make([]byte, 64)
+	assert.Nil(t, job.SetReadDeadline(time.Now().Add(50*time.Millisecond)))
+	start := time.Now()
+	n, err := job.Read(buf)
+	assert.Zero(t, n)
+	assert.True(t, errors.Is(err, os.ErrDeadlineExceeded), "%v", err)
+	assert.WithinDuration(t, start.Add(50*time.Millisecond), time.Now(), time.Second)
+
+	// The process is untouched, and reads work again once extended
+	time.Sleep(10 * time.Millisecond)
+	assert.False(t, job.isReaped())
+	assert.Nil(t, job.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = pw.Write([]byte("hello"))
+	assert.Nil(t, err)
+	n, err = job.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(buf[:n]))
+
+	// A Read already blocked is released by a deadline set meanwhile
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := job.Read(buf)
+		readErr <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	assert.Nil(t, job.SetReadDeadline(time.Now()))
+	select {
+	case err := <-readErr:
+		assert.True(t, errors.Is(err, os.ErrDeadlineExceeded), "%v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("blocked Read ignored the deadline")
+	}
+
+	// Clearing it restores normal blocking reads through to EOF
+	assert.Nil(t, job.SetReadDeadline(time.Time{}))
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		pw.Write([]byte(" world"))
+		pw.Close()
+	}()
+	rest, err := ioutil.ReadAll(job)
+	assert.Nil(t, err)
+	assert.Equal(t, " world", string(rest))
+	assert.Zero(t, job.Result())
+}
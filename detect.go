@@ -0,0 +1,165 @@
+package extcompress
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// How IsCompressed works out a file's type.
+type DetectionStrategy int
+
+const (
+	// The file's extension, checked against its format's magic bytes; then
+	// the magic bytes of every format; then libmagic, only if a registered
+	// compressor has no known magic bytes. Wrong extensions are caught for
+	// the cost of reading the first few bytes.
+	DetectCheapest DetectionStrategy = iota
+	// The file's extension alone, without opening the file
+	DetectExtension
+	// Magic bytes, then libmagic as for DetectCheapest, ignoring extensions
+	DetectContent
+	// libmagic, as GetFileTypeExternalHandler does
+	DetectLibmagic
+)
+
+// Guarded by registryMtx
+var detectionStrategy = DetectCheapest
+
+// Sets how IsCompressed detects file types. DetectCheapest by default.
+func SetDetectionStrategy(s DetectionStrategy) {
+	registryMtx.Lock()
+	defer registryMtx.Unlock()
+	detectionStrategy = s
+}
+
+// Leading bytes of the formats of the built-in handlers, by handler name.
+var contentMagics = map[string][]byte{
+	"bzip2": []byte("BZh"),
+	"gzip":  gzipMagic,
+	"xz":    xzHeaderMagic,
+	"lzop":  magics["lzop"],
+	"zstd":  {0x28, 0xb5, 0x2f, 0xfd},
+}
+
+// Number of libmagic queries IsCompressed has made, for tests.
+var libmagicQueries int64
+
+// Reports whether filePath looks compressed: whether its type maps to a
+// registered handler which isn't a passthrough. Also returns the mimetype,
+// which is empty if the file was ruled out without identifying its type.
+// Only as much detection as the strategy (see SetDetectionStrategy) needs
+// is done, so this is cheap enough to call on every file in a tree. Stdin
+// and devices are always sniffed (see StdioPath).
+func IsCompressed(filePath string) (bool, string, error) {
+	registryMtx.RLock()
+	strategy := detectionStrategy
+	extName := extMap[normalizeExtension(filepath.Ext(filePath))]
+	registryMtx.RUnlock()
+
+	if isStdioPath(filePath) {
+		buf, err := sniffStdio(filePath)
+		if err != nil {
+			return false, "", err
+		}
+		mimeType, err := GetBufferMimeType(buf)
+		if err != nil {
+			return false, "", err
+		}
+		return compressingMimeType(mimeType)
+	}
+	if strategy == DetectLibmagic {
+		return libmagicIsCompressed(filePath)
+	}
+	if strategy == DetectExtension {
+		if extName == "" {
+			return false, "", nil
+		}
+		return compressingMimeType(canonicalMimeType(extName))
+	}
+	if strategy == DetectContent {
+		extName = ""
+	}
+
+	header, err := readHeader(filePath)
+	if err != nil {
+		return false, "", err
+	}
+	// The extension's format is tried first, and failing that any other
+	if magic, ok := contentMagics[extName]; ok && bytes.HasPrefix(header, magic) {
+		return compressingMimeType(canonicalMimeType(extName))
+	}
+	for name, magic := range contentMagics {
+		if bytes.HasPrefix(header, magic) {
+			return compressingMimeType(canonicalMimeType(name))
+		}
+	}
+	if allMagicsKnown() {
+		return false, "", nil
+	}
+	return libmagicIsCompressed(filePath)
+}
+
+// Reads enough of filePath to check it against contentMagics.
+func readHeader(filePath string) ([]byte, error) {
+	size := 0
+	for _, magic := range contentMagics {
+		if len(magic) > size {
+			size = len(magic)
+		}
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	header := make([]byte, size)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	return header[:n], nil
+}
+
+func libmagicIsCompressed(filePath string) (bool, string, error) {
+	atomic.AddInt64(&libmagicQueries, 1)
+	mimeType, _, err := queryMimeType(context.Background(), mimeQuery{filePath: filePath})
+	if err != nil {
+		return false, "", err
+	}
+	return compressingMimeType(mimeType)
+}
+
+// Reports whether mimeType resolves to a handler which isn't a passthrough,
+// through aliases and wildcards as GetExternalHandlerFromMimeType would.
+func compressingMimeType(mimeType string) (bool, string, error) {
+	registryMtx.RLock()
+	defer registryMtx.RUnlock()
+	name, _, ok := resolveMimeType(mimeType)
+	return ok && !filtersMap[name].Passthrough, mimeType, nil
+}
+
+func canonicalMimeType(name string) string {
+	registryMtx.RLock()
+	defer registryMtx.RUnlock()
+	if mimeType, ok := canonicalMimeTypes[name]; ok {
+		return mimeType
+	}
+	return name
+}
+
+// True if every registered handler which isn't a passthrough has known magic
+// bytes, so a file matching none of them can't be compressed.
+func allMagicsKnown() bool {
+	registryMtx.RLock()
+	defer registryMtx.RUnlock()
+	for _, name := range mimeMap {
+		if _, ok := contentMagics[name]; !ok && !filtersMap[name].Passthrough {
+			return false
+		}
+	}
+	return true
+}
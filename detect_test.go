@@ -0,0 +1,114 @@
+package extcompress
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setDetectionStrategy(t *testing.T, s DetectionStrategy) {
+	registryMtx.RLock()
+	old := detectionStrategy
+	registryMtx.RUnlock()
+	SetDetectionStrategy(s)
+	t.Cleanup(func() { SetDetectionStrategy(old) })
+}
+
+// Writes the detection fixtures into dir: gzip data named correctly and
+// wrongly, and plain text named as gzip and as text.
+func writeDetectFixtures(t *testing.T, dir string) (gz, hiddenGz, fakeGz, text string) {
+	compressed, err := ioutil.ReadFile(writeCompressed(t, dir, "gzip", []byte(data)))
+	assert.Nil(t, err)
+	gz, hiddenGz = path.Join(dir, "real.gz"), path.Join(dir, "real.txt")
+	fakeGz, text = path.Join(dir, "fake.gz"), path.Join(dir, "plain.txt")
+	assert.Nil(t, ioutil.WriteFile(gz, compressed, os.FileMode(0644)))
+	assert.Nil(t, ioutil.WriteFile(hiddenGz, compressed, os.FileMode(0644)))
+	assert.Nil(t, ioutil.WriteFile(fakeGz, []byte(data), os.FileMode(0644)))
+	assert.Nil(t, ioutil.WriteFile(text, []byte(data), os.FileMode(0644)))
+	return
+}
+
+func TestIsCompressed(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	gz, hiddenGz, fakeGz, text := writeDetectFixtures(t, tmpdir)
+
+	for _, strategy := range []DetectionStrategy{DetectCheapest, DetectContent, DetectLibmagic} {
+		setDetectionStrategy(t, strategy)
+
+		for _, filePath := range []string{gz, hiddenGz} {
+			compressed, mimeType, err := IsCompressed(filePath)
+			assert.Nil(t, err)
+			assert.True(t, compressed, "%s with strategy %d", filePath, strategy)
+			assert.Contains(t, []string{"application/gzip", "application/x-gzip"}, mimeType)
+		}
+		for _, filePath := range []string{fakeGz, text} {
+			compressed, _, err := IsCompressed(filePath)
+			assert.Nil(t, err)
+			assert.False(t, compressed, "%s with strategy %d", filePath, strategy)
+		}
+	}
+
+	setDetectionStrategy(t, DetectCheapest)
+	_, _, err := IsCompressed(path.Join(tmpdir, "missing.gz"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestIsCompressedByExtension(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	gz, hiddenGz, fakeGz, text := writeDetectFixtures(t, tmpdir)
+	setDetectionStrategy(t, DetectExtension)
+
+	// Only the name is looked at, so it is fooled both ways
+	for filePath, expected := range map[string]bool{gz: true, hiddenGz: false, fakeGz: true, text: false} {
+		compressed, _, err := IsCompressed(filePath)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, compressed, filePath)
+	}
+	compressed, mimeType, err := IsCompressed(path.Join(tmpdir, "missing.xz"))
+	assert.Nil(t, err)
+	assert.True(t, compressed)
+	assert.Equal(t, "application/x-xz", mimeType)
+}
+
+func TestIsCompressedStrategyOrder(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	gz, hiddenGz, _, _ := writeDetectFixtures(t, tmpdir)
+
+	// The extension and magic bytes settle compressed files without libmagic
+	setDetectionStrategy(t, DetectCheapest)
+	before := atomic.LoadInt64(&libmagicQueries)
+	for _, filePath := range []string{gz, hiddenGz} {
+		compressed, _, err := IsCompressed(filePath)
+		assert.Nil(t, err)
+		assert.True(t, compressed)
+	}
+	assert.Equal(t, before, atomic.LoadInt64(&libmagicQueries))
+
+	setDetectionStrategy(t, DetectLibmagic)
+	_, _, err := IsCompressed(gz)
+	assert.Nil(t, err)
+	assert.Equal(t, before+1, atomic.LoadInt64(&libmagicQueries))
+}
+
+func TestCompressingMimeType(t *testing.T) {
+	for mimeType, expected := range map[string]bool{
+		"application/gzip":   true,
+		"application/x-gzip": true,
+		"application/zstd":   true,
+		"text/plain":         false,
+		"application/x-tar":  false,
+		"image/png":          false,
+	} {
+		compressed, reported, err := compressingMimeType(mimeType)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, compressed, mimeType)
+		assert.Equal(t, mimeType, reported)
+	}
+}
@@ -0,0 +1,63 @@
+package extcompress
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func waitDone(t *testing.T, job *CompressionJob) {
+	select {
+	case <-job.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Done was not closed")
+	}
+}
+
+func TestDoneAfterEOF(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	proc, err := h.CompressStream(bytes.NewReader([]byte("hello")))
+	assert.Nil(t, err)
+	job := proc.(*CompressionJob)
+
+	_, err = ioutil.ReadAll(job)
+	assert.Nil(t, err)
+	// Reaped in the background without a call to Result
+	waitDone(t, job)
+	assert.Equal(t, 0, job.Result())
+
+	// Reads after reaping keep reporting EOF
+	n, err := job.Read(make([]byte, 10))
+	assert.Equal(t, 0, n)
+	assert.Equal(t, "EOF", err.Error())
+}
+
+func TestDoneAfterResult(t *testing.T) {
+	proc, err := NewFilter("sh", CompressFlags("-c", "exit 3")).CompressStream(bytes.NewReader(nil))
+	assert.Nil(t, err)
+	job := proc.(*CompressionJob)
+
+	assert.Equal(t, 3, job.Result())
+	waitDone(t, job)
+}
+
+func TestDoneKilledJob(t *testing.T) {
+	proc, err := NewFilter("sleep", CompressFlags("60")).CompressStream(bytes.NewReader(nil))
+	assert.Nil(t, err)
+	job := proc.(*CompressionJob)
+
+	select {
+	case <-job.Done():
+		t.Fatal("Done closed while the job was running")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	job.kill()
+	waitDone(t, job)
+	assert.Equal(t, 0, job.Result())
+}
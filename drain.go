@@ -0,0 +1,227 @@
+package extcompress
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Outcome of decompressing one file with the output thrown away, filled in
+// whether or not it succeeded.
+type DrainResult struct {
+	Path string
+	// Size of the compressed file
+	InputBytes int64
+	// Bytes the file decompressed to, or -1 if they weren't counted and the
+	// tool didn't report them
+	OutputBytes int64
+	// Whether OutputBytes was counted by the package, rather than taken from
+	// the tool's verbose output, which may be rounded
+	Counted  bool
+	Duration time.Duration
+	// Exit status of the tool, or -1 if it didn't run or exit normally
+	ExitCode int
+	// The end of what the tool wrote to stderr
+	StderrTail string
+	// Why the file couldn't be read, or nil
+	Err error
+}
+
+// Counts what is written to it and keeps none of it.
+type countingDiscard struct {
+	n int64
+}
+
+func (w *countingDiscard) Write(p []byte) (int, error) {
+	atomic.AddInt64(&w.n, int64(len(p)))
+	return len(p), nil
+}
+
+// Lets exec copy the tool's output in through a pooled buffer.
+func (w *countingDiscard) ReadFrom(r io.Reader) (int64, error) {
+	return pooledWriter{struct{ io.Writer }{w}}.ReadFrom(r)
+}
+
+// Decompresses filePath, throwing the output away, to check it can be read
+// or to benchmark the tool. The tool writes straight to /dev/null, so none
+// of the output passes through this process unless Options.DrainCount asks
+// for it to be counted; otherwise OutputBytes comes from the tool's verbose
+// output where it has ProgressParsers. Corrupt input fails with a
+// CorruptInputError. The result is filled in on failure too, with Err the
+// same as the error returned.
+func (c Filter) DrainDecompress(filePath string) (DrainResult, error) {
+	started := time.Now()
+	res := DrainResult{Path: filePath, OutputBytes: -1, ExitCode: -1}
+	if st, err := os.Stat(filePath); err == nil {
+		res.InputBytes = st.Size()
+	}
+	tail := &stderrTail{}
+	defer tail.complete()
+	c.stderrCapture = tail
+
+	var err error
+	if c.drainsDirectly(filePath) {
+		err = c.drainDirect(filePath, &res)
+	} else {
+		err = c.drainThrough(filePath, &res)
+	}
+	res.Duration = time.Since(started)
+	res.ExitCode = exitCodeOf(err)
+	res.StderrTail = tail.String()
+	res.Err = err
+	return res, err
+}
+
+// True if the tool can be run on filePath with its stdout on /dev/null.
+// Otherwise the output is read through the package as for Decompress.
+func (c Filter) drainsDirectly(filePath string) bool {
+	return !isStdioPath(filePath) &&
+		!enabled(c.opts.Hardened) &&
+		!c.sourceReadOnly() &&
+		!c.RequiresTempOutput &&
+		!c.PreferFIFO &&
+		!hasPlaceholder(c.DecompressFlags, InputPlaceholder) &&
+		!hasPlaceholder(c.DecompressFlags, OutputPlaceholder) &&
+		c.fallbackDecoder(OpDecompress) == nil &&
+		!c.pathTooLong(OpDecompress, filePath)
+}
+
+// Runs the tool on filePath with its output on /dev/null, or counted.
+func (c Filter) drainDirect(filePath string, res *DrainResult) error {
+	id := c.newJobID()
+	jlog := c.jobLog(id).WithFields(log.Fields{"compressCmd": printable(c.Command), "filepath": printable(filePath)})
+	jlog.Info("External Decompression Command (discarding output)")
+
+	// The last size the tool reports is the total
+	reported := int64(-1)
+	if !enabled(c.opts.DrainCount) && len(c.ProgressParsers) > 0 {
+		user := c.opts.Progress
+		c.opts.Progress = func(p Progress) {
+			if p.Percent == 100 && p.Uncompressed >= 0 {
+				atomic.StoreInt64(&reported, p.Uncompressed)
+			}
+			if user != nil {
+				user(p)
+			}
+		}
+	}
+
+	args, err := c.buildArgs(false, c.DecompressFlags, argPath(filePath))
+	if err != nil {
+		return err
+	}
+	jlog.WithField("args", printableAll(c.redact(args))).Debug("External command arguments")
+	cmd := c.newCmd(args)
+	c.warningCapture = c.newWarningLog()
+	tail := c.decompressorStderr(cmd, id, "drain")
+
+	var counter *countingDiscard
+	if enabled(c.opts.DrainCount) {
+		counter = &countingDiscard{}
+		cmd.Stdout = counter
+	} else {
+		devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		defer devNull.Close()
+		cmd.Stdout = devNull
+	}
+
+	err = c.runCmd(jlog, cmd, "drain", id)
+	if status := exitStatusOf(err); status > 0 {
+		if tail.corruptInput() {
+			err = CorruptInputError{c.Command, status, tail.String(), id}
+		} else {
+			err = ExitStatusError{c.Command, status, id}
+		}
+	}
+	if err != nil {
+		jlog.WithField("error", err.Error()).Warn("Compression command failed.")
+		return err
+	}
+	if counter != nil {
+		res.OutputBytes, res.Counted = atomic.LoadInt64(&counter.n), true
+	} else {
+		res.OutputBytes = atomic.LoadInt64(&reported)
+	}
+	return nil
+}
+
+// Decompresses filePath through the package, counting the output.
+func (c Filter) drainThrough(filePath string, res *DrainResult) error {
+	proc, err := c.Decompress(filePath)
+	if err != nil {
+		return err
+	}
+	n, err := copyPooled(struct{ io.Writer }{ioutil.Discard}, proc)
+	if err != nil {
+		proc.Close()
+		return err
+	}
+	if job, ok := proc.(*CompressionJob); ok {
+		err = job.Err()
+	} else if status := statusOf(proc); status != 0 {
+		err = ExitStatusError{c.Command, status, proc.ID()}
+	}
+	if err != nil {
+		return err
+	}
+	res.OutputBytes, res.Counted = n, true
+	return nil
+}
+
+// Drains each of paths in turn as for DrainDecompress, also returning their
+// totals, in which a file's output is its decompressed size where known.
+// Options.OnSummary, if set, is given the running totals while it runs. A
+// file which fails doesn't stop the rest; the returned error is only for
+// cancellation.
+func (c Filter) DrainDecompressFiles(paths []string) ([]DrainResult, Summary, error) {
+	started := time.Now()
+	results := make([]DrainResult, len(paths))
+	opResults := make([]FileOpResult, len(paths))
+	live := c.trackSummary(len(paths))
+	var err error
+	done := 0
+	for _, p := range paths {
+		if err = c.contextErr(); err != nil {
+			break
+		}
+		results[done], _ = c.DrainDecompress(p)
+		opResults[done] = results[done].fileOpResult()
+		live.add(opResults, done)
+		done++
+	}
+	live.stop()
+	for i := done; i < len(paths); i++ {
+		results[i] = DrainResult{Path: paths[i], OutputBytes: -1, ExitCode: -1, Err: err}
+	}
+
+	s := Summarize(opResults[:done])
+	s.Considered = len(paths)
+	s.WallTime = time.Since(started)
+	return results, s, err
+}
+
+// Returns the result as a FileOpResult for summarizing.
+func (r DrainResult) fileOpResult() FileOpResult {
+	res := FileOpResult{
+		OriginalPath: r.Path,
+		InputBytes:   r.InputBytes,
+		Duration:     r.Duration,
+		ExitCode:     r.ExitCode,
+		StderrTail:   r.StderrTail,
+		Err:          r.Err,
+	}
+	if r.OutputBytes > 0 {
+		res.OutputBytes = r.OutputBytes
+		if r.InputBytes > 0 {
+			res.Ratio = float64(r.OutputBytes) / float64(r.InputBytes)
+		}
+	}
+	return res
+}
@@ -0,0 +1,168 @@
+package extcompress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDrainDecompress(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	original := seekableTestData(300000)
+	for _, command := range []string{"gzip", "zstd"} {
+		filename := writeCompressed(t, tmpdir, command, original)
+		h, err := GetFileTypeExternalHandler(filename)
+		assert.Nil(t, err)
+		st, err := os.Stat(filename)
+		assert.Nil(t, err)
+
+		res, err := h.DrainDecompress(filename)
+		assert.Nil(t, err, command)
+		assert.Equal(t, filename, res.Path)
+		assert.Equal(t, st.Size(), res.InputBytes)
+		assert.Zero(t, res.ExitCode)
+		assert.NotZero(t, res.Duration)
+		assert.False(t, res.Counted)
+
+		res, err = h.WithOptions(Options{DrainCount: Bool(true)}).DrainDecompress(filename)
+		assert.Nil(t, err, command)
+		assert.True(t, res.Counted)
+		assert.Equal(t, int64(len(original)), res.OutputBytes, command)
+	}
+
+	// zstd reports the exact size when verbose; gzip reports none
+	h, err := GetExternalHandlerFromMimeType("application/zstd")
+	assert.Nil(t, err)
+	res, err := h.DrainDecompress(path.Join(tmpdir, "zstd-input.zstd"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(original)), res.OutputBytes)
+	h, err = GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	res, err = h.DrainDecompress(path.Join(tmpdir, "gzip-input.gzip"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-1), res.OutputBytes)
+}
+
+func TestDrainDecompressCorrupt(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	filename := writeCompressed(t, tmpdir, "gzip", seekableTestData(300000))
+	compressed, err := ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.Nil(t, ioutil.WriteFile(filename, compressed[:len(compressed)/2], os.FileMode(0644)))
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	for _, opts := range []Options{{}, {DrainCount: Bool(true)}, {SourceReadOnly: Bool(true)}} {
+		res, err := h.WithOptions(opts).DrainDecompress(filename)
+		assert.True(t, errors.Is(err, ErrCorruptInput), "%+v: %v", opts, err)
+		assert.Equal(t, err, res.Err)
+		assert.NotZero(t, res.ExitCode)
+		assert.NotEmpty(t, res.StderrTail)
+	}
+
+	_, err = h.DrainDecompress(path.Join(tmpdir, "missing.gz"))
+	assert.NotNil(t, err)
+}
+
+func TestDrainDecompressThroughPackage(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	original := seekableTestData(100000)
+	filename := writeCompressed(t, tmpdir, "gzip", original)
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	// The tool never gets the path of a read-only source, so the output
+	// comes through the package and is counted anyway
+	res, err := h.WithOptions(Options{SourceReadOnly: Bool(true)}).DrainDecompress(filename)
+	assert.Nil(t, err)
+	assert.True(t, res.Counted)
+	assert.Equal(t, int64(len(original)), res.OutputBytes)
+}
+
+func TestDrainDecompressFiles(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	original := seekableTestData(100000)
+	good := writeCompressed(t, tmpdir, "gzip", original)
+	bad := path.Join(tmpdir, "bad.gz")
+	assert.Nil(t, ioutil.WriteFile(bad, []byte("not gzip data"), os.FileMode(0644)))
+
+	var live []Summary
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	h = h.WithOptions(Options{DrainCount: Bool(true), OnSummary: func(s Summary) { live = append(live, s) }})
+	results, summary, err := h.DrainDecompressFiles([]string{good, bad})
+	assert.Nil(t, err)
+	assert.Len(t, results, 2)
+	assert.Nil(t, results[0].Err)
+	assert.True(t, errors.Is(results[1].Err, ErrCorruptInput))
+	assert.Equal(t, 2, summary.Considered)
+	assert.Equal(t, 1, summary.Succeeded)
+	assert.Equal(t, 1, summary.Failed)
+	assert.Equal(t, int64(len(original)), summary.OutputBytes)
+	assert.Equal(t, map[string]int{"corrupt_input": 1}, summary.Errors)
+	assert.NotEmpty(t, live)
+
+	// Cancellation stops the sweep, marking the rest
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results, summary, err = h.WithContext(ctx).DrainDecompressFiles([]string{good, bad})
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, context.Canceled, results[1].Err)
+	assert.Zero(t, summary.Succeeded)
+}
+
+func benchmarkDrain(b *testing.B, drain func(h ExternalHandler, filename string) error) {
+	for _, mb := range []int{10, 50} {
+		b.Run(fmt.Sprintf("%dMB", mb), func(b *testing.B) {
+			tmpdir, err := ioutil.TempDir("", "extcompress_bench")
+			assert.Nil(b, err)
+			defer os.RemoveAll(tmpdir)
+			h, err := GetExternalHandlerFromMimeType("application/gzip")
+			assert.Nil(b, err)
+			filename := writeCompressed(b, tmpdir, "gzip", seekableTestData(mb<<20))
+
+			b.SetBytes(int64(mb << 20))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := drain(h, filename); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDrainDecompress(b *testing.B) {
+	benchmarkDrain(b, func(h ExternalHandler, filename string) error {
+		_, err := h.DrainDecompress(filename)
+		return err
+	})
+}
+
+func BenchmarkDecompressCopyDiscard(b *testing.B) {
+	benchmarkDrain(b, func(h ExternalHandler, filename string) error {
+		proc, err := h.Decompress(filename)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(ioutil.Discard, proc); err != nil {
+			return err
+		}
+		return processErr(proc)
+	})
+}
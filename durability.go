@@ -0,0 +1,73 @@
+package extcompress
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// How hard file producing operations work to make their output survive a
+// crash or power loss.
+type Durability int
+
+const (
+	// Not set, leaving it to the defaults, and failing them DurabilityNone
+	DurabilityUnset Durability = iota
+	// Leave flushing to the OS
+	DurabilityNone
+	// Flush the output file's data before the original is removed
+	DurabilityDataOnly
+	// Flush the output file and its directory entry before the original is
+	// removed or replaced
+	DurabilityFull
+)
+
+// The sync calls, indirected so tests can observe them.
+var (
+	syncData = fdatasync
+	syncFull = func(f *os.File) error { return f.Sync() }
+)
+
+// True if output is flushed at all.
+func (d Durability) syncs() bool {
+	return d == DurabilityDataOnly || d == DurabilityFull
+}
+
+// Flushes f as the handler's durability requires.
+func (c Filter) syncOutput(f *os.File) error {
+	switch c.opts.Durability {
+	case DurabilityDataOnly:
+		return syncData(f)
+	case DurabilityFull:
+		return syncFull(f)
+	}
+	return nil
+}
+
+// Flushes the directory containing path, persisting a rename or creation,
+// when the handler's durability is Full.
+func (c Filter) syncParent(path string) error {
+	if c.opts.Durability != DurabilityFull {
+		return nil
+	}
+	d, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return syncFull(d)
+}
+
+// Returns true if in-place operations must be done by the package rather
+// than the tool, which removes the original however its output fared.
+func (c Filter) packageInPlace(compress bool) bool {
+	if c.Passthrough {
+		return false
+	}
+	if c.RequiresTempOutput || c.PreferFIFO {
+		return true
+	}
+	if compress && (c.opts.FileChange.checks() || c.opts.RatioGuard != nil || enabled(c.opts.VerifyOutputFormat)) {
+		return true
+	}
+	return c.opts.Durability.syncs() || enabled(c.opts.Hardened)
+}
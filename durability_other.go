@@ -0,0 +1,12 @@
+//go:build !linux
+
+package extcompress
+
+import (
+	"os"
+)
+
+// fdatasync isn't available everywhere, so flush everything instead.
+func fdatasync(f *os.File) error {
+	return f.Sync()
+}
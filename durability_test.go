@@ -0,0 +1,123 @@
+package extcompress
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type syncRecord struct {
+	kind string
+	name string
+	// Whether the watched original still existed at the time
+	original bool
+}
+
+// Hooks the sync calls for the duration of the test, recording each one and
+// whether original still existed when it was made.
+func recordSyncs(t *testing.T, original string) *[]syncRecord {
+	var records []syncRecord
+	oldData, oldFull := syncData, syncFull
+	record := func(kind string, f *os.File) {
+		_, err := os.Stat(original)
+		records = append(records, syncRecord{kind, f.Name(), err == nil})
+	}
+	syncData = func(f *os.File) error {
+		record("data", f)
+		return oldData(f)
+	}
+	syncFull = func(f *os.File) error {
+		record("full", f)
+		return oldFull(f)
+	}
+	t.Cleanup(func() { syncData, syncFull = oldData, oldFull })
+	return &records
+}
+
+func TestDurabilityNone(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	filename := path.Join(tmpdir, "pipechaining")
+	records := recordSyncs(t, filename)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	assert.Nil(t, h.CompressFileInPlace(filename))
+	assert.Empty(t, *records)
+}
+
+func TestDurabilityInPlace(t *testing.T) {
+	for _, mimeType := range []string{"application/gzip", "application/x-bzip2"} {
+		tmpdir := setupTestDir(t)
+		defer os.RemoveAll(tmpdir)
+		filename := path.Join(tmpdir, "pipechaining")
+
+		h, err := GetExternalHandlerFromMimeType(mimeType)
+		assert.Nil(t, err)
+
+		// Data only: the output is flushed while the original still exists
+		records := recordSyncs(t, filename)
+		assert.Nil(t, h.WithOptions(Options{Durability: DurabilityDataOnly}).CompressFileInPlace(filename))
+		assert.Len(t, *records, 1)
+		assert.Equal(t, "data", (*records)[0].kind)
+		assert.True(t, (*records)[0].original)
+		_, err = os.Stat(filename)
+		assert.True(t, os.IsNotExist(err))
+
+		// Full: the output and then its directory are flushed before the
+		// original is removed
+		compressed := filename + h.(Filter).suffix()
+		records = recordSyncs(t, compressed)
+		out, err := h.WithOptions(Options{Durability: DurabilityFull}).DecompressFileInPlaceWithOptions(compressed, InPlaceOptions{})
+		assert.Nil(t, err)
+		assert.Equal(t, filename, out)
+		assert.Len(t, *records, 2)
+		assert.Equal(t, "full", (*records)[0].kind)
+		assert.Equal(t, syncRecord{"full", tmpdir, true}, (*records)[1])
+		assert.True(t, (*records)[0].original)
+		assertNoTempFiles(t, tmpdir)
+	}
+}
+
+func TestDurabilityFileOutputs(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	records := recordSyncs(t, tmpdir)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	h = h.WithOptions(Options{Durability: DurabilityFull})
+
+	dst := path.Join(tmpdir, "out.gz")
+	w, err := h.CompressIntoFile(dst)
+	assert.Nil(t, err)
+	w.Write([]byte("data"))
+	assert.Nil(t, w.Close())
+	assert.Len(t, *records, 2)
+	assert.Equal(t, tmpdir, filepath.Dir((*records)[0].name))
+	assert.Equal(t, tmpdir, (*records)[1].name)
+
+	// Even by default, the output is synced before it is renamed into place
+	*records = nil
+	gz, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	w, err = gz.CompressIntoFile(path.Join(tmpdir, "default.gz"))
+	assert.Nil(t, err)
+	w.Write([]byte("data"))
+	assert.Nil(t, w.Close())
+	if assert.Len(t, *records, 1) {
+		assert.Equal(t, "full", (*records)[0].kind)
+		assert.Equal(t, tmpdir, filepath.Dir((*records)[0].name))
+		assert.NotEqual(t, path.Join(tmpdir, "default.gz"), (*records)[0].name)
+	}
+
+	*records = nil
+	assert.Nil(t, h.DecompressToFile(dst, path.Join(tmpdir, "out")))
+	assert.Equal(t, []syncRecord{
+		{"full", path.Join(tmpdir, "out"), true},
+		{"full", tmpdir, true},
+	}, *records)
+}
@@ -0,0 +1,124 @@
+package extcompress
+
+import (
+	"os"
+	"strings"
+	"sync"
+)
+
+// Environment variables the compressors read their own defaults from. Left
+// in place these silently change the output, so they are removed from child
+// environments unless InheritCompressionEnv(true) is set.
+var compressionEnvKnobs = []string{
+	"GZIP",
+	"BZIP", "BZIP2",
+	"XZ_OPT", "XZ_DEFAULTS",
+	"LZOP",
+	"ZSTD_CLEVEL", "ZSTD_NBTHREADS",
+}
+
+var (
+	inheritEnvMtx sync.RWMutex
+	inheritEnv    bool
+)
+
+// Controls whether spawned compressors see the compressor knob variables
+// (GZIP, XZ_OPT, ZSTD_CLEVEL etc.) from this process's environment. They are
+// scrubbed by default so output doesn't depend on the service environment.
+func InheritCompressionEnv(inherit bool) {
+	inheritEnvMtx.Lock()
+	defer inheritEnvMtx.Unlock()
+	inheritEnv = inherit
+}
+
+func inheritsCompressionEnv() bool {
+	inheritEnvMtx.RLock()
+	defer inheritEnvMtx.RUnlock()
+	return inheritEnv
+}
+
+// Held while the package changes this process's environment, see loadMagic,
+// so children don't start with the change.
+var environMtx sync.RWMutex
+
+// Returns this process's environment, as os.Environ does, without any change
+// the package is partway through making.
+func environ() []string {
+	environMtx.RLock()
+	defer environMtx.RUnlock()
+	return os.Environ()
+}
+
+// Set for tools whose output the package parses (progress, version and
+// error messages), so it isn't translated on hosts with other locales.
+var cLocaleEnv = []string{"LANG=C", "LC_ALL=C"}
+
+// Returns env, or this process's environment if it is nil, with the C
+// locale forced.
+func withCLocale(env []string) []string {
+	if env == nil {
+		env = environ()
+	}
+	filtered := make([]string, 0, len(env)+len(cLocaleEnv))
+	for _, kv := range env {
+		if !strings.HasPrefix(kv, "LANG=") && !strings.HasPrefix(kv, "LC_ALL=") {
+			filtered = append(filtered, kv)
+		}
+	}
+	return append(filtered, cLocaleEnv...)
+}
+
+// Returns the variables the options set in the child's environment.
+func (c Filter) envOverrides() []string {
+	var env []string
+	if c.opts.TempDir != "" {
+		for _, name := range append([]string{"TMPDIR"}, c.TempDirEnv...) {
+			env = append(env, name+"="+c.opts.TempDir)
+		}
+	}
+	// Hermetic tools also need the C locale, so messages don't vary between
+	// hosts, and UTC for anything derived from the clock
+	if enabled(c.opts.Hermetic) || c.opts.Progress != nil {
+		env = append(env, cLocaleEnv...)
+	}
+	if enabled(c.opts.Hermetic) {
+		env = append(env, "TZ=UTC")
+	}
+	return env
+}
+
+// Returns the environment for a child process: this process's environment
+// with the compressor knobs and the filter's ScrubEnv entries removed, and
+// the options' overrides applied.
+func (c Filter) childEnv() []string {
+	env := environ()
+	overrides := c.envOverrides()
+	if inheritsCompressionEnv() && len(c.ScrubEnv) == 0 && len(overrides) == 0 {
+		return env
+	}
+
+	scrub := map[string]bool{}
+	for _, kv := range overrides {
+		scrub[kv[:strings.IndexByte(kv, '=')]] = true
+	}
+	if !inheritsCompressionEnv() || enabled(c.opts.Hermetic) {
+		for _, name := range compressionEnvKnobs {
+			scrub[name] = true
+		}
+	}
+	for _, name := range c.ScrubEnv {
+		scrub[name] = true
+	}
+
+	filtered := make([]string, 0, len(env))
+	for _, kv := range env {
+		name := kv
+		if i := strings.IndexByte(kv, '='); i >= 0 {
+			name = kv[:i]
+		}
+		if !scrub[name] {
+			filtered = append(filtered, kv)
+		}
+	}
+	return append(filtered, overrides...)
+}
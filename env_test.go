@@ -0,0 +1,108 @@
+package extcompress
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func compressBytes(t *testing.T, h ExternalHandler, input []byte) []byte {
+	job, err := h.CompressStream(bytes.NewReader(input))
+	assert.Nil(t, err)
+	out, err := ioutil.ReadAll(job)
+	assert.Nil(t, err)
+	assert.Zero(t, job.Result())
+	return out
+}
+
+func TestCompressionEnvScrubbed(t *testing.T) {
+	input := seekableTestData(200000)
+
+	// The reference output from a completely clean environment
+	cmd := exec.Command("gzip", "-c")
+	cmd.Env = []string{}
+	cmd.Stdin = bytes.NewReader(input)
+	expected, err := cmd.Output()
+	assert.Nil(t, err)
+
+	t.Setenv("GZIP", "-1")
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	assert.Equal(t, expected, compressBytes(t, h, input))
+
+	// Opting out lets the knob through again
+	InheritCompressionEnv(true)
+	defer InheritCompressionEnv(false)
+	assert.NotEqual(t, expected, compressBytes(t, h, input))
+}
+
+func TestChildEnv(t *testing.T) {
+	t.Setenv("XZ_OPT", "-9")
+	t.Setenv("MY_KNOB", "1")
+	t.Setenv("LANG", "C")
+
+	f := Filter{Command: "env", ScrubEnv: []string{"MY_KNOB"}}
+	env := f.childEnv()
+	assert.Contains(t, env, "LANG=C")
+	assert.NotContains(t, env, "XZ_OPT=-9")
+	assert.NotContains(t, env, "MY_KNOB=1")
+
+	// The per-filter list still applies when inheriting the knobs
+	InheritCompressionEnv(true)
+	defer InheritCompressionEnv(false)
+	env = f.childEnv()
+	assert.Contains(t, env, "XZ_OPT=-9")
+	assert.NotContains(t, env, "MY_KNOB=1")
+}
+
+func TestWithTempDir(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	// A fake filter which reports the environment it was run with
+	f := Filter{
+		Command:             "sh",
+		CompressStreamFlags: []string{"-c", "cat >/dev/null; env"},
+		TempDirEnv:          []string{"TMP"},
+	}
+	h := f.WithTempDir(tmpdir)
+
+	plan := h.Plan()
+	assert.Nil(t, plan.Err)
+	assert.Equal(t, tmpdir, plan.Options.TempDir)
+	assert.Equal(t, []string{"TMPDIR=" + tmpdir, "TMP=" + tmpdir}, plan.Env)
+
+	out := compressBytes(t, h, nil)
+	lines := strings.Split(string(out), "\n")
+	assert.Contains(t, lines, "TMPDIR="+tmpdir)
+	assert.Contains(t, lines, "TMP="+tmpdir)
+
+	// Without the option the parent's TMPDIR is inherited
+	t.Setenv("TMPDIR", "/parent/tmp")
+	out = compressBytes(t, f, nil)
+	assert.Contains(t, strings.Split(string(out), "\n"), "TMPDIR=/parent/tmp")
+}
+
+func TestWithTempDirValidation(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	h, err := GetExternalHandlerFromMimeType("application/x-xz")
+	assert.Nil(t, err)
+
+	for _, dir := range []string{path.Join(tmpdir, "missing"), path.Join(tmpdir, "pipechaining")} {
+		bad := h.WithTempDir(dir)
+		assert.IsType(t, InvalidOption{}, bad.Plan().Err)
+		_, err := bad.CompressStream(bytes.NewReader(nil))
+		assert.IsType(t, InvalidOption{}, err)
+	}
+
+	assert.IsType(t, InvalidOption{}, SetDefaultOptions("xz", Options{TempDir: path.Join(tmpdir, "missing")}))
+}
@@ -0,0 +1,103 @@
+package extcompress
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Environment variables which set package-wide default options. These sit
+// below SetDefaultOptions and per-call options in precedence, and above the
+// tools' own defaults.
+const (
+	EnvLevel   = "EXTCOMPRESS_LEVEL"
+	EnvThreads = "EXTCOMPRESS_THREADS"
+)
+
+var (
+	envOptionsMtx sync.RWMutex
+	envOptions    Options
+)
+
+func init() {
+	loadEnvOptions()
+}
+
+// Reads the environment options. Each handler only takes the values it can
+// accept; anything a registered handler can't accept is skipped for it with a
+// warning, rather than failing every operation later on. Returns the
+// warnings which were logged.
+func loadEnvOptions() []string {
+	var warnings []string
+	warn := func(format string, args ...interface{}) {
+		msg := fmt.Sprintf(format, args...)
+		log.WithField("extcompress", "environment").Warn(msg)
+		warnings = append(warnings, msg)
+	}
+
+	parse := func(name string) *int {
+		value, ok := os.LookupEnv(name)
+		if !ok || value == "" {
+			return nil
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			warn("%s=%q is not an integer; ignoring it", name, value)
+			return nil
+		}
+		return Int(n)
+	}
+
+	env := Options{
+		Level:   parse(EnvLevel),
+		Threads: parse(EnvThreads),
+	}
+
+	registryMtx.RLock()
+	// Sorted so warnings come out in a stable order
+	var names []string
+	for name := range filtersMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		filter := filtersMap[name]
+		if env.Level != nil && filter.LevelFlag != "" {
+			if err := filter.validateOptions(Options{Level: env.Level}); err != nil {
+				warn("%s=%d ignored for %s: %v", EnvLevel, *env.Level, name, err)
+			}
+		}
+		if env.Threads != nil && filter.ThreadsFlag != "" {
+			if err := filter.validateOptions(Options{Threads: env.Threads}); err != nil {
+				warn("%s=%d ignored for %s: %v", EnvThreads, *env.Threads, name, err)
+			}
+		}
+	}
+	registryMtx.RUnlock()
+
+	envOptionsMtx.Lock()
+	defer envOptionsMtx.Unlock()
+	envOptions = env
+	return warnings
+}
+
+// Returns the environment options which apply to filter.
+func (c Filter) envOptions() Options {
+	envOptionsMtx.RLock()
+	env := envOptions
+	envOptionsMtx.RUnlock()
+
+	var opts Options
+	if env.Level != nil && c.LevelFlag != "" && c.validateOptions(Options{Level: env.Level}) == nil {
+		opts.Level = env.Level
+	}
+	if env.Threads != nil && c.ThreadsFlag != "" && c.validateOptions(Options{Threads: env.Threads}) == nil {
+		opts.Threads = env.Threads
+	}
+	return opts
+}
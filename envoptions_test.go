@@ -0,0 +1,68 @@
+package extcompress
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Sets the environment options for the duration of the test.
+func setEnvOptions(t *testing.T, level string, threads string) []string {
+	// Registered first so it runs after t.Setenv has restored the environment
+	t.Cleanup(func() { loadEnvOptions() })
+	t.Setenv(EnvLevel, level)
+	t.Setenv(EnvThreads, threads)
+	return loadEnvOptions()
+}
+
+func TestEnvOptionsPrecedence(t *testing.T) {
+	warnings := setEnvOptions(t, "3", "2")
+	// 3 is fine everywhere, threads only apply where supported
+	assert.Empty(t, warnings)
+
+	h, err := GetExternalHandlerFromMimeType("application/x-xz")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"xz", "-c", "-3", "-T2"}, h.Plan().CompressStream)
+
+	gh, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"gzip", "-c", "-3"}, gh.Plan().CompressStream)
+
+	// Programmatic defaults beat the environment
+	assert.Nil(t, SetDefaultOptions("application/x-xz", Options{Level: Int(6)}))
+	defer ClearDefaultOptions("application/x-xz")
+	h, err = GetExternalHandlerFromMimeType("application/x-xz")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"xz", "-c", "-6", "-T2"}, h.Plan().CompressStream)
+
+	// Per-call options beat both
+	h = h.WithLevel(9).WithThreads(1)
+	assert.Equal(t, []string{"xz", "-c", "-9", "-T1"}, h.Plan().CompressStream)
+}
+
+func TestEnvOptionsInvalid(t *testing.T) {
+	// 15 is valid for zstd only
+	warnings := setEnvOptions(t, "15", "")
+	assert.Equal(t, []string{
+		"EXTCOMPRESS_LEVEL=15 ignored for bzip2: bzip2: invalid level: 15 is outside the range 1-9",
+		"EXTCOMPRESS_LEVEL=15 ignored for gzip: gzip: invalid level: 15 is outside the range 1-9",
+		"EXTCOMPRESS_LEVEL=15 ignored for lzop: lzop: invalid level: 15 is outside the range 1-9",
+		"EXTCOMPRESS_LEVEL=15 ignored for xz: xz: invalid level: 15 is outside the range 0-9",
+	}, warnings)
+
+	gh, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	assert.Nil(t, gh.Plan().Err)
+	assert.Equal(t, []string{"gzip", "-c"}, gh.Plan().CompressStream)
+
+	zh, err := GetExternalHandlerFromMimeType("application/zstd")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"zstd", "-q", "-c", "-15"}, zh.Plan().CompressStream)
+
+	warnings = setEnvOptions(t, "fast", "-1")
+	assert.Equal(t, []string{
+		`EXTCOMPRESS_LEVEL="fast" is not an integer; ignoring it`,
+		"EXTCOMPRESS_THREADS=-1 ignored for xz: xz: invalid threads: must not be negative",
+		"EXTCOMPRESS_THREADS=-1 ignored for zstd: zstd: invalid threads: must not be negative",
+	}, warnings)
+}
@@ -0,0 +1,73 @@
+package extcompress
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// The input file of a job, for estimating its progress.
+type jobInput struct {
+	path    string
+	size    int64
+	started time.Time
+}
+
+// Records the file a job is reading, so Progress can estimate how far
+// through it the tool is.
+func (this *CompressionJob) setInput(filePath string) {
+	path, err := filepath.Abs(filePath)
+	if err != nil {
+		return
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	this.input = &jobInput{path, st.Size(), time.Now()}
+}
+
+// Returns how much input the job has had: the size of its input file, or
+// what it has read from its stream. -1 if unknown.
+func (this *CompressionJob) bytesIn() int64 {
+	if this.input != nil {
+		return this.input.size
+	}
+	if this.stdin != nil {
+		return atomic.LoadInt64(&this.stdin.consumed)
+	}
+	return -1
+}
+
+// Returns how many bytes of its input file the job has consumed, the input's
+// total size, and an estimate of the time remaining based on the rate so far.
+// ok is false if progress can't be known: for streaming jobs, whose input
+// size is unknown, and on platforms other than Linux, where the tool's read
+// offset can't be inspected.
+//
+// The offset is the tool's read position, which runs ahead of its output by
+// however much it buffers, and the estimate assumes a steady rate, so it is
+// least accurate early on and for inputs which vary in compressibility.
+func (this *CompressionJob) Progress() (done int64, total int64, eta time.Duration, ok bool) {
+	if this.input == nil {
+		return 0, 0, 0, false
+	}
+	total = this.input.size
+	if this.isReaped() {
+		return total, total, 0, true
+	}
+	done, err := processFileOffset(this.cmd.Process.Pid, this.input.path)
+	if err != nil {
+		return 0, total, 0, false
+	}
+	if done > total {
+		done = total
+	}
+	if done == 0 {
+		return 0, total, 0, false
+	}
+	elapsed := time.Since(this.input.started)
+	eta = time.Duration(float64(elapsed) * float64(total-done) / float64(done))
+	return done, total, eta, true
+}
@@ -0,0 +1,88 @@
+package extcompress
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobProgressETA(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	// Poorly compressible, so the output paces how fast the input is read
+	raw := make([]byte, 6*mib)
+	rand.New(rand.NewSource(1)).Read(raw)
+	filename := path.Join(tmpdir, "random")
+	assert.Nil(t, ioutil.WriteFile(filename, []byte(base64.StdEncoding.EncodeToString(raw)), os.FileMode(0644)))
+	st, err := os.Stat(filename)
+	assert.Nil(t, err)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	proc, err := h.WithLevel(1).Compress(filename)
+	assert.Nil(t, err)
+	job := proc.(*CompressionJob)
+
+	// Rate limit by reading the output slowly, sampling progress as we go
+	type sample struct {
+		done, total int64
+		eta         time.Duration
+		at          time.Time
+	}
+	var samples []sample
+	buf := make([]byte, 64<<10)
+	for {
+		_, err := io.ReadFull(job, buf)
+		if err != nil {
+			break
+		}
+		if done, total, eta, ok := job.Progress(); ok {
+			samples = append(samples, sample{done, total, eta, time.Now()})
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	finished := time.Now()
+	assert.Equal(t, 0, job.Result())
+
+	assert.True(t, len(samples) > 10, "too few progress samples: %d", len(samples))
+	var last int64
+	for _, s := range samples {
+		assert.Equal(t, st.Size(), s.total)
+		assert.True(t, s.done >= last, "progress went backwards")
+		assert.True(t, s.done <= s.total)
+		last = s.done
+	}
+
+	// Past the halfway point the estimate should be in the right ballpark
+	mid := samples[len(samples)/2]
+	actual := finished.Sub(mid.at)
+	assert.True(t, mid.eta > actual/4 && mid.eta < actual*4,
+		"estimate %v too far from actual %v", mid.eta, actual)
+
+	// Once finished the job is complete
+	done, total, eta, ok := job.Progress()
+	assert.True(t, ok)
+	assert.Equal(t, total, done)
+	assert.Equal(t, time.Duration(0), eta)
+}
+
+func TestJobProgressStream(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	proc, err := h.CompressStream(bytes.NewReader([]byte("hello")))
+	assert.Nil(t, err)
+	defer proc.Result()
+
+	_, _, _, ok := proc.(*CompressionJob).Progress()
+	assert.False(t, ok)
+	ioutil.ReadAll(proc)
+}
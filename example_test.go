@@ -0,0 +1,44 @@
+package extcompress_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/wrouesnel/extcompress"
+)
+
+// Code written against compress/gzip switches to another tool by changing
+// only the constructors.
+func ExampleNewWriter() {
+	xz, err := extcompress.GetExternalHandlerFromMimeType("application/x-xz")
+	if err != nil {
+		panic(err)
+	}
+
+	var buf bytes.Buffer
+	// Was: zw := gzip.NewWriter(&buf)
+	zw, err := extcompress.NewWriter(&buf, xz)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Fprint(zw, "hello, world")
+	if err := zw.Close(); err != nil {
+		panic(err)
+	}
+
+	// Was: zr, err := gzip.NewReader(&buf)
+	zr, err := extcompress.NewReader(&buf, xz)
+	if err != nil {
+		panic(err)
+	}
+	out, err := ioutil.ReadAll(zr)
+	if err != nil {
+		panic(err)
+	}
+	if err := zr.Close(); err != nil {
+		panic(err)
+	}
+	fmt.Println(string(out))
+	// Output: hello, world
+}
@@ -0,0 +1,49 @@
+package extcompress
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Lets the conformance tests, which are outside the package, check the
+// built-in handlers.
+var InstalledHandlers = installedHandlers
+
+// Returns every registered handler whose tool is installed, by name.
+func installedHandlers(t *testing.T) map[string]ExternalHandler {
+	registryMtx.RLock()
+	defer registryMtx.RUnlock()
+
+	handlers := map[string]ExternalHandler{}
+	for name, f := range filtersMap {
+		if _, err := exec.LookPath(f.Command); err != nil {
+			t.Logf("%s not available, not checking %s", f.Command, name)
+			continue
+		}
+		f.mimeType = canonicalMimeTypes[name]
+		handlers[name] = f
+	}
+	return handlers
+}
+
+// Reads the whole of a job's output and checks it exited cleanly.
+func readJob(t *testing.T, proc CompressionProcess, err error, what string) []byte {
+	if !assert.Nil(t, err, what) {
+		return nil
+	}
+	out, err := ioutil.ReadAll(proc)
+	assert.Nil(t, err, what)
+	assert.Zero(t, proc.Result(), what)
+	return out
+}
+
+// Lets empty outputs compare equal to empty inputs.
+func nonNil(b []byte) []byte {
+	if b == nil {
+		return []byte{}
+	}
+	return b
+}
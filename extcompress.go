@@ -12,14 +12,18 @@ import (
 	"syscall"
 	"os/exec"
 	"io"
-	"strings"
 	"github.com/rakyll/magicmime"
 	"sync"
+	"sync/atomic"
+	"context"
 	
 	log "github.com/Sirupsen/logrus"
 	//"github.com/davecgh/go-spew/spew"
 	"os"
 	"bytes"
+	"fmt"
+	"errors"
+	"time"
 )
 
 // LZO isn't reliably recognized by mimemagic, so we need to define this
@@ -43,8 +47,15 @@ var mimeMap map[string]string = map[string]string {
 	"application/x-lzop" : "lzop",
 	"lzop" : "lzop",
 
+	"application/zstd" : "zstd",
+	"application/x-zstd" : "zstd",
+	"zstd" : "zstd",
+
+	"application/x-tar" : "cat",
+	"application/x-gtar" : "cat",
+
 	"text/plain" : "cat",
-	"text" : "cat",
+	"text/*" : "cat",
 	"application/x-empty" : "cat",
 	"inode/x-empty" : "cat",
 }
@@ -53,6 +64,7 @@ var mimeMap map[string]string = map[string]string {
 var filtersMap map[string]Filter = map[string]Filter{
 	"bzip2" : Filter{
 		Command: "bzip2",
+		Format: FormatBzip2,
 		CompressFlags: []string{"-c"},
 		DecompressFlags: []string{"-d", "-c"},
 
@@ -61,9 +73,20 @@ var filtersMap map[string]Filter = map[string]Filter{
 
 		CompressInPlaceFlags: []string{},
 		DecompressInPlaceFlags: []string{"-d"},
+
+		Extensions: []string{".bz2"},
+		AlreadySuffixedMessages: []string{"already has"},
+
+		LevelFlag: "-%d",
+		MinLevel: 1,
+		MaxLevel: 9,
+
+		SingleThreaded: true,
+		VersionFlags: []string{"--version"},
 	},
 	"gzip" : Filter{
 		Command: "gzip",
+		Format: FormatGzip,
 		CompressFlags: []string{"-c"},
 		DecompressFlags: []string{"-d", "-c"},
 
@@ -72,9 +95,33 @@ var filtersMap map[string]Filter = map[string]Filter{
 
 		CompressInPlaceFlags: []string{},
 		DecompressInPlaceFlags: []string{"-d"},
+
+		Extensions: []string{".gz"},
+		SuffixFlag: "-S",
+		AlreadySuffixedMessages: []string{"suffix -- unchanged"},
+		WarningExitStatuses: []int{2},
+		WarningMessages: []string{"warning:", "trailing garbage ignored"},
+
+		RestoreNameFlag: "-N",
+		IgnoreNameFlag: "-n",
+
+		LevelFlag: "-%d",
+		MinLevel: 1,
+		MaxLevel: 9,
+
+		SingleThreaded: true,
+		HermeticFlags: []string{"-n"},
+		VersionFlags: []string{"--version"},
+
+		VerboseFlags: []string{"-v"},
+		ProgressParsers: gzipProgressParsers,
 	},
 	"xz" : Filter{
 		Command: "xz",
+		Format: FormatXz,
+		// Multithreaded (the default since 5.4) it writes nothing until a
+		// whole block, tens of MiB, has been read
+		Latency: LatencyStoreAndForward,
 		CompressFlags: []string{"-c"},
 		DecompressFlags: []string{"-d", "-c"},
 
@@ -83,9 +130,26 @@ var filtersMap map[string]Filter = map[string]Filter{
 
 		CompressInPlaceFlags: []string{},
 		DecompressInPlaceFlags: []string{"-d"},
+
+		Extensions: []string{".xz"},
+		SuffixFlag: "-S",
+		AlreadySuffixedMessages: []string{"already has", "suffix, skipping"},
+		WarningExitStatuses: []int{2},
+
+		LevelFlag: "-%d",
+		MinLevel: 0,
+		MaxLevel: 9,
+		ThreadsFlag: "-T%d",
+
+		VersionFlags: []string{"--version"},
+		MinVersion: "5.2.0",	// Threads
+
+		VerboseFlags: []string{"-v"},
+		ProgressParsers: xzProgressParsers,
 	},
 	"lzop" : Filter{
 		Command: "lzop",
+		Format: FormatLzop,
 		CompressFlags: []string{"-c"},
 		DecompressFlags: []string{"-d", "-c"},
 
@@ -94,9 +158,55 @@ var filtersMap map[string]Filter = map[string]Filter{
 
 		CompressInPlaceFlags: []string{"-U"},
 		DecompressInPlaceFlags: []string{"-U", "-d"},
+
+		Extensions: []string{".lzo"},
+		SuffixFlag: "-S",
+		AlreadySuffixedMessages: []string{"already has", "suffix -- unchanged"},
+		WarningExitStatuses: []int{2},
+		WarningMessages: []string{"warning:"},
+
+		LevelFlag: "-%d",
+		MinLevel: 1,
+		MaxLevel: 9,
+
+		SingleThreaded: true,
+		HermeticFlags: []string{"--no-name", "--no-mode", "--no-time"},
+		VersionFlags: []string{"--version"},
+	},
+	"zstd" : Filter{
+		Command: "zstd",
+		Format: FormatZstd,
+		// Writes nothing until a whole job, several MiB, has been read
+		Latency: LatencyStoreAndForward,
+		CompressFlags: []string{"-q", "-c"},
+		DecompressFlags: []string{"-q", "-d", "-c"},
+
+		CompressStreamFlags: []string{"-q", "-c"},
+		DecompressStreamFlags: []string{"-q", "-d", "-c"},
+
+		CompressInPlaceFlags: []string{"-q", "--rm"},
+		DecompressInPlaceFlags: []string{"-q", "-d", "--rm"},
+
+		Extensions: []string{".zst", ".zstd"},
+		WarningMessages: []string{"warning"},
+
+		LevelFlag: "-%d",
+		MinLevel: 1,
+		MaxLevel: 19,
+		ThreadsFlag: "-T%d",
+		AdaptFlag: "--adapt",
+
+		VersionFlags: []string{"--version"},
+		MinVersion: "1.3.0",
+
+		VerboseFlags: []string{"-v", "--progress"},
+		ProgressParsers: zstdProgressParsers,
 	},
 	"cat" : Filter{
 		Command: "cat",
+		Passthrough: true,
+		SingleThreaded: true,
+		Format: FormatIdentity,
 		CompressFlags: []string{},
 		DecompressFlags: []string{},
 
@@ -128,40 +238,151 @@ func NewLogWriter(fnLog func(... interface{}) ) *LogWriter {
 }
 
 var (
-	mimeQueryCh chan string
-	mimeResponseCh chan mimeResponse
+	mimeQueryCh chan mimeQuery
 )
 
+// A detection request for the magic mime worker. If buf is non-nil it is
+// inspected instead of the file at filePath. The answer is sent on resp,
+// which must be buffered so the worker never waits on an abandoned query.
+// With reopen set the worker instead reloads libmagic with database.
+type mimeQuery struct {
+	filePath string
+	buf []byte
+	resp chan mimeResponse
+	reopen bool
+	database string
+}
+
 type mimeResponse struct {
 	mimetype string
 	err error
+	detector Detector
 }
 
 func init() {
 	// Start the magic mime worker
-	mimeQueryCh = make(chan string,0)
-	mimeResponseCh = make(chan mimeResponse,0)
+	mimeQueryCh = make(chan mimeQuery,0)
 	go magicMimeWorker()
 }
 
-// Interface of an external handler type for dealing with library compression
+// Interface of an external handler type for dealing with library compression.
+// Handlers are immutable values: the With methods return modified copies,
+// options are copied in rather than referenced, and one handler may be used
+// from any number of goroutines at once.
 type ExternalHandler interface {
+	// Performs any operation, with options applied for just this call. The
+	// operation methods below are shorthands for it.
+	Run(op Operation, in Input, opts *Options) (CompressionProcess, error)
+
 	// Stream compression/decompression from file
 	Compress(filePath string) (CompressionProcess, error)
 	Decompress(filePath string) (CompressionProcess, error)
 	
-	// Pure stream handlers
+	// Pure stream handlers. Readers which are also io.Closers are closed
+	// once the job completes, or just have their read side shut down if
+	// they are connections which can half-close.
 	CompressStream(io.Reader) (CompressionProcess, error)
-	DecompressStream(io.ReadCloser) (CompressionProcess, error)
+	DecompressStream(io.Reader) (CompressionProcess, error)
+
+	// Push-based compression into a file, written atomically on Close
+	CompressIntoFile(dstPath string) (io.WriteCloser, error)
+	// File to file operations, copied by the kernel for passthrough
+	// handlers. Decompression can be sparse.
+	CompressToFile(filePath string, dstPath string) error
+	DecompressToFile(filePath string, dstPath string) error
+	// Decompression into memory, preallocated for the output's size
+	DecompressToBytes(filePath string, sizeHint int) ([]byte, error)
+	DecompressAppend(dst []byte, r io.Reader) ([]byte, error)
+	// The first n bytes of the decompressed file, stopping the tool there
+	DecompressPrefix(filePath string, n int64) ([]byte, error)
+	// Decompression checked against a checksum of the original
+	DecompressAndVerify(filePath string, expected Checksum) error
+	DecompressStreamAndVerify(r io.Reader, expected Checksum) error
 	
 	// In place compression/decompression
 	CompressFileInPlace(filePath string) error
 	DecompressFileInPlace(filePath string) error
+	// In place operations with options, returning the resulting filename
+	CompressFileInPlaceWithOptions(filePath string, opts InPlaceOptions) (string, error)
+	DecompressFileInPlaceWithOptions(filePath string, opts InPlaceOptions) (string, error)
+	// In place compression of many files, with as few runs of the tool as
+	// possible
+	CompressFilesInPlace(paths []string, opts InPlaceOptions) ([]FileResult, error)
+	// In place operations reporting sizes, timing and the tool's exit status
+	// and stderr, whether or not they succeeded
+	CompressFileInPlaceResult(filePath string, opts InPlaceOptions) (FileOpResult, error)
+	DecompressFileInPlaceResult(filePath string, opts InPlaceOptions) (FileOpResult, error)
+	CompressFilesInPlaceResult(paths []string, opts InPlaceOptions) ([]FileOpResult, error)
+	// Batch in place compression, also returning the batch's totals
+	CompressFilesInPlaceSummary(paths []string, opts InPlaceOptions) ([]FileOpResult, Summary, error)
+	// Decompression with the output thrown away, for checking files can be
+	// read
+	DrainDecompress(filePath string) (DrainResult, error)
+	DrainDecompressFiles(paths []string) ([]DrainResult, Summary, error)
+	// Predict the filename an in place operation will produce
+	CompressedFileName(filePath string, opts InPlaceOptions) (string, error)
+	DecompressedFileName(filePath string, opts InPlaceOptions) (string, error)
+
+	// Random access to the uncompressed contents, for formats with an index
+	OpenReaderAt(filePath string) (io.ReaderAt, error)
+	// Iterate the members of a concatenated archive individually
+	DecompressMembers(filePath string) (MemberIterator, error)
 	
 	// Informational - return the commands this interface will run as strings
 	CommandStreamCompress() string
 	CommandStreamDecompress() string
 	MimeType() string
+
+	// Return a copy of the handler with the given options applied over its
+	// current ones
+	WithOptions(opts Options) ExternalHandler
+	WithLevel(level int) ExternalHandler
+	WithThreads(threads int) ExternalHandler
+	WithTempDir(dir string) ExternalHandler
+	// Returns a copy of the handler which kills streaming jobs that use too
+	// much CPU for the output they produce
+	WithCPUGuard(guard CPUGuard) ExternalHandler
+	// Returns a copy of the handler whose stream compression adapts its
+	// level to how fast the output is consumed
+	WithStreamingAdaptive(bounds AdaptiveBounds) ExternalHandler
+	// Returns a copy of the handler whose jobs each run in a transient
+	// systemd scope with the given unit properties
+	WithSystemdScope(props map[string]string) ExternalHandler
+	// Returns a copy of the handler which stops compression that makes its
+	// input larger
+	WithRatioGuard(guard RatioGuard) ExternalHandler
+	// Returns a copy of the handler which sends the tool's stderr to w
+	// instead of the debug log
+	WithStderr(w io.Writer) ExternalHandler
+	// Returns a copy of the handler which reports progress parsed from the
+	// tool's verbose output to fn, for tools which have ProgressParsers
+	WithProgress(fn ProgressFunc) ExternalHandler
+	// Returns a copy of the handler whose operations are cancelled by ctx
+	WithContext(ctx context.Context) ExternalHandler
+	// Returns a copy of the handler whose jobs get id rather than a
+	// generated ID
+	WithJobID(id string) ExternalHandler
+	// Returns a copy of the handler which adds fields to every log line
+	// about its jobs
+	WithLogFields(fields log.Fields) ExternalHandler
+	// How the handler was chosen and where its options came from
+	Provenance() Provenance
+	// Returns a copy of the handler whose spawns queue at priority p when
+	// the process limit is reached
+	WithPriority(p Priority) ExternalHandler
+	// Returns a copy of the handler which runs reproducibly, and an error
+	// listing anything about that which can't be guaranteed for its tool
+	Hermetic() (ExternalHandler, error)
+	// How the handler was matched when looked up by mimetype
+	MatchedBy() MatchKind
+	// What the handler is able to do
+	Capabilities() Capabilities
+	// The effective options and the commands they produce
+	Options() Options
+	Plan() Plan
+	// Proves the handler works by round-tripping a small sample through
+	// its tool, within ctx
+	SelfTest(ctx context.Context) error
 }
 
 // Handles most unix-style filter commands and implements the externalhandler
@@ -177,6 +398,113 @@ type Filter struct {
 	
 	CompressInPlaceFlags []string
 	DecompressInPlaceFlags []string
+
+	// True if the command passes data through untransformed. In-place
+	// operations are no-ops for passthrough filters.
+	Passthrough bool
+
+	// True if the command can't write to stdout. Its output goes to a
+	// private temp file substituted for OutputPlaceholder in its flags (or
+	// appended if they have none), and is read once the command finishes.
+	// CompressIntoFile isn't supported for such commands.
+	RequiresTempOutput bool
+	// True to connect InputPlaceholder and OutputPlaceholder in the
+	// command's flags to the stream API through fifos, rather than a temp
+	// file, so a command which needs paths can still stream. Unix only.
+	PreferFIFO bool
+	// Whether the command writes output as it reads input, or only once it
+	// has read all of it. Reported by Capabilities.
+	Latency Latency
+
+	// Format of the data the command produces. Unset looks the command up
+	// in the alias table.
+	Format Format
+
+	// File extensions of the format, canonical one first, used for naming
+	// output and looking up handlers by file name
+	Extensions []string
+
+	// Suffix the in-place operations add and remove, if not the canonical
+	// extension, and the flag which overrides it. Without a SuffixFlag
+	// custom suffixes are handled by the package instead of the tool.
+	Suffix string
+	SuffixFlag string
+	// Fragments of the messages the tool gives when it leaves a file alone
+	// for already having a compressed suffix
+	AlreadySuffixedMessages []string
+	// Exit statuses which mean the tool succeeded with warnings, and
+	// fragments of the stderr lines which are warnings (matched ignoring
+	// case). Both are reported as warnings rather than failures unless
+	// Options.TreatWarningsAsErrors is set.
+	WarningExitStatuses []int
+	WarningMessages []string
+
+	// Flags to restore or ignore a stored original name and timestamp when
+	// decompressing in place. Empty if the format doesn't store them.
+	RestoreNameFlag string
+	IgnoreNameFlag string
+
+	// Format of the compression level flag (e.g. "-%d") and the levels the
+	// tool accepts. Empty if the tool has no levels.
+	LevelFlag string
+	MinLevel int
+	MaxLevel int
+	// Format of the thread count flag. Empty if the tool is single threaded.
+	ThreadsFlag string
+	// Flag making stream compression adapt its level to the consumer, to
+	// which "=min=N,max=N" bounds are appended. Empty if the tool can't.
+	AdaptFlag string
+	// True if the tool only ever uses one thread, for Hermetic
+	SingleThreaded bool
+	// Flags which stop the tool storing names, timestamps or modes in its
+	// output, for Hermetic. Empty if its format stores none.
+	HermeticFlags []string
+
+	// Flags which make the tool print its version, and the oldest version
+	// the handler works properly with, for Report. Empty if not probed.
+	VersionFlags []string
+	MinVersion string
+
+	// Extra environment variables to remove from the child's environment,
+	// on top of the package's list of compressor knobs
+	ScrubEnv []string
+	// Variables besides TMPDIR which point the tool at its temp directory
+	TempDirEnv []string
+
+	// Flags which make the tool report progress on stderr, and the parsers
+	// for its reports
+	VerboseFlags []string
+	ProgressParsers []ProgressParser
+
+	// Marks sensitive arguments (keys, passphrases) which are shown as
+	// Redacted wherever the command line is logged or reported
+	RedactArgs RedactFunc
+
+	// How long Close gives the tool to exit after SIGINT before sending
+	// SIGTERM, and after SIGTERM before sending SIGKILL. Zero uses
+	// DefaultGraceSIGINT and DefaultGraceSIGTERM, negative skips the
+	// signal. Options.GraceSIGINT and GraceSIGTERM override them.
+	GraceSIGINT time.Duration
+	GraceSIGTERM time.Duration
+
+	// Effective options, merged from the defaults and any With* calls
+	opts Options
+	// Set if the handler can't be used, e.g. its command wasn't found
+	err error
+	// Cancels the handler's operations, if set
+	ctx context.Context
+	// Options of the in-place operation in progress, for naming its output
+	inPlace InPlaceOptions
+	// Also given the tool's stderr, for FileOpResult
+	stderrCapture *stderrTail
+	// Collects the warnings of the operation in progress
+	warningCapture *warningLog
+	// How the handler was found from its mimetype
+	matchedBy MatchKind
+	// Why the handler runs the way it does, see Provenance
+	prov Provenance
+	// Where each option field was last set from, e.g. "env", see noteOptions
+	optionSources map[string]string
 	
 	mimeType string
 }
@@ -189,6 +517,7 @@ type CompressionProcess interface {
 
 	Read(p []byte) (n int, err error)
 	Close() error
+	ID() string	// Identifies the job in logs, progress reports and errors
 }
 
 // Implements the ReadCloser interface to allow safely shutting down remotely
@@ -198,89 +527,259 @@ type CompressionJob struct {
 	pipe io.ReadCloser
 	result int
 
-	termFlag bool	// True if we deliberately killed this job via Close()
-
-	// Used to make Result
-	wg sync.WaitGroup
+	termFlag int32	// Set if we deliberately killed this job via Close()
+	graceSIGINT time.Duration	// How long Close waits after each signal, see Filter.GraceSIGINT
+	graceSIGTERM time.Duration
+	lastSignal int32	// The last signal Close sent, atomically
+
+	produced int64	// Bytes read from the job so far, updated atomically
+	reading int32	// Reads in progress, atomically
+	eof int32	// Set once the pipe has returned EOF, atomically
+	abortMtx sync.Mutex
+	abortErr error	// Why the job was killed from outside, if it was
+	drainUnread bool	// Discard unread output when waiting for the result
+	undrainedStall time.Duration	// See Options.UndrainedStall
+	input *jobInput	// The file being read, for file based operations
+	stopWatch func() bool	// Stops watching the handler's context
+	stdin *cancelableReader	// The caller's reader, for streaming jobs
+	source io.Closer	// Closed once the job completes, if the reader was one, see closeSource
+	command string	// The command line, as shown in errors
+	tool string	// The handler's command, without any wrapper it runs under
+	stderrTail *stderrTail	// End of a decompressor's stderr, for Err
+	id string	// Given at spawn, see ID
+	log *log.Entry	// Logs with the job's ID
+	span *opSpan	// Ended once the job is reaped, if tracing
+	reapedExternally bool	// Reaped by someone else, see SetReapedStatus
+	waitErr error	// Why no exit status could be collected, if none was
+	spill *spillOutput	// Where the output is, if the tool can't stream
+	ratioGuard *RatioGuard	// Guarding the job, if it compresses
+	formatCheck *formatCheck	// Of the start of the output, if it is verified
+	warnings *warningLog	// Given by the tool, see Warnings
+	prov Provenance	// The spawning handler's, see Provenance
+	format Format	// Of the job's output, see Format
+	fanout FanoutPolicy	// What Fanout does when a destination fails
+	fanoutStatus []FanoutStatus	// How each destination fared, once Fanout returns
+	reopen func() (CompressionProcess, error)	// Starts the job afresh, if it read a file, see Reopen
+
+	// Closed once the process has been reaped and result is set
+	reapOnce sync.Once
+	done chan struct{}
 }
 
-// Creates a new compression job and initializes the wait group
-func newCompressionJob(cmd *exec.Cmd, pipe io.ReadCloser) *CompressionJob {
+// Creates a new compression job and initializes the done channel
+func newCompressionJob(id string, cmd *exec.Cmd, pipe io.ReadCloser) *CompressionJob {
 	job := CompressionJob{}
+	job.id = id
+	job.log = log.WithField(JobIDField, id)
 	job.cmd = cmd
 	job.pipe = pipe
-	job.wg.Add(1)
+	job.done = make(chan struct{})
 
 	return &job
 }
 
-func (rwc CompressionJob) Read(p []byte) (n int, err error) {
-	return rwc.pipe.Read(p)
+// Creates the job for a started streaming command, with any monitoring the
+// filter's options call for
+func (c Filter) newJob(id string, cmd *exec.Cmd, pipe io.ReadCloser) *CompressionJob {
+	return c.newStreamJob(id, cmd, pipe, nil, nil)
 }
 
-func (this *CompressionJob) Close() error {
-	// If process not existed, request kill
-	if this.cmd.ProcessState != nil {
-		// Close requested, so kill the process, then close it's pipe.
-//		err := this.cmd.Process.Signal(syscall.SIGINT)
-//		if err != nil {
-//			log.WithField("error", err.Error()).Error("Error sending signal to external process")
-//		}
-
-//		// If the int isn't respected after a few seconds, do a term.
-//		t := time.NewTimer(time.Second * 3)
-//		<- t.C
-//
-		log.Debug("Terminating still active compression command")
-		err := this.cmd.Process.Signal(syscall.SIGTERM)
+// Like newJob, for commands fed from source, which is released if the job is
+// torn down and closed once it completes
+func (c Filter) newStreamJob(id string, cmd *exec.Cmd, pipe io.ReadCloser, stdin *cancelableReader, source io.Reader) *CompressionJob {
+	job := newCompressionJob(id, cmd, pipe)
+	job.log = c.jobLog(id)
+	job.stdin = stdin
+	job.source, _ = source.(io.Closer)
+	job.command = c.displayCommand(c.toolArgs(cmd))
+	job.drainUnread = enabled(c.opts.DrainUnread)
+	job.undrainedStall = c.opts.UndrainedStall
+	job.graceSIGINT, job.graceSIGTERM = c.gracePeriods()
+	job.tool = c.Command
+	job.prov = c.prov
+	job.fanout = c.opts.Fanout
+	job.stopWatch = c.killOnDone(job.log, cmd, job.abort)
+	c.guardJob(job)
+	return job
+}
+
+func (rwc *CompressionJob) Read(p []byte) (n int, err error) {
+	// Reaping closes the pipe, so repeat the EOF rather than reading it
+	if atomic.LoadInt32(&rwc.eof) != 0 {
+		return 0, io.EOF
+	}
+	atomic.AddInt32(&rwc.reading, 1)
+	defer atomic.AddInt32(&rwc.reading, -1)
+	n, err = rwc.pipe.Read(p)
+	if check := rwc.formatCheck; check != nil && !check.done {
+		// Nothing is passed on until the magic bytes are known to be right
+		for err == nil && n < len(check.magic) && n < len(p) {
+			var m int
+			m, err = rwc.pipe.Read(p[n:])
+			n += m
+		}
+	}
+	atomic.AddInt64(&rwc.produced, int64(n))
+	checkErr := rwc.checkFormat(p[:n])
+	if checkErr == nil && err == io.EOF {
+		checkErr = rwc.checkFormatComplete()
+	}
+	if checkErr != nil {
 		if err != nil {
-			log.WithField("error", err.Error()).Error("Error sending signal to external process")
+			// Reaped as for any other end of output
+			go rwc.getResult()
 		}
-		this.termFlag = true
+		return 0, checkErr
 	}
+	if err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+		if err == io.EOF {
+			atomic.StoreInt32(&rwc.eof, 1)
+		}
+		// The output is finished with, so reap in the background to make
+		// Done usable without a call to Result.
+		go rwc.getResult()
+		if abortErr := rwc.aborted(); abortErr != nil {
+			err = abortErr
+		}
+	}
+	return n, err
+}
+
+// Returns a channel which is closed once the process has been reaped, by
+// Result, Close or in the background after its output hits EOF. Result
+// will not block once it is closed.
+func (this *CompressionJob) Done() <-chan struct{} {
+	return this.done
+}
+
+// Records why the job is being killed, so readers see the reason instead of
+// a truncated stream.
+func (this *CompressionJob) abort(err error) {
+	this.abortMtx.Lock()
+	defer this.abortMtx.Unlock()
+	this.abortErr = err
+	this.stdin.cancel()
+}
+
+func (this *CompressionJob) aborted() error {
+	this.abortMtx.Lock()
+	defer this.abortMtx.Unlock()
+	return this.abortErr
+}
+
+func (this *CompressionJob) isReaped() bool {
+	select {
+	case <-this.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Stops the job if it is still running, by signalling its process with
+// escalating force (see Filter.GraceSIGINT), and waits for it to be reaped.
+// TerminatedBy then reports which signal ended it.
+func (this *CompressionJob) Close() error {
+	// If process not existed, request kill
+	if !this.isReaped() && atomic.CompareAndSwapInt32(&this.termFlag, 0, 1) {
+		this.log.Debug("Terminating still active compression command")
+		go this.escalate()
+	}
+	this.stdin.cancel()
 	this.pipe.Close()
 	return this.getResult()
 }
 
+// Forcibly terminates the job's process and reaps it, for when the caller has
+// read all it wants and the rest of the output is irrelevant. The result is
+// forced to success, as with Close.
+func (this *CompressionJob) kill() {
+	if this.isReaped() {
+		return
+	}
+	if err := this.cmd.Process.Kill(); err != nil {
+		this.log.WithField("error", err.Error()).Debug("Error killing external process")
+	}
+	atomic.StoreInt32(&this.termFlag, 1)
+	this.stdin.cancel()
+	this.pipe.Close()
+	this.getResult()
+}
+
+// Reaps the process exactly once, blocking until it has been reaped.
 func (this *CompressionJob) getResult() error {
-	if err := this.cmd.Wait(); err != nil {
-		// Result is forced to 0 (success) if we forcibly closed the pipe.
-		if !this.termFlag {
-			if exiterr, ok := err.(*exec.ExitError); ok {
-				// The program has exited with an exit code != 0
-
-				// This works on both Unix and Windows. Although package
-				// syscall is generally platform dependent, WaitStatus is
-				// defined for both Unix and Windows and in both cases has
-				// an ExitStatus() method with the same signature.
-				if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
-					this.result = status.ExitStatus()
-				}
-			} else {
-				log.Fatalf("cmd.Wait: %v", err)
+	this.reapOnce.Do(this.wait)
+	<-this.done
+	return nil
+}
+
+func (this *CompressionJob) wait() {
+	if this.stopWatch != nil {
+		defer this.stopWatch()
+	}
+	if this.stdin != nil {
+		go this.releaseStdinOnExit()
+	}
+	status, external, err := waitCmd(this.log, this.cmd, this.command, this.id)
+	status, err = this.warnings.exited(status, err)
+	this.reapedExternally = external
+	// Result is forced to 0 (success) if we forcibly closed the pipe.
+	if err != nil && atomic.LoadInt32(&this.termFlag) == 0 {
+		if _, ok := err.(*exec.ExitError); ok || external {
+			// The program has exited with an exit code != 0, or was reaped
+			// by someone else (see SetReapedStatus)
+			this.result = status
+			if err == ErrReapedExternally {
+				this.waitErr = err
 			}
+		} else {
+			this.log.WithField("error", err.Error()).Error("cmd.Wait failed")
+			this.result = -1
+			this.waitErr = err
 		}
 	}
 
-	this.wg.Done()	// Clear the waiting for results
-	return nil
+	this.span.end(this.result, this.bytesIn(), atomic.LoadInt64(&this.produced), this.failure())
+
+	// Nothing reads the source after the process exits
+	if this.source != nil {
+		if err := closeSource(this.source); err != nil {
+			this.log.WithField("error", err.Error()).Debug("Error closing job input")
+		}
+	}
+
+	this.stderrTail.complete()
+	close(this.done)	// Release anyone waiting for results
 }
 
 // Returns the exit status of the compression command. Blocks until the compression
-// command is actually terminated.
+// command is actually terminated. Returns -1 if the output is never read
+// and the command can't finish (see Wait). See SetStrictResults for finding
+// callers which should use Err instead.
 func (this *CompressionJob) Result() int {
-	if this.cmd.ProcessState == nil {
-		this.getResult()
-	}
+	result := this.status()
+	this.checkStrictResult(result)
+	return result
+}
 
-	this.wg.Wait()	// Wait for command to exit
-	return this.result
+// Does the work of Result.
+func (this *CompressionJob) status() int {
+	result, err := this.Wait()
+	if err != nil {
+		this.log.WithField("compressCmd", printable(this.cmd.Path)).WithField("error", err.Error()).Warn("Compression job result unavailable")
+		return -1
+	}
+	return result
 }
 
 // Check that all handlers are properly registered, fail hard if they're not.
+// VerifyHandlers can self-test them instead, and returns what it finds.
 func CheckHandlers() {
+	registryMtx.RLock()
+	defer registryMtx.RUnlock()
+
 	for k, v := range filtersMap {
-		hlog := log.WithField("mimetype", k).WithField("handler", v)
+		hlog := log.WithField("mimetype", k).WithField("handler", v.Command)
 		_, err := exec.LookPath(v.Command)
 		if err != nil {
 			hlog.Fatal("Handler unavailable!")
@@ -291,15 +790,24 @@ func CheckHandlers() {
 // Go-routine which serves magicmime requests because libmagic is not thread
 // safe.
 func magicMimeWorker() {
-	err:= magicmime.Open(magicmime.MAGIC_MIME_TYPE |
-		magicmime.MAGIC_SYMLINK | magicmime.MAGIC_ERROR)
-	if err != nil {
-		log.Fatalln("libmagic initialization failure", err.Error())
+	// Without any database only the package's own magic bytes detect types
+	if err := reopenMagic(""); err != nil {
+		log.WithField("error", err.Error()).Error("libmagic initialization failure")
 	}
 	defer magicmime.Close()
 
 	// Listen
-	for filePath := range mimeQueryCh {
+	for q := range mimeQueryCh {
+		if q.reopen {
+			q.resp <- mimeResponse{err: reopenMagic(q.database)}
+			continue
+		}
+		if q.buf != nil {
+			q.resp <- bufferMimeType(q.buf)
+			continue
+		}
+		filePath := q.filePath
+
 		// Grab all input files and test against the internal magic database
 		// first
 		wasFound := func() bool {
@@ -307,56 +815,135 @@ func magicMimeWorker() {
 			defer f.Close()
 			if err == nil {
 				for name, magic := range magics {
-					var err error
 					numBytes := len(magic)
 
+					// Files shorter than the magic (even empty ones) just
+					// don't match it
 					filemagic := make([]byte, numBytes)
-					_, err = f.Read(filemagic)
-					if err != nil {
+					n, err := f.ReadAt(filemagic, 0)
+					if err != nil && err != io.EOF {
 						// Couldn't read, let magicmime try?
-						mimeResponseCh <- mimeResponse{"", err}
+						q.resp <- mimeResponse{"", err, DetectedNone}
 						return true
 					}
 					// Compare bytes
-					if bytes.Equal(filemagic, magic) {
-						mimeResponseCh <- mimeResponse{mimeMap[name], nil}
+					if bytes.Equal(filemagic[:n], magic) {
+						q.resp <- mimeResponse{lookupHandlerName(name), nil, DetectedMagic}
 						return true
 					}
 				}
 			}
 			return false
 		}()
-		if !wasFound {
+		if !wasFound && magicState.err != nil {
+			q.resp <- mimeResponse{"", magicState.err, DetectedNone}
+		} else if !wasFound {
 			mimetype, err := magicmime.TypeByFile(filePath)
-			mimeResponseCh <- mimeResponse{mimetype, err}
+			q.resp <- mimeResponse{mimetype, err, DetectedLibmagic}
 		}
 	}
 }
 
+// Detects the mimetype of an in-memory buffer. Must only be called from the
+// magic mime worker.
+func bufferMimeType(buf []byte) mimeResponse {
+	for name, magic := range magics {
+		if bytes.HasPrefix(buf, magic) {
+			return mimeResponse{lookupHandlerName(name), nil, DetectedMagic}
+		}
+	}
+	if magicState.err != nil {
+		return mimeResponse{"", magicState.err, DetectedNone}
+	}
+	mimetype, err := magicmime.TypeByBuffer(buf)
+	return mimeResponse{mimetype, err, DetectedLibmagic}
+}
+
+// Do a filemagic lookup on the contents of buf
+func GetBufferMimeType(buf []byte) (string, error) {
+	if buf == nil {
+		buf = []byte{}
+	}
+	mimetype, _, err := queryMimeType(context.Background(), mimeQuery{buf: buf})
+	return mimetype, err
+}
+
+// Sends q to the magic mime worker and waits for the answer, and which
+// detector gave it, giving up if ctx is done first.
+func queryMimeType(ctx context.Context, q mimeQuery) (string, Detector, error) {
+	if err := ctx.Err(); err != nil {
+		return "", DetectedNone, err
+	}
+	q.resp = make(chan mimeResponse, 1)
+	select {
+	case mimeQueryCh <- q:
+	case <-ctx.Done():
+		return "", DetectedNone, ctx.Err()
+	}
+	select {
+	case r := <-q.resp:
+		return r.mimetype, r.detector, r.err
+	case <-ctx.Done():
+		return "", DetectedNone, ctx.Err()
+	}
+}
+
+// Returns the mimetype of filePath's contents and what found it.
+func detectFileType(ctx context.Context, filePath string) (string, Detector, error) {
+	q := mimeQuery{filePath: filePath}
+	if isStdioPath(filePath) {
+		// Detect from the data, since the path says nothing about it
+		buf, err := sniffStdio(filePath)
+		if err != nil {
+			return "", DetectedNone, err
+		}
+		q = mimeQuery{buf: buf}
+	}
+	return queryMimeType(ctx, q)
+}
+
 // Do a filemagic lookup and return a handler interface for the given type
 func GetFileTypeExternalHandler(filePath string) (ExternalHandler, error) {
-    mimeQueryCh <- filePath
-	r := <- mimeResponseCh
-	if r.err != nil {
-		return nil, r.err
+	return GetFileTypeExternalHandlerContext(context.Background(), filePath)
+}
+
+// Like GetFileTypeExternalHandler, but gives up on detection when ctx is done
+// and returns a handler bound to ctx (see WithContext).
+func GetFileTypeExternalHandlerContext(ctx context.Context, filePath string) (ExternalHandler, error) {
+	mimetype, detector, err := detectFileType(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	h, err := GetExternalHandlerFromMimeType(mimetype)
+	if err != nil {
+		return nil, err
+	}
+	if f, ok := h.(Filter); ok {
+		f.prov.Detector = detector
+		h = f
 	}
-    return GetExternalHandlerFromMimeType(r.mimetype)
+	if ctx == context.Background() {
+		return h, nil
+	}
+	return h.WithContext(ctx), nil
 }
 
 func GetExternalHandlerFromMimeType(mimeType string) (ExternalHandler, error) {
-	handlername, ok := mimeMap[mimeType]
-    if !ok {
-    	// Try splitting on the / and looking for a bulk handler
-    	firstpart := strings.Split(mimeType, "/")[0]
-    	handlername, ok = mimeMap[firstpart]
-    	if !ok {
-    		return nil, error(UnknownFileType{mimeType})
-    	}
-    }
+	registryMtx.RLock()
+	defer registryMtx.RUnlock()
+
+	// Falls back to a wildcard handler for the top level type, if any
+	handlername, matchedBy, ok := resolveMimeType(mimeType)
+	if !ok {
+		return nil, error(UnknownFileType{mimeType})
+	}
 
 	handler := filtersMap[handlername]
     
     handler.mimeType = mimeType
+    handler.matchedBy = matchedBy
+    handler.stampLookup(handlername, mimeType, matchedBy, SelectedRegistered)
+    handler.applyRegisteredOptions(handlername)
     extHandler := ExternalHandler(handler)
     return extHandler, nil
 }
@@ -368,144 +955,77 @@ func (r UnknownFileType) Error() string {
 	return "This file type is not known to us."
 }
 
+// Returned when an external command ran but exited unsuccessfully.
+type ExitStatusError struct {
+	Command string
+	ExitStatus int
+	JobID string	// Empty if the command wasn't run as a job
+}
+func (r ExitStatusError) Error() string {
+	if r.JobID != "" {
+		return fmt.Sprintf("%s exited with status %d (job %s)", r.Command, r.ExitStatus, r.JobID)
+	}
+	return fmt.Sprintf("%s exited with status %d", r.Command, r.ExitStatus)
+}
+
+// Creates the command for an invocation of the filter with the package's
+// standard process setup applied.
+func (c Filter) newCmd(args []string) *exec.Cmd {
+	var cmd *exec.Cmd
+	if path, err := resolveCommand(c.Command); err == nil {
+		cmd = exec.Command(path, args...)
+		cmd.Args[0] = c.Command
+	} else {
+		cmd = exec.Command(c.Command, args...)
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true} // Don't pass on parent signals
+	if c.opts.ParentDeathSignal != 0 {
+		// Dying with us instead, if asked
+		setParentDeathSignal(cmd.SysProcAttr, c.opts.ParentDeathSignal)
+	}
+	cmd.Env = c.childEnv()
+	c.wrapScope(cmd)
+	return cmd
+}
+
 func (c Filter) MimeType() string {
 	return c.mimeType
 }
 
 func (c Filter) CommandStreamCompress() string {
-	return strings.Join(append([]string{c.Command}, c.CompressStreamFlags...), " ")
+	args, _ := c.buildArgs(true, c.flags(OpCompressStream))
+	return c.displayCommand(args)
 }
 
 func (c Filter) CommandStreamDecompress() string {
-	return strings.Join(append([]string{c.Command}, c.DecompressStreamFlags...), " ")
+	args, _ := c.buildArgs(false, c.DecompressStreamFlags)
+	return c.displayCommand(args)
 }
 
 func (c Filter) Compress(filePath string) (CompressionProcess, error) {
-	var logFields = log.Fields{"compressCmd" : c.Command, "filepath" : filePath }
-	log.WithFields(logFields).Info("External Compression Command")
-	
-	cmd := exec.Command(c.Command, append(c.CompressFlags, filePath)...)
-	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true} // Don't pass on parent signals
-
-	rdr, err := cmd.StdoutPipe()
-	if err != nil {
-		log.Errorf("Failed to get stdout pipe.")
-		return nil, err
-	}
-	
-	err = cmd.Start()
-	if err != nil {
-		log.WithFields(logFields).Error("Compression command failed.")
-		return nil, err
-	}
-
-	return newCompressionJob(cmd, rdr), err
+	return c.Run(OpCompress, FileInput(filePath), nil)
 }
 
 func (c Filter) CompressStream(rd io.Reader) (CompressionProcess, error) {
-	var logFields = log.Fields{"compressCmd" : c.Command }
-	log.WithFields(logFields).Info("External Compression Command")
-	
-	cmd := exec.Command(c.Command,c.CompressStreamFlags...)
-	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true} // Don't pass on parent signals
-
-	cmd.Stdin = rd
-	cmd.Stderr = NewLogWriter(log.WithField("extcompress", "CompressStream").Debug)
-	
-	rdr, err := cmd.StdoutPipe()
-	if err != nil {
-		log.Errorf("Failed to get stdout pipe.")
-		return nil, err
-	}
-	
-	err = cmd.Start()
-	if err != nil {
-		log.WithFields(logFields).Error("Compression command failed.")
-		return nil, err
-	}
-
-	return newCompressionJob(cmd, rdr), err
+	return c.Run(OpCompressStream, StreamInput(rd), nil)
 }
 
 // Call the compression utility in standalone compression mode
-func (c Filter) CompressFileInPlace(filePath string) error {	
-	var logFields = log.Fields{"compressCmd" : c.Command, "filepath" : filePath }
-	log.WithFields(logFields).Info("External Compression Command")
-	
-	cmd := exec.Command(c.Command, append(c.CompressInPlaceFlags, filePath)...)
-
-	cmd.Stderr = NewLogWriter(log.WithField("extcompress", "CompressFileInPlace").Debug)
-
-	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true} // Don't pass on parent signals
-	err := cmd.Run()
-	if err != nil {
-		log.WithFields(logFields).WithField("error", err.Error()).Warn("Compression command failed.")
-	}
-	
+func (c Filter) CompressFileInPlace(filePath string) error {
+	_, err := c.Run(OpCompressInPlace, FileInput(filePath), nil)
 	return err
 }
 
-func (c Filter) DecompressStream(rd io.ReadCloser) (CompressionProcess, error) {
-	var logFields = log.Fields{"compressCmd" : c.Command }
-	log.WithFields(logFields).Info("External Compression Command")
-	
-	cmd := exec.Command(c.Command,c.DecompressStreamFlags...)
-	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true} // Don't pass on parent signals
-	cmd.Stdin = rd
-	cmd.Stderr = NewLogWriter(log.WithField("extcompress", "DecompressStream").Debug)
-
-	rdr, err := cmd.StdoutPipe()
-	if err != nil {
-		log.Errorf("Failed to get stdout pipe.")
-		return nil, err
-	}
-	
-	err = cmd.Start()
-	if err != nil {
-		log.WithFields(logFields).Error("Compression command failed.")
-		return nil, err
-	}
-
-	return newCompressionJob(cmd, rdr), err
+func (c Filter) DecompressStream(rd io.Reader) (CompressionProcess, error) {
+	return c.Run(OpDecompressStream, StreamInput(rd), nil)
 }
 
-func (c Filter) DecompressFileInPlace(filePath string) error {	
-	var logFields = log.Fields{"compressCmd" : c.Command, "filepath" : filePath }
-	log.WithFields(logFields).Info("External Decompression Command")
-	
-	cmd := exec.Command(c.Command, append(c.DecompressInPlaceFlags, filePath)...)
-
-	cmd.Stderr = NewLogWriter(log.WithField("extcompress", "DecompressFileInPlace").Debug)
-
-	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true} // Don't pass on parent signals
-	err := cmd.Run()
-	if err != nil {
-		log.WithFields(logFields).Warn("DeCompression command failed.")
-	}
-	
+func (c Filter) DecompressFileInPlace(filePath string) error {
+	_, err := c.Run(OpDecompressInPlace, FileInput(filePath), nil)
 	return err
 }
 
 // Decompress the given file and return the stream
 func (c Filter) Decompress(filePath string) (CompressionProcess, error) {
-	var logFields = log.Fields{"compressCmd" : c.Command, "filepath" : filePath }
-	log.WithFields(logFields).Info("External Decompression Command")
-	
-	cmd := exec.Command(c.Command, append(c.DecompressFlags, filePath)...)
-
-	cmd.Stderr = NewLogWriter(log.WithField("extcompress", "Decompress").Debug)
-
-	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true} // Don't pass on parent signals
-	rdr, err := cmd.StdoutPipe()
-	if err != nil {
-		log.Errorf("Failed to get stdout pipe.")
-		return nil, err
-	}
-	
-	if err := cmd.Start(); err != nil {
-		log.Errorf("External decompression command error:", err.Error())
-		return nil, err
-	}
-	
-	return newCompressionJob(cmd, rdr), err
+	return c.Run(OpDecompress, FileInput(filePath), nil)
 }
@@ -10,7 +10,6 @@ import (
 	"bytes"
 	"fmt"
 	"strings"
-	"path/filepath"
 	"github.com/Sirupsen/logrus"
 )
 
@@ -74,12 +73,6 @@ func TestMimeHandlerMappings(t *testing.T) {
 		assert.EqualValues(t, mimeMap[hSource.MimeType()], mimeMap[hResult.MimeType()])
 	}
 
-	// Helper to find altered in-place filenames
-	globMatch := func (filename string) string {
-		s, _ := filepath.Glob(fmt.Sprintf("%s*", filename))
-		return s[0]
-	}
-
 	// Basic sanity
 	for k, _ := range mimeMap {
 		fmt.Println("Checking", k)
@@ -120,7 +113,8 @@ func TestMimeHandlerMappings(t *testing.T) {
 		err = h.CompressFileInPlace(filename) // Recompress
 		assert.Nil(t, err)
 
-		mutatedFilename := globMatch(filename)
+		mutatedFilename, err := ResolveInPlaceOutput(filename, h)
+		assert.Nil(t, err)
 		fmt.Println("Looking for mutated filename: ", mutatedFilename)
 		h_inplace, _ := GetFileTypeExternalHandler(mutatedFilename) // Should be remutated
 		mimeCheck(h, h_inplace)
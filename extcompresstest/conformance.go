@@ -0,0 +1,333 @@
+// Package extcompresstest checks that handlers honour the contracts of the
+// extcompress package, for authors of custom filters. The built-in handlers
+// are held to the same checks.
+package extcompresstest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wrouesnel/extcompress"
+)
+
+// Input sizes at the boundaries tools get wrong: nothing, a single byte, and
+// either side of the usual pipe buffer size.
+var DefaultSizes = []int{0, 1, 64 * 1024, 64*1024 + 1}
+
+// How long Close and Result get to return before they are considered hung.
+var Timeout = 10 * time.Second
+
+// How much input MeasureLatency writes while waiting for output, and how
+// long it then waits before ending the input.
+var (
+	LatencyProbeSize = 4 << 20
+	LatencyWait      = 2 * time.Second
+)
+
+// Tunes RunConformance for a handler.
+type ConformanceOpts struct {
+	// Operations the handler doesn't support. Checks which need them are
+	// skipped.
+	Skip []extcompress.Operation
+	// Input sizes to round trip. Nil uses DefaultSizes.
+	Sizes []int
+	// Skips checking that libmagic recognises the handler's output, for
+	// formats it doesn't know
+	SkipDetection bool
+}
+
+func (o ConformanceOpts) supports(ops ...extcompress.Operation) bool {
+	for _, op := range ops {
+		for _, skipped := range o.Skip {
+			if op == skipped {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Runs the package's behavioural checks against h as subtests of t:
+// round trips through every operation at each input size, detection of the
+// output, naming of in-place outputs, Result and Close on jobs which are
+// unread or stuck, failing with a message on corrupt input, and that the
+// handler's declared latency is what it does.
+func RunConformance(t *testing.T, h extcompress.ExternalHandler, opts ConformanceOpts) {
+	sizes := opts.Sizes
+	if sizes == nil {
+		sizes = DefaultSizes
+	}
+	for _, size := range sizes {
+		input := make([]byte, size)
+		rand.New(rand.NewSource(int64(size))).Read(input)
+		t.Run(fmt.Sprintf("RoundTrip/%d", size), func(t *testing.T) {
+			checkRoundTrips(t, h, opts, input)
+		})
+	}
+	if opts.supports(extcompress.OpCompressStream) {
+		t.Run("UnreadResult", func(t *testing.T) { checkUnreadResult(t, h) })
+		t.Run("StuckClose", func(t *testing.T) { checkStuckClose(t, h) })
+		t.Run("Latency", func(t *testing.T) { checkLatency(t, h) })
+	}
+	if opts.supports(extcompress.OpDecompressStream) && !h.Capabilities().Passthrough {
+		t.Run("CorruptInput", func(t *testing.T) { checkCorruptInput(t, h) })
+	}
+}
+
+func checkRoundTrips(t *testing.T, h extcompress.ExternalHandler, opts ConformanceOpts, input []byte) {
+	tmpdir, err := ioutil.TempDir("", "extcompress_conformance")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpdir)
+
+	filename := path.Join(tmpdir, "input")
+	assert.Nil(t, ioutil.WriteFile(filename, input, os.FileMode(0644)))
+	compressedName := path.Join(tmpdir, "compressed")
+
+	// Streams, which also provide the compressed data for the file checks
+	// when they can
+	var compressed []byte
+	if opts.supports(extcompress.OpCompressStream) {
+		proc, err := h.CompressStream(bytes.NewReader(input))
+		compressed = ReadJob(t, proc, err, "CompressStream")
+	}
+	if opts.supports(extcompress.OpCompress) {
+		proc, err := h.Compress(filename)
+		fromFile := ReadJob(t, proc, err, "Compress")
+		if compressed == nil {
+			compressed = fromFile
+		}
+	}
+	if compressed == nil {
+		return
+	}
+	if opts.supports(extcompress.OpDecompressStream) {
+		proc, err := h.DecompressStream(bytes.NewReader(compressed))
+		assert.Equal(t, input, nonNil(ReadJob(t, proc, err, "DecompressStream")))
+	}
+	assert.Nil(t, ioutil.WriteFile(compressedName, compressed, os.FileMode(0644)))
+	if opts.supports(extcompress.OpDecompress) {
+		proc, err := h.Decompress(compressedName)
+		assert.Equal(t, input, nonNil(ReadJob(t, proc, err, "Decompress")))
+	}
+
+	// The output is recognised, and the input (random data, which may have
+	// no handler at all) isn't mistaken for it
+	if !opts.SkipDetection && !h.Capabilities().Passthrough {
+		detected, err := extcompress.GetFileTypeExternalHandler(filename)
+		if _, unknown := err.(extcompress.UnknownFileType); !unknown {
+			assert.Nil(t, err, "detecting the input")
+		}
+		assert.False(t, err == nil && extcompress.SameFormat(detected, h), "input detected as compressed")
+		detected, err = extcompress.GetFileTypeExternalHandler(compressedName)
+		assert.Nil(t, err, "detecting the output")
+		assert.True(t, err == nil && extcompress.SameFormat(detected, h), "output not detected as %s", extcompress.FormatOf(h))
+	}
+
+	// To and from files the package writes
+	if opts.supports(extcompress.OpCompressStream, extcompress.OpDecompress) {
+		w, err := h.CompressIntoFile(compressedName)
+		assert.Nil(t, err)
+		_, err = w.Write(input)
+		assert.Nil(t, err)
+		assert.Nil(t, w.Close(), "CompressIntoFile")
+		decompressedName := path.Join(tmpdir, "decompressed")
+		assert.Nil(t, h.DecompressToFile(compressedName, decompressedName), "DecompressToFile")
+		roundTripped, err := ioutil.ReadFile(decompressedName)
+		assert.Nil(t, err)
+		assert.Equal(t, input, nonNil(roundTripped))
+		assert.Nil(t, os.Remove(decompressedName))
+	}
+	assert.Nil(t, os.Remove(compressedName))
+
+	// In place, by the tool and by the package
+	if opts.supports(extcompress.OpCompressInPlace, extcompress.OpDecompressInPlace) {
+		checkInPlace(t, h, filename, input)
+		checkInPlace(t, h.WithOptions(extcompress.Options{Durability: extcompress.DurabilityDataOnly}), filename, input)
+	}
+}
+
+func checkInPlace(t *testing.T, h extcompress.ExternalHandler, filename string, input []byte) {
+	predicted, err := h.CompressedFileName(filename, extcompress.InPlaceOptions{})
+	assert.Nil(t, err)
+	out, err := h.CompressFileInPlaceWithOptions(filename, extcompress.InPlaceOptions{})
+	assert.Nil(t, err, "CompressFileInPlace")
+	assert.Equal(t, predicted, out, "CompressFileInPlace output name")
+	_, err = os.Stat(out)
+	assert.Nil(t, err, "CompressFileInPlace output")
+
+	predicted, err = h.DecompressedFileName(out, extcompress.InPlaceOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, filename, predicted)
+	out, err = h.DecompressFileInPlaceWithOptions(out, extcompress.InPlaceOptions{})
+	assert.Nil(t, err, "DecompressFileInPlace")
+	assert.Equal(t, filename, out, "DecompressFileInPlace output name")
+
+	roundTripped, err := ioutil.ReadFile(out)
+	assert.Nil(t, err)
+	assert.Equal(t, input, nonNil(roundTripped))
+	entries, err := ioutil.ReadDir(path.Dir(filename))
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1, "in place operations left extra files behind")
+}
+
+// Result on a job whose output is never read must still return once the
+// handler is told to discard it.
+func checkUnreadResult(t *testing.T, h extcompress.ExternalHandler) {
+	input := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(input)
+	proc, err := h.WithOptions(extcompress.Options{DrainUnread: extcompress.Bool(true)}).CompressStream(bytes.NewReader(input))
+	if !assert.Nil(t, err) {
+		return
+	}
+	returns(t, "Result", func() { assert.Zero(t, proc.Result()) })
+	returns(t, "Result again", func() { assert.Zero(t, proc.Result()) })
+}
+
+// Close must tear a job down even when its input never arrives.
+func checkStuckClose(t *testing.T, h extcompress.ExternalHandler) {
+	r, w := io.Pipe()
+	defer w.Close()
+	proc, err := h.CompressStream(r)
+	if !assert.Nil(t, err) {
+		return
+	}
+	returns(t, "Close", func() { proc.Close() })
+	returns(t, "Result after Close", func() { proc.Result() })
+}
+
+// The handler's output must start before its input ends exactly when its
+// Capabilities say it streams.
+func checkLatency(t *testing.T, h extcompress.ExternalHandler) {
+	measured, err := MeasureLatency(h)
+	if !assert.Nil(t, err) {
+		return
+	}
+	declared := extcompress.LatencyStoreAndForward
+	if h.Capabilities().Streaming {
+		declared = extcompress.LatencyStreaming
+	}
+	assert.Equal(t, declared, measured, "declared latency")
+}
+
+// Measures whether h's compressed output starts before its input ends.
+// Random data is written to CompressStream until output arrives, or until
+// LatencyProbeSize bytes have been written and LatencyWait has passed; only
+// then is the input closed.
+func MeasureLatency(h extcompress.ExternalHandler) (extcompress.Latency, error) {
+	r, w := io.Pipe()
+	proc, err := h.CompressStream(r)
+	if err != nil {
+		w.Close()
+		return 0, err
+	}
+
+	first := make(chan struct{})
+	readErr := make(chan error, 1)
+	go func() {
+		var once sync.Once
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := proc.Read(buf)
+			if n > 0 {
+				once.Do(func() { close(first) })
+			}
+			if err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				readErr <- err
+				return
+			}
+		}
+	}()
+
+	latency := extcompress.LatencyStoreAndForward
+	src := rand.New(rand.NewSource(1))
+	chunk := make([]byte, 64*1024)
+write:
+	for written := 0; written < LatencyProbeSize; written += len(chunk) {
+		select {
+		case <-first:
+			latency = extcompress.LatencyStreaming
+			break write
+		default:
+		}
+		src.Read(chunk)
+		if _, err := w.Write(chunk); err != nil {
+			proc.Close()
+			return 0, err
+		}
+	}
+	if latency != extcompress.LatencyStreaming {
+		select {
+		case <-first:
+			latency = extcompress.LatencyStreaming
+		case <-time.After(LatencyWait):
+		}
+	}
+	w.Close()
+
+	if err := <-readErr; err != nil {
+		return 0, err
+	}
+	if status := proc.Result(); status != 0 {
+		return 0, extcompress.ExitStatusError{Command: h.Provenance().Command, ExitStatus: status, JobID: proc.ID()}
+	}
+	return latency, nil
+}
+
+// Corrupt input must fail, with the tool saying why on stderr.
+func checkCorruptInput(t *testing.T, h extcompress.ExternalHandler) {
+	var stderr bytes.Buffer
+	garbage := bytes.Repeat([]byte("not compressed data "), 100)
+	proc, err := h.WithStderr(&stderr).DecompressStream(bytes.NewReader(garbage))
+	if !assert.Nil(t, err) {
+		return
+	}
+	_, err = ioutil.ReadAll(proc)
+	assert.Nil(t, err)
+	assert.NotZero(t, proc.Result(), "corrupt input accepted")
+	assert.NotEmpty(t, stderr.String(), "no message for corrupt input")
+}
+
+// Reads the whole of a job's output and checks it exited cleanly.
+func ReadJob(t *testing.T, proc extcompress.CompressionProcess, err error, what string) []byte {
+	if !assert.Nil(t, err, what) {
+		return nil
+	}
+	out, err := ioutil.ReadAll(proc)
+	assert.Nil(t, err, what)
+	assert.Zero(t, proc.Result(), what)
+	return out
+}
+
+// Fails t if fn takes longer than Timeout.
+func returns(t *testing.T, what string, fn func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+	select {
+	case <-done:
+	case <-time.After(Timeout):
+		t.Errorf("%s did not return within %s", what, Timeout)
+	}
+}
+
+// Lets empty outputs compare equal to empty inputs.
+func nonNil(b []byte) []byte {
+	if b == nil {
+		return []byte{}
+	}
+	return b
+}
@@ -0,0 +1,60 @@
+package extcompresstest
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wrouesnel/extcompress"
+)
+
+func TestRunConformanceCustomFilter(t *testing.T) {
+	if _, err := exec.LookPath("gzip"); err != nil {
+		t.Skip("gzip not available")
+	}
+	h := extcompress.NewFilter("gzip",
+		extcompress.CompressFlags("-c", "-1"),
+		extcompress.DecompressFlags("-d", "-c"),
+		extcompress.InPlaceFlags([]string{"-1"}, []string{"-d"}),
+		extcompress.Suffix(".gz", "-S"),
+		extcompress.OutputFormat(extcompress.FormatGzip),
+	)
+	RunConformance(t, h, ConformanceOpts{Sizes: []int{0, 1000}})
+}
+
+func TestRunConformanceSkip(t *testing.T) {
+	if _, err := exec.LookPath("gzip"); err != nil {
+		t.Skip("gzip not available")
+	}
+	// A stream only filter, whose output libmagic wouldn't know
+	h := extcompress.NewFilter("gzip",
+		extcompress.CompressFlags("-c", "-n"),
+		extcompress.DecompressFlags("-d", "-c"),
+	)
+	RunConformance(t, h, ConformanceOpts{
+		Skip: []extcompress.Operation{
+			extcompress.OpCompress, extcompress.OpDecompress,
+			extcompress.OpCompressInPlace, extcompress.OpDecompressInPlace,
+		},
+		SkipDetection: true,
+	})
+}
+
+func TestMeasureLatency(t *testing.T) {
+	if _, err := exec.LookPath("gzip"); err != nil {
+		t.Skip("gzip not available")
+	}
+	latency, err := MeasureLatency(extcompress.NewFilter("gzip", extcompress.CompressFlags("-c")))
+	assert.Nil(t, err)
+	assert.Equal(t, extcompress.LatencyStreaming, latency)
+
+	// Holds all its input before writing any of it
+	h := extcompress.NewFilter("sh",
+		extcompress.CompressFlags("-c", "f=$(mktemp) && cat >$f && gzip -c <$f; rm -f $f"),
+		extcompress.OutputLatency(extcompress.LatencyStoreAndForward),
+	)
+	latency, err = MeasureLatency(h)
+	assert.Nil(t, err)
+	assert.Equal(t, extcompress.LatencyStoreAndForward, latency)
+	assert.False(t, h.Capabilities().Streaming)
+}
@@ -0,0 +1,96 @@
+package extcompress
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The mimetype reported for each handler by extension lookups
+var canonicalMimeTypes = map[string]string{
+	"bzip2": "application/x-bzip2",
+	"gzip":  "application/gzip",
+	"xz":    "application/x-xz",
+	"lzop":  "application/x-lzop",
+	"zstd":  "application/zstd",
+}
+
+// Handler name by extension, built from each filter's Extensions. Guarded by
+// registryMtx.
+var extMap = map[string]string{}
+
+func init() {
+	for name, f := range filtersMap {
+		if err := checkExtensions(name, f.Extensions); err != nil {
+			panic(err)
+		}
+		addExtensions(name, f.Extensions)
+	}
+}
+
+// Lowercases ext and gives it a leading dot.
+func normalizeExtension(ext string) string {
+	ext = strings.ToLower(ext)
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	return ext
+}
+
+// Checks none of exts belong to a handler other than name. Must be called
+// with registryMtx held.
+func checkExtensions(name string, exts []string) error {
+	for _, ext := range exts {
+		if ext == "" || ext == "." {
+			return fmt.Errorf("%w: empty extension", ErrRegistration)
+		}
+		if existing, ok := extMap[normalizeExtension(ext)]; ok && existing != name {
+			return fmt.Errorf("%w: extension %s is already used by %s", ErrRegistration, ext, existing)
+		}
+	}
+	return nil
+}
+
+// Replaces the extensions mapped to name with exts. Must be called with
+// registryMtx held for writing.
+func addExtensions(name string, exts []string) {
+	for ext, existing := range extMap {
+		if existing == name {
+			delete(extMap, ext)
+		}
+	}
+	for _, ext := range exts {
+		extMap[normalizeExtension(ext)] = name
+	}
+}
+
+// Sets the file extensions of the format, canonical one first.
+func Extensions(exts ...string) FilterOption {
+	return func(f *Filter) {
+		f.Extensions = withFlags(exts)
+	}
+}
+
+// Returns the file extensions of the format handling mimeType, canonical one
+// first, or nil if there are none.
+func ExtensionsForMimeType(mimeType string) []string {
+	registryMtx.RLock()
+	defer registryMtx.RUnlock()
+	name, ok := mimeMap[mimeType]
+	if !ok || len(filtersMap[name].Extensions) == 0 {
+		return nil
+	}
+	return withFlags(filtersMap[name].Extensions)
+}
+
+// Returns the mimetype of the format using the file extension ext (with or
+// without its leading dot, in any case).
+func MimeTypeForExtension(ext string) (string, bool) {
+	registryMtx.RLock()
+	defer registryMtx.RUnlock()
+	name, ok := extMap[normalizeExtension(ext)]
+	if !ok {
+		return "", false
+	}
+	mimeType, ok := canonicalMimeTypes[name]
+	return mimeType, ok
+}
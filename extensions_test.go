@@ -0,0 +1,53 @@
+package extcompress
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtensionLookups(t *testing.T) {
+	assert.Equal(t, []string{".gz"}, ExtensionsForMimeType("application/x-gzip"))
+	assert.Equal(t, []string{".zst", ".zstd"}, ExtensionsForMimeType("application/zstd"))
+	assert.Nil(t, ExtensionsForMimeType("text/plain"))
+	assert.Nil(t, ExtensionsForMimeType("application/x-no-such-type"))
+
+	for ext, expected := range map[string]string{
+		".gz":  "application/gzip",
+		"bz2":  "application/x-bzip2",
+		".XZ":  "application/x-xz",
+		".lzo": "application/x-lzop",
+		"zstd": "application/zstd",
+	} {
+		mimeType, ok := MimeTypeForExtension(ext)
+		assert.True(t, ok, ext)
+		assert.Equal(t, expected, mimeType, ext)
+	}
+
+	_, ok := MimeTypeForExtension(".txt")
+	assert.False(t, ok)
+}
+
+func TestRegisterFilterExtensions(t *testing.T) {
+	unregisterFilter(t, "test-ext")
+	h := NewFilter("zstd", Extensions(".tzst", ".tar.zst"))
+	assert.Nil(t, RegisterFilter("test-ext", h, "application/x-test-ext"))
+
+	assert.Equal(t, []string{".tzst", ".tar.zst"}, ExtensionsForMimeType("application/x-test-ext"))
+	mimeType, ok := MimeTypeForExtension(".tar.zst")
+	assert.True(t, ok)
+	assert.Equal(t, "application/x-test-ext", mimeType)
+
+	// Re-registering replaces the old extensions
+	h = NewFilter("zstd", Extensions(".tzst"))
+	assert.Nil(t, RegisterFilter("test-ext", h, "application/x-test-ext"))
+	_, ok = MimeTypeForExtension(".tar.zst")
+	assert.False(t, ok)
+
+	// Extensions of other handlers can't be claimed
+	unregisterFilter(t, "test-ext-clash")
+	err := RegisterFilter("test-ext-clash", NewFilter("zstd", Extensions(".GZ")), "application/x-test-ext-clash")
+	assert.ErrorIs(t, err, ErrRegistration)
+	_, err = GetExternalHandlerFromMimeType("application/x-test-ext-clash")
+	assert.IsType(t, UnknownFileType{}, err)
+}
@@ -0,0 +1,99 @@
+package extcompress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+)
+
+// First descriptor given to ExtraInputs; 0 to 2 are the standard streams.
+const firstExtraFD = 3
+
+// Matches the placeholders in arguments which name an extra input, e.g.
+// "{fd3}" for the first.
+var fdPlaceholder = regexp.MustCompile(`\{fd([0-9]+)\}`)
+
+// Expands the {fdN} placeholders in args to /dev/fd/N, checking each names
+// one of the handler's ExtraInputs.
+func (c Filter) expandFDPlaceholders(args []string) ([]string, error) {
+	var expanded []string
+	for i, arg := range args {
+		var badFD string
+		out := fdPlaceholder.ReplaceAllStringFunc(arg, func(m string) string {
+			fd, err := strconv.Atoi(fdPlaceholder.FindStringSubmatch(m)[1])
+			if err != nil || fd < firstExtraFD || fd-firstExtraFD >= len(c.opts.ExtraInputs) {
+				badFD = m
+			}
+			return "/dev/fd/" + strconv.Itoa(fd)
+		})
+		if badFD != "" {
+			return nil, InvalidOption{c.Command, "ExtraInputs", fmt.Sprintf("%s has no input", badFD)}
+		}
+		if out != arg && expanded == nil {
+			// Copied, since args may be shared with the filter's flags
+			expanded = append([]string(nil), args...)
+		}
+		if expanded != nil {
+			expanded[i] = out
+		}
+	}
+	if expanded == nil {
+		return args, nil
+	}
+	return expanded, nil
+}
+
+// The extra inputs of one spawn. Files are inherited directly; readers are
+// copied to the child through a pipe once it has started.
+type extraInputs struct {
+	pipes []extraPipe
+}
+
+type extraPipe struct {
+	// The read end is closed in this process once the child has it
+	r, w *os.File
+	src  io.Reader
+}
+
+// Gives cmd the handler's ExtraInputs as descriptors 3 onwards.
+func (c Filter) attachExtraInputs(cmd *exec.Cmd) (*extraInputs, error) {
+	extra := &extraInputs{}
+	for _, in := range c.opts.ExtraInputs {
+		if f, ok := in.(*os.File); ok {
+			cmd.ExtraFiles = append(cmd.ExtraFiles, f)
+			continue
+		}
+		r, w, err := os.Pipe()
+		if err != nil {
+			extra.abort()
+			return nil, err
+		}
+		cmd.ExtraFiles = append(cmd.ExtraFiles, r)
+		extra.pipes = append(extra.pipes, extraPipe{r, w, in})
+	}
+	return extra, nil
+}
+
+// Starts feeding the readers to the child, which now holds the read ends.
+func (e *extraInputs) started() {
+	for _, p := range e.pipes {
+		p.r.Close()
+		go func(p extraPipe) {
+			// Stops early, with EPIPE, if the child doesn't read it all
+			copyPooled(p.w, p.src)
+			p.w.Close()
+		}(p)
+	}
+}
+
+// Releases the pipes of a spawn which failed to start, leaving the readers
+// unread.
+func (e *extraInputs) abort() {
+	for _, p := range e.pipes {
+		p.r.Close()
+		p.w.Close()
+	}
+}
@@ -0,0 +1,79 @@
+package extcompress
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns a zstd dictionary file; zstd uses any file as a raw dictionary.
+func writeDictionary(t *testing.T, dir string) *os.File {
+	var dict strings.Builder
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&dict, "line %d of the shared dictionary\n", i)
+	}
+	dictPath := path.Join(dir, "dict")
+	assert.Nil(t, ioutil.WriteFile(dictPath, []byte(dict.String()), 0644))
+	f, err := os.Open(dictPath)
+	assert.Nil(t, err)
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestExpandFDPlaceholders(t *testing.T) {
+	h := NewFilter("cat").WithOptions(Options{ExtraInputs: []io.Reader{strings.NewReader(""), strings.NewReader("")}}).(Filter)
+	args := []string{"-D", "{fd3}", "--key={fd4}", "plain"}
+	expanded, err := h.expandFDPlaceholders(args)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"-D", "/dev/fd/3", "--key=/dev/fd/4", "plain"}, expanded)
+	assert.Equal(t, "{fd3}", args[1])
+
+	for _, bad := range []string{"{fd5}", "{fd2}", "{fd0}"} {
+		_, err = h.expandFDPlaceholders([]string{bad})
+		assert.IsType(t, InvalidOption{}, err, bad)
+	}
+}
+
+func TestExtraInputsDictionary(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	zstd, err := GetExternalHandlerFromMimeType("application/zstd")
+	assert.Nil(t, err)
+	dict := writeDictionary(t, tmpdir)
+	withDict := zstd.WithOptions(Options{Args: []string{"-D", "{fd3}"}, ExtraInputs: []io.Reader{dict}})
+
+	// Input the dictionary covers, so the output refers to it
+	input := []byte("line 7 of the shared dictionary\nline 1234 of the shared dictionary\n")
+	compressed := compressBytes(t, withDict, input)
+
+	job, err := zstd.DecompressStream(bytes.NewReader(compressed))
+	assert.Nil(t, err)
+	ioutil.ReadAll(job)
+	assert.NotZero(t, job.Result())
+
+	// The same file serves every job
+	job, err = withDict.DecompressStream(bytes.NewReader(compressed))
+	assert.Nil(t, err)
+	out, err := ioutil.ReadAll(job)
+	assert.Nil(t, err)
+	assert.Zero(t, job.Result())
+	assert.Equal(t, input, out)
+}
+
+func TestExtraInputsReader(t *testing.T) {
+	h := NewFilter("sh", CompressFlags("-c", "cat {fd3} -")).
+		WithOptions(Options{ExtraInputs: []io.Reader{strings.NewReader("header\n")}})
+	assert.Equal(t, "header\n"+data, string(compressBytes(t, h, []byte(data))))
+
+	// A placeholder without an input is refused before anything runs
+	_, err := NewFilter("sh", CompressFlags("-c", "cat {fd3}")).CompressStream(bytes.NewReader(nil))
+	assert.IsType(t, InvalidOption{}, err)
+}
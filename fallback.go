@@ -0,0 +1,151 @@
+package extcompress
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Decompresses r in Go, for verifying data whose tool isn't installed.
+type FallbackDecoder func(r io.Reader) (io.Reader, error)
+
+var (
+	fallbackMtx      sync.RWMutex
+	fallbackDecoders = map[Format]FallbackDecoder{}
+)
+
+// Registers a Go decoder for format, used by the file and stream
+// decompression operations of handlers for the format when their tool
+// can't be found. Fallbacks never compress: compression and in-place
+// operations still need the tool. Build with the purexz tag to register
+// one for xz.
+func RegisterFallbackDecoder(format Format, dec FallbackDecoder) {
+	fallbackMtx.Lock()
+	defer fallbackMtx.Unlock()
+	if dec == nil {
+		delete(fallbackDecoders, format)
+		return
+	}
+	fallbackDecoders[format] = dec
+}
+
+// Returns the decoder op should run with instead of the tool, or nil if the
+// tool is to be used.
+func (c Filter) fallbackDecoder(op Operation) FallbackDecoder {
+	if op != OpDecompress && op != OpDecompressStream {
+		return nil
+	}
+	fallbackMtx.RLock()
+	dec := fallbackDecoders[FormatOf(c)]
+	fallbackMtx.RUnlock()
+	if dec == nil {
+		return nil
+	}
+	if _, err := exec.LookPath(c.Command); err == nil {
+		return nil
+	}
+	return dec
+}
+
+// Decompresses in with dec rather than the tool.
+func (c Filter) startFallbackJob(op Operation, in Input, dec FallbackDecoder) (CompressionProcess, error) {
+	id := c.newJobID()
+	jlog := c.jobLog(id).WithFields(log.Fields{"compressCmd": printable(c.Command), "filepath": printable(in.Path)})
+	jlog.Info("Fallback Decompression")
+
+	job := &fallbackJob{id: id, command: c.Command}
+	rd := in.Reader
+	if op == OpDecompress {
+		f, err := os.Open(in.Path)
+		if err != nil {
+			return nil, err
+		}
+		rd, job.source = f, f
+	} else if closer, ok := rd.(io.Closer); ok {
+		job.source = closer
+	}
+	decoded, err := dec(rd)
+	if err != nil {
+		job.Close()
+		return nil, job.corrupt(err)
+	}
+	job.rd = decoded
+	return job, nil
+}
+
+// A decompression done in Go, standing in for the tool.
+type fallbackJob struct {
+	id      string
+	command string
+	rd      io.Reader
+	source  io.Closer
+	closed  int32
+
+	mtx sync.Mutex
+	err error
+	eof bool
+}
+
+// Describes a decode error as the tool would have: as corrupt input.
+func (j *fallbackJob) corrupt(err error) error {
+	return CorruptInputError{Command: j.command + " (fallback)", ExitStatus: -1, Stderr: err.Error(), JobID: j.id}
+}
+
+func (j *fallbackJob) Read(p []byte) (int, error) {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+	if j.err != nil {
+		return 0, j.err
+	}
+	if j.eof {
+		return 0, io.EOF
+	}
+	if atomic.LoadInt32(&j.closed) != 0 {
+		j.err = io.ErrClosedPipe
+		return 0, j.err
+	}
+	n, err := j.rd.Read(p)
+	switch {
+	case err == io.EOF:
+		j.eof = true
+		j.Close()
+	case err != nil:
+		j.err = j.corrupt(err)
+		j.Close()
+		err = j.err
+	}
+	return n, err
+}
+
+// Reads whatever is left, so the whole input is checked, and returns 0 if
+// it decoded cleanly.
+func (j *fallbackJob) Result() int {
+	io.Copy(ioutil.Discard, j)
+	if j.Err() != nil {
+		return 1
+	}
+	return 0
+}
+
+// Returns why the job failed, once Result has returned.
+func (j *fallbackJob) Err() error {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+	return j.err
+}
+
+func (j *fallbackJob) Close() error {
+	if !atomic.CompareAndSwapInt32(&j.closed, 0, 1) || j.source == nil {
+		return nil
+	}
+	return j.source.Close()
+}
+
+func (j *fallbackJob) ID() string {
+	return j.id
+}
@@ -0,0 +1,84 @@
+package extcompress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Registers compress/gzip as the gzip fallback for the test.
+func gzipFallback(t *testing.T) {
+	RegisterFallbackDecoder(FormatGzip, func(r io.Reader) (io.Reader, error) {
+		return gzip.NewReader(r)
+	})
+	t.Cleanup(func() { RegisterFallbackDecoder(FormatGzip, nil) })
+}
+
+func TestFallbackDecoder(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	original := seekableTestData(300000)
+	filename := writeCompressed(t, tmpdir, "gzip", original)
+	compressed, err := ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+
+	gzipFallback(t)
+	pathWith(t)
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	sum := sha256.Sum256(original)
+	assert.Nil(t, h.DecompressAndVerify(filename, Checksum{ChecksumSHA256, sum[:]}))
+	assert.Nil(t, h.DecompressStreamAndVerify(bytes.NewReader(compressed), Checksum{ChecksumSHA256, sum[:]}))
+	out, err := h.DecompressToBytes(filename, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, original, out)
+
+	proc, err := h.DecompressStream(bytes.NewReader(compressed))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, proc.Result())
+
+	// Corruption is reported as the tool would
+	corrupt := path.Join(tmpdir, "corrupt.gz")
+	assert.Nil(t, ioutil.WriteFile(corrupt, compressed[:len(compressed)/2], os.FileMode(0644)))
+	err = h.DecompressAndVerify(corrupt, Checksum{ChecksumSHA256, sum[:]})
+	assert.True(t, errors.Is(err, ErrCorruptInput), "got %v", err)
+	proc, err = h.Decompress(corrupt)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, proc.Result())
+
+	// Compression still needs the tool
+	_, err = h.CompressStream(bytes.NewReader(original))
+	assert.NotNil(t, err)
+
+	report := Report()
+	for _, r := range report.Handlers {
+		if r.MimeType == "application/gzip" {
+			assert.Equal(t, StatusDegraded, r.Status)
+			assert.Contains(t, r.Reason, "fallback")
+		}
+	}
+}
+
+func TestFallbackDecoderUnused(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	gzipFallback(t)
+
+	// With the tool installed it is used as usual
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	proc, err := h.Decompress(writeCompressed(t, tmpdir, "gzip", []byte(data)))
+	assert.Nil(t, err)
+	_, ok := proc.(*CompressionJob)
+	assert.True(t, ok)
+	proc.Close()
+}
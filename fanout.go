@@ -0,0 +1,113 @@
+package extcompress
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// What Fanout does when a destination fails.
+type FanoutPolicy int
+
+const (
+	// Not set, leaving it to the defaults, and failing them FanoutFailFast
+	FanoutUnset FanoutPolicy = iota
+	// Stop the job and fail
+	FanoutFailFast
+	// Stop writing to that destination and carry on with the rest, failing
+	// only once none are left
+	FanoutBestEffort
+)
+
+// How writing to one destination of Fanout went.
+type FanoutStatus struct {
+	Written int64
+	// Why writing to the destination stopped, or nil if it got everything
+	Err error
+}
+
+// Describes a destination of Fanout failing.
+type FanoutError struct {
+	// Position of the destination in the arguments to Fanout
+	Index int
+	Err   error
+}
+
+func (r FanoutError) Error() string {
+	return fmt.Sprintf("fanout destination %d failed: %v", r.Index, r.Err)
+}
+
+func (r FanoutError) Unwrap() error {
+	return r.Err
+}
+
+// Returned for every destination of a job which was stopped because others
+// failed before they got all the output.
+var ErrFanoutAborted = errors.New("extcompress: fanout stopped before the output ended")
+
+// Copies the job's output to every one of dsts, then waits for the job.
+// Destinations are written in turn, so the slowest sets the pace. What
+// happens when one fails depends on the handler's Options.Fanout: by
+// default the job is stopped; best-effort stops writing to that destination
+// and only stops the job once none are left. Returns the failures of the
+// destinations, as FanoutErrors, joined with the job's own (see Err);
+// FanoutStatus has how each destination fared. Fails with
+// ErrInvalidOperation given no destinations.
+func (this *CompressionJob) Fanout(dsts ...io.Writer) error {
+	if len(dsts) == 0 {
+		return ErrInvalidOperation
+	}
+	status := make([]FanoutStatus, len(dsts))
+	live := len(dsts)
+	var failures []error
+	fail := func(i int, err error) {
+		status[i].Err = err
+		failures = append(failures, FanoutError{i, err})
+		live--
+	}
+
+	pool := currentBufferPool()
+	buf := pool.Get()
+	defer pool.Put(buf)
+	for live > 0 {
+		n, readErr := this.Read(buf)
+		for i, dst := range dsts {
+			if n == 0 || status[i].Err != nil {
+				continue
+			}
+			wn, err := dst.Write(buf[:n])
+			status[i].Written += int64(wn)
+			if err == nil && wn != n {
+				err = io.ErrShortWrite
+			}
+			if err != nil {
+				this.log.WithField("destination", i).WithField("error", err.Error()).Warn("Fanout destination failed")
+				fail(i, err)
+			}
+		}
+		if readErr != nil {
+			break
+		}
+		if len(failures) > 0 && this.fanout != FanoutBestEffort {
+			break
+		}
+	}
+
+	if live < len(dsts) && (live == 0 || this.fanout != FanoutBestEffort) {
+		for i := range status {
+			if status[i].Err == nil {
+				status[i].Err = ErrFanoutAborted
+			}
+		}
+		this.abort(failures[0])
+		this.kill()
+	}
+	this.fanoutStatus = status
+	return joinDistinct(append(failures, this.Err())...)
+}
+
+// Returns how each destination of Fanout fared, in the order they were
+// given, or nil if Fanout hasn't been called or hasn't finished.
+func (this *CompressionJob) FanoutStatus() []FanoutStatus {
+	return this.fanoutStatus
+}
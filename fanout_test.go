@@ -0,0 +1,108 @@
+package extcompress
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errSinkBroken = errors.New("sink broken")
+
+// Accepts limit bytes, then fails every write.
+type brokenSink struct {
+	limit int
+	bytes.Buffer
+}
+
+func (s *brokenSink) Write(p []byte) (int, error) {
+	if s.Len()+len(p) > s.limit {
+		n, _ := s.Buffer.Write(p[:s.limit-s.Len()])
+		return n, errSinkBroken
+	}
+	return s.Buffer.Write(p)
+}
+
+// Starts a job compressing size bytes of incompressible data, so there is
+// plenty of output.
+func fanoutJob(t *testing.T, policy FanoutPolicy, size int) (*CompressionJob, []byte) {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	input := randomTestData(size)
+	job, err := h.WithOptions(Options{Fanout: policy}).CompressStream(bytes.NewReader(input))
+	assert.Nil(t, err)
+	return job.(*CompressionJob), input
+}
+
+// Checks compressed decompresses to original.
+func assertGzipOf(t *testing.T, original []byte, compressed []byte) {
+	gz, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	plain, err := gz.DecompressStream(bytes.NewReader(compressed))
+	assert.Nil(t, err)
+	buf := &bytes.Buffer{}
+	_, err = buf.ReadFrom(plain)
+	assert.Nil(t, err)
+	assert.Zero(t, plain.Result())
+	assert.Equal(t, original, buf.Bytes())
+}
+
+func TestFanout(t *testing.T) {
+	job, input := fanoutJob(t, FanoutFailFast, 1<<20)
+	var a, b bytes.Buffer
+	assert.Nil(t, job.Fanout(&a, &b))
+	assert.Equal(t, a.Bytes(), b.Bytes())
+	assertGzipOf(t, input, a.Bytes())
+	assert.Equal(t, []FanoutStatus{{int64(a.Len()), nil}, {int64(b.Len()), nil}}, job.FanoutStatus())
+
+	job, _ = fanoutJob(t, FanoutFailFast, 10)
+	assert.Equal(t, ErrInvalidOperation, job.Fanout())
+	job.Close()
+}
+
+func TestFanoutFailFast(t *testing.T) {
+	job, _ := fanoutJob(t, FanoutFailFast, 4<<20)
+	var good bytes.Buffer
+	bad := &brokenSink{limit: 1000}
+	err := job.Fanout(&good, bad)
+
+	assert.True(t, errors.Is(err, errSinkBroken), "%v", err)
+	var fanoutErr FanoutError
+	assert.True(t, errors.As(err, &fanoutErr))
+	assert.Equal(t, 1, fanoutErr.Index)
+	assert.Equal(t, fanoutErr, job.Err())
+
+	status := job.FanoutStatus()
+	assert.Equal(t, ErrFanoutAborted, status[0].Err)
+	assert.Equal(t, errSinkBroken, status[1].Err)
+	assert.Equal(t, int64(1000), status[1].Written)
+	assert.True(t, status[0].Written < 4<<20, "the good destination got everything")
+}
+
+func TestFanoutBestEffort(t *testing.T) {
+	job, input := fanoutJob(t, FanoutBestEffort, 4<<20)
+	var good bytes.Buffer
+	bad := &brokenSink{limit: 1000}
+	err := job.Fanout(bad, &good)
+
+	// The failure is reported, but the job ran to the end for the other
+	var fanoutErr FanoutError
+	assert.True(t, errors.As(err, &fanoutErr), "%v", err)
+	assert.Equal(t, 0, fanoutErr.Index)
+	assert.Nil(t, job.Err())
+	assertGzipOf(t, input, good.Bytes())
+
+	status := job.FanoutStatus()
+	assert.Equal(t, FanoutStatus{1000, errSinkBroken}, status[0])
+	assert.Equal(t, FanoutStatus{int64(good.Len()), nil}, status[1])
+
+	// With every destination gone the job is stopped
+	job, _ = fanoutJob(t, FanoutBestEffort, 4<<20)
+	err = job.Fanout(&brokenSink{limit: 10}, &brokenSink{limit: 20})
+	assert.True(t, errors.Is(err, errSinkBroken))
+	assert.NotNil(t, job.Err())
+	for _, s := range job.FanoutStatus() {
+		assert.Equal(t, errSinkBroken, s.Err)
+	}
+}
@@ -0,0 +1,235 @@
+package extcompress
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Stands for the input file in the flags of a tool which can't read stdin.
+// File operations substitute the file; streaming ones a fifo with
+// Filter.PreferFIFO, and /dev/stdin otherwise.
+const InputPlaceholder = "{input}"
+
+// How long to wait between attempts to unblock a fifo the tool never opened.
+var fifoReleaseInterval = time.Millisecond
+
+// Replaces placeholder in args with value, reporting whether it was found.
+func substitutePlaceholder(args []string, placeholder string, value string) ([]string, bool) {
+	out := make([]string, 0, len(args))
+	found := false
+	for _, arg := range args {
+		if strings.Contains(arg, placeholder) {
+			found = true
+			arg = strings.Replace(arg, placeholder, value, -1)
+		}
+		out = append(out, arg)
+	}
+	return out, found
+}
+
+func hasPlaceholder(args []string, placeholder string) bool {
+	_, found := substitutePlaceholder(args, placeholder, "")
+	return found
+}
+
+// Our end of a fifo shared with the tool. Opening it blocks until the tool
+// opens the other end, so it happens on a goroutine, and is released by
+// opening the other end ourselves if the tool exits first.
+type fifoEnd struct {
+	path   string
+	flag   int
+	opened chan struct{}
+	f      *os.File
+	err    error
+}
+
+func newFIFOEnd(path string, flag int) (*fifoEnd, error) {
+	if err := makeFIFO(path); err != nil {
+		return nil, err
+	}
+	return &fifoEnd{path: path, flag: flag, opened: make(chan struct{})}, nil
+}
+
+func (e *fifoEnd) open() {
+	e.f, e.err = os.OpenFile(e.path, e.flag, 0)
+	close(e.opened)
+}
+
+func (e *fifoEnd) isOpen() bool {
+	select {
+	case <-e.opened:
+		return true
+	default:
+		return false
+	}
+}
+
+// Unblocks open if the tool never opened the other end, by briefly opening
+// it ourselves. Our end then sees EOF or EPIPE as if the tool had closed it.
+func (e *fifoEnd) release() {
+	other := os.O_RDONLY
+	if e.flag == os.O_RDONLY {
+		// Fails with ENXIO until our open is waiting
+		other = os.O_WRONLY
+	}
+	for !e.isOpen() {
+		if f, err := os.OpenFile(e.path, other|syscall.O_NONBLOCK, 0); err == nil {
+			f.Close()
+		}
+		select {
+		case <-e.opened:
+		case <-time.After(fifoReleaseInterval):
+		}
+	}
+}
+
+// Keeps unblocking the tool opening its end of an output fifo after ours is
+// closed, until done is closed, so it fails writing as it would to a closed
+// pipe rather than waiting forever for a reader.
+func (e *fifoEnd) keepReleased(done <-chan struct{}) {
+	for {
+		if f, err := os.OpenFile(e.path, os.O_RDONLY|syscall.O_NONBLOCK, 0); err == nil {
+			f.Close()
+		}
+		select {
+		case <-done:
+			return
+		case <-time.After(fifoReleaseInterval):
+		}
+	}
+}
+
+// Bridges the stream API to a tool which needs paths to read and write,
+// through fifos in a private directory which is removed once the tool has
+// exited and our ends of them are open.
+type fifoBridge struct {
+	dir string
+	in  *fifoEnd
+	out *fifoEnd
+	job *CompressionJob
+}
+
+// Creates fifos for the placeholders in args, returning args with them
+// substituted. Streaming operations get an input fifo for InputPlaceholder.
+func (c Filter) newFIFOBridge(streams bool, args []string) (*fifoBridge, []string, error) {
+	dir, err := ioutil.TempDir(c.opts.TempDir, "extcompress-fifo-")
+	if err != nil {
+		return nil, nil, err
+	}
+	b := &fifoBridge{dir: dir}
+	if streams && hasPlaceholder(args, InputPlaceholder) {
+		if b.in, err = newFIFOEnd(filepath.Join(dir, "input"), os.O_WRONLY); err != nil {
+			os.RemoveAll(dir)
+			return nil, nil, err
+		}
+		args, _ = substitutePlaceholder(args, InputPlaceholder, b.in.path)
+	}
+	if hasPlaceholder(args, OutputPlaceholder) || c.RequiresTempOutput {
+		if b.out, err = newFIFOEnd(filepath.Join(dir, "output"), os.O_RDONLY); err != nil {
+			os.RemoveAll(dir)
+			return nil, nil, err
+		}
+		var found bool
+		if args, found = substitutePlaceholder(args, OutputPlaceholder, b.out.path); !found {
+			args = append(args, b.out.path)
+		}
+	}
+	return b, args, nil
+}
+
+// Starts opening the fifos once job has been spawned, copying src to the
+// input fifo if there is one.
+func (b *fifoBridge) start(job *CompressionJob, src io.Reader) {
+	b.job = job
+	var wg sync.WaitGroup
+	if b.in != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.in.open()
+			if b.in.err != nil {
+				return
+			}
+			// Errors mean the tool stopped reading, which its exit status
+			// reports
+			copyPooled(b.in.f, src)
+			b.in.f.Close()
+		}()
+	}
+	if b.out != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.out.open()
+		}()
+	}
+	go b.releaseOnExit()
+	go func() {
+		wg.Wait()
+		// Kept until then so a closed output fifo can still be released
+		<-job.done
+		if err := os.RemoveAll(b.dir); err != nil {
+			job.log.WithField("error", err.Error()).Warn("Error removing fifos")
+		}
+	}()
+}
+
+// Releases any fifo the tool never opened once it has exited, so a tool
+// failing before opening them can't leave the job hung.
+func (b *fifoBridge) releaseOnExit() {
+	for {
+		if (b.in == nil || b.in.isOpen()) && (b.out == nil || b.out.isOpen()) {
+			return
+		}
+		select {
+		case <-b.job.done:
+		default:
+			if exited, err := processExited(b.job.cmd.Process.Pid); err == nil && !exited {
+				time.Sleep(undrainedPollInterval)
+				continue
+			}
+		}
+		b.release()
+		return
+	}
+}
+
+func (b *fifoBridge) release() {
+	if b.in != nil {
+		b.in.release()
+	}
+	if b.out != nil {
+		b.out.release()
+	}
+}
+
+func (b *fifoBridge) Read(p []byte) (int, error) {
+	<-b.out.opened
+	if b.out.err != nil {
+		return 0, b.out.err
+	}
+	return b.out.f.Read(p)
+}
+
+func (b *fifoBridge) Close() error {
+	b.out.release()
+	go b.out.keepReleased(b.job.done)
+	if b.out.f == nil {
+		return b.out.err
+	}
+	return b.out.f.Close()
+}
+
+// Returns the output fifo once it is open, for checking whether it's full.
+func (b *fifoBridge) file() (*os.File, bool) {
+	if !b.out.isOpen() || b.out.f == nil {
+		return nil, false
+	}
+	return b.out.f, true
+}
@@ -0,0 +1,161 @@
+package extcompress
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A tool which only works on named files: fakepipe <-c|-d> <in> <out>
+const fakePipeScript = `#!/bin/sh
+exec gzip $1 -c < "$2" > "$3"
+`
+
+// Writes its first line, then waits for gate to exist before the rest:
+// fakeslow <gate> <out>
+const fakeSlowScript = `#!/bin/sh
+{
+	echo first
+	while [ ! -e "$1" ]; do sleep 0.01; done
+	echo rest
+} > "$2"
+`
+
+func writeTool(t *testing.T, dir string, name string, script string) string {
+	tool := path.Join(dir, name)
+	assert.Nil(t, ioutil.WriteFile(tool, []byte(script), os.FileMode(0755)))
+	return tool
+}
+
+// Returns a PreferFIFO handler around tool, making its fifos in its own
+// temp directory.
+func setupFIFOFilter(t *testing.T, tmpdir string, tool string, opts ...FilterOption) (Filter, string) {
+	fifoDir := path.Join(tmpdir, "fifos")
+	assert.Nil(t, os.Mkdir(fifoDir, os.FileMode(0755)))
+	f := NewFilter(tool, opts...).(Filter)
+	f.PreferFIFO = true
+	f.Extensions = []string{".gz"}
+	return f.WithTempDir(fifoDir).(Filter), fifoDir
+}
+
+func assertFIFOsRemoved(t *testing.T, fifoDir string) {
+	assert.Eventually(t, func() bool {
+		entries, err := ioutil.ReadDir(fifoDir)
+		return err == nil && len(entries) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestFIFORoundTrip(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	tool := writeTool(t, tmpdir, "fakepipe", fakePipeScript)
+	h, fifoDir := setupFIFOFilter(t, tmpdir, tool,
+		CompressFlags("-c", InputPlaceholder, OutputPlaceholder),
+		DecompressFlags("-d", InputPlaceholder, OutputPlaceholder),
+		Suffix(".gz", ""))
+
+	original := seekableTestData(500000)
+	proc, err := h.CompressStream(bytes.NewReader(original))
+	compressed := readJob(t, proc, err, "compress")
+	proc, err = h.DecompressStream(bytes.NewReader(compressed))
+	assert.Equal(t, original, readJob(t, proc, err, "decompress"))
+
+	// File operations substitute the path for the input
+	filename := path.Join(tmpdir, "pipechaining")
+	proc, err = h.Compress(filename)
+	compressed = readJob(t, proc, err, "compress file")
+	proc, err = h.DecompressStream(bytes.NewReader(compressed))
+	assert.Equal(t, data, string(readJob(t, proc, err, "decompress")))
+
+	// In place goes through the package
+	assert.Nil(t, h.CompressFileInPlace(filename))
+	assert.Nil(t, h.DecompressFileInPlace(filename+".gz"))
+	out, err := ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, data, string(out))
+	assertFIFOsRemoved(t, fifoDir)
+}
+
+func TestFIFOStreams(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	tool := writeTool(t, tmpdir, "fakeslow", fakeSlowScript)
+	gate := path.Join(tmpdir, "gate")
+	h, fifoDir := setupFIFOFilter(t, tmpdir, tool, CompressFlags(gate, OutputPlaceholder))
+
+	proc, err := h.CompressStream(strings.NewReader(""))
+	assert.Nil(t, err)
+	first := make([]byte, len("first\n"))
+	_, err = io.ReadFull(proc, first)
+	assert.Nil(t, err)
+	assert.Equal(t, "first\n", string(first))
+
+	// The first bytes arrived while the tool was still running
+	select {
+	case <-proc.(*CompressionJob).Done():
+		t.Fatal("tool exited before its output was read")
+	default:
+	}
+	assert.Nil(t, ioutil.WriteFile(gate, nil, os.FileMode(0644)))
+	rest, err := ioutil.ReadAll(proc)
+	assert.Nil(t, err)
+	assert.Equal(t, "rest\n", string(rest))
+	assert.Equal(t, 0, proc.Result())
+	assertFIFOsRemoved(t, fifoDir)
+}
+
+func TestFIFOToolFailsBeforeOpen(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	tool := writeTool(t, tmpdir, "fakefail", "#!/bin/sh\nexit 3\n")
+	h, fifoDir := setupFIFOFilter(t, tmpdir, tool,
+		CompressFlags(InputPlaceholder, OutputPlaceholder))
+
+	// An endless input must not keep the job alive either
+	proc, err := h.CompressStream(zeroReader{})
+	assert.Nil(t, err)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		out, err := ioutil.ReadAll(proc)
+		assert.Nil(t, err)
+		assert.Empty(t, out)
+		assert.Equal(t, 3, proc.Result())
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("job hung on fifos the tool never opened")
+	}
+	assertFIFOsRemoved(t, fifoDir)
+}
+
+func TestFIFOCloseUnread(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	tool := writeTool(t, tmpdir, "fakepipe", fakePipeScript)
+	h, fifoDir := setupFIFOFilter(t, tmpdir, tool,
+		CompressFlags("-c", InputPlaceholder, OutputPlaceholder))
+
+	proc, err := h.CompressStream(bytes.NewReader(seekableTestData(1 << 20)))
+	assert.Nil(t, err)
+	assert.Nil(t, proc.Close())
+	assertFIFOsRemoved(t, fifoDir)
+}
+
+func TestSubstitutePlaceholder(t *testing.T) {
+	args, found := substitutePlaceholder([]string{"-o", "--out=" + OutputPlaceholder}, OutputPlaceholder, "/x")
+	assert.True(t, found)
+	assert.Equal(t, []string{"-o", "--out=/x"}, args)
+
+	args, found = substitutePlaceholder([]string{"-c"}, InputPlaceholder, "/x")
+	assert.False(t, found)
+	assert.Equal(t, []string{"-c"}, args)
+}
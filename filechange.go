@@ -0,0 +1,140 @@
+package extcompress
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// Returned by file based compression when the input changed while it was
+// being compressed (see FileChangePolicy).
+var ErrFileChanged = errors.New("extcompress: file changed during compression")
+
+// How file based compression deals with inputs which are still being
+// written to, such as live log files.
+type FileChangePolicy int
+
+const (
+	// Not set, leaving it to the defaults, and failing them
+	// FileChangeIgnore
+	FileChangeUnset FileChangePolicy = iota
+	// Pass the path to the tool and don't check it
+	FileChangeIgnore
+	// Compress exactly the bytes present when the operation started,
+	// ignoring anything appended since. Fails with ErrFileChanged if the
+	// file is truncated before they can be read.
+	FileChangeSnapshot
+	// Fail with ErrFileChanged if the file's size or modification time
+	// differs once the tool has finished with it.
+	FileChangeStrict
+)
+
+// True if the policy does anything about changes.
+func (p FileChangePolicy) checks() bool {
+	return p == FileChangeSnapshot || p == FileChangeStrict
+}
+
+// Reads exactly size bytes of a file, noting if it ends early.
+type snapshotReader struct {
+	r         io.Reader
+	remaining int64
+	short     int32
+}
+
+func (s *snapshotReader) Read(p []byte) (int, error) {
+	if s.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > s.remaining {
+		p = p[:s.remaining]
+	}
+	n, err := s.r.Read(p)
+	s.remaining -= int64(n)
+	if err == io.EOF && s.remaining > 0 {
+		// Report the shortfall once the job finishes rather than failing the
+		// copy into the tool, which would abort it mid-stream.
+		atomic.StoreInt32(&s.short, 1)
+	}
+	return n, err
+}
+
+// A compression job whose input is checked for changes when its output ends.
+type fileChangeJob struct {
+	CompressionProcess
+	file  *os.File
+	once  sync.Once
+	check func() error
+	err   error
+}
+
+func (j *fileChangeJob) finish() error {
+	j.once.Do(func() {
+		j.err = j.check()
+		j.file.Close()
+	})
+	return j.err
+}
+
+func (j *fileChangeJob) Read(p []byte) (int, error) {
+	n, err := j.CompressionProcess.Read(p)
+	if err == io.EOF {
+		if cerr := j.finish(); cerr != nil {
+			err = cerr
+		}
+	}
+	return n, err
+}
+
+func (j *fileChangeJob) Close() error {
+	defer j.once.Do(func() { j.file.Close() })
+	return j.CompressionProcess.Close()
+}
+
+// Compresses filePath according to the handler's FileChangePolicy.
+func (c Filter) compressChangingFile(filePath string) (CompressionProcess, error) {
+	policy := c.opts.FileChange
+	c.opts.FileChange = FileChangeIgnore
+
+	f, st, err := c.openSource(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if !st.Mode().IsRegular() {
+		// Devices and pipes have no meaningful size to hold them to
+		f.Close()
+		return c.Compress(filePath)
+	}
+
+	var proc CompressionProcess
+	var check func() error
+	switch policy {
+	case FileChangeSnapshot:
+		snap := &snapshotReader{r: f, remaining: st.Size()}
+		proc, err = c.CompressStream(snap)
+		check = func() error {
+			if atomic.LoadInt32(&snap.short) != 0 {
+				return ErrFileChanged
+			}
+			return nil
+		}
+	default:
+		proc, err = c.Compress(filePath)
+		check = func() error {
+			now, err := os.Stat(filePath)
+			if err != nil {
+				return err
+			}
+			if now.Size() != st.Size() || !now.ModTime().Equal(st.ModTime()) {
+				return ErrFileChanged
+			}
+			return nil
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &fileChangeJob{CompressionProcess: proc, file: f, check: check}, nil
+}
@@ -0,0 +1,125 @@
+package extcompress
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Starts compressing filename, then calls change once the compressor is
+// part way through the file, and returns the output and read error.
+func compressWhileChanging(t *testing.T, h ExternalHandler, filename string, change func()) ([]byte, error) {
+	proc, err := h.Compress(filename)
+	assert.Nil(t, err)
+
+	// The pipe fills long before the compressor reaches the end of the
+	// file, so it is still reading when the file changes.
+	var out bytes.Buffer
+	_, err = io.CopyN(&out, proc, 4096)
+	assert.Nil(t, err)
+	change()
+	_, err = io.Copy(&out, proc)
+	proc.Result()
+	return out.Bytes(), err
+}
+
+func appendTo(t *testing.T, filename string, data []byte) {
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0)
+	assert.Nil(t, err)
+	_, err = f.Write(data)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+}
+
+func TestFileChangeSnapshot(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	original := seekableTestData(8 * mib)
+	filename := path.Join(tmpdir, "live.log")
+	assert.Nil(t, ioutil.WriteFile(filename, original, os.FileMode(0644)))
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	h = h.WithOptions(Options{FileChange: FileChangeSnapshot})
+
+	out, err := compressWhileChanging(t, h, filename, func() {
+		appendTo(t, filename, []byte("appended after the snapshot\n"))
+	})
+	assert.Nil(t, err)
+
+	// Exactly the bytes present at the start were compressed
+	proc, err := h.DecompressStream(ioutil.NopCloser(bytes.NewReader(out)))
+	assert.Nil(t, err)
+	decompressed, err := ioutil.ReadAll(proc)
+	assert.Nil(t, err)
+	assert.Equal(t, len(original), len(decompressed))
+	assert.Equal(t, original, decompressed)
+
+	// Truncation means the snapshot can't be completed
+	assert.Nil(t, ioutil.WriteFile(filename, original, os.FileMode(0644)))
+	_, err = compressWhileChanging(t, h, filename, func() {
+		assert.Nil(t, os.Truncate(filename, mib))
+	})
+	assert.Equal(t, ErrFileChanged, err)
+}
+
+func TestFileChangeStrict(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	filename := path.Join(tmpdir, "live.log")
+	assert.Nil(t, ioutil.WriteFile(filename, seekableTestData(8*mib), os.FileMode(0644)))
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	h = h.WithOptions(Options{FileChange: FileChangeStrict})
+
+	_, err = compressWhileChanging(t, h, filename, func() {
+		appendTo(t, filename, []byte("appended mid-run\n"))
+	})
+	assert.Equal(t, ErrFileChanged, err)
+
+	// An unchanged file compresses normally
+	_, err = compressWhileChanging(t, h, filename, func() {})
+	assert.Nil(t, err)
+}
+
+func TestFileChangeStrictInPlace(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	filename := path.Join(tmpdir, "pipechaining")
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	h = h.WithOptions(Options{FileChange: FileChangeStrict})
+
+	// Unchanged files are replaced as usual
+	assert.Nil(t, h.CompressFileInPlace(filename))
+	_, err = os.Stat(filename + ".gz")
+	assert.Nil(t, err)
+	_, err = os.Stat(filename)
+	assert.True(t, os.IsNotExist(err))
+
+	// A change makes the operation fail, keeping the original
+	changing := path.Join(tmpdir, "live.log")
+	assert.Nil(t, ioutil.WriteFile(changing, seekableTestData(8*mib), os.FileMode(0644)))
+	st, err := os.Stat(changing)
+	assert.Nil(t, err)
+	err = h.(Filter).replaceFileWith(changing, changing+".gz", st, func(filePath string) (CompressionProcess, error) {
+		proc, err := h.Compress(filePath)
+		appendTo(t, changing, []byte("appended mid-run\n"))
+		return proc, err
+	})
+	assert.Equal(t, ErrFileChanged, err)
+	_, err = os.Stat(changing)
+	assert.Nil(t, err)
+	_, err = os.Stat(changing + ".gz")
+	assert.True(t, os.IsNotExist(err))
+	assertNoTempFiles(t, tmpdir)
+}
@@ -0,0 +1,68 @@
+package extcompress
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Returns filePath as it is passed to a tool. A relative path starting with
+// a dash gets a "./" so the tool can't take it for an option. Everything
+// else is passed byte for byte.
+func argPath(filePath string) string {
+	if strings.HasPrefix(filePath, "-") {
+		return "./" + filePath
+	}
+	return filePath
+}
+
+// Returns s for logs and error messages, with control characters and bytes
+// which aren't valid UTF-8 escaped as in a Go string literal. Printable text,
+// spaces included, is left as it is.
+func printable(s string) string {
+	clean := true
+	for _, r := range s {
+		if r == utf8.RuneError || !unicode.IsPrint(r) && r != ' ' {
+			clean = false
+			break
+		}
+	}
+	if clean {
+		return s
+	}
+	quoted := strconv.Quote(s)
+	return quoted[1 : len(quoted)-1]
+}
+
+// Applies printable to each of args.
+func printableAll(args []string) []string {
+	out := make([]string, len(args))
+	for i, arg := range args {
+		out[i] = printable(arg)
+	}
+	return out
+}
+
+// Reports whether s ends in suffix, ignoring ASCII case. Only the bytes of
+// s under the suffix are compared, so names which aren't valid UTF-8 are
+// handled as they are.
+func hasSuffixFold(s string, suffix string) bool {
+	if len(s) < len(suffix) {
+		return false
+	}
+	tail := s[len(s)-len(suffix):]
+	for i := 0; i < len(suffix); i++ {
+		a, b := tail[i], suffix[i]
+		if 'A' <= a && a <= 'Z' {
+			a += 'a' - 'A'
+		}
+		if 'A' <= b && b <= 'Z' {
+			b += 'a' - 'A'
+		}
+		if a != b {
+			return false
+		}
+	}
+	return true
+}
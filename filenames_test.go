@@ -0,0 +1,110 @@
+package extcompress
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Names which take unusual paths through argv construction, suffix
+// handling and logging. The long one is as long as a name can be once a
+// four byte suffix such as ".zst" is added.
+var hostileNames = []string{
+	"with space",
+	"new\nline",
+	"bad\xffbyte",
+	strings.Repeat("n", 251),
+	"-leading-dash",
+}
+
+// Changes into dir for the rest of the test.
+func chdir(t *testing.T, dir string) {
+	wd, err := os.Getwd()
+	assert.Nil(t, err)
+	assert.Nil(t, os.Chdir(dir))
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestHostileFilenames(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	// Relative, so names can start with a dash
+	chdir(t, tmpdir)
+
+	for _, mimeType := range []string{"application/gzip", "application/x-xz", "application/x-bzip2", "application/zstd"} {
+		h, err := GetExternalHandlerFromMimeType(mimeType)
+		assert.Nil(t, err)
+		suffix := h.(Filter).suffix()
+		for _, name := range hostileNames {
+			assert.Nil(t, ioutil.WriteFile(name, []byte(data), os.FileMode(0644)))
+
+			// Compression of the file itself
+			proc, err := h.Compress(name)
+			assert.Nil(t, err, "%s %q", mimeType, name)
+			compressed, err := ioutil.ReadAll(proc)
+			assert.Nil(t, err)
+			assert.Equal(t, 0, proc.Result(), "%s %q", mimeType, name)
+
+			// In place, and detected by its contents
+			assert.Nil(t, h.CompressFileInPlace(name), "%s %q", mimeType, name)
+			out, err := ioutil.ReadFile(name + suffix)
+			assert.Nil(t, err, "%s %q", mimeType, name)
+			assert.Equal(t, len(compressed) > 0, len(out) > 0)
+			detected, err := GetFileTypeExternalHandler(name + suffix)
+			if assert.Nil(t, err, "%s %q", mimeType, name) {
+				assert.Equal(t, mimeMap[mimeType], mimeMap[detected.MimeType()])
+			}
+
+			// Decompression of the file, and in place
+			plain, err := h.DecompressToBytes(name+suffix, 0)
+			assert.Nil(t, err, "%s %q", mimeType, name)
+			assert.Equal(t, data, string(plain))
+			decompressed, err := h.DecompressFileInPlaceWithOptions(name+suffix, InPlaceOptions{})
+			assert.Nil(t, err, "%s %q", mimeType, name)
+			assert.Equal(t, name, decompressed)
+			plain, err = ioutil.ReadFile(name)
+			assert.Nil(t, err)
+			assert.Equal(t, data, string(plain))
+			os.Remove(name)
+		}
+	}
+}
+
+func TestHostileFilenamesBatch(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	chdir(t, tmpdir)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	for _, name := range hostileNames {
+		assert.Nil(t, ioutil.WriteFile(name, []byte(data), os.FileMode(0644)))
+	}
+	results, err := h.CompressFilesInPlace(hostileNames, InPlaceOptions{})
+	assert.Nil(t, err)
+	for i, res := range results {
+		assert.Nil(t, res.Err, "%q", hostileNames[i])
+		assert.Equal(t, hostileNames[i]+".gz", res.Output)
+	}
+}
+
+func TestPrintable(t *testing.T) {
+	assert.Equal(t, "with space", printable("with space"))
+	assert.Equal(t, "naïve", printable("naïve"))
+	assert.Equal(t, `new\nline`, printable("new\nline"))
+	assert.Equal(t, `bad\xffbyte`, printable("bad\xffbyte"))
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	assert.Equal(t, `gzip -c ./-x bad\xff`, h.(Filter).displayCommand([]string{"-c", argPath("-x"), "bad\xff"}))
+}
+
+func TestHasSuffixFold(t *testing.T) {
+	assert.True(t, hasSuffixFold("file.GZ", ".gz"))
+	assert.True(t, hasSuffixFold("\xff.gz", ".gz"))
+	assert.False(t, hasSuffixFold("file.g\xff", ".gz"))
+	assert.False(t, hasSuffixFold("gz", ".gz"))
+}
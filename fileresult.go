@@ -0,0 +1,110 @@
+package extcompress
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// Outcome of an in-place operation on one file, filled in whether or not it
+// succeeded.
+type FileOpResult struct {
+	OriginalPath string
+	// Name of the file produced. Empty if the operation failed.
+	ResultPath string
+	// Sizes of the original file and of the file produced. OutputBytes is 0
+	// if nothing was produced.
+	InputBytes  int64
+	OutputBytes int64
+	// OutputBytes over InputBytes, or 0 if either is unknown
+	Ratio    float64
+	Duration time.Duration
+	// Exit status of the tool, or -1 if it didn't run or exit normally. For a
+	// batch, the status of the run the file was part of.
+	ExitCode int
+	// The end of what the tool wrote to stderr
+	StderrTail string
+	// What the tool warned about, if it succeeded with warnings or
+	// Options.TreatWarningsAsErrors made them fail the file
+	Warnings []string
+	// Number of hard links the original had, and the policy applied to it
+	// if there were others
+	Links     uint64
+	HardLinks HardLinkPolicy
+	// Why the file failed, or nil
+	Err error
+}
+
+// Fills in the fields which can be worked out once the operation is over.
+// outPath is empty if nothing was produced.
+func (r *FileOpResult) finish(outPath string, err error, started time.Time, tail *stderrTail) {
+	r.Duration = time.Since(started)
+	r.ExitCode = exitCodeOf(err)
+	r.StderrTail = tail.String()
+	tail.complete()
+	r.Err = err
+	if outPath == "" {
+		return
+	}
+	r.ResultPath = outPath
+	if st, statErr := os.Stat(outPath); statErr == nil {
+		r.OutputBytes = st.Size()
+	}
+	if r.InputBytes > 0 && r.OutputBytes > 0 {
+		r.Ratio = float64(r.OutputBytes) / float64(r.InputBytes)
+	}
+}
+
+// Returns the exit status a failed operation's error carries, 0 for success
+// and -1 if the tool didn't run or exit normally.
+func exitCodeOf(err error) int {
+	var exitErr ExitStatusError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus
+	}
+	var corruptErr CorruptInputError
+	if errors.As(err, &corruptErr) {
+		return corruptErr.ExitStatus
+	}
+	return exitStatusOf(err)
+}
+
+// Returns a result for filePath with its size filled in, and a copy of the
+// handler which keeps the end of the tool's stderr and its warnings for it.
+func (c Filter) startFileOp(filePath string, opts InPlaceOptions) (Filter, *FileOpResult, *stderrTail) {
+	res := &FileOpResult{OriginalPath: filePath}
+	if st, err := os.Stat(filePath); err == nil {
+		res.InputBytes = st.Size()
+		res.Links = linkCount(st)
+		if res.Links > 1 {
+			res.HardLinks = opts.HardLinks
+		}
+	}
+	tail := &stderrTail{}
+	c.stderrCapture = tail
+	c.warningCapture = &warningLog{}
+	return c, res, tail
+}
+
+// Compresses filePath in place, honoring opts, as for
+// CompressFileInPlaceWithOptions, and reports how it went. The result is
+// filled in on failure too, with Err the same as the error returned.
+func (c Filter) CompressFileInPlaceResult(filePath string, opts InPlaceOptions) (FileOpResult, error) {
+	started := time.Now()
+	c, res, tail := c.startFileOp(filePath, opts)
+	outPath, err := c.CompressFileInPlaceWithOptions(filePath, opts)
+	res.Warnings = c.warningCapture.list()
+	res.finish(outPath, err, started, tail)
+	return *res, err
+}
+
+// Decompresses filePath in place, honoring opts, as for
+// DecompressFileInPlaceWithOptions, and reports how it went.
+func (c Filter) DecompressFileInPlaceResult(filePath string, opts InPlaceOptions) (FileOpResult, error) {
+	started := time.Now()
+	c, res, tail := c.startFileOp(filePath, opts)
+	outPath, err := c.DecompressFileInPlaceWithOptions(filePath, opts)
+	res.Warnings = c.warningCapture.list()
+	res.finish(outPath, err, started, tail)
+	return *res, err
+}
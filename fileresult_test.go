@@ -0,0 +1,88 @@
+package extcompress
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressFileInPlaceResult(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	filePath := path.Join(tmpdir, "data.txt")
+	assert.Nil(t, ioutil.WriteFile(filePath, []byte(data), os.FileMode(0644)))
+
+	res, err := h.CompressFileInPlaceResult(filePath, InPlaceOptions{})
+	assert.Nil(t, err)
+	assert.Nil(t, res.Err)
+	assert.Equal(t, filePath, res.OriginalPath)
+	assert.Equal(t, filePath+".gz", res.ResultPath)
+	assert.Equal(t, int64(len(data)), res.InputBytes)
+	st, err := os.Stat(res.ResultPath)
+	assert.Nil(t, err)
+	assert.Equal(t, st.Size(), res.OutputBytes)
+	assert.InDelta(t, float64(st.Size())/float64(len(data)), res.Ratio, 1e-9)
+	assert.True(t, res.Duration > 0)
+	assert.Zero(t, res.ExitCode)
+}
+
+func TestDecompressFileInPlaceResultFailure(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	filePath := path.Join(tmpdir, "bogus.gz")
+	assert.Nil(t, ioutil.WriteFile(filePath, []byte(data), os.FileMode(0644)))
+
+	res, err := h.DecompressFileInPlaceResult(filePath, InPlaceOptions{})
+	assert.NotNil(t, err)
+	assert.Equal(t, err, res.Err)
+	assert.Equal(t, filePath, res.OriginalPath)
+	assert.Empty(t, res.ResultPath)
+	assert.Equal(t, int64(len(data)), res.InputBytes)
+	assert.Zero(t, res.OutputBytes)
+	assert.Zero(t, res.Ratio)
+	assert.True(t, res.Duration > 0)
+	assert.Equal(t, 1, res.ExitCode)
+	assert.Contains(t, res.StderrTail, "not in gzip format")
+}
+
+func TestCompressFilesInPlaceResult(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	paths := writeSmallFiles(t, tmpdir, 3)
+	bad := paths[1]
+	makeUnreadable(t, bad)
+
+	results, err := h.CompressFilesInPlaceResult(paths, InPlaceOptions{})
+	assert.Nil(t, err)
+	assert.Len(t, results, len(paths))
+	for i, res := range results {
+		assert.Equal(t, paths[i], res.OriginalPath)
+		assert.NotZero(t, res.ExitCode)
+		assert.NotEmpty(t, res.StderrTail)
+		if res.OriginalPath == bad {
+			assert.NotNil(t, res.Err)
+			assert.Empty(t, res.ResultPath)
+			continue
+		}
+		assert.Nil(t, res.Err)
+		assert.Equal(t, res.OriginalPath+".gz", res.ResultPath)
+		assert.Equal(t, int64(len(data)), res.InputBytes)
+		assert.NotZero(t, res.OutputBytes)
+		assert.NotZero(t, res.Ratio)
+	}
+}
@@ -0,0 +1,87 @@
+package extcompress
+
+import (
+	"path/filepath"
+)
+
+// Canonical identifier of a compressed data format, shared by every tool
+// which reads and writes it (gzip and pigz are both FormatGzip).
+type Format string
+
+const (
+	FormatUnknown  Format = ""
+	FormatGzip     Format = "gzip"
+	FormatBzip2    Format = "bzip2"
+	FormatXz       Format = "xz"
+	FormatZstd     Format = "zstd"
+	FormatLz4      Format = "lz4"
+	FormatLzop     Format = "lzop"
+	FormatIdentity Format = "identity"
+)
+
+// Format of the output of each command, for filters which don't set their
+// Format. Alternative implementations map onto the format they produce; lzma
+// is grouped with xz, which reads it.
+var formatAliases = map[string]Format{
+	"gzip":   FormatGzip,
+	"pigz":   FormatGzip,
+	"unpigz": FormatGzip,
+	"bzip2":  FormatBzip2,
+	"pbzip2": FormatBzip2,
+	"lbzip2": FormatBzip2,
+	"xz":     FormatXz,
+	"pxz":    FormatXz,
+	"pixz":   FormatXz,
+	"lzma":   FormatXz,
+	"zstd":   FormatZstd,
+	"zstdmt": FormatZstd,
+	"pzstd":  FormatZstd,
+	"lz4":    FormatLz4,
+	"lzop":   FormatLzop,
+	"cat":    FormatIdentity,
+}
+
+// Sets the format of the data the handler produces, for tools not in the
+// alias table or run with flags which change their output format.
+func OutputFormat(format Format) FilterOption {
+	return func(f *Filter) {
+		f.Format = format
+	}
+}
+
+// Returns the format of the data h produces, or FormatUnknown if it can't
+// be told.
+func FormatOf(h ExternalHandler) Format {
+	f, ok := h.(Filter)
+	if !ok {
+		return FormatUnknown
+	}
+	if f.Format != FormatUnknown {
+		return f.Format
+	}
+	if f.Passthrough {
+		return FormatIdentity
+	}
+	return formatAliases[filepath.Base(f.Command)]
+}
+
+// True if a and b produce the same format, so the output of either can be
+// read by the other. Handlers of unknown format never match.
+func SameFormat(a, b ExternalHandler) bool {
+	format := FormatOf(a)
+	return format != FormatUnknown && format == FormatOf(b)
+}
+
+// Reports whether filePath is already in the format h produces, e.g. to
+// skip files a recompression pass has no need to touch. Files of a type
+// with no handler are never in h's format.
+func AlreadyInFormat(filePath string, h ExternalHandler) (bool, error) {
+	current, err := GetFileTypeExternalHandler(filePath)
+	if _, unknown := err.(UnknownFileType); unknown {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return SameFormat(current, h), nil
+}
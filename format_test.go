@@ -0,0 +1,87 @@
+package extcompress
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatOfBuiltins(t *testing.T) {
+	expected := map[string]Format{
+		"bzip2": FormatBzip2,
+		"gzip":  FormatGzip,
+		"xz":    FormatXz,
+		"lzop":  FormatLzop,
+		"zstd":  FormatZstd,
+		"cat":   FormatIdentity,
+	}
+	for name, format := range expected {
+		assert.Equal(t, format, FormatOf(filtersMap[name]), name)
+	}
+	for mimeType := range mimeMap {
+		if isMimeWildcard(mimeType) {
+			continue
+		}
+		h, err := GetExternalHandlerFromMimeType(mimeType)
+		assert.Nil(t, err)
+		assert.NotEqual(t, FormatUnknown, FormatOf(h), mimeType)
+	}
+	assert.Equal(t, FormatIdentity, FormatOf(Identity()))
+}
+
+func TestFormatAliases(t *testing.T) {
+	gz, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	bz, err := GetExternalHandlerFromMimeType("application/x-bzip2")
+	assert.Nil(t, err)
+	xz, err := GetExternalHandlerFromMimeType("application/x-xz")
+	assert.Nil(t, err)
+
+	for command, format := range formatAliases {
+		assert.Equal(t, format, FormatOf(NewFilter(command)), command)
+	}
+	assert.True(t, SameFormat(NewFilter("pigz"), gz))
+	assert.True(t, SameFormat(NewFilter("/usr/local/bin/pbzip2"), bz))
+	assert.True(t, SameFormat(NewFilter("lzma"), xz))
+	assert.False(t, SameFormat(gz, bz))
+	assert.False(t, SameFormat(gz, Identity()))
+
+	// Unknown tools match nothing, not even themselves
+	unknown := NewFilter("extcompress-no-such-command")
+	assert.Equal(t, FormatUnknown, FormatOf(unknown))
+	assert.False(t, SameFormat(unknown, unknown))
+	assert.Equal(t, FormatUnknown, FormatOf(nil))
+
+	// Flags changing the output format are declared with OutputFormat
+	zstdGzip := NewFilter("zstd", CompressFlags("-q", "-c", "--format=gzip"), OutputFormat(FormatGzip))
+	assert.True(t, SameFormat(zstdGzip, gz))
+}
+
+func TestAlreadyInFormat(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	gz, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	xz, err := GetExternalHandlerFromMimeType("application/x-xz")
+	assert.Nil(t, err)
+
+	filename := path.Join(tmpdir, "pipechaining")
+	in, err := AlreadyInFormat(filename, gz)
+	assert.Nil(t, err)
+	assert.False(t, in)
+
+	compressed, err := gz.CompressFileInPlaceWithOptions(filename, InPlaceOptions{})
+	assert.Nil(t, err)
+	in, err = AlreadyInFormat(compressed, gz)
+	assert.Nil(t, err)
+	assert.True(t, in)
+	in, err = AlreadyInFormat(compressed, NewFilter("pigz"))
+	assert.Nil(t, err)
+	assert.True(t, in)
+	in, err = AlreadyInFormat(compressed, xz)
+	assert.Nil(t, err)
+	assert.False(t, in)
+}
@@ -0,0 +1,139 @@
+package extcompress
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Returned by compression whose output doesn't start with the magic bytes
+// of the handler's format (see Options.VerifyOutputFormat), e.g. because
+// its flags are wrong and it is passing its input through.
+var ErrUnexpectedOutputFormat = errors.New("extcompress: output is not in the handler's format")
+
+// Describes output which failed the format check. Wraps
+// ErrUnexpectedOutputFormat.
+type UnexpectedOutputFormatError struct {
+	Command string
+	Format  Format
+	// The first bytes of the output
+	Header []byte
+	JobID  string
+}
+
+func (r UnexpectedOutputFormatError) Error() string {
+	return fmt.Sprintf("%s: %s: expected %s, starts with %x (job %s)",
+		r.Command, ErrUnexpectedOutputFormat.Error(), r.Format, r.Header, r.JobID)
+}
+
+func (r UnexpectedOutputFormatError) Unwrap() error {
+	return ErrUnexpectedOutputFormat
+}
+
+// Returns the leading bytes of data in format, or nil if they aren't known.
+// The detector's magics are keyed by the built-in handler for each format,
+// which has the format's name.
+func formatMagic(format Format) []byte {
+	return contentMagics[string(format)]
+}
+
+// Checks the start of a job's output against its format's magic bytes.
+type formatCheck struct {
+	magic  []byte
+	header []byte
+	done   bool
+}
+
+// Takes the next output read from the job. Returns false once enough has
+// been seen to know it is in the wrong format.
+func (fc *formatCheck) feed(p []byte) bool {
+	if fc.done {
+		return true
+	}
+	need := len(fc.magic) - len(fc.header)
+	if need > len(p) {
+		need = len(p)
+	}
+	fc.header = append(fc.header, p[:need]...)
+	if len(fc.header) < len(fc.magic) {
+		return true
+	}
+	fc.done = true
+	return bytes.Equal(fc.header, fc.magic)
+}
+
+// Returns the magic bytes the output of a compression job should start
+// with, or nil if it isn't to be checked.
+func (c Filter) expectedMagic(format Format, jlog *log.Entry) []byte {
+	if !enabled(c.opts.VerifyOutputFormat) || c.Passthrough {
+		return nil
+	}
+	magic := formatMagic(format)
+	if magic == nil {
+		jlog.WithField("format", string(format)).Debug("No magic bytes known to verify output format")
+	}
+	return magic
+}
+
+// Has a compression job check its output as it is read, if the filter's
+// options ask for it.
+func (c Filter) guardFormat(job *CompressionJob) {
+	if magic := c.expectedMagic(job.format, job.log); magic != nil {
+		job.formatCheck = &formatCheck{magic: magic}
+	}
+}
+
+// Feeds output read from the job to its format check, killing the job if
+// the output is in the wrong format.
+func (this *CompressionJob) checkFormat(p []byte) error {
+	if this.formatCheck == nil || this.formatCheck.feed(p) {
+		return nil
+	}
+	err := this.formatError()
+	this.log.WithField("error", err.Error()).Warn("Stopping job producing the wrong format")
+	this.abort(err)
+	if !this.isReaped() {
+		if err := syscall.Kill(-this.cmd.Process.Pid, syscall.SIGKILL); err != nil {
+			this.log.WithField("error", err.Error()).Debug("Error killing external process")
+		}
+	}
+	return err
+}
+
+// Checks output which ended before its magic bytes were complete.
+func (this *CompressionJob) checkFormatComplete() error {
+	if this.formatCheck == nil || this.formatCheck.done {
+		return nil
+	}
+	this.formatCheck.done = true
+	err := this.formatError()
+	this.abort(err)
+	return err
+}
+
+func (this *CompressionJob) formatError() error {
+	return UnexpectedOutputFormatError{this.tool, this.format, this.formatCheck.header, this.id}
+}
+
+// Checks the finished output file of a compression job against the
+// handler's format.
+func (c Filter) checkOutputFile(filePath string, id string) error {
+	magic := c.expectedMagic(FormatOf(c), c.jobLog(id))
+	if magic == nil {
+		return nil
+	}
+	header, err := readHeader(filePath)
+	if err != nil {
+		return err
+	}
+	if len(header) > len(magic) {
+		header = header[:len(magic)]
+	}
+	if !bytes.Equal(header, magic) {
+		return UnexpectedOutputFormatError{c.Command, FormatOf(c), header, id}
+	}
+	return nil
+}
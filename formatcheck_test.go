@@ -0,0 +1,95 @@
+package extcompress
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A gzip filter registered with decompression flags by mistake, which
+// passes its input through unchanged.
+func misregisteredGzip() ExternalHandler {
+	return NewFilter("gzip", CompressFlags("-d", "-c", "-f"), Suffix(".gz", ""))
+}
+
+func TestVerifyOutputFormat(t *testing.T) {
+	for _, mimeType := range []string{"application/x-bzip2", "application/gzip", "application/x-xz", "application/zstd"} {
+		h, err := GetExternalHandlerFromMimeType(mimeType)
+		assert.Nil(t, err)
+		compressBytes(t, h.WithOptions(Options{VerifyOutputFormat: Bool(true)}), []byte(data))
+	}
+	compressBytes(t, Identity().WithOptions(Options{VerifyOutputFormat: Bool(true)}), []byte(data))
+
+	// Unchecked, the plaintext goes unnoticed
+	out := compressBytes(t, misregisteredGzip(), []byte(data))
+	assert.Equal(t, data, string(out))
+	bad := misregisteredGzip().WithOptions(Options{VerifyOutputFormat: Bool(true)})
+
+	// The check fires long before the output is finished
+	proc, err := bad.CompressStream(bytes.NewReader(seekableTestData(4 << 20)))
+	assert.Nil(t, err)
+	out, err = ioutil.ReadAll(proc)
+	assert.True(t, errors.Is(err, ErrUnexpectedOutputFormat), "%v", err)
+	assert.Empty(t, out)
+	assert.Equal(t, FormatGzip, err.(UnexpectedOutputFormatError).Format)
+	assertReturns(t, "Result", func() { assert.NotZero(t, proc.Result()) })
+
+	// Output too short to hold the magic bytes
+	short := NewFilter("printf", CompressFlags("x"), OutputFormat(FormatXz)).
+		WithOptions(Options{VerifyOutputFormat: Bool(true)})
+	proc, err = short.CompressStream(bytes.NewReader(nil))
+	assert.Nil(t, err)
+	_, err = ioutil.ReadAll(proc)
+	assert.Equal(t, UnexpectedOutputFormatError{"printf", FormatXz, []byte("x"), proc.ID()}, err)
+}
+
+func TestVerifyOutputFormatFiles(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	bad := misregisteredGzip().WithOptions(Options{VerifyOutputFormat: Bool(true)})
+
+	// In place, the original is left as it was
+	filename := path.Join(tmpdir, "input.log")
+	assert.Nil(t, ioutil.WriteFile(filename, []byte(data), os.FileMode(0644)))
+	_, err := bad.CompressFileInPlaceWithOptions(filename, InPlaceOptions{})
+	assert.True(t, errors.Is(err, ErrUnexpectedOutputFormat), "%v", err)
+	out, err := ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, data, string(out))
+	_, err = os.Stat(filename + ".gz")
+	assert.True(t, os.IsNotExist(err))
+
+	// Into a file, nothing appears at the destination
+	dst := path.Join(tmpdir, "output.gz")
+	w, err := bad.CompressIntoFile(dst)
+	assert.Nil(t, err)
+	_, err = w.Write([]byte(data))
+	assert.Nil(t, err)
+	err = w.Close()
+	assert.True(t, errors.Is(err, ErrUnexpectedOutputFormat), "%v", err)
+	_, err = os.Stat(dst)
+	assert.True(t, os.IsNotExist(err))
+	// Nor is the temporary file left behind
+	entries, err := ioutil.ReadDir(tmpdir)
+	assert.Nil(t, err)
+	for _, entry := range entries {
+		assert.NotContains(t, entry.Name(), "output.gz")
+	}
+
+	// A correct filter passes both
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	h = h.WithOptions(Options{VerifyOutputFormat: Bool(true)})
+	_, err = h.CompressFileInPlaceWithOptions(filename, InPlaceOptions{})
+	assert.Nil(t, err)
+	w, err = h.CompressIntoFile(dst)
+	assert.Nil(t, err)
+	_, err = w.Write([]byte(data))
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+}
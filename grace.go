@@ -0,0 +1,104 @@
+package extcompress
+
+import (
+	"os/exec"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// How long Close waits for a tool to exit after SIGINT before sending
+// SIGTERM, and after SIGTERM before sending SIGKILL, unless the handler or
+// its options say otherwise.
+const (
+	DefaultGraceSIGINT  = 2 * time.Second
+	DefaultGraceSIGTERM = 5 * time.Second
+)
+
+// Sets the handler's default grace periods (see Filter.GraceSIGINT).
+func GracePeriods(sigint time.Duration, sigterm time.Duration) FilterOption {
+	return func(f *Filter) {
+		f.GraceSIGINT = sigint
+		f.GraceSIGTERM = sigterm
+	}
+}
+
+// Returns how long a job's process gets after each signal Close sends it:
+// the options' periods, else the handler's, else the package defaults.
+func (c Filter) gracePeriods() (sigint time.Duration, sigterm time.Duration) {
+	pick := func(opt time.Duration, handler time.Duration, def time.Duration) time.Duration {
+		switch {
+		case opt != 0:
+			return opt
+		case handler != 0:
+			return handler
+		}
+		return def
+	}
+	return pick(c.opts.GraceSIGINT, c.GraceSIGINT, DefaultGraceSIGINT),
+		pick(c.opts.GraceSIGTERM, c.GraceSIGTERM, DefaultGraceSIGTERM)
+}
+
+// Signals the job's processes to stop, escalating from SIGINT to SIGTERM to
+// SIGKILL as each grace period passes without it being reaped. Signals with
+// a negative grace period are skipped.
+func (this *CompressionJob) escalate() {
+	steps := []struct {
+		sig   syscall.Signal
+		grace time.Duration
+	}{
+		{syscall.SIGINT, this.graceSIGINT},
+		{syscall.SIGTERM, this.graceSIGTERM},
+		{syscall.SIGKILL, 0},
+	}
+	for _, step := range steps {
+		if step.grace < 0 {
+			continue
+		}
+		jlog := this.log.WithField("signal", step.sig.String())
+		jlog.Debug("Signalling still active compression command")
+		atomic.StoreInt32(&this.lastSignal, int32(step.sig))
+		// The whole process group, so nothing the tool started is left
+		// holding its pipes open (see newCmd)
+		if err := syscall.Kill(-this.cmd.Process.Pid, step.sig); err != nil {
+			jlog.WithField("error", err.Error()).Debug("Error sending signal to external process")
+			return
+		}
+		if step.sig == syscall.SIGKILL {
+			return
+		}
+		timer := time.NewTimer(step.grace)
+		select {
+		case <-this.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// Returns the signal which ended the job's process: the one it died of, or
+// if it exited of its own accord after Close signalled it, the last signal
+// Close sent. 0 if the process exited without being signalled, or hasn't
+// been reaped yet.
+func (this *CompressionJob) TerminatedBy() syscall.Signal {
+	if !this.isReaped() {
+		return 0
+	}
+	if sig, ok := exitSignal(this.cmd); ok {
+		return sig
+	}
+	return syscall.Signal(atomic.LoadInt32(&this.lastSignal))
+}
+
+// Returns the signal cmd's process died of, if it did.
+func exitSignal(cmd *exec.Cmd) (syscall.Signal, bool) {
+	if cmd.ProcessState == nil {
+		return 0, false
+	}
+	status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return 0, false
+	}
+	return status.Signal(), true
+}
@@ -0,0 +1,89 @@
+package extcompress
+
+import (
+	"bytes"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Starts a fake tool from trappingFilter, returning once it has set up its
+// signal handling.
+func startTrapping(t *testing.T, h ExternalHandler, opts *Options) *CompressionJob {
+	proc, err := h.Run(OpCompressStream, StreamInput(bytes.NewReader(nil)), opts)
+	assert.Nil(t, err)
+	_, err = proc.Read(make([]byte, 1))
+	assert.Nil(t, err)
+	return proc.(*CompressionJob)
+}
+
+// A fake tool which ignores the signals in trapped.
+func trappingFilter(trapped string, opts ...FilterOption) ExternalHandler {
+	script := "trap '' " + trapped + "; echo; while :; do sleep 0.01; done"
+	return NewFilter("sh", append([]FilterOption{CompressFlags("-c", script)}, opts...)...)
+}
+
+// Closes job, returning how long that took.
+func timeClose(t *testing.T, job *CompressionJob) time.Duration {
+	started := time.Now()
+	assert.Nil(t, job.Close())
+	assert.Zero(t, job.Result())
+	return time.Since(started)
+}
+
+func TestCloseGraceTiers(t *testing.T) {
+	grace := GracePeriods(200*time.Millisecond, 400*time.Millisecond)
+
+	// A tool which stops on SIGINT needs only that
+	job := startTrapping(t, trappingFilter("HUP", grace), nil)
+	assert.True(t, timeClose(t, job) < 200*time.Millisecond)
+	assert.Equal(t, syscall.SIGINT, job.TerminatedBy())
+
+	// One ignoring it is sent SIGTERM once its grace is up
+	job = startTrapping(t, trappingFilter("INT", grace), nil)
+	elapsed := timeClose(t, job)
+	assert.True(t, elapsed >= 200*time.Millisecond, "%v", elapsed)
+	assert.True(t, elapsed < 600*time.Millisecond, "%v", elapsed)
+	assert.Equal(t, syscall.SIGTERM, job.TerminatedBy())
+
+	// And one ignoring both is killed after both
+	job = startTrapping(t, trappingFilter("INT TERM", grace), nil)
+	elapsed = timeClose(t, job)
+	assert.True(t, elapsed >= 600*time.Millisecond, "%v", elapsed)
+	assert.Equal(t, syscall.SIGKILL, job.TerminatedBy())
+}
+
+func TestCloseGraceOverrides(t *testing.T) {
+	// The handler's periods are the default for its jobs
+	h := trappingFilter("INT TERM", GracePeriods(time.Minute, time.Minute))
+	assert.Equal(t, time.Minute, h.(Filter).GraceSIGINT)
+
+	// Options override them, and negative periods skip the signal
+	job := startTrapping(t, h.WithOptions(Options{GraceSIGINT: -1, GraceSIGTERM: 100 * time.Millisecond}), nil)
+	elapsed := timeClose(t, job)
+	assert.True(t, elapsed >= 100*time.Millisecond, "%v", elapsed)
+	assert.True(t, elapsed < 10*time.Second, "%v", elapsed)
+	assert.Equal(t, syscall.SIGKILL, job.TerminatedBy())
+
+	// Including per call
+	job = startTrapping(t, h, &Options{GraceSIGINT: -1, GraceSIGTERM: -1})
+	assert.True(t, timeClose(t, job) < 10*time.Second)
+	assert.Equal(t, syscall.SIGKILL, job.TerminatedBy())
+
+	// Without them the package defaults apply
+	sigint, sigterm := NewFilter("sh").(Filter).gracePeriods()
+	assert.Equal(t, DefaultGraceSIGINT, sigint)
+	assert.Equal(t, DefaultGraceSIGTERM, sigterm)
+}
+
+func TestTerminatedByUnsignalled(t *testing.T) {
+	proc, err := NewFilter("sh", CompressFlags("-c", "exit 3")).CompressStream(bytes.NewReader(nil))
+	assert.Nil(t, err)
+	job := proc.(*CompressionJob)
+	assert.Equal(t, 3, job.Result())
+	assert.Equal(t, syscall.Signal(0), job.TerminatedBy())
+	assert.Nil(t, job.Close())
+	assert.Equal(t, syscall.Signal(0), job.TerminatedBy())
+}
@@ -0,0 +1,166 @@
+package extcompress
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+var (
+	// Returned by hardened operations when a path is, or was swapped for, a
+	// symlink.
+	ErrSymlinkRejected = errors.New("extcompress: refusing to operate through a symlink")
+	// Returned by hardened operations when a source is owned by neither this
+	// process's user nor the owner of its directory.
+	ErrOwnershipMismatch = errors.New("extcompress: file has an unexpected owner")
+	// Returned by hardened operations when a source isn't a regular file.
+	ErrNotRegularFile = errors.New("extcompress: not a regular file")
+)
+
+// Opens filePath for a hardened operation without following symlinks, and
+// checks it is a regular file owned by this process's user or by the owner
+// of the directory containing it. Everything after this works on the
+// returned file, so the path can't be swapped out from under the operation.
+func openHardened(filePath string) (*os.File, os.FileInfo, error) {
+	f, err := os.OpenFile(filePath, os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		if errors.Is(err, syscall.ELOOP) {
+			return nil, nil, ErrSymlinkRejected
+		}
+		return nil, nil, err
+	}
+	st, err := f.Stat()
+	if err == nil {
+		err = checkHardenedSource(filePath, st)
+	}
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, st, nil
+}
+
+// Opens filePath for reading, with openHardened's checks if the handler is
+// hardened.
+func (c Filter) openSource(filePath string) (*os.File, os.FileInfo, error) {
+	if enabled(c.opts.Hardened) {
+		return openHardened(filePath)
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, st, nil
+}
+
+func checkHardenedSource(filePath string, st os.FileInfo) error {
+	if !st.Mode().IsRegular() {
+		return ErrNotRegularFile
+	}
+	sys, ok := st.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	if int(sys.Uid) == os.Geteuid() {
+		return nil
+	}
+	dir, err := os.Stat(filepath.Dir(filePath))
+	if err != nil {
+		return err
+	}
+	if dirSys, ok := dir.Sys().(*syscall.Stat_t); ok && dirSys.Uid == sys.Uid {
+		return nil
+	}
+	return ErrOwnershipMismatch
+}
+
+// Runs a file based operation in hardened mode, streaming the opened file
+// to the tool rather than letting it open the path itself.
+func (c Filter) hardenedFileJob(filePath string, compress bool) (CompressionProcess, error) {
+	f, _, err := openHardened(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return c.fileStreamJob(f, filePath, compress)
+}
+
+// Runs a file operation on filePath as a stream operation reading f, which
+// the job closes once it completes.
+func (c Filter) fileStreamJob(f *os.File, filePath string, compress bool) (CompressionProcess, error) {
+	var proc CompressionProcess
+	var err error
+	if compress {
+		proc, err = c.CompressStream(f)
+	} else {
+		proc, err = c.DecompressStream(f)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if job, ok := proc.(*CompressionJob); ok {
+		job.setInput(filePath)
+	}
+	return proc, err
+}
+
+// Moves tmpPath to dstPath. Hardened operations refuse to replace anything
+// already at dstPath, as O_EXCL would. If tmpPath is on another filesystem
+// it is copied beside dstPath first, so the output still appears whole.
+func (c Filter) placeOutput(tmpPath string, dstPath string) error {
+	err := c.moveOutput(tmpPath, dstPath)
+	if !isCrossDevice(err) {
+		return err
+	}
+	local, err := c.copyBeside(tmpPath, dstPath)
+	if err != nil {
+		return err
+	}
+	if err := c.moveOutput(local, dstPath); err != nil {
+		os.Remove(local)
+		return err
+	}
+	return os.Remove(tmpPath)
+}
+
+// Renames, or for hardened operations links, tmpPath to dstPath.
+func (c Filter) moveOutput(tmpPath string, dstPath string) error {
+	if !enabled(c.opts.Hardened) {
+		return renameFile(tmpPath, dstPath)
+	}
+	if err := linkFile(tmpPath, dstPath); err != nil {
+		return err
+	}
+	return os.Remove(tmpPath)
+}
+
+// Checks srcPath still names the file described by st before it is removed.
+func (c Filter) checkUnchangedSource(srcPath string, st os.FileInfo) error {
+	if !enabled(c.opts.Hardened) {
+		return nil
+	}
+	now, err := os.Lstat(srcPath)
+	if err != nil {
+		return err
+	}
+	if !os.SameFile(st, now) {
+		return ErrSymlinkRejected
+	}
+	return nil
+}
+
+// Opens dstPath for writing, refusing to follow symlinks or reuse an
+// existing file in hardened mode.
+func (c Filter) createOutput(dstPath string, mode os.FileMode) (*os.File, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if enabled(c.opts.Hardened) {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_EXCL | syscall.O_NOFOLLOW
+	}
+	return os.OpenFile(dstPath, flags, mode)
+}
@@ -0,0 +1,138 @@
+package extcompress
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHardenedRejectsSymlink(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	elsewhere := setupTestDir(t)
+	defer os.RemoveAll(elsewhere)
+
+	target := path.Join(elsewhere, "pipechaining")
+	link := path.Join(tmpdir, "link")
+	assert.Nil(t, os.Symlink(target, link))
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	h = h.WithOptions(Options{Hardened: Bool(true)})
+
+	_, err = h.CompressFileInPlaceWithOptions(link, InPlaceOptions{})
+	assert.Equal(t, ErrSymlinkRejected, err)
+	_, err = h.Compress(link)
+	assert.Equal(t, ErrSymlinkRejected, err)
+	assert.Equal(t, ErrSymlinkRejected, h.DecompressToFile(link, path.Join(tmpdir, "out")))
+
+	// Nothing was touched or created
+	contents, err := ioutil.ReadFile(target)
+	assert.Nil(t, err)
+	assert.Equal(t, data, string(contents))
+	_, err = os.Lstat(link + ".gz")
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Lstat(target + ".gz")
+	assert.True(t, os.IsNotExist(err))
+	assertNoTempFiles(t, tmpdir)
+
+	// Directories and other non-regular files are refused too
+	_, err = h.Compress(tmpdir)
+	assert.Equal(t, ErrNotRegularFile, err)
+}
+
+func TestHardenedSymlinkSwap(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	elsewhere := setupTestDir(t)
+	defer os.RemoveAll(elsewhere)
+
+	filename := path.Join(tmpdir, "pipechaining")
+	target := path.Join(elsewhere, "pipechaining")
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	f := h.WithOptions(Options{Hardened: Bool(true)}).(Filter)
+
+	// The real file passes the checks, then is swapped for a symlink while
+	// the compressor runs
+	src, st, err := openHardened(filename)
+	assert.Nil(t, err)
+	defer src.Close()
+	err = f.replaceFileWith(filename, filename+".gz", st, func(string) (CompressionProcess, error) {
+		assert.Nil(t, os.Remove(filename))
+		assert.Nil(t, os.Symlink(target, filename))
+		return f.CompressStream(src)
+	})
+	assert.Equal(t, ErrSymlinkRejected, err)
+
+	// Neither the symlink nor its target were removed, and no output is left
+	st, err = os.Lstat(filename)
+	assert.Nil(t, err)
+	assert.True(t, st.Mode()&os.ModeSymlink != 0)
+	contents, err := ioutil.ReadFile(target)
+	assert.Nil(t, err)
+	assert.Equal(t, data, string(contents))
+	_, err = os.Lstat(filename + ".gz")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestHardenedRefusesExistingOutput(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	filename := path.Join(tmpdir, "pipechaining")
+	existing := []byte("already here")
+	assert.Nil(t, ioutil.WriteFile(filename+".gz", existing, os.FileMode(0644)))
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	h = h.WithOptions(Options{Hardened: Bool(true)})
+
+	_, err = h.CompressFileInPlaceWithOptions(filename, InPlaceOptions{})
+	assert.True(t, os.IsExist(err))
+	contents, err := ioutil.ReadFile(filename + ".gz")
+	assert.Nil(t, err)
+	assert.Equal(t, existing, contents)
+	_, err = os.Stat(filename)
+	assert.Nil(t, err)
+	assertNoTempFiles(t, tmpdir)
+
+	// Without anything in the way it works as usual
+	assert.Nil(t, os.Remove(filename+".gz"))
+	out, err := h.CompressFileInPlaceWithOptions(filename, InPlaceOptions{})
+	assert.Nil(t, err)
+	assert.Nil(t, h.DecompressToFile(out, filename))
+	assert.True(t, os.IsExist(h.DecompressToFile(out, filename)))
+	contents, err = ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, data, string(contents))
+}
+
+func TestHardenedOwnershipMismatch(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("ownership can only be changed as root")
+	}
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	filename := path.Join(tmpdir, "pipechaining")
+	assert.Nil(t, os.Chown(filename, 1234, 1234))
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	hardened := h.WithOptions(Options{Hardened: Bool(true)})
+
+	_, err = hardened.CompressFileInPlaceWithOptions(filename, InPlaceOptions{})
+	assert.Equal(t, ErrOwnershipMismatch, err)
+	_, err = os.Stat(filename)
+	assert.Nil(t, err)
+
+	// Files belonging to the owner of their directory are fine
+	assert.Nil(t, os.Chown(tmpdir, 1234, 1234))
+	_, err = hardened.CompressFileInPlaceWithOptions(filename, InPlaceOptions{})
+	assert.Nil(t, err)
+}
@@ -0,0 +1,88 @@
+package extcompress
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// Matched by the error from an in-place operation skipping a file with other
+// hard links, under HardLinkSkip.
+var ErrHardLinked = errors.New("extcompress: file has other hard links")
+
+// Describes a file skipped for having other hard links. Wraps ErrHardLinked.
+type HardLinkedError struct {
+	Path  string
+	Links uint64
+}
+
+func (r HardLinkedError) Error() string {
+	return fmt.Sprintf("%s has %d hard links: %s", r.Path, r.Links, ErrHardLinked.Error())
+}
+
+func (r HardLinkedError) Unwrap() error {
+	return ErrHardLinked
+}
+
+// What an in-place operation does with a file which has other hard links.
+// The tools refuse such files unless forced, as replacing one name of a
+// file leaves the others with the original content.
+type HardLinkPolicy int
+
+const (
+	// Leave the file alone and fail with a HardLinkedError (the default)
+	HardLinkSkip HardLinkPolicy = iota
+	// Replace this name of the file, breaking its link with the others,
+	// which keep the original content
+	HardLinkForce
+	// Write the output beside the file and leave the file, and all its
+	// links, as they were
+	HardLinkCopyThrough
+)
+
+func (p HardLinkPolicy) String() string {
+	switch p {
+	case HardLinkForce:
+		return "force"
+	case HardLinkCopyThrough:
+		return "copy-through"
+	default:
+		return "skip"
+	}
+}
+
+// Returns the number of names st's file has, or 1 if that can't be known.
+func linkCount(st os.FileInfo) uint64 {
+	if sys, ok := st.Sys().(*syscall.Stat_t); ok {
+		return uint64(sys.Nlink)
+	}
+	return 1
+}
+
+// Applies opts' hard link policy to filePath ahead of an in-place operation.
+// Returns true if the file has other links, which the package has to deal
+// with rather than the tool.
+func (c Filter) checkHardLinks(filePath string, opts InPlaceOptions) (bool, error) {
+	if c.Passthrough {
+		return false, nil
+	}
+	st, err := os.Stat(filePath)
+	if err != nil {
+		// Left for the operation itself to report
+		return false, nil
+	}
+	links := linkCount(st)
+	if links <= 1 {
+		return false, nil
+	}
+	if opts.HardLinks == HardLinkSkip {
+		return false, HardLinkedError{filePath, links}
+	}
+	return true, nil
+}
+
+// True if the source described by st is kept once its output is in place.
+func (c Filter) keepSource(st os.FileInfo) bool {
+	return c.inPlace.HardLinks == HardLinkCopyThrough && linkCount(st) > 1
+}
@@ -0,0 +1,129 @@
+package extcompress
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Writes a file with a second hard link to it, returning both names.
+func writeHardLinked(t *testing.T, dir string, name string) (string, string) {
+	filename := path.Join(dir, name)
+	assert.Nil(t, ioutil.WriteFile(filename, []byte(data), os.FileMode(0644)))
+	other := path.Join(dir, name+"-link")
+	assert.Nil(t, os.Link(filename, other))
+	return filename, other
+}
+
+func TestHardLinkPolicies(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	for _, mimeType := range []string{"application/gzip", "application/x-xz", "application/zstd"} {
+		h, err := GetExternalHandlerFromMimeType(mimeType)
+		assert.Nil(t, err)
+		suffix := h.(Filter).suffix()
+
+		// Skip leaves everything alone
+		filename, other := writeHardLinked(t, tmpdir, "skip")
+		res, err := h.CompressFileInPlaceResult(filename, InPlaceOptions{HardLinks: HardLinkSkip})
+		assert.True(t, errors.Is(err, ErrHardLinked), "%s: %v", mimeType, err)
+		assert.Equal(t, HardLinkedError{filename, 2}, err)
+		assert.Equal(t, uint64(2), res.Links)
+		assert.Equal(t, HardLinkSkip, res.HardLinks)
+		_, err = os.Stat(filename + suffix)
+		assert.True(t, os.IsNotExist(err))
+		assertSameFile(t, filename, other)
+
+		// Force replaces this name only
+		filename, other = writeHardLinked(t, tmpdir, "force")
+		res, err = h.CompressFileInPlaceResult(filename, InPlaceOptions{HardLinks: HardLinkForce})
+		assert.Nil(t, err, mimeType)
+		assert.Equal(t, HardLinkForce, res.HardLinks)
+		assert.Equal(t, filename+suffix, res.ResultPath)
+		_, err = os.Stat(filename)
+		assert.True(t, os.IsNotExist(err), mimeType)
+		out, err := ioutil.ReadFile(other)
+		assert.Nil(t, err)
+		assert.Equal(t, data, string(out))
+
+		// Copy through leaves the links as they were
+		filename, other = writeHardLinked(t, tmpdir, "copy")
+		res, err = h.CompressFileInPlaceResult(filename, InPlaceOptions{HardLinks: HardLinkCopyThrough})
+		assert.Nil(t, err, mimeType)
+		assert.Equal(t, HardLinkCopyThrough, res.HardLinks)
+		assertSameFile(t, filename, other)
+		out, err = h.DecompressToBytes(res.ResultPath, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, data, string(out))
+
+		for _, name := range []string{"skip", "force", "copy"} {
+			for _, n := range []string{name, name + "-link", name + suffix} {
+				os.Remove(path.Join(tmpdir, n))
+			}
+		}
+	}
+}
+
+func assertSameFile(t *testing.T, a string, b string) {
+	sta, err := os.Stat(a)
+	assert.Nil(t, err)
+	stb, err := os.Stat(b)
+	assert.Nil(t, err)
+	assert.True(t, os.SameFile(sta, stb), "%s and %s aren't linked", a, b)
+}
+
+func TestHardLinkedDecompress(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	compressed := path.Join(tmpdir, "linked.gz")
+	assert.Nil(t, os.Rename(writeCompressed(t, tmpdir, "gzip", []byte(data)), compressed))
+	other := compressed + "-link"
+	assert.Nil(t, os.Link(compressed, other))
+
+	_, err = h.DecompressFileInPlaceWithOptions(compressed, InPlaceOptions{})
+	assert.True(t, errors.Is(err, ErrHardLinked))
+
+	decompressed, err := h.DecompressFileInPlaceWithOptions(compressed, InPlaceOptions{HardLinks: HardLinkForce})
+	assert.Nil(t, err)
+	out, err := ioutil.ReadFile(decompressed)
+	assert.Nil(t, err)
+	assert.Equal(t, data, string(out))
+	_, err = os.Stat(other)
+	assert.Nil(t, err)
+}
+
+func TestHardLinkedBatch(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	paths := writeSmallFiles(t, tmpdir, 2)
+	linked, _ := writeHardLinked(t, tmpdir, "linked")
+	paths = append(paths, linked)
+
+	results, summary, err := h.CompressFilesInPlaceSummary(paths, InPlaceOptions{})
+	assert.Nil(t, err)
+	assert.True(t, errors.Is(results[2].Err, ErrHardLinked))
+	assert.Equal(t, uint64(2), results[2].Links)
+	assert.Equal(t, 2, summary.Succeeded)
+	assert.Equal(t, 1, summary.Skipped)
+	assert.Equal(t, 0, summary.Failed)
+
+	results, summary, err = h.CompressFilesInPlaceSummary([]string{linked}, InPlaceOptions{HardLinks: HardLinkCopyThrough})
+	assert.Nil(t, err)
+	assert.Nil(t, results[0].Err)
+	assert.Equal(t, HardLinkCopyThrough, results[0].HardLinks)
+	assert.Equal(t, linked+".gz", results[0].ResultPath)
+	assert.Equal(t, 1, summary.Succeeded)
+	_, err = os.Stat(linked)
+	assert.Nil(t, err)
+}
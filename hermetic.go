@@ -0,0 +1,62 @@
+package extcompress
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Formats which store nothing about what was compressed beyond its data.
+var formatsWithoutMetadata = map[Format]bool{
+	FormatBzip2:    true,
+	FormatXz:       true,
+	FormatZstd:     true,
+	FormatLz4:      true,
+	FormatIdentity: true,
+}
+
+// Lists what Hermetic couldn't guarantee for a handler.
+type HermeticError struct {
+	Command string
+	Unmet   []string
+}
+
+func (r HermeticError) Error() string {
+	return fmt.Sprintf("%s: can't guarantee hermetic execution: %s", r.Command, strings.Join(r.Unmet, "; "))
+}
+
+// Returns a copy of the handler set up for reproducible output, e.g. for
+// build systems: one thread, the C locale and UTC, no names or timestamps
+// stored in the output (gzip's -n), no compressor knobs from the environment
+// (GZIP, XZ_OPT etc., even with InheritCompressionEnv) and no options from
+// EXTCOMPRESS_LEVEL or EXTCOMPRESS_THREADS. The same input then gives the
+// same output, and Plan is the same, on every run and host with the same
+// version of the tool. The handler is returned even if that can't all be
+// guaranteed for its tool, along with a HermeticError saying what can't.
+func (c Filter) Hermetic() (ExternalHandler, error) {
+	if c.optionSource("Level") == "env" {
+		c.opts.Level = nil
+	}
+	opts := Options{Hermetic: Bool(true)}
+	var unmet []string
+	if c.ThreadsFlag != "" {
+		opts.Threads = Int(1)
+	} else if !c.SingleThreaded {
+		unmet = append(unmet, "the number of threads can't be set")
+	}
+	if len(c.HermeticFlags) == 0 && !formatsWithoutMetadata[FormatOf(c)] {
+		unmet = append(unmet, "names and timestamps may be stored in the output")
+	}
+
+	c.opts = c.opts.Merge(opts)
+	c.noteOptions("hermetic", opts)
+	if len(unmet) > 0 {
+		return c, HermeticError{c.Command, unmet}
+	}
+	return c, nil
+}
+
+// Returns where the handler's option field was last set from, e.g. "env",
+// or "" if it was never set.
+func (c Filter) optionSource(field string) string {
+	return c.optionSources[field]
+}
@@ -0,0 +1,118 @@
+package extcompress
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHermetic(t *testing.T) {
+	input := seekableTestData(300000)
+	for name, h := range installedHandlers(t) {
+		hermetic, err := h.Hermetic()
+		assert.Nil(t, err, name)
+		assert.True(t, enabled(hermetic.Options().Hermetic), name)
+		assert.Equal(t, compressBytes(t, hermetic, input), compressBytes(t, hermetic, input), name)
+
+		again, _ := h.Hermetic()
+		assert.Equal(t, hermetic.Plan(), again.Plan(), name)
+		assert.Contains(t, hermetic.Plan().Env, "LC_ALL=C", name)
+		if h.Capabilities().Threads {
+			assert.Equal(t, 1, *hermetic.Options().Threads, name)
+		}
+	}
+}
+
+func TestHermeticIgnoresNamesAndTimes(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	input := seekableTestData(10000)
+	older, newer := path.Join(tmpdir, "older"), path.Join(tmpdir, "newer")
+	assert.Nil(t, ioutil.WriteFile(older, input, 0644))
+	assert.Nil(t, ioutil.WriteFile(newer, input, 0644))
+	assert.Nil(t, os.Chtimes(older, time.Unix(1000, 0), time.Unix(1000, 0)))
+
+	compressFile := func(h ExternalHandler, filePath string) []byte {
+		proc, err := h.Compress(filePath)
+		assert.Nil(t, err)
+		out, err := ioutil.ReadAll(proc)
+		assert.Nil(t, err)
+		assert.Zero(t, proc.Result())
+		return out
+	}
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	assert.NotEqual(t, compressFile(h, older), compressFile(h, newer))
+	hermetic, err := h.Hermetic()
+	assert.Nil(t, err)
+	assert.Equal(t, compressFile(hermetic, older), compressFile(hermetic, newer))
+}
+
+func TestHermeticEnvironment(t *testing.T) {
+	input := seekableTestData(200000)
+	h, err := GetExternalHandlerFromMimeType("application/x-xz")
+	assert.Nil(t, err)
+	hermetic, err := h.Hermetic()
+	assert.Nil(t, err)
+	expected := compressBytes(t, hermetic, input)
+
+	// Neither the tool's knobs nor the package's environment options get in
+	t.Setenv("XZ_OPT", "-0")
+	InheritCompressionEnv(true)
+	defer InheritCompressionEnv(false)
+	assert.NotEqual(t, expected, compressBytes(t, h, input))
+	assert.Equal(t, expected, compressBytes(t, hermetic, input))
+
+	setEnvOptions(t, "1", "2")
+	h, err = GetExternalHandlerFromMimeType("application/x-xz")
+	assert.Nil(t, err)
+	hermetic, err = h.Hermetic()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"xz", "-c", "-T1"}, hermetic.Plan().CompressStream)
+	assert.Equal(t, expected, compressBytes(t, hermetic, input))
+
+	// Options set explicitly are kept
+	hermetic, _ = h.WithLevel(1).Hermetic()
+	assert.Equal(t, []string{"xz", "-c", "-1", "-T1"}, hermetic.Plan().CompressStream)
+}
+
+func TestHermeticOptionSources(t *testing.T) {
+	setEnvOptions(t, "1", "2")
+	h, err := GetExternalHandlerFromMimeType("application/x-xz")
+	assert.Nil(t, err)
+	f := h.(Filter)
+	assert.Equal(t, "env", f.optionSource("Level"))
+	assert.Equal(t, "", f.optionSource("Hermetic"))
+
+	// Decided from the options themselves, not how the provenance shows them
+	f.prov.Options = nil
+	hermetic, err := f.Hermetic()
+	assert.Nil(t, err)
+	assert.Nil(t, hermetic.Options().Level)
+	assert.Equal(t, "hermetic", hermetic.(Filter).optionSource("Threads"))
+
+	// A later setting of the field is what counts, and doesn't touch the
+	// handler it was derived from
+	derived := f.WithLevel(4).(Filter)
+	assert.Equal(t, "with", derived.optionSource("Level"))
+	assert.Equal(t, "env", f.optionSource("Level"))
+}
+
+func TestHermeticUnmet(t *testing.T) {
+	h := NewFilter("sh", CompressFlags("-c", "cat"))
+	hermetic, err := h.Hermetic()
+	var hermeticErr HermeticError
+	assert.True(t, errors.As(err, &hermeticErr))
+	assert.Equal(t, "sh", hermeticErr.Command)
+	assert.Len(t, hermeticErr.Unmet, 2)
+
+	// The handler is still usable, with what could be applied
+	assert.True(t, enabled(hermetic.Options().Hermetic))
+	assert.Equal(t, []byte("data"), compressBytes(t, hermetic, []byte("data")))
+}
@@ -0,0 +1,408 @@
+package extcompress
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Controls whether decompression restores the file name and timestamp some
+// formats (gzip) store in the archive header.
+type StoredName int
+
+const (
+	// Use the tool's default behavior, which for gzip is not to restore.
+	StoredNameDefault StoredName = iota
+	// Restore the stored name and timestamp (gzip -N).
+	StoredNameRestore
+	// Explicitly ignore any stored name and timestamp (gzip -n).
+	StoredNameIgnore
+)
+
+// Options for the in-place compression operations.
+type InPlaceOptions struct {
+	// Overrides the filter's default suffix (e.g. ".gz-old" instead of ".gz").
+	// On decompression it is the suffix which is stripped.
+	Suffix string
+	// Whether decompression restores a stored original name and timestamp.
+	StoredName StoredName
+	// Directory the output is written in before it is moved into place,
+	// instead of beside it. Output in a directory on another filesystem is
+	// copied into place, unless Atomic is set.
+	TempDir string
+	// Requires the output to appear by a single rename: a TempDir on another
+	// filesystem is rejected with a CrossDeviceError before any work is done.
+	Atomic bool
+	// What happens to files with other hard links
+	HardLinks HardLinkPolicy
+}
+
+// Returned when an in-place suffix is unusable with a filter.
+type InvalidSuffix struct {
+	Suffix string
+	Reason string
+}
+
+func (r InvalidSuffix) Error() string {
+	return fmt.Sprintf("invalid suffix %q: %s", r.Suffix, r.Reason)
+}
+
+// Returned when in-place decompression is asked to act on a file which does
+// not carry the expected suffix, so no output name can be derived.
+type UnknownSuffix struct {
+	FilePath string
+	Suffix   string
+}
+
+func (r UnknownSuffix) Error() string {
+	return fmt.Sprintf("%s does not have the suffix %q", r.FilePath, r.Suffix)
+}
+
+// Returns the suffix the tool adds and removes by itself: Suffix, or
+// failing that the canonical extension.
+func (c Filter) suffix() string {
+	if c.Suffix == "" && len(c.Extensions) > 0 {
+		return c.Extensions[0]
+	}
+	return c.Suffix
+}
+
+// Resolves the suffix to use and checks it against the tool's constraints.
+// Without one in opts, a suffix the handler's Options.Args give the tool
+// is used.
+func (c Filter) inPlaceSuffix(opts InPlaceOptions) (string, error) {
+	if opts.Suffix == "" {
+		if suffix := c.suffixFromArgs(); suffix != "" {
+			return suffix, nil
+		}
+		return c.suffix(), nil
+	}
+	if strings.ContainsRune(opts.Suffix, '/') {
+		return "", InvalidSuffix{opts.Suffix, "must not contain '/'"}
+	}
+	if strings.ContainsRune(opts.Suffix, 0) {
+		return "", InvalidSuffix{opts.Suffix, "must not contain NUL"}
+	}
+	if suffixReason(opts.Suffix) != "" {
+		return "", InvalidSuffix{opts.Suffix, "must not contain control characters"}
+	}
+	return opts.Suffix, nil
+}
+
+// True if the suffix or temp directory has to be applied by the package
+// rather than the tool.
+func (c Filter) packageSuffix(opts InPlaceOptions) bool {
+	return opts.Suffix != "" && opts.Suffix != c.suffix() && c.SuffixFlag == "" ||
+		opts.TempDir != ""
+}
+
+// Returns the name in-place compression of filePath will produce.
+func (c Filter) CompressedFileName(filePath string, opts InPlaceOptions) (string, error) {
+	suffix, err := c.inPlaceSuffix(opts)
+	if err != nil {
+		return "", err
+	}
+	if c.Passthrough {
+		return filePath, nil
+	}
+	return filePath + suffix, nil
+}
+
+// Returns the flag selecting the requested stored name behavior.
+func (c Filter) storedNameFlag(opts InPlaceOptions) (string, error) {
+	var flag string
+	switch opts.StoredName {
+	case StoredNameDefault:
+		return "", nil
+	case StoredNameRestore:
+		flag = c.RestoreNameFlag
+	case StoredNameIgnore:
+		flag = c.IgnoreNameFlag
+	}
+	if flag == "" {
+		return "", ErrNotSupported
+	}
+	return flag, nil
+}
+
+// Returns the name in-place decompression of filePath will produce. When the
+// stored name is being restored this reads the archive header, since the
+// result may be named nothing like filePath.
+func (c Filter) DecompressedFileName(filePath string, opts InPlaceOptions) (string, error) {
+	suffix, err := c.inPlaceSuffix(opts)
+	if err != nil {
+		return "", err
+	}
+	if _, err := c.storedNameFlag(opts); err != nil {
+		return "", err
+	}
+	if c.Passthrough {
+		return filePath, nil
+	}
+
+	if opts.StoredName == StoredNameRestore {
+		name, err := gzipStoredName(filePath)
+		if err != nil {
+			return "", err
+		}
+		if name != "" {
+			return filepath.Join(filepath.Dir(filePath), name), nil
+		}
+	}
+	if suffix == "" {
+		return filePath, nil
+	}
+	if !strings.HasSuffix(filePath, suffix) || len(filePath) == len(suffix) {
+		return "", UnknownSuffix{filePath, suffix}
+	}
+	return strings.TrimSuffix(filePath, suffix), nil
+}
+
+// Compresses filePath in place, honoring opts, and returns the name of the
+// compressed file.
+func (c Filter) CompressFileInPlaceWithOptions(filePath string, opts InPlaceOptions) (string, error) {
+	if isStdioPath(filePath) {
+		return "", StdioPathError{filePath}
+	}
+	if err := c.checkSourceWritable(filePath); err != nil {
+		return "", err
+	}
+	if err := c.checkCompressedSuffix(filePath, opts); err != nil {
+		return "", err
+	}
+	outPath, err := c.CompressedFileName(filePath, opts)
+	if err != nil {
+		return "", err
+	}
+	c.inPlace = opts
+	hardLinked, err := c.checkHardLinks(filePath, opts)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case hardLinked, c.packageInPlace(true), c.packageSuffix(opts) && !c.Passthrough:
+		err = c.replaceFile(filePath, outPath, true)
+		if err == ErrIncompressible && c.incompressibleFallback(filePath, err) == nil {
+			return filePath, nil
+		}
+	case opts.Suffix == "" || opts.Suffix == c.suffix() || c.Passthrough:
+		err = c.CompressFileInPlace(filePath)
+	default:
+		c.CompressInPlaceFlags = withFlags(c.CompressInPlaceFlags, c.SuffixFlag, opts.Suffix)
+		err = c.CompressFileInPlace(filePath)
+	}
+	if err != nil {
+		return "", err
+	}
+	return outPath, nil
+}
+
+// Decompresses filePath in place, honoring opts, and returns the name of the
+// decompressed file.
+func (c Filter) DecompressFileInPlaceWithOptions(filePath string, opts InPlaceOptions) (string, error) {
+	if isStdioPath(filePath) {
+		return "", StdioPathError{filePath}
+	}
+	if err := c.checkSourceWritable(filePath); err != nil {
+		return "", err
+	}
+	outPath, err := c.DecompressedFileName(filePath, opts)
+	if err != nil {
+		return "", err
+	}
+	c.inPlace = opts
+
+	if flag, _ := c.storedNameFlag(opts); flag != "" {
+		c.DecompressInPlaceFlags = withFlags(c.DecompressInPlaceFlags, flag)
+	}
+
+	hardLinked, err := c.checkHardLinks(filePath, opts)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case hardLinked, c.packageInPlace(false), c.packageSuffix(opts) && !c.Passthrough:
+		if opts.StoredName != StoredNameDefault {
+			return "", ErrNotSupported
+		}
+		err = c.replaceFile(filePath, outPath, false)
+	case opts.Suffix == "" || opts.Suffix == c.suffix() || c.Passthrough:
+		err = c.DecompressFileInPlace(filePath)
+	default:
+		c.DecompressInPlaceFlags = withFlags(c.DecompressInPlaceFlags, c.SuffixFlag, opts.Suffix)
+		err = c.DecompressFileInPlace(filePath)
+	}
+	if err != nil {
+		return "", err
+	}
+	return outPath, nil
+}
+
+// Emulates an in-place operation for tools which can't name their own
+// output: the transformed stream is written to a temporary file beside the
+// source, renamed to outPath, and only then is the source removed (unless
+// it is a hard linked file being copied through).
+func (c Filter) replaceFile(srcPath string, outPath string, compress bool) error {
+	transform := c.Decompress
+	if compress {
+		transform = c.Compress
+	}
+	if !enabled(c.opts.Hardened) {
+		st, err := os.Stat(srcPath)
+		if err != nil {
+			return err
+		}
+		return c.replaceFileWith(srcPath, outPath, st, transform)
+	}
+
+	// Transform the file that was checked, not whatever the path names later
+	f, st, err := openHardened(srcPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.replaceFileWith(srcPath, outPath, st, func(string) (CompressionProcess, error) {
+		if compress {
+			return c.CompressStream(f)
+		}
+		return c.DecompressStream(f)
+	})
+}
+
+// Does the work of replaceFile, with st describing the source.
+func (c Filter) replaceFileWith(srcPath string, outPath string, st os.FileInfo,
+	transform func(string) (CompressionProcess, error)) error {
+	jlog := log.WithFields(log.Fields{"compressCmd": printable(c.Command), "filepath": printable(srcPath), "output": printable(outPath)})
+	jlog.Info("Package-side in-place operation")
+
+	if err := c.checkTempDir(outPath); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(c.tempDirFor(outPath), "."+filepath.Base(outPath)+".")
+	if err != nil {
+		return err
+	}
+
+	err = func() error {
+		job, err := transform(srcPath)
+		if err != nil {
+			return err
+		}
+		jlog = jlog.WithField(JobIDField, job.ID())
+		if _, err := copyPooled(tmp, job); err != nil {
+			job.Close()
+			return err
+		}
+		if status := statusOf(job); status != 0 {
+			return ExitStatusError{c.Command, status, job.ID()}
+		}
+		if err := warningsFailure(job); err != nil {
+			return err
+		}
+		if j, ok := job.(*CompressionJob); ok {
+			if err := j.checkRatio(); err != nil {
+				return err
+			}
+		}
+		mode := st.Mode()
+		if err := tmp.Chmod(c.outputMode(&mode)); err != nil {
+			return err
+		}
+		if err := c.ownerFor(st).applyFile(tmp); err != nil {
+			return err
+		}
+		if err := c.syncOutput(tmp); err != nil {
+			return err
+		}
+		return tmp.Close()
+	}()
+	if err != nil {
+		jlog.WithField("error", err.Error()).Warn("Compression command failed.")
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := c.placeOutput(tmp.Name(), outPath); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := c.syncParent(outPath); err != nil {
+		return err
+	}
+	if c.keepSource(st) {
+		return nil
+	}
+	if err := c.checkUnchangedSource(srcPath, st); err != nil {
+		os.Remove(outPath)
+		return err
+	}
+	if err := os.Remove(srcPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Returns a copy of flags with extra appended, leaving the original (which may
+// be shared with the registry) untouched.
+func withFlags(flags []string, extra ...string) []string {
+	out := make([]string, 0, len(flags)+len(extra))
+	out = append(out, flags...)
+	return append(out, extra...)
+}
+
+// gzip header flags
+const (
+	gzipFlagExtra = 1 << 2
+	gzipFlagName  = 1 << 3
+)
+
+// Reads the original file name stored in a gzip header, returning "" if there
+// is none. Only the base name is returned, as gzip -N itself discards any
+// directory components.
+func gzipStoredName(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return "", err
+	}
+	if !bytes.Equal(header[:2], gzipMagic) {
+		return "", ErrBadIndex
+	}
+
+	flags := header[3]
+	if flags&gzipFlagName == 0 {
+		return "", nil
+	}
+	if flags&gzipFlagExtra != 0 {
+		var xlen [2]byte
+		if _, err := io.ReadFull(br, xlen[:]); err != nil {
+			return "", err
+		}
+		if _, err := br.Discard(int(binary.LittleEndian.Uint16(xlen[:]))); err != nil {
+			return "", err
+		}
+	}
+
+	name, err := br.ReadString(0)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Base(strings.TrimSuffix(name, "\x00")), nil
+}
@@ -0,0 +1,182 @@
+package extcompress
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInPlaceCustomSuffix(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	// gzip and xz apply the suffix natively, bzip2 and zstd via the package
+	for _, mimeType := range []string{"application/gzip", "application/x-xz", "application/x-bzip2", "application/zstd"} {
+		h, err := GetExternalHandlerFromMimeType(mimeType)
+		assert.Nil(t, err)
+
+		filename := path.Join(tmpdir, "app.log")
+		assert.Nil(t, ioutil.WriteFile(filename, []byte(data), os.FileMode(0640)))
+
+		opts := InPlaceOptions{Suffix: ".gz-old"}
+		predicted, err := h.CompressedFileName(filename, opts)
+		assert.Nil(t, err)
+		assert.Equal(t, filename+".gz-old", predicted)
+
+		compressed, err := h.CompressFileInPlaceWithOptions(filename, opts)
+		assert.Nil(t, err, mimeType)
+		assert.Equal(t, predicted, compressed)
+
+		_, err = os.Stat(filename)
+		assert.True(t, os.IsNotExist(err), "%s left the original behind", mimeType)
+		st, err := os.Stat(compressed)
+		assert.Nil(t, err)
+		assert.Equal(t, os.FileMode(0640), st.Mode().Perm(), mimeType)
+
+		hc, err := GetFileTypeExternalHandler(compressed)
+		assert.Nil(t, err)
+		assert.Equal(t, mimeMap[mimeType], mimeMap[hc.MimeType()])
+
+		decompressed, err := h.DecompressFileInPlaceWithOptions(compressed, opts)
+		assert.Nil(t, err, mimeType)
+		assert.Equal(t, filename, decompressed)
+
+		out, err := ioutil.ReadFile(filename)
+		assert.Nil(t, err)
+		assert.Equal(t, data, string(out))
+		_, err = os.Stat(compressed)
+		assert.True(t, os.IsNotExist(err))
+	}
+}
+
+func TestInPlaceDefaultSuffix(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	filename := path.Join(tmpdir, "pipechaining")
+	compressed, err := h.CompressFileInPlaceWithOptions(filename, InPlaceOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, filename+".gz", compressed)
+	_, err = os.Stat(compressed)
+	assert.Nil(t, err)
+
+	decompressed, err := h.DecompressFileInPlaceWithOptions(compressed, InPlaceOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, filename, decompressed)
+}
+
+func TestInPlaceExplicitCanonicalSuffix(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	// Asking for the suffix the tool uses anyway leaves it to the tool
+	for _, mimeType := range []string{"application/gzip", "application/x-xz", "application/x-bzip2", "application/zstd"} {
+		h, err := GetExternalHandlerFromMimeType(mimeType)
+		assert.Nil(t, err)
+		opts := InPlaceOptions{Suffix: h.(Filter).Extensions[0]}
+
+		filename := path.Join(tmpdir, "app.log")
+		assert.Nil(t, ioutil.WriteFile(filename, []byte(data), os.FileMode(0644)))
+		compressed, err := h.CompressFileInPlaceWithOptions(filename, opts)
+		assert.Nil(t, err, mimeType)
+		assert.Equal(t, filename+opts.Suffix, compressed)
+
+		decompressed, err := h.DecompressFileInPlaceWithOptions(compressed, opts)
+		assert.Nil(t, err, mimeType)
+		assert.Equal(t, filename, decompressed)
+		out, err := ioutil.ReadFile(filename)
+		assert.Nil(t, err)
+		assert.Equal(t, data, string(out))
+	}
+}
+
+func TestInPlaceSuffixFromExtensions(t *testing.T) {
+	h := NewFilter("foo", Extensions(".foo", ".fo"))
+	name, err := h.CompressedFileName("/tmp/file", InPlaceOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "/tmp/file.foo", name)
+	name, err = h.DecompressedFileName("/tmp/file.foo", InPlaceOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "/tmp/file", name)
+
+	// An explicit Suffix still wins
+	name, err = NewFilter("foo", Extensions(".foo"), Suffix(".f", "")).CompressedFileName("/tmp/file", InPlaceOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "/tmp/file.f", name)
+}
+
+func TestInPlaceSuffixValidation(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	_, err = h.CompressedFileName("/tmp/file", InPlaceOptions{Suffix: ".gz/old"})
+	assert.IsType(t, InvalidSuffix{}, err)
+
+	_, err = h.CompressFileInPlaceWithOptions("/tmp/file", InPlaceOptions{Suffix: "/x"})
+	assert.IsType(t, InvalidSuffix{}, err)
+
+	_, err = h.DecompressedFileName("/tmp/file.bz2", InPlaceOptions{})
+	assert.Equal(t, UnknownSuffix{"/tmp/file.bz2", ".gz"}, err)
+}
+
+func TestInPlaceStoredName(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	// gzip records the name and mtime of the file it compresses
+	original := path.Join(tmpdir, "original.txt")
+	assert.Nil(t, ioutil.WriteFile(original, []byte(data), os.FileMode(0644)))
+	mtime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	assert.Nil(t, os.Chtimes(original, mtime, mtime))
+
+	archive, err := exec.Command("gzip", "-c", original).Output()
+	assert.Nil(t, err)
+	assert.Nil(t, os.Remove(original))
+
+	renamed := path.Join(tmpdir, "renamed.gz")
+
+	// Restoring uses the stored name and timestamp
+	assert.Nil(t, ioutil.WriteFile(renamed, archive, os.FileMode(0644)))
+	opts := InPlaceOptions{StoredName: StoredNameRestore}
+	predicted, err := h.DecompressedFileName(renamed, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, original, predicted)
+	result, err := h.DecompressFileInPlaceWithOptions(renamed, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, original, result)
+	st, err := os.Stat(original)
+	assert.Nil(t, err)
+	assert.True(t, mtime.Equal(st.ModTime()), "mtime was %v", st.ModTime())
+
+	// Ignoring and the default both name the output after the archive
+	for _, mode := range []StoredName{StoredNameIgnore, StoredNameDefault} {
+		assert.Nil(t, ioutil.WriteFile(renamed, archive, os.FileMode(0644)))
+		opts := InPlaceOptions{StoredName: mode}
+		result, err := h.DecompressFileInPlaceWithOptions(renamed, opts)
+		assert.Nil(t, err)
+		assert.Equal(t, path.Join(tmpdir, "renamed"), result)
+		st, err := os.Stat(result)
+		assert.Nil(t, err)
+		assert.False(t, mtime.Equal(st.ModTime()))
+		assert.Nil(t, os.Remove(result))
+	}
+}
+
+func TestInPlaceStoredNameUnsupported(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/x-bzip2")
+	assert.Nil(t, err)
+
+	_, err = h.DecompressFileInPlaceWithOptions("/tmp/file.bz2", InPlaceOptions{StoredName: StoredNameRestore})
+	assert.Equal(t, ErrNotSupported, err)
+}
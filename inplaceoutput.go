@@ -0,0 +1,115 @@
+package extcompress
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Matched by the error from ResolveInPlaceOutput when no output, or more
+// than one possible output, is found.
+var ErrOutputNotFound = errors.New("extcompress: in-place output not found")
+
+// Describes an in-place output which couldn't be found. Wraps
+// ErrOutputNotFound.
+type OutputNotFoundError struct {
+	OriginalPath string
+	// Files which could each be the output, if there was more than one
+	Candidates []string
+}
+
+func (r OutputNotFoundError) Error() string {
+	if len(r.Candidates) > 0 {
+		return fmt.Sprintf("%s: %s: ambiguous between %s", r.OriginalPath, ErrOutputNotFound.Error(), strings.Join(r.Candidates, ", "))
+	}
+	return fmt.Sprintf("%s: %s", r.OriginalPath, ErrOutputNotFound.Error())
+}
+
+func (r OutputNotFoundError) Unwrap() error {
+	return ErrOutputNotFound
+}
+
+// Returns the file in-place compression of originalPath through h produced.
+// Looks for, in turn: the name h would give it, including any suffix set in
+// its Options.Args; originalPath with each extension of h's format; and
+// the files beside originalPath named after it plus any registered
+// extension.
+// Unlike globbing for originalPath followed by anything, files which only
+// share its prefix, such as a backup of it, aren't mistaken for the output.
+func ResolveInPlaceOutput(originalPath string, h ExternalHandler) (string, error) {
+	var candidates []string
+	if f, ok := h.(Filter); ok {
+		if f.Passthrough {
+			if _, err := os.Lstat(originalPath); err != nil {
+				return "", OutputNotFoundError{originalPath, nil}
+			}
+			return originalPath, nil
+		}
+		for _, ext := range f.Extensions {
+			candidates = append(candidates, originalPath+normalizeExtension(ext))
+		}
+	}
+	if name, err := h.CompressedFileName(originalPath, InPlaceOptions{}); err == nil {
+		candidates = append([]string{name}, candidates...)
+	}
+	for _, candidate := range candidates {
+		if candidate == originalPath {
+			continue
+		}
+		if _, err := os.Lstat(candidate); err == nil {
+			return candidate, nil
+		}
+	}
+
+	found := scanForOutput(originalPath)
+	if len(found) == 1 {
+		return found[0], nil
+	}
+	return "", OutputNotFoundError{originalPath, found}
+}
+
+// Returns the suffix the handler's Options.Args set with its SuffixFlag,
+// as "-S .z" or "--suffix=.z", or "" if they set none.
+func (c Filter) suffixFromArgs() string {
+	if c.SuffixFlag == "" {
+		return ""
+	}
+	var suffix string
+	for i, arg := range c.opts.Args {
+		switch {
+		case arg == c.SuffixFlag && i+1 < len(c.opts.Args):
+			suffix = c.opts.Args[i+1]
+		case strings.HasPrefix(arg, c.SuffixFlag+"="):
+			suffix = strings.TrimPrefix(arg, c.SuffixFlag+"=")
+		}
+	}
+	return suffix
+}
+
+// Returns the files beside originalPath named after it plus a registered
+// extension, in any case.
+func scanForOutput(originalPath string) []string {
+	dir, base := filepath.Split(originalPath)
+	entries, err := ioutil.ReadDir(filepath.Clean(dir + "."))
+	if err != nil {
+		return nil
+	}
+	registryMtx.RLock()
+	defer registryMtx.RUnlock()
+	var found []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if len(name) <= len(base) || !strings.HasPrefix(name, base) {
+			continue
+		}
+		if _, ok := extMap[strings.ToLower(name[len(base):])]; ok {
+			found = append(found, filepath.Join(dir, name))
+		}
+	}
+	sort.Strings(found)
+	return found
+}
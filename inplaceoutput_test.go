@@ -0,0 +1,83 @@
+package extcompress
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveInPlaceOutput(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	filename := path.Join(tmpdir, "file")
+	for _, p := range []string{filename, filename + ".backup"} {
+		assert.Nil(t, ioutil.WriteFile(p, []byte(data), os.FileMode(0644)))
+	}
+	assert.Nil(t, h.CompressFileInPlace(filename))
+
+	// Globbing would have picked the backup
+	globbed, _ := filepath.Glob(filename + "*")
+	assert.Equal(t, filename+".backup", globbed[0])
+	out, err := ResolveInPlaceOutput(filename, h)
+	assert.Nil(t, err)
+	assert.Equal(t, filename+".gz", out)
+
+	// A suffix given to the tool in Args
+	other := path.Join(tmpdir, "other")
+	assert.Nil(t, ioutil.WriteFile(other, []byte(data), os.FileMode(0644)))
+	suffixed := h.WithOptions(Options{Args: []string{"-S", ".z"}})
+	assert.Nil(t, suffixed.CompressFileInPlace(other))
+	out, err = ResolveInPlaceOutput(other, suffixed)
+	assert.Nil(t, err)
+	assert.Equal(t, other+".z", out)
+
+	// Registered extensions in another case are found by the scan
+	upper := path.Join(tmpdir, "upper")
+	assert.Nil(t, ioutil.WriteFile(upper+".GZ", []byte(data), os.FileMode(0644)))
+	out, err = ResolveInPlaceOutput(upper, h)
+	assert.Nil(t, err)
+	assert.Equal(t, upper+".GZ", out)
+
+	// Passthrough handlers leave the file where it was
+	out, err = ResolveInPlaceOutput(filename+".backup", Identity())
+	assert.Nil(t, err)
+	assert.Equal(t, filename+".backup", out)
+}
+
+func TestResolveInPlaceOutputNotFound(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	h, err := GetExternalHandlerFromMimeType("application/x-xz")
+	assert.Nil(t, err)
+	filename := path.Join(tmpdir, "file")
+	assert.Nil(t, ioutil.WriteFile(filename+".backup", []byte(data), os.FileMode(0644)))
+
+	_, err = ResolveInPlaceOutput(filename, h)
+	assert.True(t, errors.Is(err, ErrOutputNotFound))
+	assert.Equal(t, OutputNotFoundError{filename, nil}, err)
+
+	// Several outputs of other formats can't be told apart
+	for _, ext := range []string{".bz2", ".zst"} {
+		assert.Nil(t, ioutil.WriteFile(filename+ext, []byte(data), os.FileMode(0644)))
+	}
+	_, err = ResolveInPlaceOutput(filename, h)
+	assert.Equal(t, OutputNotFoundError{filename, []string{filename + ".bz2", filename + ".zst"}}, err)
+
+	// One is taken as the output
+	assert.Nil(t, os.Remove(filename+".bz2"))
+	out, err := ResolveInPlaceOutput(filename, h)
+	assert.Nil(t, err)
+	assert.Equal(t, filename+".zst", out)
+
+	_, err = ResolveInPlaceOutput(filename, Identity())
+	assert.True(t, errors.Is(err, ErrOutputNotFound))
+}
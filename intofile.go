@@ -0,0 +1,169 @@
+package extcompress
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Writer side of a compressor spawned by CompressIntoFile. Output goes to a
+// temporary file beside the destination, which is only renamed into place
+// once the compressor has exited successfully.
+type fileCompressor struct {
+	filter  Filter
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	tmp     *os.File
+	dstPath string
+	closed  bool
+	id      string
+	span    *opSpan
+	// Bytes written to the compressor, and its exit status and output size
+	// once it has exited, for the span
+	written  int64
+	status   int
+	produced int64
+	// Stops watching the handler's context
+	stopWatch func() bool
+	// Given by the compressor
+	warnings *warningLog
+}
+
+func (fc *fileCompressor) Write(p []byte) (int, error) {
+	n, err := fc.stdin.Write(p)
+	fc.written += int64(n)
+	return n, err
+}
+
+// Flushes the compressor, waits for it to exit and moves the output to its
+// final name. On any failure the temporary file is removed and the
+// destination is left untouched.
+func (fc *fileCompressor) Close() error {
+	if fc.closed {
+		return nil
+	}
+	fc.closed = true
+
+	err := fc.finish()
+	if err != nil {
+		fc.tmp.Close()
+		os.Remove(fc.tmp.Name())
+	}
+	fc.span.end(fc.status, fc.written, fc.produced, err)
+	return err
+}
+
+func (fc *fileCompressor) finish() error {
+	fc.stdin.Close()
+
+	status, _, err := waitCmd(fc.filter.jobLog(fc.id), fc.cmd, fc.filter.displayCommand(fc.filter.toolArgs(fc.cmd)), fc.id)
+	status, err = fc.warnings.exited(status, err)
+	fc.status = status
+	if st, statErr := fc.tmp.Stat(); statErr == nil {
+		fc.produced = st.Size()
+	}
+	if !fc.stopWatch() {
+		return fc.filter.ctx.Err()
+	}
+	if err != nil {
+		if exiterr, ok := err.(*exec.ExitError); ok {
+			if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
+				return ExitStatusError{fc.filter.displayCommand(fc.filter.toolArgs(fc.cmd)), status.ExitStatus(), fc.id}
+			}
+		}
+		return err
+	}
+
+	if err := fc.warnings.failure(fc.filter.displayCommand(fc.filter.toolArgs(fc.cmd)), fc.id); err != nil {
+		return err
+	}
+	if err := fc.filter.checkOutputFile(fc.tmp.Name(), fc.id); err != nil {
+		return err
+	}
+	if err := fc.tmp.Chmod(fc.filter.outputMode(nil)); err != nil {
+		return err
+	}
+	// Always synced before the rename, whatever the durability asks for
+	sync := fc.filter.syncOutput
+	if !fc.filter.opts.Durability.syncs() {
+		sync = syncFull
+	}
+	if err := sync(fc.tmp); err != nil {
+		return err
+	}
+	if err := fc.tmp.Close(); err != nil {
+		return err
+	}
+	if err := fc.filter.placeOutput(fc.tmp.Name(), fc.dstPath); err != nil {
+		return err
+	}
+	return fc.filter.syncParent(fc.dstPath)
+}
+
+// Spawns the compressor with its output going to dstPath and returns its
+// stdin. The file is written atomically: nothing appears at dstPath until
+// Close succeeds.
+func (c Filter) CompressIntoFile(dstPath string) (io.WriteCloser, error) {
+	id := c.newJobID()
+	jlog := c.jobLog(id).WithFields(log.Fields{"compressCmd": printable(c.Command), "filepath": printable(dstPath)})
+	jlog.Info("External Compression Command")
+
+	if c.RequiresTempOutput {
+		return nil, ErrNotSupported
+	}
+	args, err := c.buildArgs(true, c.flags(OpCompressStream))
+	if err != nil {
+		return nil, err
+	}
+	args, _ = substitutePlaceholder(args, InputPlaceholder, "/dev/stdin")
+	args, _ = substitutePlaceholder(args, OutputPlaceholder, "/dev/stdout")
+
+	if err := c.makeParentDirs(dstPath); err != nil {
+		return nil, err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(dstPath), "."+filepath.Base(dstPath)+".")
+	if err != nil {
+		return nil, err
+	}
+
+	jlog.WithField("args", printableAll(c.redact(args))).Debug("External command arguments")
+	cmd := c.newCmd(args)
+	cmd.Stdout = tmp
+	warnings := c.newWarningLog()
+	c.warningCapture = warnings
+	cmd.Stderr = c.stderr(id, "CompressIntoFile")
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	span, err := c.spawn("CompressIntoFile", id, cmd)
+	if err != nil {
+		jlog.Error("Compression command failed.")
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	return &fileCompressor{
+		filter:    c,
+		cmd:       cmd,
+		stdin:     stdin,
+		tmp:       tmp,
+		dstPath:   dstPath,
+		id:        id,
+		span:      span,
+		status:    -1,
+		produced:  -1,
+		warnings:  warnings,
+		stopWatch: c.killOnDone(jlog, cmd, nil),
+	}, nil
+}
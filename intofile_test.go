@@ -0,0 +1,98 @@
+package extcompress
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressIntoFile(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	dst := path.Join(tmpdir, "report.json.gz")
+	w, err := h.CompressIntoFile(dst)
+	assert.Nil(t, err)
+
+	_, err = w.Write([]byte(data))
+	assert.Nil(t, err)
+
+	// Nothing is visible under the final name until Close
+	_, err = os.Stat(dst)
+	assert.True(t, os.IsNotExist(err))
+
+	assert.Nil(t, w.Close())
+
+	job, err := h.Decompress(dst)
+	assert.Nil(t, err)
+	out, err := ioutil.ReadAll(job)
+	assert.Nil(t, err)
+	assert.Zero(t, job.Result())
+	assert.Equal(t, data, string(out))
+
+	assertNoTempFiles(t, tmpdir)
+}
+
+func TestCompressIntoFileChildFailure(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	h := Filter{
+		Command:             "sh",
+		CompressStreamFlags: []string{"-c", "cat; exit 3"},
+	}
+
+	dst := path.Join(tmpdir, "failed.gz")
+	w, err := h.CompressIntoFile(dst)
+	assert.Nil(t, err)
+
+	_, err = w.Write([]byte(data))
+	assert.Nil(t, err)
+
+	err = w.Close()
+	assert.IsType(t, ExitStatusError{}, err)
+	assert.Equal(t, 3, err.(ExitStatusError).ExitStatus)
+
+	_, err = os.Stat(dst)
+	assert.True(t, os.IsNotExist(err))
+	assertNoTempFiles(t, tmpdir)
+}
+
+func TestCompressIntoFileNoWrites(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	h, err := GetExternalHandlerFromMimeType("application/x-xz")
+	assert.Nil(t, err)
+
+	dst := path.Join(tmpdir, "empty.xz")
+	w, err := h.CompressIntoFile(dst)
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+
+	// An empty input still produces a valid archive
+	job, err := h.Decompress(dst)
+	assert.Nil(t, err)
+	out, err := ioutil.ReadAll(job)
+	assert.Nil(t, err)
+	assert.Zero(t, job.Result())
+	assert.Empty(t, out)
+
+	// Closing twice is harmless
+	assert.Nil(t, w.Close())
+}
+
+// Only the fixture and final outputs should remain in the directory.
+func assertNoTempFiles(t *testing.T, dir string) {
+	entries, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	for _, e := range entries {
+		assert.NotEqual(t, '.', e.Name()[0], "leftover temporary file %s", e.Name())
+	}
+}
@@ -0,0 +1,69 @@
+package extcompress
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync/atomic"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Log field holding the ID of the job a line is about.
+const JobIDField = "job"
+
+var (
+	// Tells IDs from different processes apart in aggregated logs
+	jobIDPrefix  = randomJobIDPrefix()
+	jobIDCounter uint64
+)
+
+func randomJobIDPrefix() string {
+	var b [3]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "000000"
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// Returns the ID for the next job the filter starts: the one set with
+// WithJobID, or a short ID unique to this process.
+func (c Filter) newJobID() string {
+	if c.opts.JobID != "" {
+		return c.opts.JobID
+	}
+	return generateJobID()
+}
+
+func generateJobID() string {
+	return fmt.Sprintf("%s-%d", jobIDPrefix, atomic.AddUint64(&jobIDCounter, 1))
+}
+
+// Returns the ID the next job started by h will get.
+func jobIDFor(h ExternalHandler) string {
+	if f, ok := h.(Filter); ok {
+		return f.newJobID()
+	}
+	return generateJobID()
+}
+
+// Returns the logger for the job with id, carrying the handler's LogFields.
+// Only warnings and errors are logged for jobs sampling leaves out (see
+// SetLogSampling).
+func (c Filter) jobLog(id string) *log.Entry {
+	return sampledEntry(log.WithFields(c.opts.LogFields).WithField(JobIDField, id), id)
+}
+
+func (c Filter) WithJobID(id string) ExternalHandler {
+	return c.WithOptions(Options{JobID: id})
+}
+
+func (c Filter) WithLogFields(fields log.Fields) ExternalHandler {
+	return c.WithOptions(Options{LogFields: fields})
+}
+
+// Returns the ID the job was given when it was started, which is on every
+// log line, progress report and error about it.
+func (this *CompressionJob) ID() string {
+	return this.id
+}
@@ -0,0 +1,239 @@
+package extcompress
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// Collects log entries while a test has it active.
+type logCapture struct {
+	mtx     sync.Mutex
+	entries []log.Entry
+}
+
+func (lc *logCapture) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (lc *logCapture) Fire(entry *log.Entry) error {
+	lc.mtx.Lock()
+	defer lc.mtx.Unlock()
+	e := *entry
+	e.Data = log.Fields{}
+	for k, v := range entry.Data {
+		e.Data[k] = v
+	}
+	lc.entries = append(lc.entries, e)
+	return nil
+}
+
+// Returns the captured entries about the job with id.
+func (lc *logCapture) forJob(id string) []log.Entry {
+	lc.mtx.Lock()
+	defer lc.mtx.Unlock()
+	var entries []log.Entry
+	for _, e := range lc.entries {
+		if e.Data[JobIDField] == id {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+func messages(entries []log.Entry) []string {
+	var msgs []string
+	for _, e := range entries {
+		msgs = append(msgs, strings.TrimSpace(e.Message))
+	}
+	return msgs
+}
+
+var (
+	captureHookOnce sync.Once
+	captureMtx      sync.Mutex
+	activeCapture   *logCapture
+)
+
+type captureHook struct{}
+
+func (captureHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (captureHook) Fire(entry *log.Entry) error {
+	captureMtx.Lock()
+	lc := activeCapture
+	captureMtx.Unlock()
+	if lc == nil {
+		return nil
+	}
+	return lc.Fire(entry)
+}
+
+// Captures everything logged at debug level and above until the test ends.
+func captureLogs(t *testing.T) *logCapture {
+	// Hooks can't be removed, so one is installed which feeds the active
+	// capture
+	captureHookOnce.Do(func() { log.AddHook(captureHook{}) })
+
+	lc := &logCapture{}
+	captureMtx.Lock()
+	activeCapture = lc
+	captureMtx.Unlock()
+
+	oldLevel := log.GetLevel()
+	log.SetLevel(log.DebugLevel)
+	log.SetOutput(ioutil.Discard)
+	t.Cleanup(func() {
+		captureMtx.Lock()
+		activeCapture = nil
+		captureMtx.Unlock()
+		log.SetLevel(oldLevel)
+		log.SetOutput(os.Stderr)
+	})
+	return lc
+}
+
+func TestJobIDCorrelatesLogs(t *testing.T) {
+	logs := captureLogs(t)
+
+	h := NewFilter("sh", CompressFlags("-c", "echo complaint >&2; cat")).
+		WithLogFields(log.Fields{"trace": "trace-1"})
+
+	first, err := h.CompressStream(bytes.NewReader([]byte("first")))
+	assert.Nil(t, err)
+	second, err := h.WithLogFields(log.Fields{"span": "span-2"}).CompressStream(bytes.NewReader([]byte("second")))
+	assert.Nil(t, err)
+	assert.NotEmpty(t, first.ID())
+	assert.NotEqual(t, first.ID(), second.ID())
+
+	for _, job := range []CompressionProcess{first, second} {
+		_, err := ioutil.ReadAll(job)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, job.Result())
+		assert.Nil(t, job.Close())
+	}
+
+	// Spawn, arguments and the tool's stderr are all tied to the job, and
+	// carry the caller's fields
+	for _, job := range []CompressionProcess{first, second} {
+		entries := logs.forJob(job.ID())
+		assert.Subset(t, messages(entries), []string{"External Compression Command", "External command arguments", "complaint"})
+		for _, e := range entries {
+			assert.Equal(t, "trace-1", e.Data["trace"])
+		}
+	}
+	for _, e := range logs.forJob(second.ID()) {
+		assert.Equal(t, "span-2", e.Data["span"])
+	}
+	for _, e := range logs.forJob(first.ID()) {
+		assert.NotContains(t, e.Data, "span")
+	}
+}
+
+func TestWithJobID(t *testing.T) {
+	logs := captureLogs(t)
+
+	gz, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	proc, err := gz.WithJobID("request-42").DecompressStream(bytes.NewReader([]byte("not gzip data")))
+	assert.Nil(t, err)
+	assert.Equal(t, "request-42", proc.ID())
+	_, err = ioutil.ReadAll(proc)
+	assert.Nil(t, err)
+
+	err = proc.(*CompressionJob).Err()
+	var corrupt CorruptInputError
+	if assert.True(t, errors.As(err, &corrupt)) {
+		assert.Equal(t, "request-42", corrupt.JobID)
+	}
+	assert.Contains(t, err.Error(), "request-42")
+	assert.Contains(t, messages(logs.forJob("request-42")), "External Decompression Command")
+}
+
+func TestJobIDInErrors(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	h := NewFilter("sh", CompressFlags("-c", "cat >/dev/null; exit 3")).WithJobID("into-file")
+	w, err := h.CompressIntoFile(path.Join(tmpdir, "out"))
+	assert.Nil(t, err)
+	var exitErr ExitStatusError
+	if assert.True(t, errors.As(w.Close(), &exitErr)) {
+		assert.Equal(t, "into-file", exitErr.JobID)
+		assert.Equal(t, "sh -c cat >/dev/null; exit 3 exited with status 3 (job into-file)", exitErr.Error())
+	}
+
+	err = CompressChunked(bytes.NewReader([]byte(data)), 1<<20, func(int) (io.WriteCloser, error) {
+		return os.Create(path.Join(tmpdir, "chunk"))
+	}, h.WithJobID("chunked"))
+	if assert.True(t, errors.As(err, &exitErr)) {
+		assert.Equal(t, "chunked", exitErr.JobID)
+	}
+}
+
+func TestJobIDInProgress(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	logs := captureLogs(t)
+
+	h, err := GetExternalHandlerFromMimeType("application/x-xz")
+	assert.Nil(t, err)
+
+	var events []Progress
+	h = h.WithProgress(func(p Progress) { events = append(events, p) })
+	assert.Nil(t, h.CompressFileInPlace(path.Join(tmpdir, "pipechaining")))
+
+	if assert.NotEmpty(t, events) {
+		id := events[0].JobID
+		assert.NotEmpty(t, id)
+		for _, p := range events {
+			assert.Equal(t, id, p.JobID)
+		}
+		assert.Contains(t, messages(logs.forJob(id)), "External Compression Command")
+	}
+}
+
+func TestJobIDMulti(t *testing.T) {
+	logs := captureLogs(t)
+
+	gz, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	inputs := []io.Reader{bytes.NewReader([]byte("one")), bytes.NewReader([]byte("two"))}
+	jobs, err := CompressMulti(inputs, gz, MultiOpts{Concurrency: 1})
+	assert.Nil(t, err)
+
+	// IDs are known before the jobs spawn, and kept once they do
+	ids := []string{jobs[0].ID(), jobs[1].ID()}
+	assert.NotEmpty(t, ids[0])
+	assert.NotEqual(t, ids[0], ids[1])
+	for i, job := range jobs {
+		_, err := ioutil.ReadAll(job)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, job.Result())
+		assert.Equal(t, ids[i], job.ID())
+		assert.Contains(t, messages(logs.forJob(ids[i])), "External Compression Command")
+	}
+}
+
+func TestLogFieldsMerge(t *testing.T) {
+	base := Options{LogFields: log.Fields{"trace": "a", "tenant": "x"}}
+	merged := base.Merge(Options{LogFields: log.Fields{"trace": "b"}, JobID: "id"})
+	assert.Equal(t, log.Fields{"trace": "b", "tenant": "x"}, merged.LogFields)
+	assert.Equal(t, "id", merged.JobID)
+	// The original is left alone
+	assert.Equal(t, log.Fields{"trace": "a", "tenant": "x"}, base.LogFields)
+	assert.Equal(t, base, base.Merge(Options{}))
+}
@@ -0,0 +1,43 @@
+package extcompress
+
+import "fmt"
+
+// When a handler's output becomes available relative to its input, which
+// decides whether it suits interactive paths such as proxying a stream to
+// a client with a read timeout.
+type Latency int
+
+const (
+	// Output is written as the input is read
+	LatencyStreaming Latency = iota
+	// Nothing is written until all the input has been read
+	LatencyStoreAndForward
+)
+
+func (l Latency) String() string {
+	switch l {
+	case LatencyStreaming:
+		return "streaming"
+	case LatencyStoreAndForward:
+		return "store-and-forward"
+	default:
+		return fmt.Sprintf("Latency(%d)", int(l))
+	}
+}
+
+// Declares when the handler's output becomes available.
+func OutputLatency(latency Latency) FilterOption {
+	return func(f *Filter) {
+		f.Latency = latency
+	}
+}
+
+// Returns the handler's latency: store-and-forward if declared so, or if
+// its output goes through a temp file which is only read once the tool
+// exits.
+func (c Filter) latency() Latency {
+	if c.RequiresTempOutput && !c.PreferFIFO {
+		return LatencyStoreAndForward
+	}
+	return c.Latency
+}
@@ -0,0 +1,36 @@
+package extcompress
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatency(t *testing.T) {
+	assert.Equal(t, "streaming", LatencyStreaming.String())
+	assert.Equal(t, "store-and-forward", LatencyStoreAndForward.String())
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	assert.True(t, h.Capabilities().Streaming)
+	h, err = GetExternalHandlerFromMimeType("application/zstd")
+	assert.Nil(t, err)
+	assert.False(t, h.Capabilities().Streaming)
+
+	assert.False(t, NewFilter("sort", OutputLatency(LatencyStoreAndForward)).Capabilities().Streaming)
+	// Output read from a temp file once the tool exits can't stream,
+	// unless it is a FIFO
+	f := Filter{Command: "gzip", RequiresTempOutput: true}
+	assert.False(t, f.Capabilities().Streaming)
+	f.PreferFIFO = true
+	assert.True(t, f.Capabilities().Streaming)
+}
+
+func TestBestCompressorStreaming(t *testing.T) {
+	pathWith(t, "gzip", "xz", "zstd")
+
+	assertBest(t, Criteria{Preference: PreferSpeed}, "zstd")
+	assertBest(t, Criteria{Preference: PreferSpeed, Require: Capabilities{Streaming: true}}, "gzip")
+	_, err := BestCompressor(Criteria{Require: Capabilities{Streaming: true, Threads: true}})
+	assert.IsType(t, NoSuitableHandler{}, err)
+}
@@ -0,0 +1,77 @@
+package extcompress
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Localized output which the parsers must not misread. With the C locale
+// forced they are never given it, but if they are it is ignored.
+func TestProgressParsersLocalized(t *testing.T) {
+	xzGerman := "big.txt (1/1)\n" +
+		"  12,4 %     1.024 KiB / 8,0 MiB = 0,125   1,2 MiB/s       0:05   0:30\r" +
+		"big.txt: 19,8 MiB / 25,8 MiB = 0,769, 1,8 MiB/s, 0:14\n"
+	assert.Empty(t, progressFromTranscript(xzProgressParsers, xzGerman))
+
+	gzipJapanese := "big.txt:\t 24.0% -- big.txt.gz に置換しました\n"
+	assert.Empty(t, progressFromTranscript(gzipProgressParsers, gzipJapanese))
+
+	zstdGerman := "\rbig.txt              : 75,37%   (  25,8 MiB =>   19,4 MiB, big.zst)   \n"
+	assert.Empty(t, progressFromTranscript(zstdProgressParsers, zstdGerman))
+
+	_, ok := parseSize("1,234.5 KiB")
+	assert.True(t, ok)
+	_, ok = parseSize("1.234,5 KiB")
+	assert.False(t, ok)
+}
+
+// Sets a locale the tools would translate their messages for.
+func setForeignLocale(t *testing.T) {
+	t.Setenv("LANG", "de_DE.UTF-8")
+	t.Setenv("LC_ALL", "de_DE.UTF-8")
+}
+
+// A tool which answers in German unless it is in the C locale.
+const localizedScript = `if [ "$LC_ALL" = C ] && [ "$LANG" = C ]; then echo "$1" >&2; else echo "$2" >&2; fi`
+
+func TestProgressRunsInCLocale(t *testing.T) {
+	setForeignLocale(t)
+	var stderr bytes.Buffer
+	h := NewFilter("sh", CompressFlags("-c", localizedScript+"; cat", "sh", "english", "deutsch")).WithStderr(&stderr)
+
+	// Without anything to parse the locale is left alone
+	compressBytes(t, h, []byte("data"))
+	assert.Equal(t, "deutsch\n", stderr.String())
+
+	stderr.Reset()
+	h = h.WithProgress(func(Progress) {})
+	assert.Subset(t, h.Plan().Env, cLocaleEnv)
+	compressBytes(t, h, []byte("data"))
+	assert.Equal(t, "english\n", stderr.String())
+}
+
+func TestDecompressorRunsInCLocale(t *testing.T) {
+	setForeignLocale(t)
+	h := NewFilter("sh", DecompressFlags("-c", "cat >/dev/null; "+localizedScript+"; exit 1", "sh",
+		"gzip: stdin: not in gzip format", "gzip: stdin: nicht im gzip-Format"))
+	proc, err := h.DecompressStream(bytes.NewReader([]byte("data")))
+	assert.Nil(t, err)
+	ioutil.ReadAll(proc)
+	assert.True(t, errors.Is(proc.(*CompressionJob).Err(), ErrCorruptInput))
+}
+
+func TestVersionProbeRunsInCLocale(t *testing.T) {
+	setForeignLocale(t)
+	dir := pathWith(t)
+	script := "#!/bin/sh\n" + strings.Replace(localizedScript, ">&2", "", -1) + "\n"
+	assert.Nil(t, ioutil.WriteFile(path.Join(dir, "xz"), []byte(script), 0755))
+	version, err := probeVersion(path.Join(dir, "xz"), []string{"xz (XZ Utils) 5.6.4", "xz (XZ Hilfsprogramme) 5,6"})
+	assert.Nil(t, err)
+	assert.Equal(t, "5.6.4", version)
+}
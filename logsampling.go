@@ -0,0 +1,191 @@
+package extcompress
+
+import (
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Controls how much the package logs about jobs which succeed, for callers
+// running so many that logging each would swamp their logs.
+type LogSampling struct {
+	// Logs every Nth job in full. Other jobs only log warnings and errors,
+	// and a line when they fail. 0 or 1 logs every job.
+	Every int
+	// How often to log a line totalling the jobs which finished in the
+	// interval. 0 disables it.
+	Interval time.Duration
+}
+
+var (
+	sampleEvery int64
+
+	samplingMtx  sync.Mutex
+	summaryStop  chan struct{}
+	summaryDone  chan struct{}
+	intervalJobs jobTotals
+)
+
+// Jobs finished since the last summary line, updated atomically.
+type jobTotals struct {
+	jobs     int64
+	failures int64
+	bytesIn  int64
+	bytesOut int64
+}
+
+// Sets how jobs started afterwards are logged, replacing any sampling
+// already set. Sampling is decided by job ID: generated IDs are numbered,
+// so exactly every Nth job is logged, while IDs set with WithJobID are
+// hashed. Stop the summary line with Shutdown.
+func SetLogSampling(s LogSampling) {
+	samplingMtx.Lock()
+	defer samplingMtx.Unlock()
+	atomic.StoreInt64(&sampleEvery, int64(s.Every))
+	stopSummaryLocked()
+	if s.Interval <= 0 {
+		return
+	}
+	intervalJobs.reset()
+	summaryStop, summaryDone = make(chan struct{}), make(chan struct{})
+	go logSummaries(s.Interval, summaryStop, summaryDone)
+}
+
+// Stops the package's background work, logging a last summary line if
+// SetLogSampling asked for them. Jobs can still be started afterwards.
+func Shutdown() {
+	samplingMtx.Lock()
+	defer samplingMtx.Unlock()
+	stopSummaryLocked()
+}
+
+// Called with samplingMtx held.
+func stopSummaryLocked() {
+	if summaryStop == nil {
+		return
+	}
+	close(summaryStop)
+	<-summaryDone
+	summaryStop, summaryDone = nil, nil
+}
+
+func logSummaries(interval time.Duration, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case now := <-ticker.C:
+			logSummary(now.Sub(last))
+			last = now
+		case <-stop:
+			logSummary(time.Since(last))
+			return
+		}
+	}
+}
+
+// Logs the totals of the jobs which finished in the last interval, and
+// starts counting afresh.
+func logSummary(interval time.Duration) {
+	fields := intervalJobs.reset()
+	fields["interval"] = interval.String()
+	log.WithFields(fields).Info("Job summary")
+}
+
+// Returns the totals as log fields, zeroing them.
+func (t *jobTotals) reset() log.Fields {
+	return log.Fields{
+		"jobs":      atomic.SwapInt64(&t.jobs, 0),
+		"failures":  atomic.SwapInt64(&t.failures, 0),
+		"bytes_in":  atomic.SwapInt64(&t.bytesIn, 0),
+		"bytes_out": atomic.SwapInt64(&t.bytesOut, 0),
+	}
+}
+
+// Counts a finished job towards the next summary line. Negative byte
+// counts are unknown.
+func countJob(bytesIn int64, bytesOut int64, err error) {
+	atomic.AddInt64(&intervalJobs.jobs, 1)
+	if err != nil {
+		atomic.AddInt64(&intervalJobs.failures, 1)
+	}
+	if bytesIn > 0 {
+		atomic.AddInt64(&intervalJobs.bytesIn, bytesIn)
+	}
+	if bytesOut > 0 {
+		atomic.AddInt64(&intervalJobs.bytesOut, bytesOut)
+	}
+}
+
+// True if job id is logged in full.
+func logSampled(id string) bool {
+	every := uint64(atomic.LoadInt64(&sampleEvery))
+	if every <= 1 {
+		return true
+	}
+	n, err := strconv.ParseUint(id[strings.LastIndexByte(id, '-')+1:], 10, 64)
+	if err != nil {
+		h := fnv.New64a()
+		h.Write([]byte(id))
+		n = h.Sum64()
+	}
+	return n%every == 0
+}
+
+// Logs like the standard logger, through its output, formatter and hooks,
+// but only at warning level and above. Jobs which aren't sampled log
+// through it.
+var quietLogger = &log.Logger{
+	Out:       stdOutput{},
+	Formatter: stdFormatter{},
+	Hooks:     log.LevelHooks{},
+	Level:     log.WarnLevel,
+}
+
+func init() {
+	quietLogger.Hooks.Add(stdHooks{})
+}
+
+type stdOutput struct{}
+
+func (stdOutput) Write(p []byte) (int, error) {
+	return log.StandardLogger().Out.Write(p)
+}
+
+type stdFormatter struct{}
+
+func (stdFormatter) Format(entry *log.Entry) ([]byte, error) {
+	return log.StandardLogger().Formatter.Format(entry)
+}
+
+type stdHooks struct{}
+
+func (stdHooks) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (stdHooks) Fire(entry *log.Entry) error {
+	return log.StandardLogger().Hooks.Fire(entry.Level, entry)
+}
+
+// Returns entry for job id, switched to the quiet logger if the job isn't
+// sampled.
+func sampledEntry(entry *log.Entry, id string) *log.Entry {
+	if logSampled(id) {
+		return entry
+	}
+	level := log.GetLevel()
+	if level > log.WarnLevel {
+		level = log.WarnLevel
+	}
+	quietLogger.SetLevel(level)
+	entry.Logger = quietLogger
+	return entry
+}
@@ -0,0 +1,114 @@
+package extcompress
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// Runs a job through h to completion, returning its ID.
+func runSampledJob(t *testing.T, h ExternalHandler) string {
+	job, err := h.CompressStream(bytes.NewReader([]byte(data)))
+	assert.Nil(t, err)
+	ioutil.ReadAll(job)
+	job.Result()
+	return job.ID()
+}
+
+// Returns the captured entries with message msg.
+func (lc *logCapture) withMessage(msg string) []log.Entry {
+	lc.mtx.Lock()
+	defer lc.mtx.Unlock()
+	var entries []log.Entry
+	for _, e := range lc.entries {
+		if e.Message == msg {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+func TestLogSamplingRatio(t *testing.T) {
+	logs := captureLogs(t)
+	SetLogSampling(LogSampling{Every: 4})
+	defer SetLogSampling(LogSampling{})
+
+	h := NewFilter("sh", CompressFlags("-c", "cat"))
+	logged := 0
+	for i := 0; i < 12; i++ {
+		id := runSampledJob(t, h)
+		entries := logs.forJob(id)
+		if logSampled(id) {
+			logged++
+			assert.Contains(t, messages(entries), "Spawning CompressStream")
+		} else {
+			assert.Empty(t, entries)
+		}
+	}
+	// Generated IDs are numbered, so the ratio is exact
+	assert.Equal(t, 3, logged)
+}
+
+func TestLogSamplingFailures(t *testing.T) {
+	logs := captureLogs(t)
+	SetLogSampling(LogSampling{Every: 4})
+	defer SetLogSampling(LogSampling{})
+
+	h := NewFilter("sh", CompressFlags("-c", "cat; exit 3"))
+	for i := 0; i < 8; i++ {
+		id := runSampledJob(t, h)
+		entries := logs.forJob(id)
+		if logSampled(id) {
+			assert.Contains(t, messages(entries), "Spawning CompressStream")
+			continue
+		}
+		// Every failure is logged, however it was sampled
+		if assert.Len(t, entries, 1) {
+			assert.Equal(t, "Job failed", entries[0].Message)
+			assert.Equal(t, log.WarnLevel, entries[0].Level)
+			assert.Equal(t, 3, entries[0].Data["status"])
+		}
+	}
+
+	// Warnings and errors still get through for jobs left out
+	SetLogSampling(LogSampling{Every: 1 << 30})
+	NewFilter("true").(Filter).jobLog("custom").Error("broken")
+	NewFilter("true").(Filter).jobLog("custom").Debug("detail")
+	assert.Equal(t, []string{"broken"}, messages(logs.forJob("custom")))
+}
+
+func TestLogSamplingSummary(t *testing.T) {
+	logs := captureLogs(t)
+	SetLogSampling(LogSampling{Every: 2, Interval: time.Hour})
+	defer SetLogSampling(LogSampling{})
+
+	runSampledJob(t, NewFilter("sh", CompressFlags("-c", "cat")))
+	runSampledJob(t, NewFilter("sh", CompressFlags("-c", "cat")))
+	runSampledJob(t, NewFilter("sh", CompressFlags("-c", "cat; exit 1")))
+
+	// The last interval is logged on the way out
+	Shutdown()
+	summaries := logs.withMessage("Job summary")
+	if assert.Len(t, summaries, 1) {
+		assert.Equal(t, int64(3), summaries[0].Data["jobs"])
+		assert.Equal(t, int64(1), summaries[0].Data["failures"])
+		assert.Equal(t, int64(3*len(data)), summaries[0].Data["bytes_in"])
+		assert.Equal(t, int64(3*len(data)), summaries[0].Data["bytes_out"])
+	}
+	Shutdown()
+	assert.Len(t, logs.withMessage("Job summary"), 1)
+
+	// Lines come every interval until shut down
+	SetLogSampling(LogSampling{Interval: 10 * time.Millisecond})
+	for len(logs.withMessage("Job summary")) < 3 {
+		time.Sleep(5 * time.Millisecond)
+	}
+	Shutdown()
+	n := len(logs.withMessage("Job summary"))
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, n, len(logs.withMessage("Job summary")))
+}
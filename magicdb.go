@@ -0,0 +1,133 @@
+package extcompress
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rakyll/magicmime"
+)
+
+// Selects the minimal ruleset bundled with the package, for
+// SetMagicDatabase.
+const EmbeddedMagic = "embedded"
+
+// The bundled ruleset, covering the formats of the built-in handlers
+//
+//go:embed magic/compression.magic
+var embeddedMagic []byte
+
+// Matched by the error from libmagic detection when no magic database,
+// not even the bundled one, could be loaded.
+var ErrMagicUnavailable = errors.New("extcompress: no libmagic database could be loaded")
+
+// Describes a magic database which couldn't be loaded. Wraps
+// ErrMagicUnavailable.
+type MagicUnavailableError struct {
+	// The database asked for, empty for the system's
+	Path string
+	Err  error
+}
+
+func (r MagicUnavailableError) Error() string {
+	path := r.Path
+	if path == "" {
+		path = "system magic database"
+	}
+	return fmt.Sprintf("%s: %s: %v", printable(path), ErrMagicUnavailable.Error(), r.Err)
+}
+
+func (r MagicUnavailableError) Unwrap() error {
+	return ErrMagicUnavailable
+}
+
+// The database the magic mime worker has loaded, which is EmbeddedMagic
+// after a fallback, and why none is if so. Only touched by the worker.
+var magicState struct {
+	loaded string
+	err    error
+}
+
+const magicFlags = magicmime.MAGIC_MIME_TYPE | magicmime.MAGIC_SYMLINK | magicmime.MAGIC_ERROR
+
+// Sets the libmagic database used for detection: a magic file, compiled or
+// not, "" for the system's (the default, or $MAGIC), or EmbeddedMagic for
+// the ruleset bundled with the package. It is loaded straight away. If it
+// can't be, the bundled ruleset is used instead with a warning, so the
+// built-in formats are still detected; the error is only for when nothing
+// could be loaded.
+//
+// libmagic only takes a database path from $MAGIC, so while a database other
+// than the system's loads, MAGIC is changed in this process's environment.
+// Tools the package spawns never see that, but the application's own use of
+// the environment might, so call this at startup or while nothing else reads
+// or changes MAGIC.
+func SetMagicDatabase(path string) error {
+	resp := make(chan mimeResponse, 1)
+	mimeQueryCh <- mimeQuery{reopen: true, database: path, resp: resp}
+	return (<-resp).err
+}
+
+// (Re)opens libmagic with the database at path, falling back to the bundled
+// one. Must only be called from the magic mime worker.
+func reopenMagic(path string) error {
+	magicmime.Close()
+	loaded := path
+	err := loadMagic(path)
+	if err != nil && path != EmbeddedMagic {
+		magicmime.Close()
+		log.WithFields(log.Fields{"database": printable(path), "error": err.Error()}).
+			Warn("Magic database unavailable, using the embedded one")
+		if embeddedErr := loadMagic(EmbeddedMagic); embeddedErr == nil {
+			loaded, err = EmbeddedMagic, nil
+		}
+	}
+	if err != nil {
+		err = MagicUnavailableError{path, err}
+		loaded = ""
+	}
+	magicState.loaded, magicState.err = loaded, err
+	return err
+}
+
+// Opens libmagic with the database at path. libmagic only takes a path from
+// $MAGIC, so it is set for just as long as the database takes to load, and
+// put back unless something else changed it in the meantime.
+func loadMagic(path string) error {
+	if path == EmbeddedMagic {
+		f, err := ioutil.TempFile("", "extcompress-magic-")
+		if err != nil {
+			return err
+		}
+		// The rules are compiled into memory as they load
+		defer os.Remove(f.Name())
+		_, err = f.Write(embeddedMagic)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		path = f.Name()
+	}
+	if path != "" {
+		environMtx.Lock()
+		defer environMtx.Unlock()
+		old, had := os.LookupEnv("MAGIC")
+		os.Setenv("MAGIC", path)
+		defer func() {
+			if os.Getenv("MAGIC") != path {
+				return
+			}
+			if had {
+				os.Setenv("MAGIC", old)
+			} else {
+				os.Unsetenv("MAGIC")
+			}
+		}()
+	}
+	return magicmime.Open(magicFlags)
+}
@@ -0,0 +1,152 @@
+package extcompress
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setMagicDatabase(t *testing.T, path string) error {
+	t.Cleanup(func() { assert.Nil(t, SetMagicDatabase("")) })
+	return SetMagicDatabase(path)
+}
+
+// Writes a file for each built-in format holding just its magic bytes and
+// some padding, returning their paths by handler name.
+func writeMagicFiles(t *testing.T, dir string) map[string]string {
+	paths := map[string]string{}
+	for name, magic := range contentMagics {
+		filename := path.Join(dir, name+"-magic")
+		content := append(append([]byte(nil), magic...), make([]byte, 64)...)
+		assert.Nil(t, ioutil.WriteFile(filename, content, os.FileMode(0644)))
+		paths[name] = filename
+	}
+	return paths
+}
+
+// Checks libmagic detects each file as the package's own magic bytes do.
+func checkMagicMatchesBuiltin(t *testing.T, paths map[string]string) {
+	for name, filename := range paths {
+		_, builtin, err := IsCompressed(filename)
+		assert.Nil(t, err)
+		assert.Equal(t, canonicalMimeType(name), builtin)
+
+		// The worker's own magic bytes (lzop's) give the handler name
+		_, own := magics[name]
+		mimeType, detector, err := detectFileType(context.Background(), filename)
+		assert.Nil(t, err, name)
+		assert.Equal(t, name, lookupHandlerName(mimeType), name)
+		if !own {
+			assert.Equal(t, builtin, mimeType, name)
+			assert.Equal(t, DetectedLibmagic, detector, name)
+		}
+
+		content, err := ioutil.ReadFile(filename)
+		assert.Nil(t, err)
+		mimeType, err = GetBufferMimeType(content)
+		assert.Nil(t, err)
+		assert.Equal(t, name, lookupHandlerName(mimeType), name)
+	}
+}
+
+func TestEmbeddedMagic(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	assert.Nil(t, setMagicDatabase(t, EmbeddedMagic))
+	assert.Equal(t, EmbeddedMagic, magicState.loaded)
+	checkMagicMatchesBuiltin(t, writeMagicFiles(t, tmpdir))
+
+	// It knows nothing else
+	mimeType, err := GetBufferMimeType([]byte{0x00, 0x01, 0x02, 0x03, 0xff, 0xfe})
+	assert.Nil(t, err)
+	assert.Equal(t, "application/octet-stream", mimeType)
+}
+
+func TestMagicDatabaseFallback(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	logs := captureLogs(t)
+	assert.Nil(t, setMagicDatabase(t, path.Join(tmpdir, "missing.mgc")))
+	assert.Equal(t, EmbeddedMagic, magicState.loaded)
+	logs.mtx.Lock()
+	assert.Contains(t, messages(logs.entries), "Magic database unavailable, using the embedded one")
+	logs.mtx.Unlock()
+	checkMagicMatchesBuiltin(t, writeMagicFiles(t, tmpdir))
+	_, had := os.LookupEnv("MAGIC")
+	assert.False(t, had)
+
+	// Real gzip output too
+	filename := writeCompressed(t, tmpdir, "gzip", []byte(data))
+	h, err := GetFileTypeExternalHandler(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, "application/gzip", h.MimeType())
+}
+
+func TestMagicDatabasePath(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	// A database of its own, in libmagic's source format
+	db := path.Join(tmpdir, "custom.magic")
+	assert.Nil(t, ioutil.WriteFile(db, []byte("0\tstring\tEXTCOMPRESS\tcustom data\n!:mime\tapplication/x-extcompress-test\n"), os.FileMode(0644)))
+	assert.Nil(t, setMagicDatabase(t, db))
+	assert.Equal(t, db, magicState.loaded)
+	mimeType, err := GetBufferMimeType([]byte("EXTCOMPRESS data"))
+	assert.Nil(t, err)
+	assert.Equal(t, "application/x-extcompress-test", mimeType)
+
+	// The system database again
+	assert.Nil(t, SetMagicDatabase(""))
+	mimeType, err = GetBufferMimeType([]byte("EXTCOMPRESS data"))
+	assert.Nil(t, err)
+	assert.Equal(t, "text/plain", mimeType)
+}
+
+func TestMagicDatabaseEnvironment(t *testing.T) {
+	// Registered first, so the system database is reloaded once MAGIC is
+	// back as it was
+	t.Cleanup(func() { assert.Nil(t, SetMagicDatabase("")) })
+	t.Setenv("MAGIC", "/nonexistent/magic")
+	f := NewFilter("gzip").(Filter)
+
+	// Children spawned while a database loads see the application's MAGIC,
+	// never the one set to load it
+	stop := make(chan struct{})
+	seen := make(chan []string, 1)
+	go func() {
+		var wrong []string
+		for {
+			select {
+			case <-stop:
+				seen <- wrong
+				return
+			default:
+			}
+			for _, kv := range f.childEnv() {
+				if strings.HasPrefix(kv, "MAGIC=") && kv != "MAGIC=/nonexistent/magic" {
+					wrong = append(wrong, kv)
+				}
+			}
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, SetMagicDatabase(EmbeddedMagic))
+	}
+	close(stop)
+	assert.Empty(t, <-seen)
+	assert.Equal(t, "/nonexistent/magic", os.Getenv("MAGIC"))
+}
+
+func TestMagicUnavailableError(t *testing.T) {
+	err := error(MagicUnavailableError{"", errors.New("could not find any valid magic files")})
+	assert.True(t, errors.Is(err, ErrMagicUnavailable))
+	assert.Equal(t, "system magic database: extcompress: no libmagic database could be loaded: could not find any valid magic files", err.Error())
+}
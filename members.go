@@ -0,0 +1,149 @@
+package extcompress
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Iterates over the concatenated members of a multi-member archive, yielding
+// a decompression process for each member in turn.
+type MemberIterator interface {
+	// Returns the decompressed stream of the next member, or io.EOF once all
+	// members have been returned.
+	Next() (CompressionProcess, error)
+	// Releases the underlying file. Processes already returned are unaffected.
+	Close() error
+}
+
+// Locates the member beginning at or after offset, returning its compressed
+// extent. Returns io.EOF when no members remain.
+type memberLocator func(f io.ReaderAt, offset int64, size int64) (start int64, end int64, err error)
+
+type memberIterator struct {
+	handler ExternalHandler
+	f       *os.File
+	size    int64
+	offset  int64
+	locate  memberLocator
+}
+
+func (it *memberIterator) Next() (CompressionProcess, error) {
+	start, end, err := it.locate(it.f, it.offset, it.size)
+	if err != nil {
+		return nil, err
+	}
+	it.offset = end
+
+	return it.handler.DecompressStream(io.NewSectionReader(it.f, start, end-start))
+}
+
+func (it *memberIterator) Close() error {
+	return it.f.Close()
+}
+
+// Opens an iterator over the individual members of a concatenated archive.
+// Member boundaries are discoverable for gzip (by walking member headers and
+// trailers) and xz (from each stream's index), other formats return
+// ErrNotSupported.
+func (c Filter) DecompressMembers(filePath string) (MemberIterator, error) {
+	if c.Command != "gzip" && c.Command != "xz" {
+		return nil, ErrNotSupported
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	locate := gzipMemberLocator
+	if c.Command == "xz" {
+		// The indexes are cheap to read, so resolve them once up front.
+		streams, err := xzStreams(f, st.Size())
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		locate = xzStreamLocator(streams)
+	}
+
+	return &memberIterator{
+		handler: c,
+		f:       f,
+		size:    st.Size(),
+		locate:  locate,
+	}, nil
+}
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Counts the bytes consumed by a flate reader. Implementing io.ByteReader
+// stops compress/gzip from adding its own read-ahead buffering, so the count
+// is exactly the length of the member once its trailer has been read.
+type countingByteReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (c *countingByteReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingByteReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+// gzip members carry no length, so the only way to find the end of one is to
+// walk its deflate stream through to the trailer.
+func gzipMemberLocator(f io.ReaderAt, offset int64, size int64) (int64, int64, error) {
+	magic := make([]byte, len(gzipMagic))
+	if _, err := f.ReadAt(magic, offset); err != nil || !bytes.Equal(magic, gzipMagic) {
+		// Trailing garbage is ignored, as gzip itself does
+		return 0, 0, io.EOF
+	}
+
+	cr := &countingByteReader{r: bufio.NewReader(io.NewSectionReader(f, offset, size-offset))}
+	zr, err := gzip.NewReader(cr)
+	if err != nil {
+		return 0, 0, err
+	}
+	zr.Multistream(false)
+
+	if _, err := io.Copy(ioutil.Discard, zr); err != nil {
+		return 0, 0, err
+	}
+
+	return offset, offset + cr.n, nil
+}
+
+func xzStreamLocator(streams []xzStream) memberLocator {
+	return func(f io.ReaderAt, offset int64, size int64) (int64, int64, error) {
+		for _, s := range streams {
+			if s.offset < offset {
+				continue
+			}
+			end := s.offset + xzHeaderSize
+			for _, b := range s.blocks {
+				end += b.size()
+			}
+			end += s.indexSize + xzFooterSize
+			return s.offset, end, nil
+		}
+		return 0, 0, io.EOF
+	}
+}
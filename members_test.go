@@ -0,0 +1,106 @@
+package extcompress
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var memberPayloads = [][]byte{
+	[]byte("first member of the archive\n"),
+	[]byte("second member, which is a little longer than the first\n"),
+	[]byte("third\n"),
+}
+
+// Compresses each payload separately and concatenates the results.
+func concatenatedArchive(t *testing.T, h ExternalHandler) []byte {
+	var archive bytes.Buffer
+	for _, p := range memberPayloads {
+		job, err := h.CompressStream(bytes.NewReader(p))
+		assert.Nil(t, err)
+		_, err = io.Copy(&archive, job)
+		assert.Nil(t, err)
+		assert.Zero(t, job.Result())
+	}
+	return archive.Bytes()
+}
+
+func TestMultiMemberDecompression(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	expected := bytes.Join(memberPayloads, nil)
+
+	for name, filter := range filtersMap {
+		if _, err := exec.LookPath(filter.Command); err != nil {
+			t.Logf("Skipping %s: %v", name, err)
+			continue
+		}
+
+		archive := concatenatedArchive(t, filter)
+		filename := path.Join(tmpdir, name+".multi")
+		assert.Nil(t, ioutil.WriteFile(filename, archive, os.FileMode(0644)))
+
+		job, err := filter.Decompress(filename)
+		assert.Nil(t, err)
+		out, err := ioutil.ReadAll(job)
+		assert.Nil(t, err)
+		assert.Zero(t, job.Result())
+		assert.Equal(t, expected, out, "Decompress with %s", name)
+
+		job, err = filter.DecompressStream(ioutil.NopCloser(bytes.NewReader(archive)))
+		assert.Nil(t, err)
+		out, err = ioutil.ReadAll(job)
+		assert.Nil(t, err)
+		assert.Zero(t, job.Result())
+		assert.Equal(t, expected, out, "DecompressStream with %s", name)
+	}
+}
+
+func TestDecompressMembers(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	for _, mimeType := range []string{"application/gzip", "application/x-xz"} {
+		h, err := GetExternalHandlerFromMimeType(mimeType)
+		assert.Nil(t, err)
+
+		archive := concatenatedArchive(t, h)
+		// Stream padding and trailing zeros should not produce phantom members
+		archive = append(archive, 0, 0, 0, 0)
+		filename := path.Join(tmpdir, "members")
+		assert.Nil(t, ioutil.WriteFile(filename, archive, os.FileMode(0644)))
+
+		it, err := h.DecompressMembers(filename)
+		assert.Nil(t, err)
+
+		var members [][]byte
+		for {
+			job, err := it.Next()
+			if err == io.EOF {
+				break
+			}
+			assert.Nil(t, err)
+			out, err := ioutil.ReadAll(job)
+			assert.Nil(t, err)
+			assert.Zero(t, job.Result())
+			members = append(members, out)
+		}
+		assert.Nil(t, it.Close())
+		assert.Equal(t, memberPayloads, members, mimeType)
+	}
+}
+
+func TestDecompressMembersUnsupported(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/x-bzip2")
+	assert.Nil(t, err)
+
+	_, err = h.DecompressMembers("/nonexistent")
+	assert.Equal(t, ErrNotSupported, err)
+}
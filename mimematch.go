@@ -0,0 +1,79 @@
+package extcompress
+
+import (
+	"strings"
+)
+
+// How a handler's mimetype was matched when it was looked up.
+type MatchKind int
+
+const (
+	// The handler wasn't looked up by mimetype (NewFilter, BestCompressor)
+	MatchNone MatchKind = iota
+	// The mimetype is registered to the handler
+	MatchExact
+	// The mimetype fell back to a wildcard registration such as "text/*"
+	MatchWildcard
+)
+
+func (k MatchKind) String() string {
+	switch k {
+	case MatchExact:
+		return "exact"
+	case MatchWildcard:
+		return "wildcard"
+	default:
+		return "none"
+	}
+}
+
+// Whether lookups of unregistered mimetypes fall back to wildcard entries.
+// Guarded by registryMtx.
+var mimeFallback = true
+
+// Sets whether mimetypes with no registration of their own fall back to a
+// wildcard registration for their top level type, e.g. "text/x-weird" to
+// the handler for "text/*". Enabled by default.
+func SetMimeFallback(enabled bool) {
+	registryMtx.Lock()
+	defer registryMtx.Unlock()
+	mimeFallback = enabled
+}
+
+// True if mimeType is a wildcard for a whole top level type, e.g. "text/*".
+func isMimeWildcard(mimeType string) bool {
+	topLevel := strings.TrimSuffix(mimeType, "/*")
+	return topLevel != mimeType && topLevel != "" && !strings.ContainsAny(topLevel, "/*")
+}
+
+// Checks a mimetype is usable in a registration: either exact, or a
+// wildcard for a whole top level type.
+func checkMimePattern(mimeType string) bool {
+	return !strings.ContainsRune(mimeType, '*') || isMimeWildcard(mimeType)
+}
+
+// Returns the handler name mimeType resolves to and how. Must be called
+// with registryMtx held.
+func resolveMimeType(mimeType string) (string, MatchKind, bool) {
+	if isMimeWildcard(mimeType) {
+		return "", MatchNone, false
+	}
+	if name, ok := mimeMap[mimeType]; ok {
+		return name, MatchExact, true
+	}
+	if !mimeFallback {
+		return "", MatchNone, false
+	}
+	slash := strings.IndexByte(mimeType, '/')
+	if slash <= 0 {
+		return "", MatchNone, false
+	}
+	if name, ok := mimeMap[mimeType[:slash]+"/*"]; ok {
+		return name, MatchWildcard, true
+	}
+	return "", MatchNone, false
+}
+
+func (c Filter) MatchedBy() MatchKind {
+	return c.matchedBy
+}
@@ -0,0 +1,73 @@
+package extcompress
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setMimeFallback(t *testing.T, enabled bool) {
+	registryMtx.RLock()
+	old := mimeFallback
+	registryMtx.RUnlock()
+	SetMimeFallback(enabled)
+	t.Cleanup(func() { SetMimeFallback(old) })
+}
+
+func TestMimeMatchExact(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	assert.Equal(t, MatchExact, h.MatchedBy())
+	h, err = GetExternalHandlerFromMimeType("text/plain")
+	assert.Nil(t, err)
+	assert.Equal(t, MatchExact, h.MatchedBy())
+
+	// Handlers not found by mimetype weren't matched at all
+	assert.Equal(t, MatchNone, NewFilter("gzip").MatchedBy())
+}
+
+func TestMimeMatchWildcard(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("text/x-weird")
+	assert.Nil(t, err)
+	assert.Equal(t, MatchWildcard, h.MatchedBy())
+	assert.True(t, h.Capabilities().Passthrough)
+	assert.Equal(t, "text/x-weird", h.MimeType())
+
+	// Only wildcards take part, not bare top level types
+	for _, mimeType := range []string{"video/mp4", "application/x-rar", "text", "text/*", "/plain"} {
+		_, err := GetExternalHandlerFromMimeType(mimeType)
+		assert.IsType(t, UnknownFileType{}, err, mimeType)
+	}
+	unregisterFilter(t, "bulk")
+	assert.Nil(t, RegisterFilter("bulk", Identity(), "application"))
+	_, err = GetExternalHandlerFromMimeType("application/x-rar")
+	assert.IsType(t, UnknownFileType{}, err)
+
+	// Until one is registered for the type
+	assert.Nil(t, RegisterFilter("bulk", Identity(), "application/*"))
+	h, err = GetExternalHandlerFromMimeType("application/x-rar")
+	assert.Nil(t, err)
+	assert.Equal(t, MatchWildcard, h.MatchedBy())
+	h, err = GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	assert.Equal(t, MatchExact, h.MatchedBy())
+	assert.False(t, h.Capabilities().Passthrough)
+}
+
+func TestMimeMatchFallbackDisabled(t *testing.T) {
+	setMimeFallback(t, false)
+
+	_, err := GetExternalHandlerFromMimeType("text/x-weird")
+	assert.IsType(t, UnknownFileType{}, err)
+	h, err := GetExternalHandlerFromMimeType("text/plain")
+	assert.Nil(t, err)
+	assert.Equal(t, MatchExact, h.MatchedBy())
+}
+
+func TestRegisterMimePatterns(t *testing.T) {
+	unregisterFilter(t, "patterns")
+	for _, bad := range []string{"*", "*/*", "text/x-*", "text/*/*"} {
+		err := RegisterFilter("patterns", Identity(), bad)
+		assert.ErrorIs(t, err, ErrRegistration, bad)
+	}
+}
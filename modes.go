@@ -0,0 +1,42 @@
+package extcompress
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Mode of files written from a stream, which have no source to copy a mode
+// from, when Options.Mode is unset.
+const defaultFileMode os.FileMode = 0644
+
+// Returns the mode for an output file. src is the mode of the file it was
+// transformed from, or nil for pure writes.
+func (c Filter) outputMode(src *os.FileMode) os.FileMode {
+	if c.opts.Mode != 0 {
+		return c.opts.Mode.Perm()
+	}
+	if src != nil {
+		return src.Perm()
+	}
+	return defaultFileMode
+}
+
+// Creates any missing parent directories of path with Options.DirMode. With
+// no DirMode set, missing directories are left for the operation to fail on.
+func (c Filter) makeParentDirs(path string) error {
+	if c.opts.DirMode == 0 {
+		return nil
+	}
+	dir := filepath.Dir(path)
+	if _, err := os.Stat(dir); err == nil || !os.IsNotExist(err) {
+		return err
+	}
+	if err := c.makeParentDirs(dir); err != nil {
+		return err
+	}
+	if err := os.Mkdir(dir, c.opts.DirMode.Perm()); err != nil && !os.IsExist(err) {
+		return err
+	}
+	// Mkdir is subject to the umask, so set the mode exactly
+	return os.Chmod(dir, c.opts.DirMode.Perm())
+}
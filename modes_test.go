@@ -0,0 +1,93 @@
+package extcompress
+
+import (
+	"os"
+	"path"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func fileMode(t *testing.T, filename string) os.FileMode {
+	st, err := os.Stat(filename)
+	assert.Nil(t, err)
+	return st.Mode().Perm()
+}
+
+// Sets a restrictive umask for the test, which the modes must not depend on.
+func setUmask(t *testing.T, mask int) {
+	old := syscall.Umask(mask)
+	t.Cleanup(func() { syscall.Umask(old) })
+}
+
+func TestOutputModesPureWrite(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	setUmask(t, 077)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	for mode, opts := range map[os.FileMode]Options{
+		0644: {},
+		0640: {Mode: 0640},
+	} {
+		dst := path.Join(tmpdir, "out.gz")
+		w, err := h.WithOptions(opts).CompressIntoFile(dst)
+		assert.Nil(t, err)
+		w.Write([]byte("data"))
+		assert.Nil(t, w.Close())
+		assert.Equal(t, mode, fileMode(t, dst))
+		os.Remove(dst)
+	}
+}
+
+func TestOutputModesTransform(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	setUmask(t, 077)
+
+	h, err := GetExternalHandlerFromMimeType("application/x-bzip2")
+	assert.Nil(t, err)
+	filename := path.Join(tmpdir, "pipechaining")
+	assert.Nil(t, os.Chmod(filename, 0664))
+
+	// Package-side in-place operations preserve the source mode...
+	out, err := h.CompressFileInPlaceWithOptions(filename, InPlaceOptions{Suffix: ".bzz"})
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0664), fileMode(t, out))
+
+	// ...as does decompressing to a file...
+	dst := path.Join(tmpdir, "decompressed")
+	assert.Nil(t, h.DecompressToFile(out, dst))
+	assert.Equal(t, os.FileMode(0664), fileMode(t, dst))
+
+	// ...unless a mode is given, which also applies to existing files
+	assert.Nil(t, h.WithOptions(Options{Mode: 0600}).DecompressToFile(out, dst))
+	assert.Equal(t, os.FileMode(0600), fileMode(t, dst))
+	out, err = h.WithOptions(Options{Mode: 0640}).DecompressFileInPlaceWithOptions(out, InPlaceOptions{Suffix: ".bzz"})
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0640), fileMode(t, out))
+}
+
+func TestOutputDirMode(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	setUmask(t, 077)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	// Without a DirMode missing directories are an error
+	dst := path.Join(tmpdir, "a", "b", "out.gz")
+	_, err = h.CompressIntoFile(dst)
+	assert.True(t, os.IsNotExist(err))
+
+	w, err := h.WithOptions(Options{DirMode: 0750}).CompressIntoFile(dst)
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+	assert.Equal(t, os.FileMode(0750), fileMode(t, path.Join(tmpdir, "a")))
+	assert.Equal(t, os.FileMode(0750), fileMode(t, path.Join(tmpdir, "a", "b")))
+	assert.Equal(t, os.FileMode(0644), fileMode(t, dst))
+}
@@ -0,0 +1,74 @@
+package extcompress
+
+import (
+	"sync"
+	"time"
+)
+
+// How often running jobs are checked by the monitor.
+var monitorInterval = time.Second
+
+// A periodic check on a running job. Returns false once the job no longer
+// needs watching.
+type monitorCheck func() bool
+
+// Runs the periodic checks for every watched job (CPU guards and the like)
+// from a single goroutine, which only runs while something is being watched.
+type jobMonitor struct {
+	mtx     sync.Mutex
+	checks  map[*monitorCheck]struct{}
+	running bool
+}
+
+var monitor = jobMonitor{checks: map[*monitorCheck]struct{}{}}
+
+// Adds check to the monitor loop, starting it if needed.
+func (m *jobMonitor) watch(check monitorCheck) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.checks[&check] = struct{}{}
+	if !m.running {
+		m.running = true
+		// Read here, not by the loop, so tests can change it safely
+		go m.loop(monitorInterval)
+	}
+}
+
+func (m *jobMonitor) loop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		m.mtx.Lock()
+		checks := make([]*monitorCheck, 0, len(m.checks))
+		for check := range m.checks {
+			checks = append(checks, check)
+		}
+		m.mtx.Unlock()
+
+		// Checks run without the lock so they are free to kill jobs
+		var finished []*monitorCheck
+		for _, check := range checks {
+			if !(*check)() {
+				finished = append(finished, check)
+			}
+		}
+
+		m.mtx.Lock()
+		for _, check := range finished {
+			delete(m.checks, check)
+		}
+		if len(m.checks) == 0 {
+			m.running = false
+			m.mtx.Unlock()
+			return
+		}
+		m.mtx.Unlock()
+	}
+}
+
+// Returns the number of checks currently being run.
+func (m *jobMonitor) watching() int {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return len(m.checks)
+}
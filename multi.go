@@ -0,0 +1,332 @@
+package extcompress
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Shared limits applied across all the compressors spawned by CompressMulti.
+type MultiOpts struct {
+	// Maximum number of compressors running at once. 0 means no limit.
+	Concurrency int
+	// Total input bytes per second shared by all jobs. 0 means no limit.
+	BytesPerSecond int64
+	// Total memory budget, consumed at MemoryPerJob by each running job.
+	// Both must be set for the budget to apply.
+	MemoryLimit  int64
+	MemoryPerJob int64
+	// Cancelling the context terminates every running compressor and fails
+	// any which have not yet started.
+	Context context.Context
+}
+
+// Maximum number of jobs allowed to run at once under these options.
+func (o MultiOpts) slots(inputs int) int {
+	n := inputs
+	if o.Concurrency > 0 && o.Concurrency < n {
+		n = o.Concurrency
+	}
+	if o.MemoryLimit > 0 && o.MemoryPerJob > 0 {
+		if m := int(o.MemoryLimit / o.MemoryPerJob); m < n {
+			n = m
+		}
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// Token bucket shared between readers. Callers sleep until their bytes would
+// have been allowed at the configured rate.
+type rateLimiter struct {
+	rate int64
+	mtx  sync.Mutex
+	next time.Time
+}
+
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	l.mtx.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	until := l.next
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.rate))
+	l.mtx.Unlock()
+
+	t := time.NewTimer(until.Sub(now))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type rateLimitedReader struct {
+	r       io.Reader
+	limiter *rateLimiter
+	ctx     context.Context
+
+	// Set if the input was cut short by cancellation, atomically
+	truncated int32
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.limiter.wait(r.ctx, n); werr != nil {
+			// Let the compressor see EOF; the job is being torn down anyway.
+			atomic.StoreInt32(&r.truncated, 1)
+			return n, io.EOF
+		}
+	}
+	return n, err
+}
+
+// The shared state of one CompressMulti invocation.
+type multiGroup struct {
+	handler ExternalHandler
+	ctx     context.Context
+	slots   chan struct{}
+	limiter *rateLimiter
+
+	mtx     sync.Mutex
+	running map[*multiJob]struct{}
+	// Jobs yet to finish, and what stops the context cancelling the group,
+	// called once they all have
+	pending    int
+	stopCancel func() bool
+}
+
+// A job which is spawned lazily once a slot becomes free. It holds its slot
+// until the compressor has been reaped.
+type multiJob struct {
+	group *multiGroup
+	input io.Reader
+	// Assigned up front so the job can be identified before it spawns
+	id string
+
+	startOnce   sync.Once
+	releaseOnce sync.Once
+	finishOnce  sync.Once
+	job         CompressionProcess
+	limited     *rateLimitedReader
+	err         error
+}
+
+func (m *multiJob) start() error {
+	m.startOnce.Do(func() {
+		g := m.group
+		select {
+		case g.slots <- struct{}{}:
+		case <-g.ctx.Done():
+			m.err = g.ctx.Err()
+			m.finish()
+			return
+		}
+
+		// Re-check: the slot may have been won in a race with cancellation.
+		if m.err = g.ctx.Err(); m.err != nil {
+			<-g.slots
+			m.finish()
+			return
+		}
+
+		input := m.input
+		if g.limiter != nil {
+			m.limited = &rateLimitedReader{r: input, limiter: g.limiter, ctx: g.ctx}
+			input = m.limited
+		}
+
+		proc, err := g.handler.WithJobID(m.id).CompressStream(input)
+		if err != nil {
+			<-g.slots
+			m.err = err
+			m.finish()
+			return
+		}
+		m.job = proc
+
+		g.mtx.Lock()
+		g.running[m] = struct{}{}
+		g.mtx.Unlock()
+	})
+	return m.err
+}
+
+func (m *multiJob) release() {
+	m.releaseOnce.Do(func() {
+		g := m.group
+		g.mtx.Lock()
+		delete(g.running, m)
+		g.mtx.Unlock()
+		<-g.slots
+		m.finish()
+	})
+}
+
+// Counts the job as finished, whether or not it ran, and stops the group
+// watching its context once every job has.
+func (m *multiJob) finish() {
+	m.finishOnce.Do(func() {
+		g := m.group
+		g.mtx.Lock()
+		g.pending--
+		done := g.pending == 0
+		g.mtx.Unlock()
+		if done {
+			g.stopCancel()
+		}
+	})
+}
+
+func (m *multiJob) Read(p []byte) (int, error) {
+	if err := m.start(); err != nil {
+		return 0, err
+	}
+	n, err := m.job.Read(p)
+	if err == io.EOF {
+		// Reap now so the slot is freed even if Result is never called.
+		statusOf(m.job)
+		m.release()
+		// The compressor may have finished cleanly on a truncated input
+		if m.limited != nil && atomic.LoadInt32(&m.limited.truncated) != 0 {
+			err = m.group.ctx.Err()
+		}
+	} else if err != nil && m.group.ctx.Err() != nil {
+		// Closed under it by cancellation
+		err = m.group.ctx.Err()
+	}
+	return n, err
+}
+
+func (m *multiJob) Result() int {
+	if err := m.start(); err != nil {
+		return -1
+	}
+	defer m.release()
+	return m.job.Result()
+}
+
+func (m *multiJob) ID() string {
+	return m.id
+}
+
+func (m *multiJob) Close() error {
+	// Closing a job which never started just stops it from starting.
+	m.startOnce.Do(func() {
+		m.err = io.ErrClosedPipe
+		m.finish()
+	})
+	if m.job == nil {
+		return nil
+	}
+	defer m.release()
+	return m.job.Close()
+}
+
+// Terminates every compressor currently running in the group.
+func (g *multiGroup) cancel() {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	for m := range g.running {
+		job, ok := m.job.(*CompressionJob)
+		if !ok {
+			// Nothing to signal, so stopped as its reader would
+			go m.job.Close()
+			continue
+		}
+		// Signalled rather than closed, so its result shows it was cut short
+		if err := job.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+			job.log.WithField("error", err.Error()).Debug("Error signalling cancelled compression job")
+		}
+	}
+}
+
+// Compresses each of inputs with its own instance of h, under shared limits.
+// The returned processes are in input order; each is spawned when it is first
+// read from (or its Result is requested) and a slot is free, and gives its
+// slot back once its compressor has exited.
+func CompressMulti(inputs []io.Reader, h ExternalHandler, opts MultiOpts) ([]CompressionProcess, error) {
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	g := &multiGroup{
+		handler: h,
+		ctx:     ctx,
+		slots:   make(chan struct{}, opts.slots(len(inputs))),
+		running: make(map[*multiJob]struct{}),
+		pending: len(inputs),
+	}
+	if opts.BytesPerSecond > 0 {
+		g.limiter = &rateLimiter{rate: opts.BytesPerSecond}
+	}
+
+	g.stopCancel = context.AfterFunc(ctx, g.cancel)
+	if len(inputs) == 0 {
+		g.stopCancel()
+	}
+
+	jobs := make([]CompressionProcess, len(inputs))
+	for i, input := range inputs {
+		jobs[i] = &multiJob{group: g, input: input, id: jobIDFor(h)}
+	}
+	return jobs, nil
+}
+
+// Compresses every input into the matching output, running as many at once as
+// opts allows. Returns one error per input, nil where the input succeeded.
+func CompressMultiTo(inputs []io.Reader, outputs []io.Writer, h ExternalHandler, opts MultiOpts) []error {
+	errs := make([]error, len(inputs))
+	if len(outputs) != len(inputs) {
+		for i := range errs {
+			errs[i] = io.ErrShortWrite
+		}
+		return errs
+	}
+
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	jobs, err := CompressMulti(inputs, h, opts)
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+
+	var wg sync.WaitGroup
+	for i := range jobs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			job := jobs[i]
+			_, err := copyPooled(outputs[i], job)
+			if err != nil {
+				job.Close()
+			} else if status := statusOf(job); status != 0 {
+				err = ExitStatusError{h.CommandStreamCompress(), status, job.ID()}
+			}
+			// A cancelled job's failure is the cancellation, not its exit status
+			if err != nil && ctx.Err() != nil {
+				err = ctx.Err()
+			}
+			errs[i] = err
+		}(i)
+	}
+	wg.Wait()
+
+	return errs
+}
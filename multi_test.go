@@ -0,0 +1,217 @@
+package extcompress
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Tracks how many inputs are being consumed at once. An input is only read
+// once its compressor has been spawned, and is exhausted before the
+// compressor's slot is released.
+type concurrencyProbe struct {
+	mtx     sync.Mutex
+	active  int
+	maxSeen int
+}
+
+type probedReader struct {
+	probe   *concurrencyProbe
+	r       io.Reader
+	started bool
+	done    bool
+}
+
+func (p *probedReader) Read(b []byte) (int, error) {
+	if !p.started {
+		p.started = true
+		p.probe.mtx.Lock()
+		p.probe.active++
+		if p.probe.active > p.probe.maxSeen {
+			p.probe.maxSeen = p.probe.active
+		}
+		p.probe.mtx.Unlock()
+		// Give other jobs a chance to overlap
+		time.Sleep(10 * time.Millisecond)
+	}
+	n, err := p.r.Read(b)
+	if err == io.EOF && !p.done {
+		p.done = true
+		p.probe.mtx.Lock()
+		p.probe.active--
+		p.probe.mtx.Unlock()
+	}
+	return n, err
+}
+
+func TestCompressMulti(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	probe := &concurrencyProbe{}
+	var payloads [][]byte
+	var inputs []io.Reader
+	var outputs []io.Writer
+	var buffers []*bytes.Buffer
+	for i := 0; i < 50; i++ {
+		payload := []byte(fmt.Sprintf("payload for tenant %d\n", i))
+		payloads = append(payloads, payload)
+		inputs = append(inputs, &probedReader{probe: probe, r: bytes.NewReader(payload)})
+		b := &bytes.Buffer{}
+		buffers = append(buffers, b)
+		outputs = append(outputs, b)
+	}
+
+	errs := CompressMultiTo(inputs, outputs, h, MultiOpts{Concurrency: 4})
+	for i, err := range errs {
+		assert.Nil(t, err, "input %d", i)
+	}
+
+	assert.True(t, probe.maxSeen <= 4, "saw %d concurrent jobs", probe.maxSeen)
+	assert.True(t, probe.maxSeen > 1, "jobs never overlapped")
+
+	// Outputs line up with their inputs
+	for i, b := range buffers {
+		dr, err := h.DecompressStream(ioutil.NopCloser(b))
+		assert.Nil(t, err)
+		out, err := ioutil.ReadAll(dr)
+		assert.Nil(t, err)
+		assert.Zero(t, dr.Result())
+		assert.Equal(t, payloads[i], out)
+	}
+}
+
+func TestCompressMultiOrdering(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	var inputs []io.Reader
+	for i := 0; i < 10; i++ {
+		inputs = append(inputs, bytes.NewReader([]byte(fmt.Sprintf("job %d", i))))
+	}
+
+	jobs, err := CompressMulti(inputs, h, MultiOpts{Concurrency: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, len(inputs), len(jobs))
+
+	// Reading in reverse order must not deadlock against the semaphore
+	for i := len(jobs) - 1; i >= 0; i-- {
+		dr, err := h.DecompressStream(ioutil.NopCloser(jobs[i]))
+		assert.Nil(t, err)
+		out, err := ioutil.ReadAll(dr)
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("job %d", i), string(out))
+		assert.Zero(t, jobs[i].Result())
+	}
+}
+
+func TestCompressMultiCancel(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	// Inputs which trickle data forever keep every slot busy.
+	var inputs []io.Reader
+	var outputs []io.Writer
+	for i := 0; i < 8; i++ {
+		inputs = append(inputs, io.LimitReader(zeroReader{}, 1<<40))
+		outputs = append(outputs, ioutil.Discard)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan []error)
+	go func() {
+		done <- CompressMultiTo(inputs, outputs, h, MultiOpts{
+			Concurrency:    2,
+			BytesPerSecond: 1 << 20,
+			Context:        ctx,
+		})
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+
+	select {
+	case errs := <-done:
+		for i, err := range errs {
+			assert.Equal(t, context.Canceled, err, "input %d", i)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("cancellation did not tear down the jobs")
+	}
+}
+
+// Hands out its jobs wrapped, so they aren't *CompressionJob
+type wrappingHandler struct{ ExternalHandler }
+
+type wrappedProcess struct{ CompressionProcess }
+
+func (h wrappingHandler) WithJobID(id string) ExternalHandler {
+	return wrappingHandler{h.ExternalHandler.WithJobID(id)}
+}
+
+func (h wrappingHandler) CompressStream(rd io.Reader) (CompressionProcess, error) {
+	proc, err := h.ExternalHandler.CompressStream(rd)
+	if err != nil {
+		return nil, err
+	}
+	return wrappedProcess{proc}, nil
+}
+
+func TestCompressMultiOtherProcesses(t *testing.T) {
+	gz, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	h := wrappingHandler{gz}
+
+	inputs := []io.Reader{bytes.NewReader([]byte("first")), bytes.NewReader([]byte("second"))}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	jobs, err := CompressMulti(inputs, h, MultiOpts{Concurrency: 1, Context: ctx})
+	assert.Nil(t, err)
+	for i, job := range jobs {
+		dr, err := gz.DecompressStream(ioutil.NopCloser(job))
+		assert.Nil(t, err)
+		out, err := ioutil.ReadAll(dr)
+		assert.Nil(t, err)
+		assert.Zero(t, job.Result(), "input %d", i)
+		assert.Equal(t, []string{"first", "second"}[i], string(out))
+	}
+
+	// Once every job is done, the group no longer watches the context
+	g := jobs[0].(*multiJob).group
+	assert.False(t, g.stopCancel())
+
+	// And cancelling stops jobs which aren't CompressionJobs by closing them
+	jobs, err = CompressMulti([]io.Reader{io.LimitReader(zeroReader{}, 1<<40)}, h,
+		MultiOpts{BytesPerSecond: 1 << 20, Context: ctx})
+	assert.Nil(t, err)
+	done := make(chan error)
+	go func() {
+		_, err := ioutil.ReadAll(jobs[0])
+		done <- err
+	}()
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("cancellation did not stop the job")
+	}
+	jobs[0].Close()
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
@@ -0,0 +1,117 @@
+package extcompress
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// The token a peer uses to accept uncompressed data, as in HTTP's
+// Content-Encoding. Mimetypes of passthrough handlers, like text/plain, are
+// taken to mean the same.
+const IdentityToken = "identity"
+
+// Matched by the error from NegotiateHandler when the peer accepts nothing
+// which can be produced here.
+var ErrNoCommonFormat = errors.New("extcompress: no compression format in common with peer")
+
+// Describes a failed negotiation. Wraps ErrNoCommonFormat.
+type NoCommonFormatError struct {
+	Remote []string
+	Local  []string
+	// Why each format the peer accepts was passed over
+	Reasons []string
+}
+
+func (r NoCommonFormatError) Error() string {
+	return fmt.Sprintf("%s: peer accepts %s (%s)", ErrNoCommonFormat.Error(),
+		strings.Join(r.Remote, ", "), strings.Join(r.Reasons, "; "))
+}
+
+func (r NoCommonFormatError) Unwrap() error {
+	return ErrNoCommonFormat
+}
+
+// Returns the registered handler name token stands for, through aliases but
+// not wildcards, or "" if it is unknown. Called with registryMtx held.
+func negotiationName(token string) string {
+	token = strings.ToLower(strings.TrimSpace(token))
+	if token == IdentityToken {
+		return "cat"
+	}
+	name, ok := mimeMap[token]
+	if !ok {
+		return ""
+	}
+	if filtersMap[name].Passthrough {
+		return "cat"
+	}
+	return name
+}
+
+// Picks the handler to send data to a peer with, from the mimetypes the
+// peer accepts and those this side prefers, best first. Aliases on either
+// side count as the same format, and handlers whose tool isn't installed
+// are passed over. With no local preference the peer's order is used.
+// Identity is only chosen if nothing else is in common, and only if the
+// local preference is empty or lists it. Returns the handler with the
+// peer's own token for the chosen format, to echo back to it.
+func NegotiateHandler(remoteAccepted []string, localPreference []string) (ExternalHandler, string, error) {
+	registryMtx.RLock()
+	defer registryMtx.RUnlock()
+
+	// The peer's first token for each format it accepts
+	remote := map[string]string{}
+	var remoteOrder []string
+	var reasons []string
+	for _, token := range remoteAccepted {
+		name := negotiationName(token)
+		if name == "" {
+			reasons = append(reasons, token+" is not known here")
+			continue
+		}
+		if _, ok := remote[name]; !ok {
+			remote[name] = token
+			remoteOrder = append(remoteOrder, name)
+		}
+	}
+
+	order := remoteOrder
+	if len(localPreference) > 0 {
+		order = nil
+		for _, token := range localPreference {
+			if name := negotiationName(token); name != "" {
+				order = append(order, name)
+			}
+		}
+	}
+
+	identity := false
+	for _, name := range order {
+		token, ok := remote[name]
+		if !ok {
+			continue
+		}
+		if name == "cat" {
+			identity = true
+			continue
+		}
+		f := filtersMap[name]
+		if _, err := exec.LookPath(f.Command); err != nil {
+			reasons = append(reasons, name+": "+f.Command+" is not installed")
+			continue
+		}
+		f.mimeType = canonicalMimeTypes[name]
+		f.stampLookup(name, token, MatchExact, SelectedNegotiated)
+		f.applyRegisteredOptions(name)
+		return f, token, nil
+	}
+	if identity {
+		f := filtersMap["cat"]
+		f.stampLookup("cat", remote["cat"], MatchExact, SelectedNegotiated)
+		f.applyRegisteredOptions("cat")
+		return f, remote["cat"], nil
+	}
+	return nil, "", NoCommonFormatError{remoteAccepted, localPreference, reasons}
+}
@@ -0,0 +1,76 @@
+package extcompress
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateHandler(t *testing.T) {
+	// zstd and bzip2 aren't installed
+	pathWith(t, "gzip", "xz", "cat")
+
+	cases := []struct {
+		name    string
+		remote  []string
+		local   []string
+		command string
+		token   string
+	}{
+		{"overlapping", []string{"application/zstd", "application/gzip"}, []string{"xz", "gzip"}, "gzip", "application/gzip"},
+		{"local preference wins", []string{"application/gzip", "application/x-xz"}, []string{"xz", "gzip"}, "xz", "application/x-xz"},
+		{"remote order without preference", []string{"application/x-xz", "application/gzip"}, nil, "xz", "application/x-xz"},
+		{"alias only overlap", []string{"application/x-gzip"}, []string{"application/gzip"}, "gzip", "application/x-gzip"},
+		{"local alias", []string{"application/zstd", "application/gzip"}, []string{"application/x-zstd", "gzip"}, "gzip", "application/gzip"},
+		{"uninstalled passed over", []string{"application/zstd", "application/x-xz"}, []string{"zstd", "xz"}, "xz", "application/x-xz"},
+		{"case and space", []string{" Application/GZIP "}, []string{"gzip"}, "gzip", " Application/GZIP "},
+		{"identity last resort", []string{"identity", "application/gzip"}, []string{"identity", "gzip"}, "gzip", "application/gzip"},
+		{"identity only", []string{"application/zstd", "identity"}, []string{"zstd", "identity"}, "cat", "identity"},
+		{"identity by mimetype", []string{"text/plain"}, nil, "cat", "text/plain"},
+	}
+	for _, tc := range cases {
+		h, token, err := NegotiateHandler(tc.remote, tc.local)
+		if !assert.Nil(t, err, tc.name) {
+			continue
+		}
+		assert.Equal(t, tc.command, h.(Filter).Command, tc.name)
+		assert.Equal(t, tc.token, token, tc.name)
+		assert.Equal(t, SelectedNegotiated, h.Provenance().Selection, tc.name)
+	}
+
+	failures := []struct {
+		name   string
+		remote []string
+		local  []string
+	}{
+		{"disjoint", []string{"application/x-bzip2"}, []string{"gzip", "xz"}},
+		{"only uninstalled", []string{"application/zstd"}, []string{"zstd"}},
+		{"identity not preferred", []string{"identity"}, []string{"gzip"}},
+		{"unknown", []string{"application/x-foo"}, nil},
+		{"wildcards not matched", []string{"text/html"}, nil},
+		{"nothing", nil, nil},
+	}
+	for _, tc := range failures {
+		h, token, err := NegotiateHandler(tc.remote, tc.local)
+		assert.Nil(t, h, tc.name)
+		assert.Equal(t, "", token, tc.name)
+		assert.True(t, errors.Is(err, ErrNoCommonFormat), tc.name)
+	}
+
+	// Reasons say why the peer's formats weren't used
+	_, _, err := NegotiateHandler([]string{"application/zstd", "application/x-foo"}, nil)
+	var negErr NoCommonFormatError
+	assert.True(t, errors.As(err, &negErr))
+	assert.Equal(t, []string{"application/x-foo is not known here", "zstd: zstd is not installed"}, negErr.Reasons)
+}
+
+func TestNegotiateHandlerAlias(t *testing.T) {
+	h, _, err := NegotiateHandler([]string{"application/x-gzip"}, nil)
+	assert.Nil(t, err)
+	prov := h.Provenance()
+	assert.Equal(t, "gzip", prov.Handler)
+	assert.Equal(t, "application/x-gzip", prov.MimeType)
+	assert.True(t, prov.Alias)
+	assert.Equal(t, "application/gzip", h.MimeType())
+}
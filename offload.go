@@ -0,0 +1,76 @@
+package extcompress
+
+import (
+	"io"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// How a file-to-file operation moved its data.
+type Offload int
+
+const (
+	// Through userspace, as for any handler which runs a tool
+	OffloadNone Offload = iota
+	// By the kernel with copy_file_range, without passing through userspace
+	OffloadCopyRange
+	// By sharing the source's blocks (FICLONE), without copying them at all
+	OffloadReflink
+)
+
+func (o Offload) String() string {
+	switch o {
+	case OffloadCopyRange:
+		return "copy_file_range"
+	case OffloadReflink:
+		return "reflink"
+	default:
+		return "none"
+	}
+}
+
+// Describes a completed file-to-file operation (CompressToFile,
+// DecompressToFile).
+type Transfer struct {
+	// Bytes written to the destination
+	Bytes int64
+	// How they got there
+	Offload Offload
+}
+
+// True if file-to-file operations can leave copying to the kernel: the
+// handler passes data through unchanged, and nothing needs to see the data
+// on its way.
+func (c Filter) canOffload() bool {
+	return c.Passthrough && !enabled(c.opts.Sparse) && c.opts.RatioGuard == nil
+}
+
+// Copies filePath to dst, a reflink if the filesystem supports them, with
+// copy_file_range if not, or through userspace failing both.
+func (c Filter) offloadCopy(filePath string, dst *os.File) (Transfer, error) {
+	src, st, err := c.openSource(filePath)
+	if err != nil {
+		return Transfer{}, err
+	}
+	defer src.Close()
+	jlog := log.WithFields(c.opts.LogFields).WithField("filepath", printable(filePath))
+
+	if err := reflink(dst, src); err == nil {
+		jlog.Debug("Passthrough copy reflinked")
+		return Transfer{st.Size(), OffloadReflink}, nil
+	}
+	n, err := copyRange(dst, src)
+	if err == nil {
+		jlog.Debug("Passthrough copy offloaded with copy_file_range")
+		return Transfer{n, OffloadCopyRange}, nil
+	}
+	if n > 0 {
+		// Failed partway, rather than being unsupported
+		return Transfer{}, err
+	}
+
+	// Hide ReadFrom, which would try copy_file_range again
+	n, err = copyPooled(struct{ io.Writer }{dst}, src)
+	return Transfer{n, OffloadNone}, err
+}
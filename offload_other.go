@@ -0,0 +1,17 @@
+//go:build !linux
+
+package extcompress
+
+import (
+	"os"
+)
+
+// Copy offload is only implemented for Linux, so elsewhere passthrough
+// copies go through userspace.
+func reflink(dst *os.File, src *os.File) error {
+	return ErrNotSupported
+}
+
+func copyRange(dst *os.File, src *os.File) (int64, error) {
+	return 0, ErrNotSupported
+}
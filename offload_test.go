@@ -0,0 +1,90 @@
+package extcompress
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPassthroughToFileOffload(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	original := seekableTestData(3 * mib)
+	src := path.Join(tmpdir, "plain")
+	assert.Nil(t, ioutil.WriteFile(src, original, os.FileMode(0640)))
+
+	var transfers []Transfer
+	h := Identity().WithOptions(Options{OnTransfer: func(tr Transfer) { transfers = append(transfers, tr) }})
+
+	dst := path.Join(tmpdir, "compressed")
+	assert.Nil(t, h.CompressToFile(src, dst))
+	assert.Nil(t, h.DecompressToFile(dst, path.Join(tmpdir, "decompressed")))
+	for _, name := range []string{"compressed", "decompressed"} {
+		out, err := ioutil.ReadFile(path.Join(tmpdir, name))
+		assert.Nil(t, err)
+		assert.True(t, bytes.Equal(original, out), name)
+		st, err := os.Stat(path.Join(tmpdir, name))
+		assert.Nil(t, err)
+		assert.Equal(t, os.FileMode(0640), st.Mode().Perm())
+	}
+
+	assert.Len(t, transfers, 2)
+	for _, tr := range transfers {
+		assert.Equal(t, int64(len(original)), tr.Bytes)
+		// Reflinks depend on the filesystem, but copy_file_range works
+		// anywhere on Linux a file is copied within one filesystem
+		assert.NotEqual(t, OffloadNone, tr.Offload)
+		t.Logf("passthrough copy used %s", tr.Offload)
+	}
+}
+
+func TestPassthroughToFileFallback(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	src := path.Join(tmpdir, "pipechaining")
+	var transfer Transfer
+	h := Identity().WithOptions(Options{OnTransfer: func(tr Transfer) { transfer = tr }})
+
+	// Neither offload works into a pipe
+	r, w, err := os.Pipe()
+	assert.Nil(t, err)
+	defer r.Close()
+	done := make(chan []byte)
+	go func() {
+		out, _ := ioutil.ReadAll(r)
+		done <- out
+	}()
+	assert.Nil(t, h.CompressToFile(src, fmt.Sprintf("/dev/fd/%d", w.Fd())))
+	w.Close()
+	assert.Equal(t, data, string(<-done))
+	assert.Equal(t, Transfer{int64(len(data)), OffloadNone}, transfer)
+}
+
+func TestCompressToFile(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	var transfer Transfer
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	h = h.WithOptions(Options{OnTransfer: func(tr Transfer) { transfer = tr }})
+
+	src := path.Join(tmpdir, "pipechaining")
+	dst := path.Join(tmpdir, "out.gz")
+	assert.Nil(t, h.CompressToFile(src, dst))
+	assert.Equal(t, OffloadNone, transfer.Offload)
+
+	out, err := h.DecompressToBytes(dst, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, data, string(out))
+	st, err := os.Stat(dst)
+	assert.Nil(t, err)
+	assert.Equal(t, st.Size(), transfer.Bytes)
+}
@@ -0,0 +1,373 @@
+package extcompress
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// One of the operations a handler performs.
+type Operation int
+
+const (
+	OpCompress Operation = iota
+	OpDecompress
+	OpCompressStream
+	OpDecompressStream
+	OpCompressInPlace
+	OpDecompressInPlace
+)
+
+// Every operation, in order.
+var Operations = []Operation{
+	OpCompress, OpDecompress,
+	OpCompressStream, OpDecompressStream,
+	OpCompressInPlace, OpDecompressInPlace,
+}
+
+func (op Operation) String() string {
+	switch op {
+	case OpCompress:
+		return "Compress"
+	case OpDecompress:
+		return "Decompress"
+	case OpCompressStream:
+		return "CompressStream"
+	case OpDecompressStream:
+		return "DecompressStream"
+	case OpCompressInPlace:
+		return "CompressFileInPlace"
+	case OpDecompressInPlace:
+		return "DecompressFileInPlace"
+	}
+	return fmt.Sprintf("Operation(%d)", int(op))
+}
+
+// True for the operations which compress.
+func (op Operation) Compresses() bool {
+	return op == OpCompress || op == OpCompressStream || op == OpCompressInPlace
+}
+
+// True for the operations which read a stream rather than a file.
+func (op Operation) Streams() bool {
+	return op == OpCompressStream || op == OpDecompressStream
+}
+
+// Returns the streaming operation doing the same as op.
+func (op Operation) stream() Operation {
+	if op.Compresses() {
+		return OpCompressStream
+	}
+	return OpDecompressStream
+}
+
+// True for the operations which replace a file rather than producing a
+// stream.
+func (op Operation) InPlace() bool {
+	return op == OpCompressInPlace || op == OpDecompressInPlace
+}
+
+func (op Operation) valid() bool {
+	return op >= OpCompress && op <= OpDecompressInPlace
+}
+
+// Returned by Run for an unknown operation, or an input which doesn't suit
+// the operation.
+var ErrInvalidOperation = errors.New("extcompress: invalid operation")
+
+// What an operation works on: a file for the file and in-place operations,
+// a reader for the stream operations.
+type Input struct {
+	Path   string
+	Reader io.Reader
+}
+
+// Returns the input for a file operation on filePath.
+func FileInput(filePath string) Input {
+	return Input{Path: filePath}
+}
+
+// Returns the input for a stream operation reading rd.
+func StreamInput(rd io.Reader) Input {
+	return Input{Reader: rd}
+}
+
+// Returns the flags the filter runs op with.
+func (c Filter) flags(op Operation) []string {
+	switch op {
+	case OpCompress:
+		return c.CompressFlags
+	case OpDecompress:
+		return c.DecompressFlags
+	case OpCompressStream:
+		return withFlags(c.CompressStreamFlags, c.adaptArgs()...)
+	case OpDecompressStream:
+		return c.DecompressStreamFlags
+	case OpCompressInPlace:
+		return c.CompressInPlaceFlags
+	case OpDecompressInPlace:
+		return c.DecompressInPlaceFlags
+	}
+	return nil
+}
+
+// Performs op on in, with opts applied over the handler's options if they
+// are given. The other operation methods are shorthands for it. In-place
+// operations return a nil process once they have finished.
+func (c Filter) Run(op Operation, in Input, opts *Options) (CompressionProcess, error) {
+	if !op.valid() || op.Streams() != (in.Reader != nil) || (in.Path == "") == (in.Reader == nil) {
+		return nil, ErrInvalidOperation
+	}
+	if opts != nil {
+		c.opts = c.opts.Merge(*opts)
+	}
+
+	if in.Path != "" && isStdioPath(in.Path) {
+		if op.InPlace() {
+			return nil, StdioPathError{in.Path}
+		}
+		// The same as the stream operation on what the path stands for
+		rd, err := openStdio(in.Path)
+		if err != nil {
+			return nil, err
+		}
+		op, in = op.stream(), StreamInput(rd)
+	}
+
+	proc, err := c.run(op, in)
+	if job, ok := proc.(*CompressionJob); ok && !op.Streams() {
+		job.reopen = func() (CompressionProcess, error) {
+			return c.Run(op, in, nil)
+		}
+	}
+	return proc, err
+}
+
+// Performs op on in, once Run has checked and resolved them.
+func (c Filter) run(op Operation, in Input) (CompressionProcess, error) {
+	switch {
+	case op.InPlace():
+		if err := c.checkSourceWritable(in.Path); err != nil {
+			return nil, err
+		}
+		return nil, c.runInPlace(op, in.Path)
+	case op == OpCompress && c.opts.FileChange.checks():
+		return c.compressChangingFile(in.Path)
+	case !op.Streams() && enabled(c.opts.Hardened):
+		return c.hardenedFileJob(in.Path, op.Compresses())
+	case !op.Streams() && (c.sourceReadOnly() || c.pathTooLong(op, in.Path)):
+		// The tool gets the file on stdin, never its path
+		return c.readOnlyFileJob(in.Path, op.Compresses())
+	}
+	if dec := c.fallbackDecoder(op); dec != nil {
+		return c.startFallbackJob(op, in, dec)
+	}
+	job, err := c.startJob(op, in)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Spawns the tool for a file or stream operation.
+func (c Filter) startJob(op Operation, in Input) (*CompressionJob, error) {
+	id := c.newJobID()
+	fields := log.Fields{"compressCmd": printable(c.Command)}
+	var paths []string
+	if !op.Streams() {
+		fields["filepath"] = printable(in.Path)
+		paths = []string{argPath(in.Path)}
+	}
+	jlog := c.jobLog(id).WithFields(fields)
+	if op.Compresses() {
+		jlog.Info("External Compression Command")
+	} else {
+		jlog.Info("External Decompression Command")
+	}
+	if err := c.checkRecompression(jlog, op, in.Reader); err != nil {
+		return nil, err
+	}
+
+	flags := c.flags(op)
+	argPaths := paths
+	if hasPlaceholder(flags, InputPlaceholder) {
+		argPaths = nil
+	}
+	args, err := c.buildArgs(op.Compresses(), flags, argPaths...)
+	if err != nil {
+		return nil, err
+	}
+	if !op.Streams() {
+		args, _ = substitutePlaceholder(args, InputPlaceholder, argPath(in.Path))
+	}
+
+	var bridge *fifoBridge
+	if c.PreferFIFO {
+		bridge, args, err = c.newFIFOBridge(op.Streams(), args)
+		if err == ErrNotSupported {
+			bridge, err = nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	var spill *spillOutput
+	if c.RequiresTempOutput && (bridge == nil || bridge.out == nil) {
+		if spill, err = c.newSpill(op.Compresses()); err != nil {
+			return nil, err
+		}
+		args = spill.substitute(args)
+	}
+	args, _ = substitutePlaceholder(args, InputPlaceholder, "/dev/stdin")
+	args, _ = substitutePlaceholder(args, OutputPlaceholder, "/dev/stdout")
+	if err := c.checkArgv(args); err != nil {
+		if bridge != nil {
+			os.RemoveAll(bridge.dir)
+		}
+		if spill != nil {
+			os.RemoveAll(spill.dir)
+		}
+		return nil, err
+	}
+
+	jlog.WithField("args", printableAll(c.redact(args))).Debug("External command arguments")
+	cmd := c.newCmd(args)
+
+	var cancelable *cancelableReader
+	var stdin io.Reader
+	if op.Streams() {
+		stdin, cancelable = cancelableStdin(in.Reader)
+		if bridge == nil || bridge.in == nil {
+			cmd.Stdin = stdin
+		}
+	}
+	warnings := c.newWarningLog()
+	c.warningCapture = warnings
+	var tail *stderrTail
+	if op.Compresses() {
+		cmd.Stderr = c.stderr(id, op.String())
+	} else {
+		tail = c.decompressorStderr(cmd, id, op.String())
+	}
+
+	var rdr io.ReadCloser = spill
+	if bridge != nil && bridge.out != nil {
+		rdr = bridge
+	} else if spill == nil {
+		if rdr, err = cmd.StdoutPipe(); err != nil {
+			jlog.Errorf("Failed to get stdout pipe.")
+			return nil, err
+		}
+	}
+
+	span, err := c.spawn(op.String(), id, cmd)
+	if err != nil {
+		jlog.WithField("error", err.Error()).Error("Compression command failed.")
+		if spill != nil {
+			os.RemoveAll(spill.dir)
+		}
+		if bridge != nil {
+			os.RemoveAll(bridge.dir)
+		}
+		return nil, err
+	}
+
+	job := c.newStreamJob(id, cmd, rdr, cancelable, in.Reader)
+	if spill != nil {
+		spill.job = job
+		job.spill = spill
+	}
+	if bridge != nil {
+		bridge.start(job, stdin)
+	}
+	job.span = span
+	job.stderrTail = tail
+	job.warnings = warnings
+	job.format = c.outputFormat(op, in.Reader)
+	if !op.Streams() {
+		job.setInput(in.Path)
+	}
+	// Watched only once the job is filled in, as the guard reads its input
+	if op.Compresses() {
+		c.guardRatio(job)
+		c.guardFormat(job)
+	}
+	return job, nil
+}
+
+// Runs an in-place operation on filePath.
+func (c Filter) runInPlace(op Operation, filePath string) error {
+	compress := op.Compresses()
+	id := c.newJobID()
+	jlog := c.jobLog(id).WithFields(log.Fields{"compressCmd": printable(c.Command), "filepath": printable(filePath)})
+	if c.Passthrough {
+		jlog.Debug("Passthrough handler, nothing to do")
+		return nil
+	}
+
+	outputName := c.DecompressedFileName
+	if compress {
+		outputName = c.CompressedFileName
+		if err := c.checkCompressedSuffix(filePath, c.inPlace); err != nil {
+			return err
+		}
+	}
+	if c.packageInPlace(compress) || c.pathTooLong(op, filePath) {
+		// The tool would delete the original however it or the output
+		// fared, so run through the package and only then replace it. The
+		// package also streams files whose path the tool can't be given.
+		outPath, err := outputName(filePath, c.inPlace)
+		if err != nil {
+			return err
+		}
+		return c.incompressibleFallback(filePath, c.replaceFile(filePath, outPath, compress))
+	}
+	if compress {
+		jlog.Info("External Compression Command")
+	} else {
+		jlog.Info("External Decompression Command")
+	}
+
+	// Tools only try to keep the owner, so make sure of it afterwards
+	owner, err := c.sourceOwner(filePath)
+	if err != nil {
+		return err
+	}
+	var outPath string
+	if owner != nil {
+		if outPath, err = outputName(filePath, c.inPlace); err != nil {
+			return err
+		}
+	}
+
+	args, err := c.buildArgs(compress, c.flags(op), argPath(filePath))
+	if err != nil {
+		return err
+	}
+
+	// Kept to tell a file the tool refused for its name from a failure
+	tail := c.stderrCapture
+	if tail == nil {
+		tail = &stderrTail{}
+		c.stderrCapture = tail
+		defer tail.complete()
+	}
+
+	jlog.WithField("args", printableAll(c.redact(args))).Debug("External command arguments")
+	cmd := c.newCmd(args)
+	c.warningCapture = c.newWarningLog()
+	cmd.Stderr = c.stderr(id, op.String())
+
+	err = c.runCmd(jlog, cmd, op.String(), id)
+	if compress && c.refusedSuffixed(tail.String(), filePath) {
+		return AlreadyCompressedNameError{filePath, ""}
+	}
+	if err != nil {
+		jlog.WithField("error", err.Error()).Warn("Compression command failed.")
+		return err
+	}
+
+	return owner.apply(outPath)
+}
@@ -0,0 +1,190 @@
+package extcompress
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOperations(t *testing.T) {
+	names := map[Operation]string{
+		OpCompress:          "Compress",
+		OpDecompress:        "Decompress",
+		OpCompressStream:    "CompressStream",
+		OpDecompressStream:  "DecompressStream",
+		OpCompressInPlace:   "CompressFileInPlace",
+		OpDecompressInPlace: "DecompressFileInPlace",
+	}
+	assert.Len(t, Operations, len(names))
+	compresses, streams, inPlace := 0, 0, 0
+	for _, op := range Operations {
+		assert.Equal(t, names[op], op.String())
+		if op.Compresses() {
+			compresses++
+		}
+		if op.Streams() {
+			streams++
+		}
+		if op.InPlace() {
+			inPlace++
+		}
+		assert.False(t, op.Streams() && op.InPlace(), op.String())
+	}
+	assert.Equal(t, 3, compresses)
+	assert.Equal(t, 2, streams)
+	assert.Equal(t, 2, inPlace)
+	assert.Equal(t, "Operation(99)", Operation(99).String())
+}
+
+// Runs op through its shorthand method, returning the output of a process
+// or the contents of the file an in-place operation produced.
+func runShorthand(t *testing.T, h ExternalHandler, op Operation, in Input) []byte {
+	var proc CompressionProcess
+	var err error
+	switch op {
+	case OpCompress:
+		proc, err = h.Compress(in.Path)
+	case OpDecompress:
+		proc, err = h.Decompress(in.Path)
+	case OpCompressStream:
+		proc, err = h.CompressStream(in.Reader)
+	case OpDecompressStream:
+		proc, err = h.DecompressStream(in.Reader)
+	case OpCompressInPlace:
+		return inPlaceResult(t, h, h.CompressFileInPlace(in.Path), in.Path+".gz")
+	case OpDecompressInPlace:
+		return inPlaceResult(t, h, h.DecompressFileInPlace(in.Path), in.Path[:len(in.Path)-3])
+	}
+	return readJob(t, proc, err, op.String())
+}
+
+func runOperation(t *testing.T, h ExternalHandler, op Operation, in Input, opts *Options) []byte {
+	proc, err := h.Run(op, in, opts)
+	switch op {
+	case OpCompressInPlace:
+		assert.Nil(t, proc)
+		return inPlaceResult(t, h, err, in.Path+".gz")
+	case OpDecompressInPlace:
+		assert.Nil(t, proc)
+		return inPlaceResult(t, h, err, in.Path[:len(in.Path)-3])
+	}
+	return readJob(t, proc, err, op.String())
+}
+
+func inPlaceResult(t *testing.T, h ExternalHandler, err error, outPath string) []byte {
+	assert.Nil(t, err)
+	out, err := ioutil.ReadFile(outPath)
+	assert.Nil(t, err)
+	return out
+}
+
+// Returns the input for op, in a fresh file where it is one.
+func operationInput(t *testing.T, dir string, op Operation, compressed []byte) Input {
+	content := []byte(data)
+	name := "input"
+	if !op.Compresses() {
+		content = compressed
+		name += ".gz"
+	}
+	if op.Streams() {
+		return StreamInput(bytes.NewReader(content))
+	}
+	sub, err := ioutil.TempDir(dir, op.String())
+	assert.Nil(t, err)
+	filePath := path.Join(sub, name)
+	assert.Nil(t, ioutil.WriteFile(filePath, content, os.FileMode(0644)))
+	return FileInput(filePath)
+}
+
+func TestRunParity(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	gz, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	compressed := compressBytes(t, gz, []byte(data))
+
+	// File based gzip output holds the file's name and time, so compressed
+	// results are compared once decompressed
+	decompressed := func(out []byte) string {
+		job, err := gz.DecompressStream(bytes.NewReader(out))
+		return string(readJob(t, job, err, "decompress"))
+	}
+	for _, op := range Operations {
+		shorthand := runShorthand(t, gz, op, operationInput(t, tmpdir, op, compressed))
+		run := runOperation(t, gz, op, operationInput(t, tmpdir, op, compressed), nil)
+		if op.Compresses() {
+			shorthand, run = []byte(decompressed(shorthand)), []byte(decompressed(run))
+		}
+		assert.Equal(t, data, string(shorthand), op.String())
+		assert.Equal(t, data, string(run), op.String())
+	}
+}
+
+func TestRunOptionsApplyToEveryOperation(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	// Complains on stderr for every operation. File paths arrive as $0.
+	const transform = `echo complaint-from-tool >&2; if [ "$0" = sh ]; then exec cat; else exec cat "$0"; fi`
+	h := NewFilter("sh",
+		CompressFlags("-c", transform),
+		DecompressFlags("-c", transform),
+		InPlaceFlags([]string{"-c", `echo complaint-from-tool >&2; mv "$0" "$0.gz"`},
+			[]string{"-c", `echo complaint-from-tool >&2; mv "$0" "${0%.gz}"`}),
+		Suffix(".gz", ""),
+	)
+
+	for _, op := range Operations {
+		var stderr bytes.Buffer
+		out := runOperation(t, h, op, operationInput(t, tmpdir, op, []byte(data)), &Options{Stderr: &stderr})
+		assert.Equal(t, data, string(out), op.String())
+		assert.Equal(t, "complaint-from-tool\n", stderr.String(), op.String())
+	}
+}
+
+func TestRunInvalid(t *testing.T) {
+	gz, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	for _, c := range []struct {
+		op Operation
+		in Input
+	}{
+		{Operation(99), FileInput("x")},
+		{OpCompress, StreamInput(bytes.NewReader(nil))},
+		{OpCompressStream, FileInput("x")},
+		{OpDecompressInPlace, Input{}},
+		{OpDecompress, Input{Path: "x", Reader: bytes.NewReader(nil)}},
+	} {
+		proc, err := gz.Run(c.op, c.in, nil)
+		assert.Nil(t, proc)
+		assert.Equal(t, ErrInvalidOperation, err, c.op.String())
+	}
+}
+
+func TestPlanArgv(t *testing.T) {
+	gz, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	plan := gz.WithLevel(9).Plan()
+
+	assert.Equal(t, []string{"gzip", "-d", "-c"}, plan.Argv(OpDecompressStream))
+	for _, op := range Operations {
+		argv := plan.Argv(op)
+		assert.Equal(t, "gzip", argv[0], op.String())
+		assert.Equal(t, op.Streams(), argv[len(argv)-1] != PlanFilePlaceholder, op.String())
+		assert.Equal(t, op.Compresses(), argv[len(argv)-1-btoi(!op.Streams())] == "-9", op.String())
+	}
+	assert.Nil(t, plan.Argv(Operation(99)))
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,557 @@
+package extcompress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Options which tune how a filter runs. Nil and unset fields leave the
+// defaults in place, and failing those the tool's own; set ones override
+// them, including to switch a default off.
+type Options struct {
+	// Compression level, applied to compression operations only
+	Level *int
+	// Number of worker threads, for tools which support them
+	Threads *int
+	// Lets stream compression adjust its level to how fast the output is
+	// consumed, within the bounds, for tools with an AdaptFlag. Other
+	// operations are unaffected. Can't be combined with Level: setting
+	// either in an override clears the other.
+	StreamingAdaptive *AdaptiveBounds
+	// Extra arguments added to every invocation, e.g. "--long=27"
+	Args []string
+	// Directory the tool should use for temporary files. Empty inherits
+	// TMPDIR from this process.
+	TempDir string
+	// Kills streaming jobs using too much CPU for their output. Nil (the
+	// default) disables the guard.
+	CPUGuard *CPUGuard
+	// Stops compression which is making its input larger. Nil (the
+	// default) disables the guard.
+	RatioGuard *RatioGuard
+	// Makes Result on a streaming job whose output hasn't been read to the
+	// end discard the rest of it, rather than failing if the job is stuck.
+	DrainUnread *bool
+	// How long Result waits on a job whose output was being read, but no
+	// longer is, once its pipe has filled, before failing with
+	// ErrOutputNotConsumed. Unset waits for as long as it takes: only jobs
+	// whose output was never read fail straight away.
+	UndrainedStall time.Duration
+	// Makes DrainDecompress count the bytes decompressed exactly, passing
+	// them through the package, instead of relying on the tool's verbose
+	// output
+	DrainCount *bool
+	// Receives the tool's stderr, e.g. for visible progress from verbose
+	// flags. Nil logs it at debug level.
+	Stderr io.Writer
+	// Receives progress parsed from the tool's stderr. Setting it adds the
+	// filter's VerboseFlags to every invocation.
+	Progress ProgressFunc
+	// How file based compression treats inputs which change while being
+	// compressed
+	FileChange FileChangePolicy
+	// Makes DecompressToFile leave holes for blocks of zeros when writing to
+	// a regular file. The block size defaults to 4096.
+	Sparse          *bool
+	SparseBlockSize int
+	// Receives how each CompressToFile and DecompressToFile went, including
+	// whether the copy was offloaded to the kernel
+	OnTransfer func(Transfer)
+	// How much effort is made to make output files survive a crash before
+	// any original is removed. Tool driven in-place operations are done by
+	// the package instead when set.
+	Durability Durability
+	// Mode of files the package creates, set regardless of the umask before
+	// they appear under their final name. Unset preserves the source file's
+	// mode, or uses 0644 for files written from a stream.
+	Mode os.FileMode
+	// Mode of missing parent directories of output files, which are created
+	// if it is set
+	DirMode os.FileMode
+	// Whether outputs are given the owner of the file they were made from.
+	// Unset means only when running as root.
+	PreserveOwner *bool
+	// Guards file operations against symlink and ownership tricks: sources
+	// must be regular files, owned by this user or their directory's owner,
+	// opened without following symlinks, and outputs are never overwritten.
+	Hardened *bool
+	// ID given to the handler's jobs instead of a generated one, e.g. to
+	// match a request ID
+	JobID string
+	// Added to every log line about the handler's jobs, e.g. trace IDs
+	LogFields log.Fields
+	// Where the handler's spawns queue when the process limit is reached
+	Priority Priority
+	// Signal the tool is sent if this process dies, SIGTERM or SIGKILL, so
+	// it doesn't outlive a crash. Unset leaves it running, as the tools run
+	// in their own process group. Only the tool itself is signalled, not
+	// anything it starts. Linux only: elsewhere operations fail with
+	// ErrNotSupported. Strictly, the signal comes when the thread which
+	// started the tool exits, which Go only does for goroutines which exit
+	// locked to their thread, so don't start jobs from those.
+	ParentDeathSignal syscall.Signal
+	// Unit properties of the transient systemd scope each of the handler's
+	// jobs runs in, through systemd-run --scope. Nil runs jobs directly;
+	// an empty map runs them in scopes with systemd's defaults. Operations
+	// fail with a SystemdUnavailableError where systemd isn't running.
+	SystemdScope map[string]string
+	// What compressing a stream which is already compressed (see
+	// FormattedStream) does, and whether it is allowed without comment
+	Recompression      RecompressionPolicy
+	AllowRecompression *bool
+	// Auxiliary inputs such as dictionaries and keyfiles, given to the tool
+	// as descriptors 3 onwards and named in its arguments by the
+	// placeholders {fd3}, {fd4} and so on, which become /dev/fd/3 etc. This
+	// works where the tool can't see this process's filesystem. Files are
+	// passed as they are, so on Linux each tool opening /dev/fd/N reads a
+	// regular file from the start. Other readers are fed through a pipe, so
+	// are consumed by the first job spawned and only suit tools which don't
+	// insist on a regular file.
+	ExtraInputs []io.Reader
+	// What CompressionJob.Fanout does when one of its destinations fails
+	Fanout FanoutPolicy
+	// Receives the output of DecompressAndVerify, which discards it if
+	// this is nil
+	VerifiedOutput io.Writer
+	// Runs the tool with a fixed locale and timezone, none of the compressor
+	// knobs from the environment, and its HermeticFlags. See Hermetic.
+	Hermetic *bool
+	// Receives the running totals of batch in-place operations every
+	// SummaryInterval (default one second), and the final totals when the
+	// batch ends
+	OnSummary       SummaryFunc
+	SummaryInterval time.Duration
+	// Checks that compression output starts with the magic bytes of the
+	// handler's format, failing with ErrUnexpectedOutputFormat if not.
+	// Streams are checked as their output is read, files once written, and
+	// in-place compression is done by the package so the original survives
+	// a failed check. The identity handler, and formats without known magic
+	// bytes, aren't checked.
+	VerifyOutputFormat *bool
+	// Override the handler's Filter.GraceSIGINT and GraceSIGTERM
+	GraceSIGINT  time.Duration
+	GraceSIGTERM time.Duration
+	// Treats source files as read-only: in-place operations, which replace
+	// their source, fail with ErrSourceProtected before anything is run,
+	// and file operations read the source through the tool's stdin rather
+	// than giving it the path. See also SetSourceReadOnly.
+	SourceReadOnly *bool
+	// Makes warnings from the tool (see Filter.WarningMessages) fail
+	// operations with ErrWarnings, and keeps warning exit statuses as
+	// failures. Jobs still report their exit status from Result; Err gives
+	// the WarningsError.
+	TreatWarningsAsErrors *bool
+}
+
+// Returns a pointer to n, for filling in the optional fields of Options.
+func Int(n int) *int {
+	return &n
+}
+
+// True if the optional flag b is set and on.
+func enabled(b *bool) bool {
+	return b != nil && *b
+}
+
+// Returns o with every field set in override replacing its own. Args and
+// LogFields are accumulated rather than replaced. Pointers, slices and maps
+// are copied, so changing override afterwards doesn't affect the result.
+func (o Options) Merge(override Options) Options {
+	merged := o
+	// Level and StreamingAdaptive can't be combined, so setting one clears
+	// the other
+	if override.Level != nil {
+		merged.Level = Int(*override.Level)
+		if override.StreamingAdaptive == nil {
+			merged.StreamingAdaptive = nil
+		}
+	}
+	if override.Threads != nil {
+		merged.Threads = Int(*override.Threads)
+	}
+	if override.StreamingAdaptive != nil {
+		bounds := *override.StreamingAdaptive
+		merged.StreamingAdaptive = &bounds
+		if override.Level == nil {
+			merged.Level = nil
+		}
+	}
+	if len(override.Args) > 0 {
+		merged.Args = withFlags(o.Args, override.Args...)
+	}
+	if override.TempDir != "" {
+		merged.TempDir = override.TempDir
+	}
+	if override.CPUGuard != nil {
+		guard := *override.CPUGuard
+		merged.CPUGuard = &guard
+	}
+	if override.RatioGuard != nil {
+		guard := *override.RatioGuard
+		merged.RatioGuard = &guard
+	}
+	if override.DrainUnread != nil {
+		merged.DrainUnread = Bool(*override.DrainUnread)
+	}
+	if override.UndrainedStall != 0 {
+		merged.UndrainedStall = override.UndrainedStall
+	}
+	if override.DrainCount != nil {
+		merged.DrainCount = Bool(*override.DrainCount)
+	}
+	if override.Stderr != nil {
+		merged.Stderr = override.Stderr
+	}
+	if override.Progress != nil {
+		merged.Progress = override.Progress
+	}
+	if override.FileChange != FileChangeUnset {
+		merged.FileChange = override.FileChange
+	}
+	if override.Sparse != nil {
+		merged.Sparse = Bool(*override.Sparse)
+	}
+	if override.SparseBlockSize != 0 {
+		merged.SparseBlockSize = override.SparseBlockSize
+	}
+	if override.OnTransfer != nil {
+		merged.OnTransfer = override.OnTransfer
+	}
+	if override.Durability != DurabilityUnset {
+		merged.Durability = override.Durability
+	}
+	if override.Mode != 0 {
+		merged.Mode = override.Mode
+	}
+	if override.DirMode != 0 {
+		merged.DirMode = override.DirMode
+	}
+	if override.PreserveOwner != nil {
+		preserve := *override.PreserveOwner
+		merged.PreserveOwner = &preserve
+	}
+	if override.Hardened != nil {
+		merged.Hardened = Bool(*override.Hardened)
+	}
+	if override.JobID != "" {
+		merged.JobID = override.JobID
+	}
+	if override.Priority != PriorityUnset {
+		merged.Priority = override.Priority
+	}
+	if override.ParentDeathSignal != 0 {
+		merged.ParentDeathSignal = override.ParentDeathSignal
+	}
+	if override.SystemdScope != nil {
+		merged.SystemdScope = make(map[string]string, len(override.SystemdScope))
+		for k, v := range override.SystemdScope {
+			merged.SystemdScope[k] = v
+		}
+	}
+	if override.Recompression != RecompressionUnset {
+		merged.Recompression = override.Recompression
+	}
+	if override.AllowRecompression != nil {
+		merged.AllowRecompression = Bool(*override.AllowRecompression)
+	}
+	if len(override.ExtraInputs) > 0 {
+		merged.ExtraInputs = append([]io.Reader(nil), override.ExtraInputs...)
+	}
+	if override.Fanout != FanoutUnset {
+		merged.Fanout = override.Fanout
+	}
+	if override.VerifiedOutput != nil {
+		merged.VerifiedOutput = override.VerifiedOutput
+	}
+	if override.Hermetic != nil {
+		merged.Hermetic = Bool(*override.Hermetic)
+	}
+	if override.OnSummary != nil {
+		merged.OnSummary = override.OnSummary
+	}
+	if override.SummaryInterval != 0 {
+		merged.SummaryInterval = override.SummaryInterval
+	}
+	if override.VerifyOutputFormat != nil {
+		merged.VerifyOutputFormat = Bool(*override.VerifyOutputFormat)
+	}
+	if override.GraceSIGINT != 0 {
+		merged.GraceSIGINT = override.GraceSIGINT
+	}
+	if override.GraceSIGTERM != 0 {
+		merged.GraceSIGTERM = override.GraceSIGTERM
+	}
+	if override.SourceReadOnly != nil {
+		merged.SourceReadOnly = Bool(*override.SourceReadOnly)
+	}
+	if override.TreatWarningsAsErrors != nil {
+		merged.TreatWarningsAsErrors = Bool(*override.TreatWarningsAsErrors)
+	}
+	if len(override.LogFields) > 0 {
+		merged.LogFields = log.Fields{}
+		for k, v := range o.LogFields {
+			merged.LogFields[k] = v
+		}
+		for k, v := range override.LogFields {
+			merged.LogFields[k] = v
+		}
+	}
+	return merged
+}
+
+// Returned when an option can't be applied to a filter.
+type InvalidOption struct {
+	Command string
+	Option  string
+	Reason  string
+}
+
+func (r InvalidOption) Error() string {
+	return fmt.Sprintf("%s: invalid %s: %s", r.Command, r.Option, r.Reason)
+}
+
+// Checks the options are usable with the filter.
+func (c Filter) validateOptions(o Options) error {
+	if o.Level != nil {
+		if c.LevelFlag == "" {
+			return InvalidOption{c.Command, "level", "compression levels are not supported"}
+		}
+		if *o.Level < c.MinLevel || *o.Level > c.MaxLevel {
+			return InvalidOption{c.Command, "level",
+				fmt.Sprintf("%d is outside the range %d-%d", *o.Level, c.MinLevel, c.MaxLevel)}
+		}
+	}
+	if o.Threads != nil {
+		if c.ThreadsFlag == "" {
+			return InvalidOption{c.Command, "threads", "threading is not supported"}
+		}
+		if *o.Threads < 0 {
+			return InvalidOption{c.Command, "threads", "must not be negative"}
+		}
+	}
+	if err := c.validateAdaptive(o); err != nil {
+		return err
+	}
+	if err := c.validateParentDeathSignal(o); err != nil {
+		return err
+	}
+	if err := c.validateSystemdScope(o); err != nil {
+		return err
+	}
+	if err := c.checkConfigList("Args", o.Args); err != nil {
+		return err
+	}
+	if o.TempDir != "" {
+		if reason := execReason(o.TempDir); reason != "" {
+			return InvalidArgumentError{c.Command, "temp dir", o.TempDir, reason}
+		}
+		st, err := os.Stat(o.TempDir)
+		if err != nil {
+			return InvalidOption{c.Command, "temp dir", err.Error()}
+		}
+		if !st.IsDir() {
+			return InvalidOption{c.Command, "temp dir", o.TempDir + " is not a directory"}
+		}
+		if err := syscall.Access(o.TempDir, accessWrite); err != nil {
+			return InvalidOption{c.Command, "temp dir", o.TempDir + " is not writable"}
+		}
+	}
+	if o.SparseBlockSize < 0 {
+		return InvalidOption{c.Command, "sparse block size", "must not be negative"}
+	}
+	if o.CPUGuard != nil {
+		if o.CPUGuard.MaxCPUPerMiB <= 0 {
+			return InvalidOption{c.Command, "CPU guard", "MaxCPUPerMiB must be positive"}
+		}
+		if o.CPUGuard.Grace < 0 {
+			return InvalidOption{c.Command, "CPU guard", "Grace must not be negative"}
+		}
+	}
+	return nil
+}
+
+// W_OK for access(2)
+const accessWrite = 0x2
+
+// Builds the argument list for an invocation: the operation's flags, then the
+// effective options, then any file paths.
+func (c Filter) buildArgs(compress bool, flags []string, paths ...string) ([]string, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	if err := c.contextErr(); err != nil {
+		return nil, err
+	}
+	if err := c.validateOptions(c.opts); err != nil {
+		return nil, err
+	}
+
+	args := withFlags(flags)
+	if compress {
+		if c.opts.Level != nil {
+			args = append(args, fmt.Sprintf(c.LevelFlag, *c.opts.Level))
+		}
+		if c.opts.Threads != nil {
+			args = append(args, fmt.Sprintf(c.ThreadsFlag, *c.opts.Threads))
+		}
+		if enabled(c.opts.Hermetic) {
+			args = append(args, c.HermeticFlags...)
+		}
+	}
+	if c.opts.Progress != nil {
+		args = append(args, c.VerboseFlags...)
+	}
+	args = append(args, c.opts.Args...)
+	args, err := c.expandFDPlaceholders(args)
+	if err != nil {
+		return nil, err
+	}
+	return append(args, paths...), nil
+}
+
+func (c Filter) WithOptions(opts Options) ExternalHandler {
+	c.opts = c.opts.Merge(opts)
+	c.noteOptions("with", opts)
+	return c
+}
+
+func (c Filter) WithLevel(level int) ExternalHandler {
+	return c.WithOptions(Options{Level: Int(level)})
+}
+
+func (c Filter) WithThreads(threads int) ExternalHandler {
+	return c.WithOptions(Options{Threads: Int(threads)})
+}
+
+func (c Filter) WithTempDir(dir string) ExternalHandler {
+	return c.WithOptions(Options{TempDir: dir})
+}
+
+func (c Filter) Options() Options {
+	return Options{}.Merge(c.opts)
+}
+
+// Placeholder used for the file argument in a Plan.
+const PlanFilePlaceholder = "{file}"
+
+// Describes exactly what a handler will run, so configuration and policy can
+// be verified without spawning anything.
+type Plan struct {
+	MimeType string
+	Command  string
+	Options  Options
+	// Variables set in the child's environment
+	Env []string
+	// Error validating the options, if any. Operations will fail with it.
+	Err error
+	// Whether stream compression adapts its level to the consumer
+	Adaptive bool
+
+	// Full argv of each operation, with PlanFilePlaceholder standing in for
+	// the file path. Jobs run in a systemd scope have the systemd-run
+	// command line, with the scope's properties, before the tool's.
+	Compress          []string
+	Decompress        []string
+	CompressStream    []string
+	DecompressStream  []string
+	CompressInPlace   []string
+	DecompressInPlace []string
+}
+
+// Returns the argv of op.
+func (p Plan) Argv(op Operation) []string {
+	switch op {
+	case OpCompress:
+		return p.Compress
+	case OpDecompress:
+		return p.Decompress
+	case OpCompressStream:
+		return p.CompressStream
+	case OpDecompressStream:
+		return p.DecompressStream
+	case OpCompressInPlace:
+		return p.CompressInPlace
+	case OpDecompressInPlace:
+		return p.DecompressInPlace
+	}
+	return nil
+}
+
+func (c Filter) Plan() Plan {
+	p := Plan{
+		MimeType: c.mimeType,
+		Command:  c.Command,
+		Options:  c.Options(),
+		Env:      c.envOverrides(),
+		Err:      c.err,
+		Adaptive: c.adaptive(),
+	}
+	if p.Err == nil {
+		p.Err = c.validateOptions(c.opts)
+	}
+	argv := func(op Operation) []string {
+		var paths []string
+		if !op.Streams() {
+			paths = []string{PlanFilePlaceholder}
+		}
+		args, _ := c.buildArgs(op.Compresses(), c.flags(op), paths...)
+		argv := []string{c.Command}
+		if scope := c.scopeArgs(); scope != nil {
+			argv = append(append([]string{systemdRunCommand}, scope...), c.Command)
+		}
+		return append(argv, c.redact(args)...)
+	}
+	p.Compress = argv(OpCompress)
+	p.Decompress = argv(OpDecompress)
+	p.CompressStream = argv(OpCompressStream)
+	p.DecompressStream = argv(OpDecompressStream)
+	p.CompressInPlace = argv(OpCompressInPlace)
+	p.DecompressInPlace = argv(OpDecompressInPlace)
+	return p
+}
+
+var (
+	defaultOptionsMtx sync.RWMutex
+	// Default options by handler name
+	defaultOptions = map[string]Options{}
+)
+
+// Sets the options every handler for mimeType is created with. Defaults are
+// shared by all the mimetypes which map to the same handler, and per-call
+// options (WithLevel etc.) still override them.
+func SetDefaultOptions(mimeType string, opts Options) error {
+	registryMtx.RLock()
+	handlername, ok := mimeMap[mimeType]
+	filter := filtersMap[handlername]
+	registryMtx.RUnlock()
+	if !ok {
+		return UnknownFileType{mimeType}
+	}
+	if err := filter.validateOptions(opts); err != nil {
+		return err
+	}
+
+	defaultOptionsMtx.Lock()
+	defer defaultOptionsMtx.Unlock()
+	defaultOptions[handlername] = opts
+	return nil
+}
+
+// Removes any default options set for mimeType.
+func ClearDefaultOptions(mimeType string) {
+	defaultOptionsMtx.Lock()
+	defer defaultOptionsMtx.Unlock()
+	delete(defaultOptions, lookupHandlerName(mimeType))
+}
+
+func getDefaultOptions(handlername string) Options {
+	defaultOptionsMtx.RLock()
+	defer defaultOptionsMtx.RUnlock()
+	return defaultOptions[handlername]
+}
@@ -0,0 +1,111 @@
+package extcompress
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultOptions(t *testing.T) {
+	assert.Nil(t, SetDefaultOptions("application/x-xz", Options{Level: Int(6), Threads: Int(0)}))
+	defer ClearDefaultOptions("application/x-xz")
+
+	// Defaults apply to every alias of the handler
+	for _, mimeType := range []string{"application/x-xz", "xz"} {
+		h, err := GetExternalHandlerFromMimeType(mimeType)
+		assert.Nil(t, err)
+
+		plan := h.Plan()
+		assert.Nil(t, plan.Err)
+		assert.Equal(t, []string{"xz", "-c", "-6", "-T0"}, plan.CompressStream)
+		assert.Equal(t, []string{"xz", "-c", "-6", "-T0", PlanFilePlaceholder}, plan.Compress)
+		// Levels don't apply to decompression
+		assert.Equal(t, []string{"xz", "-d", "-c"}, plan.DecompressStream)
+		assert.Equal(t, "xz -c -6 -T0", h.CommandStreamCompress())
+	}
+
+	// Explicit options override the defaults
+	h, err := GetExternalHandlerFromMimeType("application/x-xz")
+	assert.Nil(t, err)
+	h = h.WithLevel(1)
+	assert.Equal(t, []string{"xz", "-c", "-1", "-T0"}, h.Plan().CompressStream)
+	assert.Equal(t, 1, *h.Options().Level)
+
+	// Other handlers are unaffected
+	gh, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"gzip", "-c"}, gh.Plan().CompressStream)
+
+	// The options are really applied
+	job, err := h.CompressStream(bytes.NewReader([]byte(data)))
+	assert.Nil(t, err)
+	compressed, err := ioutil.ReadAll(job)
+	assert.Nil(t, err)
+	assert.Zero(t, job.Result())
+	dr, err := h.DecompressStream(ioutil.NopCloser(bytes.NewReader(compressed)))
+	assert.Nil(t, err)
+	out, err := ioutil.ReadAll(dr)
+	assert.Nil(t, err)
+	assert.Equal(t, data, string(out))
+}
+
+func TestDefaultOptionsArgs(t *testing.T) {
+	assert.Nil(t, SetDefaultOptions("application/zstd", Options{Level: Int(15), Args: []string{"--long=27"}}))
+	defer ClearDefaultOptions("application/zstd")
+
+	h, err := GetExternalHandlerFromMimeType("application/zstd")
+	assert.Nil(t, err)
+	h = h.WithOptions(Options{Args: []string{"--no-check"}})
+
+	plan := h.Plan()
+	assert.Equal(t, []string{"zstd", "-q", "-c", "-15", "--long=27", "--no-check"}, plan.CompressStream)
+	assert.Equal(t, []string{"zstd", "-q", "-d", "-c", "--long=27", "--no-check"}, plan.DecompressStream)
+}
+
+func TestDefaultOptionsOverridden(t *testing.T) {
+	assert.Nil(t, SetDefaultOptions("application/zstd", Options{
+		Level:              Int(15),
+		Hardened:           Bool(true),
+		VerifyOutputFormat: Bool(true),
+		Durability:         DurabilityFull,
+		Fanout:             FanoutBestEffort,
+	}))
+	defer ClearDefaultOptions("application/zstd")
+
+	h, err := GetExternalHandlerFromMimeType("application/zstd")
+	assert.Nil(t, err)
+	opts := h.Options()
+	assert.True(t, enabled(opts.Hardened))
+	assert.Equal(t, DurabilityFull, opts.Durability)
+
+	// Per-call options can switch the defaults off
+	opts = h.WithOptions(Options{
+		Hardened:   Bool(false),
+		Durability: DurabilityNone,
+		Fanout:     FanoutFailFast,
+	}).Options()
+	assert.False(t, enabled(opts.Hardened))
+	assert.True(t, enabled(opts.VerifyOutputFormat))
+	assert.Equal(t, DurabilityNone, opts.Durability)
+	assert.Equal(t, FanoutFailFast, opts.Fanout)
+
+	// And a default level doesn't stop adaptive compression
+	plan := h.WithStreamingAdaptive(AdaptiveBounds{}).Plan()
+	assert.Nil(t, plan.Err)
+	assert.Equal(t, []string{"zstd", "-q", "-c", "--adapt"}, plan.CompressStream)
+}
+
+func TestInvalidOptions(t *testing.T) {
+	assert.IsType(t, InvalidOption{}, SetDefaultOptions("application/gzip", Options{Level: Int(12)}))
+	assert.IsType(t, InvalidOption{}, SetDefaultOptions("application/gzip", Options{Threads: Int(4)}))
+	assert.IsType(t, UnknownFileType{}, SetDefaultOptions("application/x-nothing", Options{}))
+
+	h, err := GetExternalHandlerFromMimeType("text/plain")
+	assert.Nil(t, err)
+	h = h.WithLevel(3)
+	assert.IsType(t, InvalidOption{}, h.Plan().Err)
+	_, err = h.CompressStream(bytes.NewReader(nil))
+	assert.IsType(t, InvalidOption{}, err)
+}
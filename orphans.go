@@ -0,0 +1,166 @@
+package extcompress
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// How long an orphan gets to exit after SIGTERM before it is killed.
+var orphanGrace = 2 * time.Second
+
+// What FindOrphans needs to know about a process.
+type procInfo struct {
+	pid   int
+	ppid  int
+	pgid  int
+	state byte
+	// Start time in clock ticks after boot
+	start uint64
+	uid   uint32
+	argv  []string
+}
+
+// True if argv is one the package would have run for a registered handler:
+// the handler's command followed by one of its operations' flags.
+func knownHandlerArgv(argv []string) bool {
+	if len(argv) == 0 {
+		return false
+	}
+	registryMtx.RLock()
+	defer registryMtx.RUnlock()
+
+	for _, f := range filtersMap {
+		if f.Passthrough || filepath.Base(argv[0]) != f.Command {
+			continue
+		}
+		for _, flags := range [][]string{
+			f.CompressFlags, f.DecompressFlags,
+			f.CompressStreamFlags, f.DecompressStreamFlags,
+			f.CompressInPlaceFlags, f.DecompressInPlaceFlags,
+		} {
+			if hasArgsPrefix(argv[1:], flags) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func hasArgsPrefix(args []string, prefix []string) bool {
+	if len(args) < len(prefix) {
+		return false
+	}
+	for i := range prefix {
+		if args[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// True if p looks like a compressor left behind by a previous run of this
+// program: reparented to init, leading its own process group as the package
+// starts them, owned by this user, started before the scan (at now, in
+// ticks after boot), and running a handler's command line which match
+// accepts.
+func (p procInfo) orphaned(now uint64, match func(cmdline string) bool) bool {
+	return p.pid != os.Getpid() &&
+		p.ppid == 1 &&
+		p.pgid == p.pid &&
+		p.uid == uint32(os.Geteuid()) &&
+		p.state != 'Z' &&
+		p.start > 0 && p.start <= now &&
+		knownHandlerArgv(p.argv) &&
+		match(strings.Join(p.argv, " "))
+}
+
+// Returns the PIDs of compressors orphaned by an earlier run of this program
+// (e.g. one which crashed), without touching them. A process is only
+// considered an orphan if everything about it fits: see ReapOrphans.
+func FindOrphans(match func(cmdline string) bool) ([]int, error) {
+	orphans, err := findOrphans(match)
+	if err != nil {
+		return nil, err
+	}
+	pids := make([]int, len(orphans))
+	for i, p := range orphans {
+		pids[i] = p.pid
+	}
+	return pids, nil
+}
+
+func findOrphans(match func(cmdline string) bool) ([]procInfo, error) {
+	now, err := uptimeTicks()
+	if err != nil {
+		return nil, err
+	}
+	procs, err := listProcesses()
+	if err != nil {
+		return nil, err
+	}
+	var orphans []procInfo
+	for _, p := range procs {
+		if p.orphaned(now, match) {
+			orphans = append(orphans, p)
+		}
+	}
+	return orphans, nil
+}
+
+// Terminates compressors orphaned by an earlier run of this program, for use
+// at startup after a crash, and returns their PIDs. Only processes which
+// have been reparented to init, lead their own process group, belong to
+// this user and run a registered handler's command with one of its
+// operation's flags are candidates, and match must also accept the full
+// command line. Each is sent SIGTERM, then SIGKILL if it is still running
+// after a grace period. Linux only.
+func ReapOrphans(match func(cmdline string) bool) ([]int, error) {
+	orphans, err := findOrphans(match)
+	if err != nil {
+		return nil, err
+	}
+	pids := make([]int, 0, len(orphans))
+	for _, p := range orphans {
+		log.WithField("pid", p.pid).WithField("cmdline", strings.Join(p.argv, " ")).Warn("Terminating orphaned compressor")
+		if err := p.escalate(); err != nil {
+			return pids, err
+		}
+		pids = append(pids, p.pid)
+	}
+	return pids, nil
+}
+
+// True if p is still the same live process, rather than gone or its PID
+// reused.
+func (p procInfo) alive() bool {
+	now, err := readProcess(p.pid)
+	return err == nil && now.start == p.start && now.state != 'Z'
+}
+
+// Sends SIGTERM, then SIGKILL if the process outlives the grace period.
+func (p procInfo) escalate() error {
+	for _, sig := range []syscall.Signal{syscall.SIGTERM, syscall.SIGKILL} {
+		if !p.alive() {
+			return nil
+		}
+		if err := syscall.Kill(p.pid, sig); err != nil {
+			if err == syscall.ESRCH {
+				return nil
+			}
+			return err
+		}
+		deadline := time.Now().Add(orphanGrace)
+		for time.Now().Before(deadline) {
+			if !p.alive() {
+				return nil
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	return nil
+}
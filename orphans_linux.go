@@ -0,0 +1,106 @@
+package extcompress
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Where processes are listed, replaced by fixtures in tests
+var procRoot = "/proc"
+
+var errMalformedStat = errors.New("malformed /proc stat")
+
+// Returns the time since boot in clock ticks.
+func uptimeTicks() (uint64, error) {
+	uptime, err := ioutil.ReadFile(filepath.Join(procRoot, "uptime"))
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(uptime))
+	if len(fields) == 0 {
+		return 0, errors.New("malformed /proc uptime")
+	}
+	seconds, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(seconds * clockTicks), nil
+}
+
+// Reads what FindOrphans needs to know about pid.
+func readProcess(pid int) (procInfo, error) {
+	dir := filepath.Join(procRoot, strconv.Itoa(pid))
+	p := procInfo{pid: pid}
+
+	st, err := os.Stat(dir)
+	if err != nil {
+		return p, err
+	}
+	sys, ok := st.Sys().(*syscall.Stat_t)
+	if !ok {
+		return p, ErrNotSupported
+	}
+	p.uid = sys.Uid
+
+	stat, err := ioutil.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return p, err
+	}
+	// Fields are counted from after the parenthesised command name, which
+	// may contain spaces: state, ppid and pgrp are fields 3-5, starttime 22.
+	end := bytes.LastIndexByte(stat, ')')
+	if end < 0 {
+		return p, errMalformedStat
+	}
+	fields := bytes.Fields(stat[end+1:])
+	if len(fields) < 20 || len(fields[0]) != 1 {
+		return p, errMalformedStat
+	}
+	p.state = fields[0][0]
+	if p.ppid, err = strconv.Atoi(string(fields[1])); err != nil {
+		return p, err
+	}
+	if p.pgid, err = strconv.Atoi(string(fields[2])); err != nil {
+		return p, err
+	}
+	if p.start, err = strconv.ParseUint(string(fields[19]), 10, 64); err != nil {
+		return p, err
+	}
+
+	cmdline, err := ioutil.ReadFile(filepath.Join(dir, "cmdline"))
+	if err != nil {
+		return p, err
+	}
+	if len(cmdline) > 0 {
+		p.argv = strings.Split(strings.TrimSuffix(string(cmdline), "\x00"), "\x00")
+	}
+	return p, nil
+}
+
+// Lists every process which can be read. Processes which exit or can't be
+// inspected during the scan are left out.
+func listProcesses() ([]procInfo, error) {
+	entries, err := ioutil.ReadDir(procRoot)
+	if err != nil {
+		return nil, err
+	}
+	var procs []procInfo
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		p, err := readProcess(pid)
+		if err != nil {
+			continue
+		}
+		procs = append(procs, p)
+	}
+	return procs, nil
+}
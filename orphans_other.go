@@ -0,0 +1,17 @@
+//go:build !linux
+
+package extcompress
+
+// Processes can only be listed on Linux, so elsewhere FindOrphans and
+// ReapOrphans fail with ErrNotSupported.
+func uptimeTicks() (uint64, error) {
+	return 0, ErrNotSupported
+}
+
+func readProcess(pid int) (procInfo, error) {
+	return procInfo{}, ErrNotSupported
+}
+
+func listProcesses() ([]procInfo, error) {
+	return nil, ErrNotSupported
+}
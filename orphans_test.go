@@ -0,0 +1,167 @@
+//go:build linux
+
+package extcompress
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Points procRoot at an empty fixture directory, 1000 seconds after boot.
+func fakeProc(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "extcompress_proc")
+	assert.Nil(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	assert.Nil(t, ioutil.WriteFile(path.Join(dir, "uptime"), []byte("1000.00 4000.00\n"), os.FileMode(0644)))
+
+	old := procRoot
+	procRoot = dir
+	t.Cleanup(func() { procRoot = old })
+	return dir
+}
+
+// Adds a process to a fixture made by fakeProc.
+func addProc(t *testing.T, root string, pid, ppid, pgid int, state byte, start uint64, argv ...string) string {
+	dir := path.Join(root, strconv.Itoa(pid))
+	assert.Nil(t, os.Mkdir(dir, os.FileMode(0755)))
+	// The command name is truncated and may hold spaces and parentheses
+	stat := fmt.Sprintf("%d (a (weird) name) %c %d %d %d 0 -1 4194304 100 0 0 0 5 2 0 0 20 0 1 0 %d 1000000 100\n",
+		pid, state, ppid, pgid, pgid, start)
+	assert.Nil(t, ioutil.WriteFile(path.Join(dir, "stat"), []byte(stat), os.FileMode(0644)))
+	cmdline := ""
+	for _, arg := range argv {
+		cmdline += arg + "\x00"
+	}
+	assert.Nil(t, ioutil.WriteFile(path.Join(dir, "cmdline"), []byte(cmdline), os.FileMode(0644)))
+	return dir
+}
+
+func matchAll(string) bool { return true }
+
+func TestReadProcess(t *testing.T) {
+	root := fakeProc(t)
+	addProc(t, root, 4242, 1, 4242, 'S', 1234, "/usr/bin/gzip", "-d", "-c", "with space")
+
+	p, err := readProcess(4242)
+	assert.Nil(t, err)
+	assert.Equal(t, procInfo{pid: 4242, ppid: 1, pgid: 4242, state: 'S', start: 1234,
+		uid: uint32(os.Geteuid()), argv: []string{"/usr/bin/gzip", "-d", "-c", "with space"}}, p)
+
+	now, err := uptimeTicks()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1000*clockTicks), now)
+
+	_, err = readProcess(4343)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestFindOrphansFixtures(t *testing.T) {
+	root := fakeProc(t)
+	unregisterFilter(t, "extcompress-test-orphan")
+	assert.Nil(t, RegisterFilter("extcompress-test-orphan", NewFilter("extcompress-test-orphan",
+		CompressFlags("--pack"), DecompressFlags("--unpack"), InPlaceFlags([]string{"--in-place"}, []string{"--in-place", "--unpack"})),
+		"application/x-extcompress-test-orphan"))
+
+	addProc(t, root, 100, 1, 100, 'S', 500, "gzip", "-d", "-c")
+	addProc(t, root, 101, 1, 101, 'R', 600, "/usr/bin/xz", "-c", "/var/spool/job.1")
+	addProc(t, root, 102, 1, 102, 'S', 500, "extcompress-test-orphan", "--unpack")
+	// Still has a parent
+	addProc(t, root, 200, 57, 200, 'S', 500, "gzip", "-c")
+	// Not leading its own process group
+	addProc(t, root, 201, 1, 57, 'S', 500, "gzip", "-c")
+	// Exited but not yet reaped
+	addProc(t, root, 202, 1, 202, 'Z', 500, "gzip", "-c")
+	// Not a compressor
+	addProc(t, root, 203, 1, 203, 'S', 500, "sleep", "100")
+	// A registered command, but not run with any of its flags
+	addProc(t, root, 204, 1, 204, 'S', 500, "extcompress-test-orphan", "--list")
+	// Started after the scan, so the start time is garbage or the PID reused
+	addProc(t, root, 205, 1, 205, 'S', 1000*clockTicks+1, "gzip", "-c")
+	addProc(t, root, 206, 1, 206, 'S', 0, "gzip", "-c")
+	// Kernel threads have no command line
+	addProc(t, root, 207, 1, 207, 'S', 500)
+	// This process
+	addProc(t, root, os.Getpid(), 1, os.Getpid(), 'S', 500, "gzip", "-c")
+	// Belongs to someone else
+	other := addProc(t, root, 208, 1, 208, 'S', 500, "gzip", "-c")
+	if os.Geteuid() == 0 {
+		assert.Nil(t, os.Chown(other, 4321, 4321))
+	} else {
+		assert.Nil(t, os.Remove(path.Join(other, "stat")))
+	}
+	// Entries which aren't processes, or have vanished, are ignored
+	assert.Nil(t, os.Mkdir(path.Join(root, "sys"), os.FileMode(0755)))
+	assert.Nil(t, os.Mkdir(path.Join(root, "209"), os.FileMode(0755)))
+
+	pids, err := FindOrphans(matchAll)
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []int{100, 101, 102}, pids)
+
+	pids, err = FindOrphans(func(cmdline string) bool {
+		return strings.HasPrefix(cmdline, "/usr/bin/xz ") && strings.Contains(cmdline, "/var/spool/")
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []int{101}, pids)
+
+	pids, err = FindOrphans(func(string) bool { return false })
+	assert.Nil(t, err)
+	assert.Empty(t, pids)
+}
+
+func TestFindOrphansNoProc(t *testing.T) {
+	root := fakeProc(t)
+	assert.Nil(t, os.Remove(path.Join(root, "uptime")))
+	_, err := FindOrphans(matchAll)
+	assert.NotNil(t, err)
+	_, err = ReapOrphans(matchAll)
+	assert.NotNil(t, err)
+}
+
+func TestReapOrphans(t *testing.T) {
+	old := orphanGrace
+	orphanGrace = 500 * time.Millisecond
+	defer func() { orphanGrace = old }()
+
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	// A gzip compressing an endless input, left behind in its own session by
+	// a shell which has exited. The marker file is never reached and only
+	// tells it apart from any others.
+	marker := path.Join(tmpdir, "orphan.marker")
+	ours := func(cmdline string) bool { return cmdline == "gzip -c -f /dev/zero "+marker }
+	assert.Nil(t, exec.Command("sh", "-c", "setsid gzip -c -f /dev/zero "+marker+" >/dev/null 2>&1 &").Run())
+	defer ReapOrphans(ours)
+
+	var pids []int
+	for start := time.Now(); len(pids) == 0 && time.Since(start) < 5*time.Second; {
+		var err error
+		pids, err = FindOrphans(ours)
+		assert.Nil(t, err)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(pids) == 0 {
+		t.Skip("orphans are not reparented to PID 1 here")
+	}
+	assert.Len(t, pids, 1)
+	orphan, err := readProcess(pids[0])
+	assert.Nil(t, err)
+
+	reaped, err := ReapOrphans(ours)
+	assert.Nil(t, err)
+	assert.Equal(t, pids, reaped)
+	assert.False(t, orphan.alive())
+
+	pids, err = FindOrphans(ours)
+	assert.Nil(t, err)
+	assert.Empty(t, pids)
+}
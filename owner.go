@@ -0,0 +1,68 @@
+package extcompress
+
+import (
+	"os"
+	"syscall"
+)
+
+// Returns a pointer to b, for filling in the optional fields of Options.
+func Bool(b bool) *bool {
+	return &b
+}
+
+// Returns true if outputs should be given their source's owner. Unless set
+// in the options, this is only done when running as root.
+func (c Filter) preserveOwner() bool {
+	if c.opts.PreserveOwner != nil {
+		return *c.opts.PreserveOwner
+	}
+	return os.Geteuid() == 0
+}
+
+// The owner of a source file, to be given to its outputs.
+type fileOwner struct {
+	uid int
+	gid int
+}
+
+// Returns the owner outputs made from st should get, or nil if ownership
+// isn't being preserved.
+func (c Filter) ownerFor(st os.FileInfo) *fileOwner {
+	if !c.preserveOwner() {
+		return nil
+	}
+	sys, ok := st.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	return &fileOwner{int(sys.Uid), int(sys.Gid)}
+}
+
+// Returns the owner outputs made from filePath should get, or nil if
+// ownership isn't being preserved.
+func (c Filter) sourceOwner(filePath string) (*fileOwner, error) {
+	if !c.preserveOwner() {
+		return nil, nil
+	}
+	st, err := os.Lstat(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return c.ownerFor(st), nil
+}
+
+// Gives f the owner, if there is one.
+func (o *fileOwner) applyFile(f *os.File) error {
+	if o == nil {
+		return nil
+	}
+	return f.Chown(o.uid, o.gid)
+}
+
+// Gives the file at path the owner, if there is one.
+func (o *fileOwner) apply(path string) error {
+	if o == nil {
+		return nil
+	}
+	return os.Lchown(path, o.uid, o.gid)
+}
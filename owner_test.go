@@ -0,0 +1,60 @@
+package extcompress
+
+import (
+	"os"
+	"path"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func fileOwnerOf(t *testing.T, filename string) fileOwner {
+	st, err := os.Lstat(filename)
+	assert.Nil(t, err)
+	sys := st.Sys().(*syscall.Stat_t)
+	return fileOwner{int(sys.Uid), int(sys.Gid)}
+}
+
+func TestPreserveOwner(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("ownership can only be changed as root")
+	}
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	service := fileOwner{1234, 5678}
+	filename := path.Join(tmpdir, "pipechaining")
+	assert.Nil(t, os.Chown(filename, service.uid, service.gid))
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	// Tool-driven in place, with a custom suffix
+	out, err := h.CompressFileInPlaceWithOptions(filename, InPlaceOptions{Suffix: ".gzz"})
+	assert.Nil(t, err)
+	assert.Equal(t, service, fileOwnerOf(t, out))
+
+	// File to file
+	dst := path.Join(tmpdir, "decompressed")
+	assert.Nil(t, h.DecompressToFile(out, dst))
+	assert.Equal(t, service, fileOwnerOf(t, dst))
+
+	// Package-side in place
+	out, err = h.WithOptions(Options{Durability: DurabilityDataOnly}).DecompressFileInPlaceWithOptions(out, InPlaceOptions{Suffix: ".gzz"})
+	assert.Nil(t, err)
+	assert.Equal(t, service, fileOwnerOf(t, out))
+
+	// Disabled, outputs belong to whoever made them
+	h = h.WithOptions(Options{PreserveOwner: Bool(false)})
+	out, err = h.WithOptions(Options{Durability: DurabilityDataOnly}).CompressFileInPlaceWithOptions(filename, InPlaceOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, fileOwner{0, 0}, fileOwnerOf(t, out))
+}
+
+func TestPreserveOwnerDefault(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	assert.Equal(t, os.Geteuid() == 0, h.(Filter).preserveOwner())
+	assert.True(t, h.WithOptions(Options{PreserveOwner: Bool(true)}).(Filter).preserveOwner())
+}
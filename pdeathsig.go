@@ -0,0 +1,20 @@
+package extcompress
+
+import (
+	"syscall"
+)
+
+// Checks the parent death signal, if one is set, can be used.
+func (c Filter) validateParentDeathSignal(o Options) error {
+	switch o.ParentDeathSignal {
+	case 0:
+		return nil
+	case syscall.SIGTERM, syscall.SIGKILL:
+	default:
+		return InvalidOption{c.Command, "parent death signal", "must be SIGTERM or SIGKILL"}
+	}
+	if !parentDeathSignalSupported {
+		return ErrNotSupported
+	}
+	return nil
+}
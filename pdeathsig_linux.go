@@ -0,0 +1,12 @@
+package extcompress
+
+import (
+	"syscall"
+)
+
+const parentDeathSignalSupported = true
+
+// Has the child sent sig when the thread which started it exits.
+func setParentDeathSignal(attr *syscall.SysProcAttr, sig syscall.Signal) {
+	attr.Pdeathsig = sig
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package extcompress
+
+import (
+	"syscall"
+)
+
+// Only Linux can signal children when their parent dies, so elsewhere
+// Options.ParentDeathSignal fails with ErrNotSupported.
+const parentDeathSignalSupported = false
+
+func setParentDeathSignal(attr *syscall.SysProcAttr, sig syscall.Signal) {}
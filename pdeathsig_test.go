@@ -0,0 +1,86 @@
+package extcompress
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const pdeathsigHelperEnv = "EXTCOMPRESS_TEST_PDEATHSIG"
+
+// Run as a separate process by TestParentDeathSignal: starts a job with the
+// signal named in the environment, prints its pid and waits to be killed.
+func TestParentDeathSignalHelper(t *testing.T) {
+	sig, err := strconv.Atoi(os.Getenv(pdeathsigHelperEnv))
+	if err != nil {
+		t.Skip("only run by TestParentDeathSignal")
+	}
+	// Runs on regardless of its pipes closing when this process dies
+	h := NewFilter("sleep", CompressFlags("60")).
+		WithOptions(Options{ParentDeathSignal: syscall.Signal(sig)})
+	proc, err := h.CompressStream(strings.NewReader(""))
+	assert.Nil(t, err)
+	fmt.Println(proc.(*CompressionJob).cmd.Process.Pid)
+	time.Sleep(time.Minute)
+}
+
+// Starts the helper, returning the pid of its job once it is running.
+func startPdeathsigHelper(t *testing.T, sig syscall.Signal) (*exec.Cmd, int) {
+	helper := exec.Command(os.Args[0], "-test.run=^TestParentDeathSignalHelper$")
+	helper.Env = append(os.Environ(), fmt.Sprintf("%s=%d", pdeathsigHelperEnv, int(sig)))
+	stdout, err := helper.StdoutPipe()
+	assert.Nil(t, err)
+	assert.Nil(t, helper.Start())
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	assert.Nil(t, err)
+	pid, err := strconv.Atoi(strings.TrimSpace(line))
+	assert.Nil(t, err)
+	return helper, pid
+}
+
+// True if pid is running, and not just waiting to be reaped.
+func processAlive(pid int) bool {
+	p, err := readProcess(pid)
+	return err == nil && p.state != 'Z' && p.state != 'X'
+}
+
+func TestParentDeathSignal(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	if h.WithOptions(Options{ParentDeathSignal: syscall.SIGKILL}).Plan().Err == ErrNotSupported {
+		t.Skip("parent death signals aren't supported here")
+	}
+
+	helper, pid := startPdeathsigHelper(t, syscall.SIGKILL)
+	assert.True(t, processAlive(pid))
+	assert.Nil(t, helper.Process.Kill())
+	helper.Wait()
+	deadline := time.Now().Add(5 * time.Second)
+	for processAlive(pid) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.False(t, processAlive(pid))
+
+	// Without it the job outlives its parent
+	helper, pid = startPdeathsigHelper(t, 0)
+	assert.Nil(t, helper.Process.Kill())
+	helper.Wait()
+	time.Sleep(100 * time.Millisecond)
+	assert.True(t, processAlive(pid))
+	syscall.Kill(pid, syscall.SIGKILL)
+}
+
+func TestParentDeathSignalInvalid(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	assert.IsType(t, InvalidOption{}, h.WithOptions(Options{ParentDeathSignal: syscall.SIGHUP}).Plan().Err)
+	assert.Equal(t, 0, int(h.Options().ParentDeathSignal))
+}
@@ -0,0 +1,35 @@
+package extcompress
+
+import (
+	"io"
+)
+
+// Decompresses only the start of filePath, returning its first n bytes, or
+// all of it if it is shorter, e.g. to sniff headers. The tool is stopped as
+// soon as n bytes have been read, so this takes about as long as producing
+// them whatever the size of the file, and its being stopped isn't a failure.
+// Fails as for Err if the tool fails before producing n bytes.
+func (c Filter) DecompressPrefix(filePath string, n int64) ([]byte, error) {
+	if n < 0 {
+		return nil, ErrInvalidOperation
+	}
+	proc, err := c.Decompress(filePath)
+	if err != nil {
+		return nil, err
+	}
+	size := n
+	if size > int64(maxPreallocate) {
+		size = int64(maxPreallocate)
+	}
+	out, err := readAppend(make([]byte, 0, size), io.LimitReader(proc, n))
+	if err != nil {
+		proc.Close()
+		return out, err
+	}
+	if int64(len(out)) == n {
+		// The rest of the output isn't wanted
+		proc.Close()
+		return out, nil
+	}
+	return out, processErr(proc)
+}
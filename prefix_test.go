@@ -0,0 +1,131 @@
+package extcompress
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecompressPrefix(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	original := seekableTestData(2 << 20)
+
+	for name, h := range installedHandlers(t) {
+		if h.Capabilities().Passthrough {
+			continue
+		}
+		filename := writeCompressed(t, tmpdir, h.(Filter).Command, original)
+
+		for _, n := range []int64{0, 4096, 1 << 20, 4 << 20} {
+			out, err := h.DecompressPrefix(filename, n)
+			assert.Nil(t, err, "%s %d", name, n)
+			want := original
+			if n < int64(len(want)) {
+				want = want[:n]
+			}
+			assert.Equal(t, want, out, "%s %d", name, n)
+		}
+	}
+}
+
+func TestDecompressPrefixErrors(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	original := seekableTestData(1 << 20)
+	filename := writeCompressed(t, tmpdir, "gzip", original)
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	_, err = h.DecompressPrefix(filename, -1)
+	assert.Equal(t, ErrInvalidOperation, err)
+
+	// Damage past the prefix doesn't matter, but short of it does
+	compressed, err := ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+	truncated := path.Join(tmpdir, "truncated.gz")
+	assert.Nil(t, ioutil.WriteFile(truncated, compressed[:len(compressed)/2], 0644))
+	out, err := h.DecompressPrefix(truncated, 4096)
+	assert.Nil(t, err)
+	assert.Equal(t, original[:4096], out)
+	out, err = h.DecompressPrefix(truncated, int64(len(original)))
+	assert.True(t, errors.Is(err, ErrCorruptInput), "%v", err)
+	assert.Equal(t, original[:len(out)], out)
+}
+
+func TestCloseStopsTool(t *testing.T) {
+	h := NewFilter("sh", DecompressFlags("-c", "cat >/dev/null; sleep 30"))
+	proc, err := h.DecompressStream(io.LimitReader(zeroReader{}, 1<<20))
+	assert.Nil(t, err)
+
+	started := time.Now()
+	assert.Nil(t, proc.Close())
+	assert.Zero(t, proc.Result())
+	assert.True(t, time.Since(started) < 10*time.Second, "Close waited for the tool to finish")
+}
+
+// Returns a gzip file of size bytes of test data, made without holding it in
+// memory.
+func writeLargeCompressed(b *testing.B, dir string, size int64) string {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(b, err)
+	chunk := seekableTestData(1 << 20)
+	r, w := io.Pipe()
+	go func() {
+		for written := int64(0); written < size; written += int64(len(chunk)) {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+		}
+		w.Close()
+	}()
+	proc, err := h.WithLevel(1).CompressStream(r)
+	assert.Nil(b, err)
+	filename := path.Join(dir, fmt.Sprintf("large-%d.gz", size))
+	f, err := os.Create(filename)
+	assert.Nil(b, err)
+	defer f.Close()
+	_, err = io.Copy(f, proc)
+	assert.Nil(b, err)
+	assert.Zero(b, proc.Result())
+	return filename
+}
+
+// Compares reading the first 4KB of a 1GB file with decompressing all of it.
+func BenchmarkDecompressPrefix(b *testing.B) {
+	tmpdir, err := ioutil.TempDir("", "extcompress_bench")
+	assert.Nil(b, err)
+	defer os.RemoveAll(tmpdir)
+	filename := writeLargeCompressed(b, tmpdir, 1<<30)
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(b, err)
+
+	b.Run("prefix", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := h.DecompressPrefix(filename, 4096); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("full", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			proc, err := h.Decompress(filename)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := io.Copy(ioutil.Discard, proc); err != nil {
+				b.Fatal(err)
+			}
+			if err := processErr(proc); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,139 @@
+package extcompress
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Kernel clock ticks per second, as used in /proc (USER_HZ)
+const clockTicks = 100
+
+// Returns the user and system CPU time used by pid and its reaped children.
+func processCPUTime(pid int) (time.Duration, error) {
+	stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+	// The command name is parenthesised and may contain spaces, so fields
+	// are counted from after it.
+	end := bytes.LastIndexByte(stat, ')')
+	if end < 0 {
+		return 0, errors.New("malformed /proc stat")
+	}
+	fields := bytes.Fields(stat[end+1:])
+	// utime, stime, cutime and cstime are fields 14-17 of the stat line
+	if len(fields) < 15 {
+		return 0, errors.New("malformed /proc stat")
+	}
+	var ticks int64
+	for _, f := range fields[11:15] {
+		n, err := strconv.ParseInt(string(f), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		ticks += n
+	}
+	return time.Duration(ticks) * time.Second / clockTicks, nil
+}
+
+const (
+	fionread    = 0x541B
+	fGetPipeSz  = 1032
+	pPid        = 1
+	wNoWait     = 0x1000000
+	siginfoSize = 128
+	siginfoPid  = 16 // Offset of si_pid
+)
+
+// Returns true if the pipe f reads from is full, i.e. its writer is blocked
+// waiting for a reader.
+func pipeFull(f *os.File) (bool, error) {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return false, err
+	}
+	var full bool
+	var serr error
+	err = conn.Control(func(fd uintptr) {
+		var avail int32
+		if _, _, e := syscall.Syscall(syscall.SYS_IOCTL, fd, fionread, uintptr(unsafe.Pointer(&avail))); e != 0 {
+			serr = e
+			return
+		}
+		size, _, e := syscall.Syscall(syscall.SYS_FCNTL, fd, fGetPipeSz, 0)
+		if e != 0 {
+			serr = e
+			return
+		}
+		// A partly read page isn't reused until it is emptied, so a pipe
+		// which has been read from blocks its writer short of its size
+		full = int(avail) > int(size)-os.Getpagesize()
+	})
+	if err != nil {
+		return false, err
+	}
+	return full, serr
+}
+
+// Returns true if pid has exited, without reaping it.
+func processExited(pid int) (bool, error) {
+	var info [siginfoSize]byte
+	_, _, e := syscall.Syscall6(syscall.SYS_WAITID, pPid, uintptr(pid), uintptr(unsafe.Pointer(&info[0])),
+		syscall.WEXITED|syscall.WNOHANG|wNoWait, 0, 0)
+	if e != 0 {
+		return false, e
+	}
+	return *(*int32)(unsafe.Pointer(&info[siginfoPid])) != 0, nil
+}
+
+// Blocks until pid has exited, without reaping it.
+func awaitExit(pid int) error {
+	var info [siginfoSize]byte
+	for {
+		_, _, e := syscall.Syscall6(syscall.SYS_WAITID, pPid, uintptr(pid), uintptr(unsafe.Pointer(&info[0])),
+			syscall.WEXITED|wNoWait, 0, 0)
+		if e != syscall.EINTR {
+			if e != 0 {
+				return e
+			}
+			return nil
+		}
+	}
+}
+
+// Returns the read offset of the file at path in pid's open files.
+func processFileOffset(pid int, path string) (int64, error) {
+	fdDir := fmt.Sprintf("/proc/%d/fd", pid)
+	fds, err := ioutil.ReadDir(fdDir)
+	if err != nil {
+		return 0, err
+	}
+	for _, fd := range fds {
+		if target, err := os.Readlink(filepath.Join(fdDir, fd.Name())); err != nil || target != path {
+			continue
+		}
+		info, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/fdinfo/%s", pid, fd.Name()))
+		if err != nil {
+			return 0, err
+		}
+		for _, line := range strings.Split(string(info), "\n") {
+			if strings.HasPrefix(line, "pos:") {
+				return strconv.ParseInt(strings.TrimSpace(line[len("pos:"):]), 10, 64)
+			}
+		}
+	}
+	return 0, os.ErrNotExist
+}
+
+func makeFIFO(path string) error {
+	return syscall.Mkfifo(path, 0600)
+}
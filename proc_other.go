@@ -0,0 +1,35 @@
+//go:build !linux
+
+package extcompress
+
+import (
+	"os"
+	"time"
+)
+
+// Process inspection is only implemented for Linux, so elsewhere the CPU
+// guard is inert, Result on an undrained job blocks and Filter.PreferFIFO is
+// ignored.
+func processCPUTime(pid int) (time.Duration, error) {
+	return 0, ErrNotSupported
+}
+
+func pipeFull(f *os.File) (bool, error) {
+	return false, ErrNotSupported
+}
+
+func processExited(pid int) (bool, error) {
+	return false, ErrNotSupported
+}
+
+func awaitExit(pid int) error {
+	return ErrNotSupported
+}
+
+func processFileOffset(pid int, path string) (int64, error) {
+	return 0, ErrNotSupported
+}
+
+func makeFIFO(path string) error {
+	return ErrNotSupported
+}
@@ -0,0 +1,175 @@
+package extcompress
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A progress report parsed from a tool's verbose output. Fields the tool
+// didn't report are -1.
+type Progress struct {
+	// Percentage of the input processed
+	Percent float64
+	// Bytes of compressed and uncompressed data processed so far
+	Compressed   int64
+	Uncompressed int64
+	// ID of the job reporting
+	JobID string
+}
+
+// Receives progress reports from a running operation.
+type ProgressFunc func(Progress)
+
+// Recognises one kind of progress line in a tool's stderr. Extract is given
+// the submatches of Pattern and returns false if they can't be used.
+type ProgressParser struct {
+	Pattern *regexp.Regexp
+	Extract func(match []string) (Progress, bool)
+}
+
+// Returns the progress in line from the first parser which understands it.
+func parseProgress(parsers []ProgressParser, line string) (Progress, bool) {
+	for _, p := range parsers {
+		if m := p.Pattern.FindStringSubmatch(line); m != nil {
+			if progress, ok := p.Extract(m); ok {
+				return progress, true
+			}
+		}
+	}
+	return Progress{}, false
+}
+
+var sizeUnits = map[string]int64{
+	"B":   1,
+	"KiB": 1 << 10, "MiB": 1 << 20, "GiB": 1 << 30, "TiB": 1 << 40,
+	"KB": 1e3, "MB": 1e6, "GB": 1e9, "TB": 1e12,
+	"bytes": 1,
+}
+
+// Matches numbers as the C locale writes them, with commas only between
+// thousands, so localized ones such as "19,8" are rejected rather than
+// misread.
+var cNumber = regexp.MustCompile(`^(\d+|\d{1,3}(,\d{3})+)(\.\d+)?$`)
+
+// Parses a human readable size such as "1,234.5 KiB" into bytes. Sizes
+// in other locales' formats are rejected.
+func parseSize(s string) (int64, bool) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return 0, false
+	}
+	unit, ok := sizeUnits[fields[1]]
+	if !ok {
+		return 0, false
+	}
+	if !cNumber.MatchString(fields[0]) {
+		return 0, false
+	}
+	n, err := strconv.ParseFloat(strings.Replace(fields[0], ",", "", -1), 64)
+	if err != nil {
+		return 0, false
+	}
+	return int64(n * float64(unit)), true
+}
+
+// Matches a size such as "1,234.5 KiB"
+const sizePattern = `([\d.,]+ (?:[KMGT]i?)?B|\d+ bytes)`
+
+// Builds an Extract function for patterns capturing a percentage (or -1 for
+// none, or 100 for a final summary line) and compressed and uncompressed
+// sizes at the given submatch indexes (0 for not reported).
+func extractProgress(percent int, compressed int, uncompressed int) func([]string) (Progress, bool) {
+	return func(m []string) (Progress, bool) {
+		p := Progress{Percent: -1, Compressed: -1, Uncompressed: -1}
+		var ok bool
+		switch {
+		case percent == 100:
+			p.Percent = 100
+		case percent > 0:
+			if p.Percent, ok = parseFloat(m[percent]); !ok {
+				return p, false
+			}
+		}
+		if compressed > 0 {
+			if p.Compressed, ok = parseSize(m[compressed]); !ok {
+				return p, false
+			}
+		}
+		if uncompressed > 0 {
+			if p.Uncompressed, ok = parseSize(m[uncompressed]); !ok {
+				return p, false
+			}
+		}
+		return p, true
+	}
+}
+
+func parseFloat(s string) (float64, bool) {
+	f, err := strconv.ParseFloat(s, 64)
+	return f, err == nil
+}
+
+var (
+	// "  12.4 %   1,234 KiB / 10.0 MiB = 0.123 ..." while running on a
+	// terminal, "file: 19.8 MiB / 25.8 MiB = 0.769, 0:01" when done.
+	xzProgressParsers = []ProgressParser{
+		{regexp.MustCompile(`^\s*([\d.]+) %\s+` + sizePattern + ` / ` + sizePattern), extractProgress(1, 2, 3)},
+		{regexp.MustCompile(`: ` + sizePattern + ` / ` + sizePattern + ` = `), extractProgress(100, 1, 2)},
+	}
+
+	// "(L3) Buffered: 128 KiB - Consumed: 2 MiB - Compressed: 1 MiB => 50.00%"
+	// and "file : 75.37% ( 25.8 MiB => 19.4 MiB, file.zst)" when compressing,
+	// "file.zst : 12 MiB...  " and "file.zst : 27017546 bytes" when
+	// decompressing.
+	zstdProgressParsers = []ProgressParser{
+		{regexp.MustCompile(`Consumed:\s*` + sizePattern + ` - Compressed:\s*` + sizePattern), extractProgress(-1, 2, 1)},
+		{regexp.MustCompile(`: [\d.]+%\s+\(\s*` + sizePattern + ` =>\s+` + sizePattern), extractProgress(100, 2, 1)},
+		{regexp.MustCompile(`: ` + sizePattern + `\.\.\.`), extractProgress(-1, 0, 1)},
+		{regexp.MustCompile(`: (\d+ bytes)\s*$`), extractProgress(100, 0, 1)},
+	}
+
+	// gzip only reports when a file is finished: "file:	 24.0% -- replaced with file.gz"
+	gzipProgressParsers = []ProgressParser{
+		{regexp.MustCompile(`:\s+-?[\d.]+% -- (?:replaced|created)`), extractProgress(100, 0, 0)},
+	}
+)
+
+// Longest partial line kept while waiting for its end
+const maxProgressLine = 4096
+
+// Passes stderr through to next while feeding complete lines to the
+// progress parsers. Lines may end in \r, as progress meters do.
+type progressWriter struct {
+	parsers []ProgressParser
+	fn      ProgressFunc
+	next    io.Writer
+	line    []byte
+	// Job the reports are from
+	id string
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.line = append(w.line, p...)
+	for {
+		i := bytes.IndexAny(w.line, "\r\n")
+		if i < 0 {
+			break
+		}
+		if progress, ok := parseProgress(w.parsers, string(w.line[:i])); ok {
+			progress.JobID = w.id
+			w.fn(progress)
+		}
+		w.line = w.line[i+1:]
+	}
+	if len(w.line) > maxProgressLine {
+		w.line = w.line[:0]
+	}
+	return w.next.Write(p)
+}
+
+func (c Filter) WithProgress(fn ProgressFunc) ExternalHandler {
+	return c.WithOptions(Options{Progress: fn})
+}
@@ -0,0 +1,81 @@
+package extcompress
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Feeds a canned stderr transcript through a progressWriter.
+func progressFromTranscript(parsers []ProgressParser, transcript string) []Progress {
+	var events []Progress
+	w := &progressWriter{
+		parsers: parsers,
+		fn:      func(p Progress) { events = append(events, p) },
+		next:    ioutil.Discard,
+	}
+	// Split the writes to check lines are reassembled
+	for i := 0; i < len(transcript); i += 7 {
+		end := i + 7
+		if end > len(transcript) {
+			end = len(transcript)
+		}
+		w.Write([]byte(transcript[i:end]))
+	}
+	return events
+}
+
+func TestProgressParsersXz(t *testing.T) {
+	transcript := "big.txt (1/1)\n" +
+		"  12.4 %     1,024 KiB / 8.0 MiB = 0.125   1.2 MiB/s       0:05   0:30\r" +
+		"  50.0 %   4.0 MiB / 16.0 MiB = 0.250   1.2 MiB/s       0:10   0:10\r" +
+		"big.txt: 19.8 MiB / 25.8 MiB = 0.769, 1.8 MiB/s, 0:14\n"
+	assert.Equal(t, []Progress{
+		{12.4, 1024 << 10, 8 << 20, ""},
+		{50, 4 << 20, 16 << 20, ""},
+		{100, 20761804, 27053260, ""},
+	}, progressFromTranscript(xzProgressParsers, transcript))
+}
+
+func TestProgressParsersZstd(t *testing.T) {
+	transcript := "*** Zstandard CLI (64-bit) v1.5.6, by Yann Collet ***\n" +
+		"\r(L3) Buffered:  128 KiB - Consumed:    2 MiB - Compressed:    1 MiB => 50.00% \r" +
+		"\rbig.txt              : 75.37%   (  25.8 MiB =>   19.4 MiB, big.zst)   \n" +
+		"\rbig.zst              : 12 MiB...     \r" +
+		"\rbig.zst             : 27017546 bytes \n"
+	assert.Equal(t, []Progress{
+		{-1, 1 << 20, 2 << 20, ""},
+		{100, 20342374, 27053260, ""},
+		{-1, -1, 12 << 20, ""},
+		{100, -1, 27017546, ""},
+	}, progressFromTranscript(zstdProgressParsers, transcript))
+}
+
+func TestProgressParsersGzip(t *testing.T) {
+	transcript := "big.txt:\t 24.0% -- replaced with big.txt.gz\n" +
+		"gzip: missing: No such file or directory\n"
+	assert.Equal(t, []Progress{{100, -1, -1, ""}},
+		progressFromTranscript(gzipProgressParsers, transcript))
+}
+
+func TestWithProgressInPlace(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	h, err := GetExternalHandlerFromMimeType("application/x-xz")
+	assert.Nil(t, err)
+
+	var events []Progress
+	var stderr bytes.Buffer
+	h = h.WithProgress(func(p Progress) { events = append(events, p) }).WithStderr(&stderr)
+	assert.Nil(t, h.CompressFileInPlace(path.Join(tmpdir, "pipechaining")))
+
+	// The final summary arrives, and stderr is still passed through
+	assert.NotEmpty(t, events)
+	assert.Equal(t, float64(100), events[len(events)-1].Percent)
+	assert.Contains(t, stderr.String(), "pipechaining")
+}
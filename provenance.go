@@ -0,0 +1,175 @@
+package extcompress
+
+import (
+	"reflect"
+	"strings"
+)
+
+// How a handler's format was identified from a file's contents.
+type Detector int
+
+const (
+	// The handler wasn't found from a file's contents
+	DetectedNone Detector = iota
+	// The package's own table of magic bytes
+	DetectedMagic
+	// libmagic
+	DetectedLibmagic
+)
+
+func (d Detector) String() string {
+	switch d {
+	case DetectedMagic:
+		return "magic"
+	case DetectedLibmagic:
+		return "libmagic"
+	default:
+		return "none"
+	}
+}
+
+// How a handler's command was chosen.
+type Selection int
+
+const (
+	// Built directly, by NewFilter or Identity
+	SelectedExplicit Selection = iota
+	// Registered for the mimetype looked up
+	SelectedRegistered
+	// Picked by BestCompressor, the first suitable handler of its ranking
+	SelectedRanked
+	// Agreed with a peer by NegotiateHandler
+	SelectedNegotiated
+)
+
+func (s Selection) String() string {
+	switch s {
+	case SelectedRegistered:
+		return "registered"
+	case SelectedRanked:
+		return "ranked"
+	case SelectedNegotiated:
+		return "negotiated"
+	default:
+		return "explicit"
+	}
+}
+
+// Records why a handler, and the jobs it spawns, run the way they do.
+type Provenance struct {
+	// The handler's registered name, or its command if it was built directly
+	Handler string
+	Command string
+	// The mimetype the handler was looked up by, and how it matched. Alias
+	// is set for exact matches on a mimetype other than the handler's
+	// canonical one, e.g. application/x-gzip.
+	MimeType string
+	Match    MatchKind
+	Alias    bool
+	Detector Detector
+	// How the command was chosen, and why candidates ranked above it were
+	// passed over
+	Selection Selection
+	Skipped   []string
+	// Where the handler's options came from, in the order they were merged,
+	// e.g. "env: Level" or "defaults: Threads"
+	Options []string
+}
+
+// Formats the provenance on one line, leaving out what doesn't apply.
+func (p Provenance) String() string {
+	fields := []string{"handler=" + p.Handler, "command=" + p.Command}
+	if p.MimeType != "" {
+		fields = append(fields, "mimetype="+p.MimeType, "match="+p.Match.String())
+	}
+	if p.Alias {
+		fields = append(fields, "alias")
+	}
+	if p.Detector != DetectedNone {
+		fields = append(fields, "detector="+p.Detector.String())
+	}
+	fields = append(fields, "selection="+p.Selection.String())
+	if len(p.Skipped) > 0 {
+		fields = append(fields, "skipped=["+strings.Join(p.Skipped, "; ")+"]")
+	}
+	if len(p.Options) > 0 {
+		fields = append(fields, "options=["+strings.Join(p.Options, "; ")+"]")
+	}
+	return strings.Join(fields, " ")
+}
+
+// Names the fields set in o, for recording where options came from.
+func (o Options) setFields() []string {
+	var names []string
+	v := reflect.ValueOf(o)
+	for i := 0; i < v.NumField(); i++ {
+		if !v.Field(i).IsZero() {
+			names = append(names, v.Type().Field(i).Name)
+		}
+	}
+	return names
+}
+
+// Returns a copy of p noting that the options set in o were merged from
+// source. Options which set nothing aren't recorded.
+func (p Provenance) withOptions(source string, o Options) Provenance {
+	names := o.setFields()
+	if len(names) == 0 {
+		return p
+	}
+	// Copied, since handlers derived from the same one share the slice
+	p.Options = append(p.Options[:len(p.Options):len(p.Options)], source+": "+strings.Join(names, ", "))
+	return p
+}
+
+// Records how the registered handler name was found for mimeType.
+func (c *Filter) stampLookup(name string, mimeType string, matchedBy MatchKind, selection Selection) {
+	c.prov = Provenance{
+		Handler:   name,
+		Command:   c.Command,
+		MimeType:  mimeType,
+		Match:     matchedBy,
+		Alias:     matchedBy == MatchExact && mimeType != canonicalMimeTypes[name],
+		Selection: selection,
+	}
+}
+
+// Sets the options of the registered handler name from the environment and
+// its defaults (see SetDefaultOptions), recording where they came from.
+func (c *Filter) applyRegisteredOptions(name string) {
+	env, defaults := c.envOptions(), getDefaultOptions(name)
+	c.opts = env.Merge(defaults)
+	c.optionSources = nil
+	c.noteOptions("env", env)
+	c.noteOptions("defaults", defaults)
+}
+
+// Records that the options set in o were merged from source, both in the
+// provenance and by field for optionSource.
+func (c *Filter) noteOptions(source string, o Options) {
+	names := o.setFields()
+	if len(names) == 0 {
+		return
+	}
+	c.prov = c.prov.withOptions(source, o)
+	// Copied, since handlers derived from the same one share the map
+	sources := make(map[string]string, len(c.optionSources)+len(names))
+	for field, from := range c.optionSources {
+		sources[field] = from
+	}
+	for _, field := range names {
+		sources[field] = source
+	}
+	c.optionSources = sources
+}
+
+// Returns how the handler came to be chosen, and where its options came
+// from.
+func (c Filter) Provenance() Provenance {
+	return c.prov
+}
+
+// Returns the provenance of the handler which spawned the job.
+func (this *CompressionJob) Provenance() Provenance {
+	return this.prov
+}
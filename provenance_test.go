@@ -0,0 +1,113 @@
+package extcompress
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProvenanceExplicit(t *testing.T) {
+	p := NewFilter("gzip").Provenance()
+	assert.Equal(t, "gzip", p.Handler)
+	assert.Equal(t, SelectedExplicit, p.Selection)
+	assert.Equal(t, MatchNone, p.Match)
+	assert.Equal(t, DetectedNone, p.Detector)
+	assert.Empty(t, p.MimeType)
+	assert.Equal(t, "handler=gzip command=gzip selection=explicit", p.String())
+
+	p = Identity().Provenance()
+	assert.Equal(t, "cat", p.Handler)
+	assert.Equal(t, SelectedExplicit, p.Selection)
+}
+
+func TestProvenanceMimeMatches(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	p := h.Provenance()
+	assert.Equal(t, "gzip", p.Handler)
+	assert.Equal(t, "application/gzip", p.MimeType)
+	assert.Equal(t, MatchExact, p.Match)
+	assert.False(t, p.Alias)
+	assert.Equal(t, SelectedRegistered, p.Selection)
+
+	h, err = GetExternalHandlerFromMimeType("application/x-gzip")
+	assert.Nil(t, err)
+	p = h.Provenance()
+	assert.True(t, p.Alias)
+	assert.Equal(t, "handler=gzip command=gzip mimetype=application/x-gzip match=exact alias selection=registered", p.String())
+
+	h, err = GetExternalHandlerFromMimeType("text/x-weird")
+	assert.Nil(t, err)
+	p = h.Provenance()
+	assert.Equal(t, MatchWildcard, p.Match)
+	assert.False(t, p.Alias)
+	assert.Equal(t, "text/x-weird", p.MimeType)
+}
+
+func TestProvenanceDetector(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	// lzop is in the package's own magic table, gzip is left to libmagic
+	lzo := path.Join(tmpdir, "data.lzo")
+	assert.Nil(t, ioutil.WriteFile(lzo, append(append([]byte{}, magics["lzop"]...), "rest"...), 0644))
+	h, err := GetFileTypeExternalHandler(lzo)
+	assert.Nil(t, err)
+	assert.Equal(t, DetectedMagic, h.Provenance().Detector)
+
+	gz := writeCompressed(t, tmpdir, "gzip", []byte(data))
+	h, err = GetFileTypeExternalHandler(gz)
+	assert.Nil(t, err)
+	assert.Equal(t, DetectedLibmagic, h.Provenance().Detector)
+	assert.Contains(t, h.Provenance().String(), "detector=libmagic")
+}
+
+func TestProvenanceRanked(t *testing.T) {
+	pathWith(t, "gzip")
+
+	h, err := BestCompressor(Criteria{Preference: PreferBalanced})
+	assert.Nil(t, err)
+	p := h.Provenance()
+	assert.Equal(t, "gzip", p.Handler)
+	assert.Equal(t, SelectedRanked, p.Selection)
+	assert.Equal(t, MatchNone, p.Match)
+	assert.Equal(t, []string{"zstd: zstd is not installed"}, p.Skipped)
+	assert.Contains(t, p.String(), "skipped=[zstd: zstd is not installed]")
+}
+
+func TestProvenanceOptions(t *testing.T) {
+	setEnvOptions(t, "3", "")
+	assert.Nil(t, SetDefaultOptions("application/x-xz", Options{Threads: Int(2)}))
+	defer ClearDefaultOptions("application/x-xz")
+
+	h, err := GetExternalHandlerFromMimeType("application/x-xz")
+	assert.Nil(t, err)
+	base := h.Provenance()
+	assert.Equal(t, []string{"env: Level", "defaults: Threads"}, base.Options)
+
+	h2 := h.WithLevel(9).WithOptions(Options{TempDir: os.TempDir(), Hardened: Bool(true)})
+	assert.Equal(t, []string{"env: Level", "defaults: Threads", "with: Level", "with: TempDir, Hardened"},
+		h2.Provenance().Options)
+	// Deriving a handler leaves the original's record alone
+	assert.Equal(t, base, h.Provenance())
+}
+
+func TestProvenanceOnJobAndSpan(t *testing.T) {
+	r := recordSpans(t)
+
+	h, err := GetExternalHandlerFromMimeType("application/x-gzip")
+	assert.Nil(t, err)
+	job, err := h.CompressStream(bytes.NewReader([]byte(data)))
+	assert.Nil(t, err)
+	_, err = ioutil.ReadAll(job)
+	assert.Nil(t, err)
+	assert.Zero(t, job.Result())
+
+	assert.Equal(t, h.Provenance(), job.(*CompressionJob).Provenance())
+	span := r.span(t, "extcompress."+OpCompressStream.String())
+	assert.Equal(t, h.Provenance().String(), span.attrs[AttrProvenance])
+}
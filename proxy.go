@@ -0,0 +1,54 @@
+package extcompress
+
+import (
+	"io"
+	"net"
+)
+
+// Implemented by connections which can shut down one direction, such as
+// *net.TCPConn and *net.UnixConn.
+type closeReader interface {
+	CloseRead() error
+}
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// Closes the input of a finished job. Connections which can half-close only
+// have their read side shut down, so the caller can still reply on them.
+func closeSource(src io.Closer) error {
+	if cr, ok := src.(closeReader); ok {
+		return cr.CloseRead()
+	}
+	return src.Close()
+}
+
+// Compresses everything read from src with h and writes it to dst, until
+// src reaches EOF (its peer closed or half-closed it). On success dst is
+// half-closed if it can be, or closed if it is a net.Conn, so its peer sees
+// the end of the output; src's read side is shut down. A reset or other
+// error reading src fails the job rather than passing on truncated output,
+// and is returned, as is anything which stops the output being written. On
+// failure dst is left open, for the caller to abort however suits it.
+func ProxyCompress(dst io.Writer, src net.Conn, h ExternalHandler) error {
+	job, err := h.CompressStream(src)
+	if err != nil {
+		return err
+	}
+	if _, err := copyPooled(dst, job); err != nil {
+		job.Close()
+		return err
+	}
+	if err := processErr(job); err != nil {
+		return err
+	}
+
+	if cw, ok := dst.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	if conn, ok := dst.(net.Conn); ok {
+		return conn.Close()
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package extcompress
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns both ends of a connected unix socket pair.
+func unixSocketPair(t *testing.T) (*net.UnixConn, *net.UnixConn) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	conn := func(fd int) *net.UnixConn {
+		f := os.NewFile(uintptr(fd), "socketpair")
+		defer f.Close()
+		c, err := net.FileConn(f)
+		assert.Nil(t, err)
+		t.Cleanup(func() { c.Close() })
+		return c.(*net.UnixConn)
+	}
+	return conn(fds[0]), conn(fds[1])
+}
+
+// Decompresses what arrives on conn until its peer closes.
+func readGzip(t *testing.T, conn io.Reader, out chan<- []byte) {
+	compressed, err := ioutil.ReadAll(conn)
+	assert.Nil(t, err)
+	gz, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	job, err := gz.DecompressStream(bytes.NewReader(compressed))
+	assert.Nil(t, err)
+	plain, err := ioutil.ReadAll(job)
+	assert.Nil(t, err)
+	assert.Zero(t, job.Result())
+	out <- plain
+}
+
+func TestProxyCompressUnixSockets(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	input := seekableTestData(1 << 20)
+
+	srcPeer, src := unixSocketPair(t)
+	dst, dstPeer := unixSocketPair(t)
+
+	go func() {
+		srcPeer.Write(input)
+		// The half-close is the child's EOF
+		srcPeer.CloseWrite()
+	}()
+	out := make(chan []byte, 1)
+	go readGzip(t, dstPeer, out)
+
+	assert.Nil(t, ProxyCompress(dst, src, h))
+	assert.Equal(t, input, <-out)
+
+	// Only the read side of the source was shut down, so it can still reply
+	_, err = src.Write([]byte("ok"))
+	assert.Nil(t, err)
+	reply := make([]byte, 2)
+	_, err = io.ReadFull(srcPeer, reply)
+	assert.Nil(t, err)
+	assert.Equal(t, "ok", string(reply))
+}
+
+func TestProxyCompressNetPipe(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	srcPeer, src := net.Pipe()
+	dst, dstPeer := net.Pipe()
+	defer srcPeer.Close()
+	defer dstPeer.Close()
+
+	go func() {
+		srcPeer.Write([]byte(data))
+		srcPeer.Close()
+	}()
+	out := make(chan []byte, 1)
+	go readGzip(t, dstPeer, out)
+
+	assert.Nil(t, ProxyCompress(dst, src, h))
+	assert.Equal(t, data, string(<-out))
+}
+
+// A connection whose peer resets it after some data.
+type resetConn struct {
+	net.Conn
+	sent bool
+}
+
+func (c *resetConn) Read(p []byte) (int, error) {
+	if !c.sent {
+		c.sent = true
+		return copy(p, data), nil
+	}
+	return 0, &net.OpError{Op: "read", Net: "unix", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+}
+
+func TestProxyCompressReset(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	srcPeer, src := net.Pipe()
+	defer srcPeer.Close()
+	dst, dstPeer := unixSocketPair(t)
+
+	err = ProxyCompress(dst, &resetConn{Conn: src}, h)
+	assert.True(t, errors.Is(err, syscall.ECONNRESET), "%v", err)
+
+	// The destination wasn't ended as if the output were complete
+	dstPeer.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err = ioutil.ReadAll(dstPeer)
+	assert.True(t, errors.Is(err, os.ErrDeadlineExceeded), "%v", err)
+}
@@ -0,0 +1,16 @@
+//go:build purexz
+
+package extcompress
+
+import (
+	"io"
+
+	"github.com/ulikunitz/xz"
+)
+
+// Lets xz data be decompressed, for verification, where xz isn't installed.
+func init() {
+	RegisterFallbackDecoder(FormatXz, func(r io.Reader) (io.Reader, error) {
+		return xz.NewReader(r)
+	})
+}
@@ -0,0 +1,40 @@
+//go:build purexz
+
+package extcompress
+
+import (
+	"crypto/sha256"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPureXzVerification(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	original := seekableTestData(300000)
+	filename := writeCompressed(t, tmpdir, "xz", original)
+
+	pathWith(t)
+	h, err := GetExternalHandlerFromMimeType("application/x-xz")
+	assert.Nil(t, err)
+
+	sum := sha256.Sum256(original)
+	assert.Nil(t, h.DecompressAndVerify(filename, Checksum{ChecksumSHA256, sum[:]}))
+	out, err := h.DecompressToBytes(filename, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, original, out)
+
+	compressed, err := ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+	corrupt := path.Join(tmpdir, "corrupt.xz")
+	assert.Nil(t, ioutil.WriteFile(corrupt, compressed[:len(compressed)/2], os.FileMode(0644)))
+	err = h.DecompressAndVerify(corrupt, Checksum{ChecksumSHA256, sum[:]})
+	assert.True(t, errors.Is(err, ErrCorruptInput), "got %v", err)
+
+	assert.NotNil(t, h.CompressFileInPlace(filename))
+}
@@ -0,0 +1,134 @@
+package extcompress
+
+import (
+	"errors"
+	"sync/atomic"
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Returned by compression which the ratio guard stopped for making its input
+// larger, e.g. because it was already compressed.
+var ErrIncompressible = errors.New("extcompress: output is larger than the input")
+
+// Stops compression of input which isn't getting any smaller. The byte
+// counts are sampled periodically while the job runs, and the output read so
+// far lags the input by what is buffered between the tool and the package,
+// so the input is discounted by that much: output within a few hundred KiB
+// of the limit counts as over it. In-place compression also checks the
+// finished output, and leaves the original untouched if the guard trips.
+type RatioGuard struct {
+	// Input the job must have consumed before the ratio is checked. Zero
+	// means 32MiB.
+	MinSample int64
+	// How much larger than its input the output may be, as a factor. Zero
+	// means 1, aborting as soon as the output is larger.
+	MaxRatio float64
+	// Makes in-place compression succeed without doing anything when the
+	// guard trips, as the identity handler would, so the caller still gets
+	// an output file. Streaming jobs still fail, since their input is gone.
+	FallbackToIdentity bool
+}
+
+const defaultRatioSample = 32 * mib
+
+// Output which may be in flight between the tool and the package, in its
+// pipes and its own buffers, when the counters are sampled.
+const ratioGuardSlack = 256 << 10
+
+func (g RatioGuard) minSample() int64 {
+	if g.MinSample <= 0 {
+		return defaultRatioSample
+	}
+	return g.MinSample
+}
+
+func (g RatioGuard) maxRatio() float64 {
+	if g.MaxRatio <= 0 {
+		return 1
+	}
+	return g.MaxRatio
+}
+
+// Returns true if out bytes of output is more than the guard allows for in
+// bytes of input, once in is beyond the minimum sample.
+func (g RatioGuard) exceeded(in int64, out int64, slack int64) bool {
+	if in < g.minSample() {
+		return false
+	}
+	return float64(out) > float64(in-slack)*g.maxRatio()
+}
+
+func (c Filter) WithRatioGuard(guard RatioGuard) ExternalHandler {
+	return c.WithOptions(Options{RatioGuard: &guard})
+}
+
+// Returns how much of its input the job has passed to the tool, or false if
+// that can't be known.
+func (this *CompressionJob) consumed() (int64, bool) {
+	if this.input != nil {
+		done, _, _, ok := this.Progress()
+		return done, ok
+	}
+	if this.stdin != nil {
+		return atomic.LoadInt64(&this.stdin.consumed), true
+	}
+	return 0, false
+}
+
+// Watches a compression job with the filter's ratio guard, if it has one.
+func (c Filter) guardRatio(job *CompressionJob) {
+	if c.opts.RatioGuard == nil {
+		return
+	}
+	guard := *c.opts.RatioGuard
+	job.ratioGuard = &guard
+	pid := job.cmd.Process.Pid
+	jlog := job.log.WithFields(log.Fields{"compressCmd": printable(c.Command), "pid": pid})
+
+	monitor.watch(func() bool {
+		if job.isReaped() {
+			return false
+		}
+		in, ok := job.consumed()
+		if !ok {
+			// Unsupported platform, or the process already exited
+			return false
+		}
+		produced := atomic.LoadInt64(&job.produced)
+		if !guard.exceeded(in, produced, ratioGuardSlack) {
+			return true
+		}
+		jlog.WithField("consumed", in).WithField("produced", produced).
+			Warn("Stopping job making its input larger")
+		job.abort(ErrIncompressible)
+		if err := syscall.Kill(-pid, syscall.SIGKILL); err != nil {
+			jlog.WithField("error", err.Error()).Debug("Error killing external process")
+		}
+		return false
+	})
+}
+
+// Checks the whole output of a finished job against its ratio guard, if it
+// has one.
+func (this *CompressionJob) checkRatio() error {
+	if this.ratioGuard == nil {
+		return nil
+	}
+	in := this.bytesIn()
+	if in >= 0 && this.ratioGuard.exceeded(in, atomic.LoadInt64(&this.produced), 0) {
+		return ErrIncompressible
+	}
+	return nil
+}
+
+// Turns ErrIncompressible from in-place compression into success if the
+// guard falls back to identity, leaving the source as it was.
+func (c Filter) incompressibleFallback(filePath string, err error) error {
+	if err != ErrIncompressible || c.opts.RatioGuard == nil || !c.opts.RatioGuard.FallbackToIdentity {
+		return err
+	}
+	log.WithFields(c.opts.LogFields).WithField("filepath", printable(filePath)).Info("Leaving incompressible file uncompressed")
+	return nil
+}
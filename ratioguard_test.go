@@ -0,0 +1,113 @@
+package extcompress
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Incompressible test data, the same for every run.
+func randomTestData(size int) []byte {
+	b := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(b)
+	return b
+}
+
+func TestRatioGuardExceeded(t *testing.T) {
+	g := RatioGuard{MinSample: 1000}
+	assert.False(t, g.exceeded(999, 5000, 0))
+	assert.True(t, g.exceeded(1000, 1001, 0))
+	assert.False(t, g.exceeded(1000, 1000, 0))
+	// Output still buffered in the pipes counts too
+	assert.True(t, g.exceeded(1000, 950, 100))
+	assert.False(t, g.exceeded(1000, 850, 100))
+
+	g = RatioGuard{MaxRatio: 1.5}
+	assert.False(t, g.exceeded(64*mib, 90*mib, 0))
+	assert.True(t, g.exceeded(64*mib, 97*mib, 0))
+}
+
+func TestRatioGuardStopsStream(t *testing.T) {
+	setMonitorInterval(t, 10*time.Millisecond)
+
+	// Output much larger than the input, as a stand in for a tool on data
+	// it can't compress
+	h := NewFilter("sh", CompressFlags("-c", "cat; cat /dev/zero")).
+		WithRatioGuard(RatioGuard{MinSample: mib})
+
+	job, err := h.CompressStream(bytes.NewReader(randomTestData(2 * mib)))
+	assert.Nil(t, err)
+	done := make(chan error, 1)
+	go func() {
+		_, err := ioutil.ReadAll(job)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		assert.Equal(t, ErrIncompressible, err)
+	case <-time.After(10 * time.Second):
+		job.Close()
+		t.Fatal("ratio guard did not stop the job")
+	}
+	assert.Equal(t, ErrIncompressible, job.(*CompressionJob).Err())
+}
+
+func TestRatioGuardAllowsCompressibleStream(t *testing.T) {
+	setMonitorInterval(t, 10*time.Millisecond)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	h = h.WithRatioGuard(RatioGuard{MinSample: mib})
+
+	job, err := h.CompressStream(bytes.NewReader(seekableTestData(8 * mib)))
+	assert.Nil(t, err)
+	_, err = ioutil.ReadAll(job)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, job.Result())
+}
+
+func TestRatioGuardInPlace(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	guarded := h.WithRatioGuard(RatioGuard{MinSample: mib})
+
+	original := randomTestData(2 * mib)
+	filename := path.Join(tmpdir, "random")
+	assert.Nil(t, ioutil.WriteFile(filename, original, os.FileMode(0644)))
+
+	// The original is left untouched and nothing else is left behind
+	assert.Equal(t, ErrIncompressible, guarded.CompressFileInPlace(filename))
+	out, err := ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, original, out)
+	entries, err := ioutil.ReadDir(tmpdir)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 2)
+
+	// Falling back succeeds, with the original as the output
+	fallback := h.WithRatioGuard(RatioGuard{MinSample: mib, FallbackToIdentity: true})
+	assert.Nil(t, fallback.CompressFileInPlace(filename))
+	outPath, err := fallback.CompressFileInPlaceWithOptions(filename, InPlaceOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, filename, outPath)
+	out, err = ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, original, out)
+
+	results, err := fallback.CompressFilesInPlace([]string{filename, path.Join(tmpdir, "pipechaining")}, InPlaceOptions{})
+	assert.Nil(t, err)
+	assert.Nil(t, results[0].Err)
+	assert.Equal(t, filename, results[0].Output)
+	assert.Nil(t, results[1].Err)
+	assert.Equal(t, path.Join(tmpdir, "pipechaining.gz"), results[1].Output)
+}
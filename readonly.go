@@ -0,0 +1,63 @@
+package extcompress
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+// Returned, before anything is run, by operations which would modify or
+// remove their source file while sources are read-only (see
+// Options.SourceReadOnly).
+var ErrSourceProtected = errors.New("extcompress: source files are read-only")
+
+// Describes an operation refused for the sake of its source. Wraps
+// ErrSourceProtected.
+type SourceProtectedError struct {
+	Path string
+}
+
+func (r SourceProtectedError) Error() string {
+	return fmt.Sprintf("%s: %s", r.Path, ErrSourceProtected.Error())
+}
+
+func (r SourceProtectedError) Unwrap() error {
+	return ErrSourceProtected
+}
+
+// Set to make every handler's sources read-only, atomically
+var sourceReadOnly int32
+
+// Makes the sources of every handler read-only, as if each had
+// Options.SourceReadOnly set, e.g. to enforce it for the whole process.
+func SetSourceReadOnly(readOnly bool) {
+	var flag int32
+	if readOnly {
+		flag = 1
+	}
+	atomic.StoreInt32(&sourceReadOnly, flag)
+}
+
+func (c Filter) sourceReadOnly() bool {
+	return enabled(c.opts.SourceReadOnly) || atomic.LoadInt32(&sourceReadOnly) != 0
+}
+
+// Checks the handler may modify or remove filePath, as in-place operations
+// do.
+func (c Filter) checkSourceWritable(filePath string) error {
+	if c.sourceReadOnly() {
+		return SourceProtectedError{filePath}
+	}
+	return nil
+}
+
+// Starts a file operation with the source opened read-only and fed to the
+// tool's stdin, so the tool never has its path to act on.
+func (c Filter) readOnlyFileJob(filePath string, compress bool) (CompressionProcess, error) {
+	f, err := os.OpenFile(filePath, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	return c.fileStreamJob(f, filePath, compress)
+}
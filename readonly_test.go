@@ -0,0 +1,90 @@
+package extcompress
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSourceReadOnlyInPlace(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	h = h.WithOptions(Options{SourceReadOnly: Bool(true)})
+	filename := path.Join(tmpdir, "input.log")
+	assert.Nil(t, ioutil.WriteFile(filename, []byte(data), os.FileMode(0644)))
+	compressed := path.Join(tmpdir, "input.gz")
+	assert.Nil(t, ioutil.WriteFile(compressed, compressBytes(t, h, []byte(data)), os.FileMode(0644)))
+
+	// Every in-place operation is refused
+	assert.Equal(t, SourceProtectedError{filename}, h.CompressFileInPlace(filename))
+	assert.Equal(t, SourceProtectedError{compressed}, h.DecompressFileInPlace(compressed))
+	_, err = h.CompressFileInPlaceWithOptions(filename, InPlaceOptions{Suffix: ".z"})
+	assert.Equal(t, SourceProtectedError{filename}, err)
+	_, err = h.DecompressFileInPlaceWithOptions(compressed, InPlaceOptions{})
+	assert.Equal(t, SourceProtectedError{compressed}, err)
+	res, err := h.CompressFileInPlaceResult(filename, InPlaceOptions{})
+	assert.True(t, errors.Is(err, ErrSourceProtected))
+	assert.Equal(t, err, res.Err)
+	results, err := h.CompressFilesInPlaceResult([]string{filename}, InPlaceOptions{})
+	assert.Nil(t, results)
+	assert.Equal(t, ErrSourceProtected, err)
+
+	// And nothing was touched
+	entries, err := ioutil.ReadDir(tmpdir)
+	assert.Nil(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Equal(t, []string{"input.gz", "input.log", "pipechaining"}, names)
+	out, err := ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, data, string(out))
+}
+
+func TestSourceReadOnlyReads(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	SetSourceReadOnly(true)
+	defer SetSourceReadOnly(false)
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	filename := path.Join(tmpdir, "input.log")
+	assert.Nil(t, ioutil.WriteFile(filename, []byte(data), os.FileMode(0444)))
+	assert.True(t, errors.Is(h.CompressFileInPlace(filename), ErrSourceProtected))
+
+	// The tool reads the file from stdin, never seeing its path
+	proc, err := h.Compress(filename)
+	assert.Nil(t, err)
+	assert.NotContains(t, proc.(*CompressionJob).cmd.Args, filename)
+	compressed, err := ioutil.ReadAll(proc)
+	assert.Nil(t, err)
+	assert.Zero(t, proc.Result())
+
+	// File to file and streams work as usual
+	dst := path.Join(tmpdir, "output.gz")
+	assert.Nil(t, h.CompressToFile(filename, dst))
+	assert.Nil(t, h.DecompressToFile(dst, path.Join(tmpdir, "output.log")))
+	out, err := ioutil.ReadFile(path.Join(tmpdir, "output.log"))
+	assert.Nil(t, err)
+	assert.Equal(t, data, string(out))
+	proc, err = h.DecompressStream(bytes.NewReader(compressed))
+	assert.Nil(t, err)
+	out, err = ioutil.ReadAll(proc)
+	assert.Nil(t, err)
+	assert.Zero(t, proc.Result())
+	assert.Equal(t, data, string(out))
+
+	out, err = ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, data, string(out))
+}
@@ -0,0 +1,100 @@
+package extcompress
+
+import (
+	"errors"
+	"os/exec"
+	"sync"
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Returned when a tool was reaped by something other than this package, such
+// as a PID 1 or SIGCHLD handler which reaps every child, and its exit status
+// couldn't be recovered through SetReapedStatus.
+var ErrReapedExternally = errors.New("extcompress: process was reaped externally, exit status unknown")
+
+// Reports the exit status of process pid if the caller reaped it, and
+// whether it did.
+type ReapedStatusFunc func(pid int) (status int, ok bool)
+
+var (
+	reapedStatusMtx sync.RWMutex
+	reapedStatus    ReapedStatusFunc
+)
+
+// Sets where the exit status of a tool is looked up when the caller's own
+// SIGCHLD handling reaped it before this package could. Programs which reap
+// all children (typically as PID 1 in a container) should record the status
+// of each child they reap and return it from fn. Without one, such a tool's
+// job reports a best-effort status of 0 and Err returns ErrReapedExternally.
+func SetReapedStatus(fn ReapedStatusFunc) {
+	reapedStatusMtx.Lock()
+	defer reapedStatusMtx.Unlock()
+	reapedStatus = fn
+}
+
+func lookupReapedStatus(pid int) (int, bool) {
+	reapedStatusMtx.RLock()
+	defer reapedStatusMtx.RUnlock()
+	if reapedStatus == nil {
+		return 0, false
+	}
+	return reapedStatus(pid)
+}
+
+var (
+	waitProcessMtx sync.RWMutex
+	// Waits for cmd, replaced in tests to simulate external reaping
+	waitProcess = func(cmd *exec.Cmd) error {
+		return cmd.Wait()
+	}
+)
+
+// Replaces waitProcess, returning a function which restores it.
+func setWaitProcess(fn func(cmd *exec.Cmd) error) (restore func()) {
+	waitProcessMtx.Lock()
+	defer waitProcessMtx.Unlock()
+	orig := waitProcess
+	waitProcess = fn
+	return func() {
+		waitProcessMtx.Lock()
+		defer waitProcessMtx.Unlock()
+		waitProcess = orig
+	}
+}
+
+// Waits for cmd, shown as command in errors, and returns its exit status, or
+// -1 if it has none. external reports the process had already been reaped by
+// someone else, in which case the status comes from SetReapedStatus, or if
+// that doesn't know it is 0 with ErrReapedExternally.
+func waitCmd(jlog *log.Entry, cmd *exec.Cmd, command string, id string) (status int, external bool, err error) {
+	waitProcessMtx.RLock()
+	wait := waitProcess
+	waitProcessMtx.RUnlock()
+	err = wait(cmd)
+	if !errors.Is(err, syscall.ECHILD) {
+		return exitStatusOf(err), false, err
+	}
+
+	pid := cmd.Process.Pid
+	status, ok := lookupReapedStatus(pid)
+	if !ok {
+		jlog.WithField("pid", pid).Warn("External process was reaped externally, exit status unknown")
+		return 0, true, ErrReapedExternally
+	}
+	jlog.WithField("pid", pid).WithField("status", status).Debug("External process was reaped externally")
+	if status != 0 {
+		return status, true, ExitStatusError{command, status, id}
+	}
+	return 0, true, nil
+}
+
+// Reports whether the job's process was reaped by something other than this
+// package (see SetReapedStatus). False until the job has finished.
+func (this *CompressionJob) ReapedExternally() bool {
+	if !this.isReaped() {
+		return false
+	}
+	return this.reapedExternally
+}
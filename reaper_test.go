@@ -0,0 +1,120 @@
+package extcompress
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Simulates a SIGCHLD handler reaping every child before the package can,
+// recording the statuses it collected.
+func reapFirst(t *testing.T) map[int]int {
+	var mtx sync.Mutex
+	statuses := map[int]int{}
+	restore := setWaitProcess(func(cmd *exec.Cmd) error {
+		var ws syscall.WaitStatus
+		_, err := syscall.Wait4(cmd.Process.Pid, &ws, 0, nil)
+		assert.Nil(t, err)
+		mtx.Lock()
+		statuses[cmd.Process.Pid] = ws.ExitStatus()
+		mtx.Unlock()
+		return cmd.Wait()
+	})
+	t.Cleanup(func() {
+		restore()
+		SetReapedStatus(nil)
+	})
+	SetReapedStatus(func(pid int) (int, bool) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		status, ok := statuses[pid]
+		return status, ok
+	})
+	return statuses
+}
+
+func TestReapedExternallyKnownStatus(t *testing.T) {
+	reapFirst(t)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	proc, err := h.CompressStream(bytes.NewBufferString(data))
+	compressed := readJob(t, proc, err, "compress")
+
+	proc, err = h.DecompressStream(bytes.NewReader(compressed))
+	assert.Nil(t, err)
+	out, err := ioutil.ReadAll(proc)
+	assert.Nil(t, err)
+	assert.Equal(t, data, string(out))
+	assert.Equal(t, 0, proc.Result())
+	job := proc.(*CompressionJob)
+	assert.True(t, job.ReapedExternally())
+	assert.Nil(t, job.Err())
+
+	// A failure is still reported with the status the caller collected
+	proc, err = h.DecompressStream(bytes.NewBufferString("not gzip"))
+	assert.Nil(t, err)
+	ioutil.ReadAll(proc)
+	assert.Equal(t, 1, proc.Result())
+	assert.IsType(t, CorruptInputError{}, proc.(*CompressionJob).Err())
+}
+
+func TestReapedExternallyUnknownStatus(t *testing.T) {
+	reapFirst(t)
+	SetReapedStatus(nil)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	proc, err := h.DecompressStream(bytes.NewBufferString("not gzip"))
+	assert.Nil(t, err)
+	ioutil.ReadAll(proc)
+
+	// Best-effort status, but the job doesn't claim success
+	assert.Equal(t, 0, proc.Result())
+	job := proc.(*CompressionJob)
+	assert.True(t, job.ReapedExternally())
+	assert.Equal(t, ErrReapedExternally, job.Err())
+	assert.Nil(t, proc.Close())
+}
+
+func TestReapedExternallyInPlace(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	reapFirst(t)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	filename := path.Join(tmpdir, "pipechaining")
+	assert.Nil(t, h.CompressFileInPlace(filename))
+	compressed := filename + ".gz"
+	out, err := h.DecompressToBytes(compressed, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, data, string(out))
+
+	// Without the status success can't be claimed
+	SetReapedStatus(nil)
+	assert.Equal(t, ErrReapedExternally, h.DecompressFileInPlace(compressed))
+}
+
+func TestWaitErrorIsNotFatal(t *testing.T) {
+	defer setWaitProcess(func(cmd *exec.Cmd) error {
+		cmd.Wait()
+		return syscall.EINVAL
+	})()
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	proc, err := h.CompressStream(bytes.NewBufferString(data))
+	assert.Nil(t, err)
+	ioutil.ReadAll(proc)
+	assert.Equal(t, -1, proc.Result())
+	assert.Equal(t, syscall.EINVAL, proc.(*CompressionJob).Err())
+	assert.False(t, proc.(*CompressionJob).ReapedExternally())
+}
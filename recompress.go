@@ -0,0 +1,94 @@
+package extcompress
+
+import (
+	"errors"
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Implemented by streams which know the format of the data they produce,
+// such as jobs (see CompressionJob.Format). Compressing a stream already in
+// a compressed format is caught when the stream is handed to a compressor.
+type FormattedStream interface {
+	Format() Format
+}
+
+// Matched by the error from a compressor given a stream which is already
+// compressed, when Options.Recompression is RecompressionError.
+var ErrRecompression = errors.New("extcompress: stream is already compressed")
+
+// Describes a compressor being given an already compressed stream. Wraps
+// ErrRecompression.
+type RecompressionError struct {
+	// The format of the stream, and of what the compressor produces
+	Upstream Format
+	Format   Format
+}
+
+func (r RecompressionError) Error() string {
+	return fmt.Sprintf("extcompress: compressing %s data with %s", r.Upstream, r.Format)
+}
+
+func (r RecompressionError) Unwrap() error {
+	return ErrRecompression
+}
+
+// What a compressor does when given an already compressed stream, unless
+// Options.AllowRecompression is set.
+type RecompressionPolicy int
+
+const (
+	// Not set, leaving it to the defaults, and failing them
+	// RecompressionWarn
+	RecompressionUnset RecompressionPolicy = iota
+	// Log a warning and compress it anyway
+	RecompressionWarn
+	// Fail with a RecompressionError
+	RecompressionFail
+)
+
+// Returns the format of rd's data, or FormatUnknown if it doesn't say.
+func streamFormat(rd interface{}) Format {
+	if fs, ok := rd.(FormattedStream); ok {
+		return fs.Format()
+	}
+	return FormatUnknown
+}
+
+// Returns the format of the output of op on rd: what the handler produces
+// for compression, whatever went in for the identity handler, and unknown
+// for decompression.
+func (c Filter) outputFormat(op Operation, rd interface{}) Format {
+	if c.Passthrough {
+		return streamFormat(rd)
+	}
+	if op.Compresses() {
+		return FormatOf(c)
+	}
+	return FormatUnknown
+}
+
+// Applies the handler's recompression policy to compressing rd with op.
+func (c Filter) checkRecompression(jlog *log.Entry, op Operation, rd interface{}) error {
+	if !op.Compresses() || c.Passthrough || enabled(c.opts.AllowRecompression) {
+		return nil
+	}
+	upstream := streamFormat(rd)
+	if upstream == FormatUnknown || upstream == FormatIdentity {
+		return nil
+	}
+	err := RecompressionError{upstream, FormatOf(c)}
+	if c.opts.Recompression == RecompressionFail {
+		return err
+	}
+	jlog.WithField("upstreamFormat", string(upstream)).Warn(err.Error())
+	return nil
+}
+
+// Returns the format of the data the job produces: its handler's for
+// compression, its input's for the identity handler, and FormatUnknown for
+// decompression. Set when the job is spawned.
+func (this *CompressionJob) Format() Format {
+	return this.format
+}
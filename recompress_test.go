@@ -0,0 +1,94 @@
+package extcompress
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns a job gzipping data, for feeding to another handler.
+func gzipJob(t *testing.T) CompressionProcess {
+	gz, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	job, err := gz.CompressStream(bytes.NewReader([]byte(data)))
+	assert.Nil(t, err)
+	t.Cleanup(func() { job.Close() })
+	return job
+}
+
+func TestJobFormat(t *testing.T) {
+	job := gzipJob(t)
+	assert.Equal(t, FormatGzip, job.(*CompressionJob).Format())
+
+	gz, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	dec, err := gz.DecompressStream(job)
+	assert.Nil(t, err)
+	assert.Equal(t, FormatUnknown, dec.(*CompressionJob).Format())
+	out, err := ioutil.ReadAll(dec)
+	assert.Nil(t, err)
+	assert.Zero(t, dec.Result())
+	assert.Equal(t, data, string(out))
+
+	// The identity handler passes its input's format on
+	id, err := Identity().CompressStream(gzipJob(t))
+	assert.Nil(t, err)
+	assert.Equal(t, FormatGzip, id.(*CompressionJob).Format())
+	ioutil.ReadAll(id)
+	assert.Zero(t, id.Result())
+}
+
+func TestRecompressionFails(t *testing.T) {
+	gz, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	zstd, err := GetExternalHandlerFromMimeType("application/zstd")
+	assert.Nil(t, err)
+
+	for _, h := range []ExternalHandler{gz, zstd} {
+		h = h.WithOptions(Options{Recompression: RecompressionFail})
+		_, err := h.CompressStream(gzipJob(t))
+		assert.True(t, errors.Is(err, ErrRecompression), FormatOf(h))
+		assert.Equal(t, RecompressionError{FormatGzip, FormatOf(h)}, err)
+	}
+
+	// Through the identity handler too
+	id, err := Identity().CompressStream(gzipJob(t))
+	assert.Nil(t, err)
+	defer id.Close()
+	_, err = gz.WithOptions(Options{Recompression: RecompressionFail}).CompressStream(id)
+	assert.True(t, errors.Is(err, ErrRecompression))
+}
+
+func TestRecompressionAllowed(t *testing.T) {
+	gz, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	zstd, err := GetExternalHandlerFromMimeType("application/zstd")
+	assert.Nil(t, err)
+
+	// Warned about by default, and allowed outright when asked for
+	for _, h := range []ExternalHandler{gz, zstd, zstd.WithOptions(Options{Recompression: RecompressionFail, AllowRecompression: Bool(true)})} {
+		job, err := h.CompressStream(gzipJob(t))
+		assert.Nil(t, err)
+		_, err = ioutil.ReadAll(job)
+		assert.Nil(t, err)
+		assert.Zero(t, job.Result())
+	}
+
+	// Decompressing first is what a transcode should do, and needs nothing
+	dec, err := gz.DecompressStream(gzipJob(t))
+	assert.Nil(t, err)
+	job, err := zstd.WithOptions(Options{Recompression: RecompressionFail}).CompressStream(dec)
+	assert.Nil(t, err)
+	compressed, err := ioutil.ReadAll(job)
+	assert.Nil(t, err)
+	assert.Zero(t, job.Result())
+	check, err := zstd.DecompressStream(bytes.NewReader(compressed))
+	assert.Nil(t, err)
+	out, err := ioutil.ReadAll(check)
+	assert.Nil(t, err)
+	assert.Zero(t, check.Result())
+	assert.Equal(t, data, string(out))
+}
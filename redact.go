@@ -0,0 +1,73 @@
+package extcompress
+
+import (
+	"strings"
+)
+
+// Replacement shown for redacted arguments.
+const Redacted = "****"
+
+// Decides whether argument i of args is sensitive. args is the full argument
+// list for an invocation, not including the command itself.
+type RedactFunc func(args []string, i int) bool
+
+// Redacts the argument following any of flags, and the value part of
+// "--flag=value" forms of them.
+func RedactAfter(flags ...string) RedactFunc {
+	return func(args []string, i int) bool {
+		for _, flag := range flags {
+			if i > 0 && args[i-1] == flag {
+				return true
+			}
+			if strings.HasPrefix(args[i], flag+"=") {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Redacts the arguments at the given positions.
+func RedactIndexes(indexes ...int) RedactFunc {
+	return func(args []string, i int) bool {
+		for _, idx := range indexes {
+			if i == idx {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Sets the handler's redaction predicate.
+func Redact(fn RedactFunc) FilterOption {
+	return func(f *Filter) {
+		f.RedactArgs = fn
+	}
+}
+
+// Returns a copy of args safe for logs, plans and error messages. The real
+// args are still what gets passed to the process.
+func (c Filter) redact(args []string) []string {
+	out := make([]string, len(args))
+	for i, arg := range args {
+		out[i] = arg
+		if c.RedactArgs == nil {
+			continue
+		}
+		if c.RedactArgs(args, i) {
+			if j := strings.IndexByte(arg, '='); j >= 0 && strings.HasPrefix(arg, "-") {
+				out[i] = arg[:j+1] + Redacted
+			} else {
+				out[i] = Redacted
+			}
+		}
+	}
+	return out
+}
+
+// Renders the command line for display, with sensitive arguments redacted
+// and unprintable bytes escaped.
+func (c Filter) displayCommand(args []string) string {
+	return strings.Join(printableAll(append([]string{c.Command}, c.redact(args)...)), " ")
+}
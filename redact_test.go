@@ -0,0 +1,79 @@
+package extcompress
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactArgs(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	const secret = "s3cret-passphrase"
+
+	var logs bytes.Buffer
+	oldLevel := log.GetLevel()
+	log.SetOutput(&logs)
+	log.SetLevel(log.DebugLevel)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetLevel(oldLevel)
+	}()
+
+	// The secret is passed as a positional parameter to the script, which
+	// echoes it back, or fails when asked to so the error path is exercised.
+	script := `[ -z "$FAIL" ] || exit 3; printf %s "$2"`
+	h := NewFilter("sh",
+		CompressFlags("-c", script, "sh", "-pass", secret),
+		DecompressFlags("-c", script, "sh", "--pass="+secret),
+		Redact(RedactAfter("-pass", "--pass")),
+	)
+
+	// The real value still reaches the process
+	job, err := h.CompressStream(bytes.NewReader(nil))
+	assert.Nil(t, err)
+	out, err := ioutil.ReadAll(job)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, job.Result())
+	assert.Equal(t, secret, string(out))
+
+	observed := []string{h.CommandStreamCompress(), h.CommandStreamDecompress()}
+	assert.Contains(t, h.CommandStreamCompress(), "-pass "+Redacted)
+	assert.Contains(t, h.CommandStreamDecompress(), "--pass="+Redacted)
+
+	plan := h.Plan()
+	for _, argv := range [][]string{plan.Compress, plan.Decompress, plan.CompressStream,
+		plan.DecompressStream, plan.CompressInPlace, plan.DecompressInPlace} {
+		observed = append(observed, strings.Join(argv, " "))
+	}
+
+	// Error messages carry the redacted command line
+	t.Setenv("FAIL", "1")
+	w, err := h.CompressIntoFile(path.Join(tmpdir, "out"))
+	assert.Nil(t, err)
+	w.Write([]byte("hello"))
+	err = w.Close()
+	assert.IsType(t, ExitStatusError{}, err)
+	observed = append(observed, err.Error())
+	assert.Contains(t, err.Error(), Redacted)
+
+	observed = append(observed, logs.String())
+	assert.Contains(t, logs.String(), Redacted)
+	for _, o := range observed {
+		assert.NotContains(t, o, secret)
+	}
+}
+
+func TestRedactIndexes(t *testing.T) {
+	h := Filter{Command: "tool", RedactArgs: RedactIndexes(1)}
+	assert.Equal(t, []string{"-k", Redacted, "-c"}, h.redact([]string{"-k", "key", "-c"}))
+	// No predicate leaves args alone
+	assert.Equal(t, []string{"-k", "key"}, Filter{}.redact([]string{"-k", "key"}))
+}
@@ -0,0 +1,155 @@
+package extcompress
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"sync"
+)
+
+// Guards mimeMap and filtersMap, which RegisterFilter can modify at runtime.
+var registryMtx sync.RWMutex
+
+// Returns the handler name registered for mimeType, or "" if there is none.
+func lookupHandlerName(mimeType string) string {
+	registryMtx.RLock()
+	defer registryMtx.RUnlock()
+	return mimeMap[mimeType]
+}
+
+// Configures a Filter built by NewFilter.
+type FilterOption func(*Filter)
+
+// Sets the flags used to compress, for both files and streams.
+func CompressFlags(flags ...string) FilterOption {
+	return func(f *Filter) {
+		f.CompressFlags = withFlags(flags)
+		f.CompressStreamFlags = withFlags(flags)
+	}
+}
+
+// Sets the flags used to decompress, for both files and streams.
+func DecompressFlags(flags ...string) FilterOption {
+	return func(f *Filter) {
+		f.DecompressFlags = withFlags(flags)
+		f.DecompressStreamFlags = withFlags(flags)
+	}
+}
+
+// Sets the flags used by the in-place operations.
+func InPlaceFlags(compress []string, decompress []string) FilterOption {
+	return func(f *Filter) {
+		f.CompressInPlaceFlags = withFlags(compress)
+		f.DecompressInPlaceFlags = withFlags(decompress)
+	}
+}
+
+// Sets the in-place suffix, and the flag which overrides it if the tool has
+// one.
+func Suffix(suffix string, flag string) FilterOption {
+	return func(f *Filter) {
+		f.Suffix = suffix
+		f.SuffixFlag = flag
+	}
+}
+
+// Sets the mimetype the handler reports.
+func MimeType(mimeType string) FilterOption {
+	return func(f *Filter) {
+		f.mimeType = mimeType
+	}
+}
+
+// Returned when a handler's command could not be found.
+type CommandNotFound struct {
+	Command string
+	Err     error
+}
+
+func (r CommandNotFound) Error() string {
+	return fmt.Sprintf("%s is unavailable: %v", r.Command, r.Err)
+}
+
+// Builds a handler for command directly, without going through mimetype
+// detection or the registry. With no options the command is run with no
+// flags for every operation. If the command can't be found on PATH every
+// operation (and Plan().Err) reports CommandNotFound; a command or flags
+// holding NUL or control characters are reported as an InvalidArgumentError.
+func NewFilter(command string, opts ...FilterOption) ExternalHandler {
+	f := Filter{Command: command}
+	for _, opt := range opts {
+		opt(&f)
+	}
+	if err := f.checkConfig(); err != nil {
+		f.err = err
+	} else if _, err := exec.LookPath(command); err != nil {
+		f.err = CommandNotFound{command, err}
+	}
+	f.opts = f.envOptions()
+	f.prov = Provenance{Handler: command, Command: command}
+	f.noteOptions("env", f.opts)
+	return f
+}
+
+// Returns a handler which passes data through unchanged.
+func Identity() ExternalHandler {
+	registryMtx.RLock()
+	f := filtersMap["cat"]
+	registryMtx.RUnlock()
+	f.prov = Provenance{Handler: "cat", Command: f.Command}
+	return f
+}
+
+// Returned by RegisterFilter for registrations which would clash with or
+// can't be expressed in the registry.
+var ErrRegistration = errors.New("invalid filter registration")
+
+// Registers a handler under name and maps each of mimeTypes to it, making it
+// available from the lookup functions. A mimetype may be a wildcard for a
+// whole top level type ("text/*"), used for otherwise unknown types of it.
+// The first mimetype is the one reported for the handler's Extensions. Only
+// handlers built by this package (NewFilter, Identity, or returned from a
+// lookup) can be registered.
+func RegisterFilter(name string, h ExternalHandler, mimeTypes ...string) error {
+	f, ok := h.(Filter)
+	if !ok {
+		return fmt.Errorf("%w: %T is not a Filter", ErrRegistration, h)
+	}
+	if name == "" {
+		return fmt.Errorf("%w: empty name", ErrRegistration)
+	}
+
+	registryMtx.Lock()
+	defer registryMtx.Unlock()
+
+	for _, mt := range mimeTypes {
+		if !checkMimePattern(mt) {
+			return fmt.Errorf("%w: %s is not a mimetype or a wildcard like text/*", ErrRegistration, mt)
+		}
+		if existing, ok := mimeMap[mt]; ok && existing != name {
+			return fmt.Errorf("%w: %s is already mapped to %s", ErrRegistration, mt, existing)
+		}
+	}
+	if err := checkExtensions(name, f.Extensions); err != nil {
+		return err
+	}
+	if err := f.checkConfig(); err != nil {
+		return err
+	}
+
+	// Registered filters carry no per-handler state; that comes from the
+	// lookup.
+	f.mimeType = ""
+	f.opts = Options{}
+	f.ctx = nil
+	f.matchedBy = MatchNone
+	filtersMap[name] = f
+	for _, mt := range mimeTypes {
+		mimeMap[mt] = name
+	}
+	addExtensions(name, f.Extensions)
+	if len(mimeTypes) > 0 {
+		canonicalMimeTypes[name] = mimeTypes[0]
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package extcompress
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewFilter(t *testing.T) {
+	if _, err := exec.LookPath("zstd"); err != nil {
+		t.Skip("zstd not available")
+	}
+
+	h := NewFilter("zstd",
+		CompressFlags("-q", "-c", "--format=gzip"),
+		DecompressFlags("-q", "-d", "-c", "--format=gzip"),
+		MimeType("application/gzip"),
+	)
+	assert.Nil(t, h.Plan().Err)
+	assert.Equal(t, "application/gzip", h.MimeType())
+	assert.Equal(t, "zstd -q -c --format=gzip", h.CommandStreamCompress())
+
+	compressed := compressBytes(t, h, []byte(data))
+
+	// The output really is gzip
+	gz, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	for _, dh := range []ExternalHandler{h, gz} {
+		job, err := dh.DecompressStream(ioutil.NopCloser(bytes.NewReader(compressed)))
+		assert.Nil(t, err)
+		out, err := ioutil.ReadAll(job)
+		assert.Nil(t, err)
+		assert.Zero(t, job.Result())
+		assert.Equal(t, data, string(out))
+	}
+}
+
+func TestNewFilterMissingCommand(t *testing.T) {
+	h := NewFilter("extcompress-no-such-command")
+	assert.IsType(t, CommandNotFound{}, h.Plan().Err)
+	_, err := h.CompressStream(bytes.NewReader(nil))
+	assert.IsType(t, CommandNotFound{}, err)
+}
+
+func TestIdentity(t *testing.T) {
+	h := Identity()
+	assert.True(t, h.Capabilities().Passthrough)
+	assert.Equal(t, data, string(compressBytes(t, h, []byte(data))))
+}
+
+// Removes everything registered under name once the test finishes.
+func unregisterFilter(t *testing.T, name string) {
+	t.Cleanup(func() {
+		registryMtx.Lock()
+		defer registryMtx.Unlock()
+		delete(filtersMap, name)
+		delete(canonicalMimeTypes, name)
+		for mt, existing := range mimeMap {
+			if existing == name {
+				delete(mimeMap, mt)
+			}
+		}
+		addExtensions(name, nil)
+	})
+}
+
+func TestRegisterFilter(t *testing.T) {
+	h := NewFilter("zstd", CompressFlags("-q", "-c", "--format=gzip"), DecompressFlags("-q", "-d", "-c"))
+	unregisterFilter(t, "zstd-gzip")
+	assert.Nil(t, RegisterFilter("zstd-gzip", h, "application/x-test-zstd-gzip"))
+
+	found, err := GetExternalHandlerFromMimeType("application/x-test-zstd-gzip")
+	assert.Nil(t, err)
+	assert.Equal(t, "application/x-test-zstd-gzip", found.MimeType())
+	assert.Equal(t, h.CommandStreamCompress(), found.CommandStreamCompress())
+
+	// Existing mappings can't be stolen
+	err = RegisterFilter("zstd-gzip", h, "application/gzip")
+	assert.ErrorIs(t, err, ErrRegistration)
+}
@@ -0,0 +1,26 @@
+package extcompress
+
+import (
+	"errors"
+)
+
+// Returned by Reopen for a job which wasn't spawned from a file, so can't be
+// started again from the beginning. Wrap its input in a ReplayableReader
+// instead.
+var ErrNotReopenable = errors.New("extcompress: job was not spawned from a file")
+
+// Kills the job and starts it again on the same file with the same options,
+// returning the new job, which reads from the start of the file. This is
+// for callers which have consumed some of the output and want it from the
+// beginning again, e.g. to re-run detection. The job being killed isn't a
+// failure; its result is forced to success as with Close. Only jobs from
+// the file operations can be reopened; others fail with ErrNotReopenable
+// and are left running.
+func (this *CompressionJob) Reopen() (CompressionProcess, error) {
+	if this.reopen == nil {
+		return nil, ErrNotReopenable
+	}
+	this.log.Debug("Reopening compression command from the start")
+	this.kill()
+	return this.reopen()
+}
@@ -0,0 +1,62 @@
+package extcompress
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReopenFileJob(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	original := seekableTestData(1 << 20)
+	compressed := writeCompressed(t, tmpdir, "gzip", original)
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	proc, err := h.Decompress(compressed)
+	assert.Nil(t, err)
+	head := make([]byte, 300)
+	_, err = io.ReadFull(proc, head)
+	assert.Nil(t, err)
+	assert.Equal(t, original[:300], head)
+
+	reopened, err := proc.(*CompressionJob).Reopen()
+	assert.Nil(t, err)
+	// The old job was stopped without failing
+	assert.Equal(t, 0, statusOf(proc))
+
+	out, err := ioutil.ReadAll(reopened)
+	assert.Nil(t, err)
+	assert.Nil(t, processErr(reopened))
+	assert.Equal(t, original, out)
+
+	// The new job can be reopened in turn
+	again, err := reopened.(*CompressionJob).Reopen()
+	assert.Nil(t, err)
+	out, err = ioutil.ReadAll(again)
+	assert.Nil(t, err)
+	assert.Nil(t, processErr(again))
+	assert.Equal(t, original, out)
+}
+
+func TestReopenStreamJob(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	proc, err := h.CompressStream(bytes.NewReader([]byte(data)))
+	assert.Nil(t, err)
+	reopened, err := proc.(*CompressionJob).Reopen()
+	assert.Nil(t, reopened)
+	assert.Equal(t, ErrNotReopenable, err)
+
+	// The job was left alone
+	_, err = ioutil.ReadAll(proc)
+	assert.Nil(t, err)
+	assert.Nil(t, processErr(proc))
+}
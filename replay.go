@@ -0,0 +1,73 @@
+package extcompress
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// Returned by Rewind once more has been read than the reader keeps.
+var ErrReplayLimitExceeded = errors.New("extcompress: more was read than can be replayed")
+
+// Returned by Rewind once the reader has already been rewound.
+var ErrAlreadyRewound = errors.New("extcompress: reader was already rewound")
+
+// Keeps the start of a stream so it can be read again once, for streams
+// which can't seek. Wrap a stream in one before giving it to detection or a
+// streaming operation, and if what comes out isn't what was expected, close
+// the job, Rewind, and start again with the same reader. Tools read ahead
+// of what they produce, so the limit needs to allow for what a job has
+// buffered as well as what the caller read from it.
+type ReplayableReader struct {
+	// Held over reads, so Rewind waits for any a closed job abandoned
+	mtx     sync.Mutex
+	r       io.Reader
+	limit   int
+	buf     []byte // What has been read, until it overflows the limit
+	replay  []byte // What is still to be read again after Rewind
+	over    bool
+	rewound bool
+}
+
+// Returns a reader which reads r, keeping up to limit bytes of it so it can
+// be rewound.
+func NewReplayableReader(r io.Reader, limit int) *ReplayableReader {
+	return &ReplayableReader{r: r, limit: limit}
+}
+
+func (rr *ReplayableReader) Read(p []byte) (int, error) {
+	rr.mtx.Lock()
+	defer rr.mtx.Unlock()
+	if len(rr.replay) > 0 {
+		n := copy(p, rr.replay)
+		rr.replay = rr.replay[n:]
+		return n, nil
+	}
+	n, err := rr.r.Read(p)
+	if n > 0 && !rr.rewound && !rr.over {
+		if len(rr.buf)+n > rr.limit {
+			rr.over, rr.buf = true, nil
+		} else {
+			rr.buf = append(rr.buf, p[:n]...)
+		}
+	}
+	return n, err
+}
+
+// Makes the reader start again from the beginning of the stream. This works
+// once, and only if no more than the limit has been read. Any read still in
+// progress, such as one a closed job left behind, is waited for and is
+// replayed too.
+func (rr *ReplayableReader) Rewind() error {
+	rr.mtx.Lock()
+	defer rr.mtx.Unlock()
+	switch {
+	case rr.rewound:
+		return ErrAlreadyRewound
+	case rr.over:
+		return ErrReplayLimitExceeded
+	}
+	rr.rewound = true
+	rr.replay, rr.buf = rr.buf, nil
+	return nil
+}
@@ -0,0 +1,66 @@
+package extcompress
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplayableReaderRewind(t *testing.T) {
+	original := seekableTestData(10000)
+	rr := NewReplayableReader(bytes.NewReader(original), 1000)
+
+	head := make([]byte, 600)
+	_, err := io.ReadFull(rr, head)
+	assert.Nil(t, err)
+	assert.Nil(t, rr.Rewind())
+
+	out, err := ioutil.ReadAll(rr)
+	assert.Nil(t, err)
+	assert.Equal(t, original, out)
+	assert.Equal(t, ErrAlreadyRewound, rr.Rewind())
+}
+
+func TestReplayableReaderLimit(t *testing.T) {
+	original := seekableTestData(10000)
+	rr := NewReplayableReader(bytes.NewReader(original), 1000)
+
+	_, err := io.ReadFull(rr, make([]byte, 1000))
+	assert.Nil(t, err)
+	_, err = io.ReadFull(rr, make([]byte, 1))
+	assert.Nil(t, err)
+	assert.Equal(t, ErrReplayLimitExceeded, rr.Rewind())
+
+	// Reading carries on where it was
+	out, err := ioutil.ReadAll(rr)
+	assert.Nil(t, err)
+	assert.Equal(t, original[1001:], out)
+}
+
+func TestReplayableReaderRedetect(t *testing.T) {
+	original := seekableTestData(1 << 20)
+	gz, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	compressed := compressBytes(t, gz, original)
+
+	// Mistakenly read as plain data, then started again as gzip
+	rr := NewReplayableReader(bytes.NewReader(compressed), len(compressed))
+	cat, err := GetExternalHandlerFromMimeType("text/plain")
+	assert.Nil(t, err)
+	proc, err := cat.DecompressStream(rr)
+	assert.Nil(t, err)
+	_, err = io.ReadFull(proc, make([]byte, 300))
+	assert.Nil(t, err)
+	proc.Close()
+
+	assert.Nil(t, rr.Rewind())
+	proc, err = gz.DecompressStream(rr)
+	assert.Nil(t, err)
+	out, err := ioutil.ReadAll(proc)
+	assert.Nil(t, err)
+	assert.Nil(t, processErr(proc))
+	assert.Equal(t, original, out)
+}
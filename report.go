@@ -0,0 +1,234 @@
+package extcompress
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Whether a handler can be used.
+type HandlerStatus int
+
+const (
+	// The command is installed and recent enough
+	StatusOK HandlerStatus = iota
+	// The command couldn't be found
+	StatusMissing
+	// The command is installed but older than the handler's MinVersion, or
+	// its version couldn't be told
+	StatusDegraded
+)
+
+func (s HandlerStatus) String() string {
+	switch s {
+	case StatusMissing:
+		return "missing"
+	case StatusDegraded:
+		return "degraded"
+	default:
+		return "ok"
+	}
+}
+
+// Reports the status by name in JSON and other text encodings.
+func (s HandlerStatus) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// What Report found for the handler of one mimetype.
+type HandlerReport struct {
+	MimeType string
+	// The registered handler name, and the command it runs
+	Handler      string
+	Command      string
+	ResolvedPath string `json:",omitempty"`
+	Version      string `json:",omitempty"`
+	Capabilities Capabilities
+	Extensions   []string `json:",omitempty"`
+	Status       HandlerStatus
+	// Why the status isn't StatusOK
+	Reason string `json:",omitempty"`
+}
+
+// The state of every registered mimetype, sorted by mimetype. Serializes to
+// JSON as is, e.g. for healthchecks.
+type RegistryReport struct {
+	Handlers []HandlerReport
+}
+
+// True if every handler in the report has StatusOK.
+func (r RegistryReport) OK() bool {
+	for _, h := range r.Handlers {
+		if h.Status != StatusOK {
+			return false
+		}
+	}
+	return true
+}
+
+// How long a probe of a command's version may take.
+var versionProbeTimeout = 5 * time.Second
+
+// Matches the version number in a tool's --version output, e.g. "1.5.6" in
+// "*** Zstandard CLI (64-bit) v1.5.6, by Yann Collet ***".
+var versionPattern = regexp.MustCompile(`[0-9]+(\.[0-9]+)+`)
+
+type versionKey struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+type versionResult struct {
+	version string
+	err     error
+}
+
+var (
+	versionCacheMtx sync.Mutex
+	// Probed versions, by binary, so reports after the first only stat
+	// the commands
+	versionCache = map[versionKey]versionResult{}
+)
+
+// Reports which registered mimetypes can be used right now: the command each
+// runs, where it was found on PATH, its version and what the handler can do.
+// Each command is probed for its version the first time it is seen; the
+// result is cached until the binary changes.
+func Report() RegistryReport {
+	registryMtx.RLock()
+	var mimeTypes []string
+	for mimeType := range mimeMap {
+		// Entries such as "gzip" are handler names, not mimetypes
+		if strings.Contains(mimeType, "/") {
+			mimeTypes = append(mimeTypes, mimeType)
+		}
+	}
+	sort.Strings(mimeTypes)
+	names := map[string]string{}
+	filters := map[string]Filter{}
+	for _, mimeType := range mimeTypes {
+		name := mimeMap[mimeType]
+		names[mimeType] = name
+		filters[name] = filtersMap[name]
+	}
+	registryMtx.RUnlock()
+
+	// Each handler is probed once, however many mimetypes it serves
+	reports := map[string]HandlerReport{}
+	for name, f := range filters {
+		reports[name] = f.report(name)
+	}
+	var report RegistryReport
+	for _, mimeType := range mimeTypes {
+		r := reports[names[mimeType]]
+		r.MimeType = mimeType
+		report.Handlers = append(report.Handlers, r)
+	}
+	return report
+}
+
+// Probes the filter registered as name.
+func (c Filter) report(name string) HandlerReport {
+	r := HandlerReport{
+		Handler:      name,
+		Command:      c.Command,
+		Capabilities: c.Capabilities(),
+		Extensions:   c.Extensions,
+	}
+	path, err := exec.LookPath(c.Command)
+	if err != nil && c.fallbackDecoder(OpDecompress) != nil {
+		r.Status = StatusDegraded
+		r.Reason = err.Error() + "; decompressing with the Go fallback"
+		return r
+	}
+	if err != nil {
+		r.Status = StatusMissing
+		r.Reason = err.Error()
+		return r
+	}
+	r.ResolvedPath = path
+	if len(c.VersionFlags) == 0 {
+		return r
+	}
+
+	r.Version, err = probeVersion(path, c.VersionFlags)
+	switch {
+	case err != nil:
+		r.Status = StatusDegraded
+		r.Reason = fmt.Sprintf("version unknown: %v", err)
+	case c.MinVersion != "" && compareVersions(r.Version, c.MinVersion) < 0:
+		r.Status = StatusDegraded
+		r.Reason = fmt.Sprintf("version %s is older than %s", r.Version, c.MinVersion)
+	}
+	return r
+}
+
+// Returns the version the binary at path reports when run with flags.
+func probeVersion(path string, flags []string) (string, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	key := versionKey{path, st.Size(), st.ModTime()}
+	versionCacheMtx.Lock()
+	cached, ok := versionCache[key]
+	versionCacheMtx.Unlock()
+	if ok {
+		return cached.version, cached.err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), versionProbeTimeout)
+	defer cancel()
+	// Some tools (bzip2) print their version on stderr
+	cmd := exec.CommandContext(ctx, path, flags...)
+	cmd.Env = withCLocale(nil)
+	out, err := cmd.CombinedOutput()
+	result := versionResult{version: versionPattern.FindString(string(out))}
+	if err != nil {
+		result = versionResult{"", err}
+	} else if result.version == "" {
+		result.err = fmt.Errorf("no version in %q", firstLine(string(out)))
+	}
+
+	versionCacheMtx.Lock()
+	versionCache[key] = result
+	versionCacheMtx.Unlock()
+	return result.version, result.err
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// Compares dotted version numbers, returning -1, 0 or 1 as a is older than,
+// the same as, or newer than b. Missing components count as 0.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var an, bn int
+		if i < len(as) {
+			an, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			bn, _ = strconv.Atoi(bs[i])
+		}
+		if an != bn {
+			if an < bn {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,108 @@
+package extcompress
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns the report for the handler of mimeType.
+func reportFor(t *testing.T, report RegistryReport, mimeType string) HandlerReport {
+	for _, h := range report.Handlers {
+		if h.MimeType == mimeType {
+			return h
+		}
+	}
+	t.Fatalf("%s not in report", mimeType)
+	return HandlerReport{}
+}
+
+// Writes a tool to dir which prints version when asked for it, counting how
+// often it was in calls.
+func writeVersionTool(t *testing.T, dir string, name string, version string) {
+	script := "#!/bin/sh\necho x >> " + path.Join(dir, name+".calls") + "\necho '" + version + "'\n"
+	assert.Nil(t, ioutil.WriteFile(path.Join(dir, name), []byte(script), 0755))
+}
+
+func versionCalls(t *testing.T, dir string, name string) int {
+	calls, _ := ioutil.ReadFile(path.Join(dir, name+".calls"))
+	return strings.Count(string(calls), "x")
+}
+
+func TestReport(t *testing.T) {
+	dir := pathWith(t, "gzip", "cat")
+	writeVersionTool(t, dir, "xz", "xz (XZ Utils) 5.1.0alpha")
+	writeVersionTool(t, dir, "zstd", "no idea")
+
+	report := Report()
+	assert.False(t, report.OK())
+
+	gz := reportFor(t, report, "application/gzip")
+	assert.Equal(t, StatusOK, gz.Status)
+	assert.Equal(t, "gzip", gz.Command)
+	assert.Equal(t, path.Join(dir, "gzip"), gz.ResolvedPath)
+	assert.NotEmpty(t, gz.Version)
+	assert.Equal(t, []string{".gz"}, gz.Extensions)
+	assert.Equal(t, gz.Version, reportFor(t, report, "application/x-gzip").Version)
+
+	bz := reportFor(t, report, "application/x-bzip2")
+	assert.Equal(t, StatusMissing, bz.Status)
+	assert.Empty(t, bz.ResolvedPath)
+	assert.NotEmpty(t, bz.Reason)
+
+	xz := reportFor(t, report, "application/x-xz")
+	assert.Equal(t, StatusDegraded, xz.Status)
+	assert.Equal(t, "5.1.0", xz.Version)
+	assert.Contains(t, xz.Reason, "older than 5.2.0")
+	assert.True(t, xz.Capabilities.Threads)
+
+	zst := reportFor(t, report, "application/zstd")
+	assert.Equal(t, StatusDegraded, zst.Status)
+	assert.Contains(t, zst.Reason, "version unknown")
+
+	// Tools without VersionFlags are only looked for
+	txt := reportFor(t, report, "text/plain")
+	assert.Equal(t, StatusOK, txt.Status)
+	assert.Empty(t, txt.Version)
+
+	// Serializes with statuses by name
+	encoded, err := json.Marshal(report)
+	assert.Nil(t, err)
+	var decoded struct {
+		Handlers []map[string]interface{}
+	}
+	assert.Nil(t, json.Unmarshal(encoded, &decoded))
+	for _, h := range decoded.Handlers {
+		if h["MimeType"] == "application/x-bzip2" {
+			assert.Equal(t, "missing", h["Status"])
+		}
+	}
+}
+
+func TestReportCachesVersions(t *testing.T) {
+	dir := pathWith(t)
+	writeVersionTool(t, dir, "xz", "xz (XZ Utils) 5.2.5")
+
+	assert.Equal(t, StatusOK, reportFor(t, Report(), "application/x-xz").Status)
+	assert.Equal(t, StatusOK, reportFor(t, Report(), "application/x-xz").Status)
+	assert.Equal(t, 1, versionCalls(t, dir, "xz"))
+
+	// Replacing the binary probes it again
+	os.Remove(path.Join(dir, "xz"))
+	writeVersionTool(t, dir, "xz", "xz (XZ Utils) 5.0.0 (old)")
+	xz := reportFor(t, Report(), "application/x-xz")
+	assert.Equal(t, StatusDegraded, xz.Status)
+	assert.Equal(t, "5.0.0", xz.Version)
+	assert.Equal(t, 2, versionCalls(t, dir, "xz"))
+}
+
+func TestCompareVersions(t *testing.T) {
+	assert.Equal(t, 0, compareVersions("1.3", "1.3.0"))
+	assert.Equal(t, -1, compareVersions("1.2.9", "1.3.0"))
+	assert.Equal(t, 1, compareVersions("1.10.0", "1.9.9"))
+}
@@ -0,0 +1,97 @@
+package extcompress
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+)
+
+// Matched by the error from starting a tool whose binary couldn't be run
+// although it was found: it was being written (ETXTBSY), was replaced by
+// one which isn't executable here (ENOEXEC, EACCES), or vanished (ENOENT).
+// These happen while packages are upgraded, and retrying shortly after
+// usually succeeds, unlike failures caused by the data (see Retryable).
+var ErrBinaryUnavailable = errors.New("extcompress: tool binary unavailable")
+
+// Describes a tool which couldn't be started. Wraps ErrBinaryUnavailable.
+type BinaryUnavailableError struct {
+	Command string
+	// The binary which was run
+	Path  string
+	Errno syscall.Errno
+}
+
+func (r BinaryUnavailableError) Error() string {
+	return fmt.Sprintf("%s: %s: %s: %v", r.Command, ErrBinaryUnavailable.Error(), r.Path, r.Errno)
+}
+
+func (r BinaryUnavailableError) Unwrap() error {
+	return ErrBinaryUnavailable
+}
+
+// True if err is from a failure worth retrying the operation for, such as
+// the tool's binary being replaced while it was started.
+func Retryable(err error) bool {
+	return errors.Is(err, ErrBinaryUnavailable)
+}
+
+type resolveKey struct {
+	command string
+	path    string
+}
+
+var (
+	resolveMtx sync.Mutex
+	// Where each command was found on PATH, for the PATH it was found
+	// with, so tools aren't searched for on every run
+	resolvedCommands = map[resolveKey]string{}
+)
+
+// Returns the binary command runs, searching PATH the first time.
+func resolveCommand(command string) (string, error) {
+	key := resolveKey{command, os.Getenv("PATH")}
+	resolveMtx.Lock()
+	path, ok := resolvedCommands[key]
+	resolveMtx.Unlock()
+	if ok {
+		return path, nil
+	}
+	path, err := exec.LookPath(command)
+	if err != nil {
+		return "", err
+	}
+	resolveMtx.Lock()
+	resolvedCommands[key] = path
+	resolveMtx.Unlock()
+	return path, nil
+}
+
+// Forgets where command was found, so the next run searches PATH again.
+func forgetResolved(command string) {
+	resolveMtx.Lock()
+	defer resolveMtx.Unlock()
+	for key := range resolvedCommands {
+		if key.command == command {
+			delete(resolvedCommands, key)
+		}
+	}
+}
+
+// Returns a BinaryUnavailableError if err, from starting cmd, means its
+// binary couldn't be run, forgetting where the binary was found. Other
+// errors are returned as they are.
+func (c Filter) classifyStartError(cmd *exec.Cmd, err error) error {
+	var errno syscall.Errno
+	if cmd.Err != nil || !errors.As(err, &errno) {
+		return err
+	}
+	switch errno {
+	case syscall.ETXTBSY, syscall.ENOEXEC, syscall.EACCES, syscall.ENOENT:
+		forgetResolved(c.Command)
+		return BinaryUnavailableError{c.Command, cmd.Path, errno}
+	}
+	return err
+}
@@ -0,0 +1,87 @@
+package extcompress
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBinaryBusy(t *testing.T) {
+	dir := pathWith(t, "cat")
+	tool := path.Join(dir, "busytool")
+	// Held open for writing, as while a package upgrade writes it
+	f, err := os.OpenFile(tool, os.O_WRONLY|os.O_CREATE, 0755)
+	assert.Nil(t, err)
+	f.WriteString("#!/bin/sh\nexec cat\n")
+	defer f.Close()
+
+	h := NewFilter("busytool")
+	_, err = h.CompressStream(bytes.NewReader([]byte(data)))
+	assert.True(t, errors.Is(err, ErrBinaryUnavailable))
+	assert.True(t, Retryable(err))
+	var binErr BinaryUnavailableError
+	assert.True(t, errors.As(err, &binErr))
+	assert.Equal(t, syscall.ETXTBSY, binErr.Errno)
+	assert.Equal(t, "busytool", binErr.Command)
+	assert.Equal(t, tool, binErr.Path)
+
+	// Once written it runs
+	f.Close()
+	job, err := h.CompressStream(bytes.NewReader([]byte(data)))
+	assert.Nil(t, err)
+	out, err := ioutil.ReadAll(job)
+	assert.Nil(t, err)
+	assert.Equal(t, data, string(out))
+	assert.Zero(t, job.Result())
+}
+
+func TestBinaryVanished(t *testing.T) {
+	first := pathWith(t, "cat")
+	second := pathWith(t, "cat")
+	t.Setenv("PATH", first+":"+second)
+	script := []byte("#!/bin/sh\nexec cat\n")
+	for _, dir := range []string{first, second} {
+		assert.Nil(t, ioutil.WriteFile(path.Join(dir, "movingtool"), script, 0755))
+	}
+
+	h := NewFilter("movingtool")
+	job, err := h.CompressStream(bytes.NewReader([]byte(data)))
+	assert.Nil(t, err)
+	ioutil.ReadAll(job)
+	assert.Zero(t, job.Result())
+
+	// Removed after it was found
+	assert.Nil(t, os.Remove(path.Join(first, "movingtool")))
+	_, err = h.CompressStream(bytes.NewReader([]byte(data)))
+	var binErr BinaryUnavailableError
+	assert.True(t, errors.As(err, &binErr))
+	assert.Equal(t, syscall.ENOENT, binErr.Errno)
+	assert.Equal(t, path.Join(first, "movingtool"), binErr.Path)
+	assert.True(t, Retryable(err))
+
+	// The next run searches PATH again, finding the other copy
+	job, err = h.CompressStream(bytes.NewReader([]byte(data)))
+	assert.Nil(t, err)
+	out, err := ioutil.ReadAll(job)
+	assert.Nil(t, err)
+	assert.Equal(t, data, string(out))
+	assert.Zero(t, job.Result())
+	assert.Equal(t, path.Join(second, "movingtool"), job.(*CompressionJob).cmd.Path)
+	assert.Equal(t, "movingtool", job.(*CompressionJob).cmd.Args[0])
+
+	// Replaced by something which can't be run here
+	assert.Nil(t, ioutil.WriteFile(path.Join(second, "movingtool"), []byte{0x7f, 'E', 'L', 'F', 0, 0, 0, 0}, 0755))
+	_, err = h.CompressStream(bytes.NewReader([]byte(data)))
+	assert.True(t, errors.As(err, &binErr))
+	assert.Equal(t, syscall.ENOEXEC, binErr.Errno)
+
+	// Errors from the data aren't worth retrying
+	assert.False(t, Retryable(ExitStatusError{"gzip", 1, ""}))
+	assert.False(t, Retryable(CorruptInputError{Command: "gzip"}))
+}
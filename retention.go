@@ -0,0 +1,164 @@
+package extcompress
+
+import (
+	"sync"
+)
+
+// How much of a dropped stderr tail is kept for its job's error.
+const stderrDroppedSize = 256
+
+// Default for SetMaxRetainedStderr.
+const defaultMaxRetainedStderr = 16 << 20
+
+// Accounts for the memory held by stderr tails across every job. Tails of
+// finished jobs are queued oldest first while there is a cap, and cut down
+// once the total is over it. Without a cap they stop being counted when
+// their job finishes, and live only as long as the job.
+type stderrRetention struct {
+	mtx      sync.Mutex
+	max      int64
+	retained int64
+	// Finished tails still holding their whole buffer, then those cut down
+	// to a prefix
+	full     tailQueue
+	prefixed tailQueue
+}
+
+// Tails oldest first.
+type tailQueue struct {
+	tails []*stderrTail
+}
+
+var retention = &stderrRetention{max: defaultMaxRetainedStderr}
+
+// Sets how many bytes the stderr kept for jobs' errors may take up in total.
+// Past it the buffers of the jobs which finished first are dropped, keeping
+// only the start of them for their errors, then dropped entirely. Running
+// jobs keep theirs, up to stderrTailSize each. 0 or less removes the cap,
+// leaving finished jobs' stderr to be freed along with the jobs.
+func SetMaxRetainedStderr(bytes int64) {
+	retention.mtx.Lock()
+	defer retention.mtx.Unlock()
+	retention.max = bytes
+	if bytes <= 0 {
+		for _, q := range []*tailQueue{&retention.full, &retention.prefixed} {
+			for tail, ok := q.pop(); ok; tail, ok = q.pop() {
+				retention.release(tail)
+			}
+		}
+		return
+	}
+	retention.enforce()
+}
+
+// Returns how many bytes of stderr are currently kept for jobs: those of
+// running jobs, and of finished ones held under the cap.
+func RetainedStderr() int64 {
+	retention.mtx.Lock()
+	defer retention.mtx.Unlock()
+	return retention.retained
+}
+
+// Counts n more bytes held by tail, unless it has stopped being counted.
+func (r *stderrRetention) grew(tail *stderrTail, n int64) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if tail.released {
+		return
+	}
+	tail.accounted += n
+	r.retained += n
+}
+
+// Stops counting tail. Called with mtx held.
+func (r *stderrRetention) release(tail *stderrTail) {
+	r.retained -= tail.accounted
+	tail.accounted = 0
+	tail.released = true
+}
+
+// Marks the tail's job as finished, so its buffer may be dropped.
+func (s *stderrTail) complete() {
+	if s == nil {
+		return
+	}
+	s.mtx.Lock()
+	first := !s.completed
+	s.completed = true
+	s.mtx.Unlock()
+	if !first {
+		return
+	}
+	retention.mtx.Lock()
+	defer retention.mtx.Unlock()
+	if retention.max <= 0 || s.accounted == 0 {
+		retention.release(s)
+		return
+	}
+	retention.full.push(s)
+	retention.enforce()
+}
+
+// Cuts the tail down to its first stderrDroppedSize bytes, returning how
+// many bytes that freed. Called with retention.mtx held.
+func (s *stderrTail) cut() int64 {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.dropped {
+		return 0
+	}
+	contents := s.contents()
+	s.dropped = true
+	s.corrupt = corruptMessage(string(contents))
+	if len(contents) > stderrDroppedSize {
+		contents = contents[:stderrDroppedSize]
+	}
+	before := cap(s.buf)
+	s.buf = append([]byte(nil), contents...)
+	s.next = 0
+	freed := int64(before - cap(s.buf))
+	s.accounted -= freed
+	return freed
+}
+
+// Drops the rest of the tail, returning how many bytes that freed. Called
+// with retention.mtx held.
+func (s *stderrTail) drop() int64 {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	freed := int64(cap(s.buf))
+	s.buf = nil
+	s.accounted -= freed
+	return freed
+}
+
+// Drops the oldest finished tails until the total is within the cap. Called
+// with mtx held.
+func (r *stderrRetention) enforce() {
+	for r.max > 0 && r.retained > r.max {
+		if tail, ok := r.full.pop(); ok {
+			r.retained -= tail.cut()
+			r.prefixed.push(tail)
+		} else if tail, ok := r.prefixed.pop(); ok {
+			r.retained -= tail.drop()
+			r.release(tail)
+		} else {
+			return
+		}
+	}
+}
+
+func (q *tailQueue) push(tail *stderrTail) {
+	q.tails = append(q.tails, tail)
+}
+
+// Returns the oldest tail, if any.
+func (q *tailQueue) pop() (*stderrTail, bool) {
+	if len(q.tails) == 0 {
+		return nil, false
+	}
+	tail := q.tails[0]
+	q.tails[0] = nil
+	q.tails = q.tails[1:]
+	return tail, true
+}
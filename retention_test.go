@@ -0,0 +1,99 @@
+package extcompress
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStderrTailRing(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	tail := &stderrTail{}
+	var all []byte
+	for i := 0; i < 200; i++ {
+		chunk := make([]byte, rng.Intn(3*stderrTailSize/2))
+		for j := range chunk {
+			chunk[j] = 'a' + byte(rng.Intn(26))
+		}
+		tail.Write(chunk)
+		all = append(all, chunk...)
+
+		want := all
+		if len(want) > stderrTailSize {
+			want = want[len(want)-stderrTailSize:]
+		}
+		assert.Equal(t, string(want), tail.String())
+		assert.True(t, cap(tail.buf) <= stderrTailSize)
+	}
+}
+
+// Runs a decompressor which fails, saying its input is corrupt, over and over
+// on stderr.
+func failingDecompress(t *testing.T) *CompressionJob {
+	h := NewFilter("sh", DecompressFlags("-c", `cat >/dev/null
+i=0
+while [ $i -lt 200 ]; do echo "not in gzip format, line $i" >&2; i=$((i+1)); done
+exit 1`))
+	proc, err := h.DecompressStream(bytes.NewReader([]byte("input")))
+	assert.Nil(t, err)
+	job := proc.(*CompressionJob)
+	assert.True(t, errors.Is(job.Err(), ErrCorruptInput))
+	return job
+}
+
+func TestMaxRetainedStderr(t *testing.T) {
+	defer SetMaxRetainedStderr(defaultMaxRetainedStderr)
+	// Leaving room for the stderr of earlier tests' jobs which haven't been
+	// reaped
+	SetMaxRetainedStderr(0)
+	max := RetainedStderr() + 3*stderrTailSize
+	SetMaxRetainedStderr(max)
+
+	var jobs []*CompressionJob
+	for i := 0; i < 20; i++ {
+		jobs = append(jobs, failingDecompress(t))
+		assert.True(t, RetainedStderr() <= max, "%d retained", RetainedStderr())
+	}
+	assert.True(t, RetainedStderr() > 0)
+
+	// The latest jobs keep everything, the first only the start of it
+	var corruptErr CorruptInputError
+	assert.True(t, errors.As(jobs[19].Err(), &corruptErr))
+	assert.True(t, strings.HasSuffix(corruptErr.Stderr, "line 199"), corruptErr.Stderr)
+	assert.True(t, errors.As(jobs[0].Err(), &corruptErr))
+	assert.True(t, strings.HasSuffix(corruptErr.Stderr, " ..."), corruptErr.Stderr)
+	assert.True(t, len(corruptErr.Stderr) <= stderrDroppedSize+4)
+
+	// Lowering the cap applies straight away
+	SetMaxRetainedStderr(max - 2*stderrTailSize)
+	assert.True(t, RetainedStderr() <= max-2*stderrTailSize)
+	assert.True(t, errors.Is(jobs[19].Err(), ErrCorruptInput))
+}
+
+func TestRetainedStderrReleased(t *testing.T) {
+	defer SetMaxRetainedStderr(defaultMaxRetainedStderr)
+	SetMaxRetainedStderr(0)
+	baseline := RetainedStderr()
+
+	// Without a cap, finished jobs' stderr isn't held or counted
+	jobs := make([]*CompressionJob, 10)
+	for i := range jobs {
+		jobs[i] = failingDecompress(t)
+	}
+	assert.Equal(t, baseline, RetainedStderr())
+	assert.True(t, errors.Is(jobs[0].Err(), ErrCorruptInput))
+
+	// With one, it is held until the cap is removed
+	SetMaxRetainedStderr(1 << 30)
+	for i := range jobs {
+		jobs[i] = failingDecompress(t)
+	}
+	assert.True(t, RetainedStderr() >= baseline+10*stderrTailSize)
+	SetMaxRetainedStderr(0)
+	assert.Equal(t, baseline, RetainedStderr())
+	assert.True(t, errors.Is(jobs[9].Err(), ErrCorruptInput))
+}
@@ -0,0 +1,216 @@
+package extcompress
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Which queue a spawn waits in when the process limit is reached. Queues are
+// served highest first, and in order of arrival within a class.
+type Priority int
+
+const (
+	// Not set, leaving it to the defaults, and failing them PriorityNormal
+	PriorityUnset Priority = iota
+	// Ordinary work
+	PriorityNormal
+	// Interactive work, started ahead of anything else waiting
+	PriorityHigh
+	// Bulk background work, started once nothing else is waiting
+	PriorityLow
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityHigh:
+		return "high"
+	case PriorityLow:
+		return "low"
+	}
+	return "normal"
+}
+
+// Classes in the order they are served.
+var priorityOrder = [...]Priority{PriorityHigh, PriorityNormal, PriorityLow}
+
+// Limits how many tools the package runs at once, across every handler.
+// Spawns beyond the limit wait for a running tool to be reaped.
+//
+// An operation which runs more than one tool at a time needs a limit of at
+// least that many, or it will wait on itself.
+type ProcessLimit struct {
+	// Maximum number of tools running at once. 0 (the default) means no
+	// limit.
+	Max int
+	// How long a spawn waits before it is moved up a class, so low priority
+	// work isn't starved by a steady stream of higher. Zero means 30
+	// seconds; negative disables promotion.
+	PromoteAfter time.Duration
+}
+
+const defaultPromoteAfter = 30 * time.Second
+
+func (l ProcessLimit) promoteAfter() time.Duration {
+	if l.PromoteAfter == 0 {
+		return defaultPromoteAfter
+	}
+	return l.PromoteAfter
+}
+
+// A spawn waiting for a slot. ready is closed once it has one.
+type schedWaiter struct {
+	ready    chan struct{}
+	queued   time.Time
+	priority Priority
+}
+
+// Hands out slots under the process limit to waiting spawns.
+type processScheduler struct {
+	mtx     sync.Mutex
+	limit   ProcessLimit
+	running int
+	queues  map[Priority][]*schedWaiter
+	// Overridden by tests
+	now func() time.Time
+}
+
+var scheduler = &processScheduler{queues: map[Priority][]*schedWaiter{}, now: time.Now}
+
+// Sets the limit on tools running at once, and how waiting work is promoted.
+// Raising the limit starts waiting spawns straight away; lowering it lets
+// running tools finish.
+func SetProcessLimit(l ProcessLimit) {
+	scheduler.setLimit(l)
+}
+
+// Returns how many spawns of priority p are waiting for a slot, counting
+// promoted spawns in the class they were promoted to.
+func QueueDepth(p Priority) int {
+	scheduler.mtx.Lock()
+	defer scheduler.mtx.Unlock()
+	scheduler.promote()
+	return len(scheduler.queues[p])
+}
+
+func (c Filter) WithPriority(p Priority) ExternalHandler {
+	return c.WithOptions(Options{Priority: p})
+}
+
+func (s *processScheduler) setLimit(l ProcessLimit) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.limit = l
+	s.dispatch()
+}
+
+// Waits for a slot for a spawn of priority p, and returns the function which
+// gives it back. Fails if ctx is done first.
+func (s *processScheduler) acquire(ctx context.Context, p Priority) (func(), error) {
+	if p == PriorityUnset {
+		p = PriorityNormal
+	}
+	s.mtx.Lock()
+	if s.hasSlot() && s.waiting() == 0 {
+		s.running++
+		s.mtx.Unlock()
+		return s.releaser(), nil
+	}
+	// Promote first, so work already starved is ahead of this
+	s.promote()
+	w := &schedWaiter{ready: make(chan struct{}), queued: s.now(), priority: p}
+	s.queues[p] = append(s.queues[p], w)
+	s.mtx.Unlock()
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	select {
+	case <-w.ready:
+		return s.releaser(), nil
+	case <-ctx.Done():
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	select {
+	case <-w.ready:
+		// Given a slot while giving up, so pass it on
+		s.running--
+		s.dispatch()
+	default:
+		s.remove(w)
+	}
+	return nil, ctx.Err()
+}
+
+// Returns a function which frees a slot, once however often it is called.
+func (s *processScheduler) releaser() func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mtx.Lock()
+			defer s.mtx.Unlock()
+			s.running--
+			s.dispatch()
+		})
+	}
+}
+
+func (s *processScheduler) hasSlot() bool {
+	return s.limit.Max <= 0 || s.running < s.limit.Max
+}
+
+func (s *processScheduler) waiting() int {
+	n := 0
+	for _, q := range s.queues {
+		n += len(q)
+	}
+	return n
+}
+
+// Drops w from its queue.
+func (s *processScheduler) remove(w *schedWaiter) {
+	q := s.queues[w.priority]
+	for i := range q {
+		if q[i] == w {
+			s.queues[w.priority] = append(q[:i:i], q[i+1:]...)
+			return
+		}
+	}
+}
+
+// Starts waiting spawns while there are free slots, highest class first.
+// Called with mtx held.
+func (s *processScheduler) dispatch() {
+	s.promote()
+	for _, p := range priorityOrder {
+		for len(s.queues[p]) > 0 && s.hasSlot() {
+			w := s.queues[p][0]
+			s.queues[p] = s.queues[p][1:]
+			s.running++
+			close(w.ready)
+		}
+	}
+}
+
+// Moves spawns which have waited too long to the back of the class above,
+// where they must wait again before moving further. Called with mtx held.
+func (s *processScheduler) promote() {
+	after := s.limit.promoteAfter()
+	if after < 0 {
+		return
+	}
+	now := s.now()
+	for i := len(priorityOrder) - 1; i > 0; i-- {
+		from, to := priorityOrder[i], priorityOrder[i-1]
+		q := s.queues[from]
+		for len(q) > 0 && now.Sub(q[0].queued) >= after {
+			w := q[0]
+			q = q[1:]
+			w.priority, w.queued = to, now
+			s.queues[to] = append(s.queues[to], w)
+		}
+		s.queues[from] = q
+	}
+}
@@ -0,0 +1,168 @@
+package extcompress
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestScheduler(l ProcessLimit) *processScheduler {
+	return &processScheduler{limit: l, queues: map[Priority][]*schedWaiter{}, now: time.Now}
+}
+
+func setProcessLimit(t *testing.T, l ProcessLimit) {
+	SetProcessLimit(l)
+	t.Cleanup(func() { SetProcessLimit(ProcessLimit{}) })
+}
+
+// Queues a spawn on s and records its name in order once it gets a slot,
+// freeing the slot straight away. Returns once it is queued.
+func queueSpawn(t *testing.T, s *processScheduler, p Priority, name string, order *[]string, mtx *sync.Mutex, wg *sync.WaitGroup) {
+	before := len(s.queuesSnapshot()[p])
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		release, err := s.acquire(context.Background(), p)
+		assert.Nil(t, err)
+		mtx.Lock()
+		*order = append(*order, name)
+		mtx.Unlock()
+		release()
+	}()
+	assert.Eventually(t, func() bool { return len(s.queuesSnapshot()[p]) > before }, time.Second, time.Millisecond)
+}
+
+func (s *processScheduler) queuesSnapshot() map[Priority][]*schedWaiter {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	snapshot := map[Priority][]*schedWaiter{}
+	for p, q := range s.queues {
+		snapshot[p] = append([]*schedWaiter(nil), q...)
+	}
+	return snapshot
+}
+
+func TestSchedulerPriorityOrder(t *testing.T) {
+	s := newTestScheduler(ProcessLimit{Max: 1, PromoteAfter: -1})
+	hold, err := s.acquire(context.Background(), PriorityNormal)
+	assert.Nil(t, err)
+
+	var order []string
+	var mtx sync.Mutex
+	var wg sync.WaitGroup
+	queueSpawn(t, s, PriorityLow, "low1", &order, &mtx, &wg)
+	queueSpawn(t, s, PriorityNormal, "normal1", &order, &mtx, &wg)
+	queueSpawn(t, s, PriorityLow, "low2", &order, &mtx, &wg)
+	queueSpawn(t, s, PriorityHigh, "high1", &order, &mtx, &wg)
+	queueSpawn(t, s, PriorityNormal, "normal2", &order, &mtx, &wg)
+	queueSpawn(t, s, PriorityHigh, "high2", &order, &mtx, &wg)
+
+	hold()
+	wg.Wait()
+	assert.Equal(t, []string{"high1", "high2", "normal1", "normal2", "low1", "low2"}, order)
+	assert.Zero(t, s.running)
+}
+
+func TestSchedulerPromotesStarvedWork(t *testing.T) {
+	s := newTestScheduler(ProcessLimit{Max: 1, PromoteAfter: time.Minute})
+	now := time.Now()
+	// Only read with mtx held, so changed under it
+	s.now = func() time.Time { return now }
+	hold, err := s.acquire(context.Background(), PriorityNormal)
+	assert.Nil(t, err)
+
+	var order []string
+	var mtx sync.Mutex
+	var wg sync.WaitGroup
+	advance := func(d time.Duration) {
+		s.mtx.Lock()
+		now = now.Add(d)
+		s.mtx.Unlock()
+	}
+	queueSpawn(t, s, PriorityLow, "low", &order, &mtx, &wg)
+	advance(30 * time.Second)
+	queueSpawn(t, s, PriorityNormal, "normal", &order, &mtx, &wg)
+
+	// The low spawn has waited long enough to move up behind the normal
+	// one, ahead of later normal work, and a high spawn still goes first
+	advance(30 * time.Second)
+	queueSpawn(t, s, PriorityHigh, "high", &order, &mtx, &wg)
+	queueSpawn(t, s, PriorityNormal, "late", &order, &mtx, &wg)
+	hold()
+	wg.Wait()
+	assert.Equal(t, []string{"high", "normal", "low", "late"}, order)
+}
+
+func TestSchedulerCancelledWait(t *testing.T) {
+	s := newTestScheduler(ProcessLimit{Max: 1})
+	hold, err := s.acquire(context.Background(), PriorityNormal)
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	release, err := s.acquire(ctx, PriorityHigh)
+	assert.Nil(t, release)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Empty(t, s.queuesSnapshot()[PriorityHigh])
+
+	hold()
+	assert.Zero(t, s.running)
+}
+
+func TestProcessLimitQueueDepth(t *testing.T) {
+	setProcessLimit(t, ProcessLimit{Max: 1})
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	// Holds the only slot until its input ends
+	rd, wr := io.Pipe()
+	blocker, err := h.CompressStream(rd)
+	assert.Nil(t, err)
+
+	queued := make(chan CompressionProcess, 1)
+	go func() {
+		proc, err := h.WithPriority(PriorityLow).CompressStream(strings.NewReader(data))
+		assert.Nil(t, err)
+		queued <- proc
+	}()
+	assert.Eventually(t, func() bool { return QueueDepth(PriorityLow) == 1 }, time.Second, time.Millisecond)
+	assert.Zero(t, QueueDepth(PriorityHigh))
+	assert.Zero(t, QueueDepth(PriorityNormal))
+
+	wr.Close()
+	_, err = ioutil.ReadAll(blocker)
+	assert.Nil(t, err)
+	assert.Zero(t, blocker.Result())
+
+	proc := <-queued
+	assert.Zero(t, QueueDepth(PriorityLow))
+	_, err = ioutil.ReadAll(proc)
+	assert.Nil(t, err)
+	assert.Zero(t, proc.Result())
+}
+
+func TestProcessLimitCancelledSpawn(t *testing.T) {
+	setProcessLimit(t, ProcessLimit{Max: 1})
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	rd, wr := io.Pipe()
+	blocker, err := h.CompressStream(rd)
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = h.WithContext(ctx).CompressStream(strings.NewReader(data))
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	wr.Close()
+	ioutil.ReadAll(blocker)
+	assert.Zero(t, blocker.Result())
+}
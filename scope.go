@@ -0,0 +1,148 @@
+package extcompress
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Command which runs tools in a transient systemd scope.
+const systemdRunCommand = "systemd-run"
+
+// Where systemd keeps its runtime state, which only exists while it is the
+// init system (see sd_booted(3)). Replaced in tests.
+var systemdRuntimeDir = "/run/systemd/system"
+
+// Matched by the error for a handler with Options.SystemdScope when its jobs
+// can't be run in a scope.
+var ErrSystemdUnavailable = errors.New("extcompress: systemd scopes unavailable")
+
+// Describes why a handler's jobs can't be run in a systemd scope. Wraps
+// ErrSystemdUnavailable.
+type SystemdUnavailableError struct {
+	Command string
+	Reason  string
+}
+
+func (r SystemdUnavailableError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", r.Command, ErrSystemdUnavailable.Error(), r.Reason)
+}
+
+func (r SystemdUnavailableError) Unwrap() error {
+	return ErrSystemdUnavailable
+}
+
+type systemdProbe struct {
+	path   string
+	reason string
+}
+
+var (
+	systemdProbeMtx sync.Mutex
+	// Whether systemd-run can be used, for the PATH it was looked for on
+	systemdProbes = map[string]systemdProbe{}
+)
+
+// Returns the systemd-run binary, or why scopes can't be used, checking
+// the first time for each PATH.
+func probeSystemdRun() (string, string) {
+	key := os.Getenv("PATH")
+	systemdProbeMtx.Lock()
+	defer systemdProbeMtx.Unlock()
+	if probe, ok := systemdProbes[key]; ok {
+		return probe.path, probe.reason
+	}
+	var probe systemdProbe
+	if path, err := exec.LookPath(systemdRunCommand); err != nil {
+		probe.reason = systemdRunCommand + " not found"
+	} else if st, err := os.Stat(systemdRuntimeDir); err != nil || !st.IsDir() {
+		probe.reason = "systemd is not running"
+	} else {
+		probe.path = path
+	}
+	systemdProbes[key] = probe
+	return probe.path, probe.reason
+}
+
+// Returns a copy of the handler whose jobs each run in their own transient
+// systemd scope with the given unit properties, e.g. CPUQuota or MemoryMax,
+// for resource accounting and limits.
+func (c Filter) WithSystemdScope(props map[string]string) ExternalHandler {
+	if props == nil {
+		props = map[string]string{}
+	}
+	return c.WithOptions(Options{SystemdScope: props})
+}
+
+// Returns the systemd-run arguments which come before the tool's path, or
+// nil if jobs don't run in a scope.
+func (c Filter) scopeArgs() []string {
+	if c.opts.SystemdScope == nil {
+		return nil
+	}
+	keys := make([]string, 0, len(c.opts.SystemdScope))
+	for k := range c.opts.SystemdScope {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	args := []string{"--scope", "--quiet"}
+	for _, k := range keys {
+		args = append(args, "-p", k+"="+c.opts.SystemdScope[k])
+	}
+	return append(args, "--")
+}
+
+// Has cmd run under systemd-run in a scope, if the options ask for one.
+// systemd-run --scope execs the tool in its place, so the process started
+// is the tool itself, in the process group set up for it, and signals and
+// exit statuses need no translating.
+func (c Filter) wrapScope(cmd *exec.Cmd) {
+	scope := c.scopeArgs()
+	if scope == nil {
+		return
+	}
+	run, _ := probeSystemdRun()
+	if run == "" {
+		// Fails to start, if validation didn't stop it first
+		run = systemdRunCommand
+	}
+	args := append([]string{systemdRunCommand}, scope...)
+	args = append(args, cmd.Path)
+	cmd.Args = append(args, cmd.Args[1:]...)
+	cmd.Path = run
+}
+
+// Returns the tool's arguments from cmd, without any wrapper it runs under.
+func (c Filter) toolArgs(cmd *exec.Cmd) []string {
+	if scope := c.scopeArgs(); scope != nil {
+		return cmd.Args[len(scope)+2:]
+	}
+	return cmd.Args[1:]
+}
+
+// Checks the scope's properties, and that scopes can be used here.
+func (c Filter) validateSystemdScope(o Options) error {
+	if o.SystemdScope == nil {
+		return nil
+	}
+	for k, v := range o.SystemdScope {
+		if k == "" || strings.Contains(k, "=") {
+			return InvalidOption{c.Command, "systemd scope", fmt.Sprintf("invalid property name %q", k)}
+		}
+		// Single line settings, so no control characters at all
+		if reason := controlReason(k, ""); reason != "" {
+			return InvalidArgumentError{c.Command, "systemd scope property", k, reason}
+		}
+		if reason := controlReason(v, ""); reason != "" {
+			return InvalidArgumentError{c.Command, "systemd scope property " + k, v, reason}
+		}
+	}
+	if _, reason := probeSystemdRun(); reason != "" {
+		return SystemdUnavailableError{c.Command, reason}
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package extcompress
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Stands in for systemd-run --scope: records its arguments, then execs the
+// command after "--" in its place, as the real one does once the scope is
+// set up.
+const fakeSystemdRun = `#!/bin/sh
+echo "$@" >> "$(dirname "$0")/systemd-run.log"
+while [ "$1" != "--" ]; do shift; done
+shift
+exec "$@"
+`
+
+func forgetSystemdProbes(t *testing.T) {
+	systemdProbeMtx.Lock()
+	systemdProbes = map[string]systemdProbe{}
+	systemdProbeMtx.Unlock()
+	t.Cleanup(func() {
+		systemdProbeMtx.Lock()
+		systemdProbes = map[string]systemdProbe{}
+		systemdProbeMtx.Unlock()
+	})
+}
+
+// Puts the stand-in systemd-run on PATH alongside tools, and has systemd
+// appear to be running. Returns the file the stand-in logs its runs to.
+func fakeSystemd(t *testing.T, tools ...string) string {
+	dir := pathWith(t, tools...)
+	assert.Nil(t, ioutil.WriteFile(path.Join(dir, "systemd-run"), []byte(fakeSystemdRun), 0755))
+	old := systemdRuntimeDir
+	systemdRuntimeDir = dir
+	t.Cleanup(func() { systemdRuntimeDir = old })
+	forgetSystemdProbes(t)
+	return path.Join(dir, "systemd-run.log")
+}
+
+func TestSystemdScopePlan(t *testing.T) {
+	fakeSystemd(t, "gzip", "sh", "dirname")
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	props := map[string]string{"MemoryMax": "64M", "CPUQuota": "50%"}
+	h = h.WithSystemdScope(props)
+	props["MemoryMax"] = "1G"
+
+	plan := h.Plan()
+	assert.Nil(t, plan.Err)
+	assert.Equal(t, map[string]string{"MemoryMax": "64M", "CPUQuota": "50%"}, plan.Options.SystemdScope)
+	assert.Equal(t, []string{"systemd-run", "--scope", "--quiet", "-p", "CPUQuota=50%", "-p", "MemoryMax=64M", "--",
+		"gzip", "-c"}, plan.CompressStream)
+	assert.Contains(t, h.Provenance().Options, "with: SystemdScope")
+
+	gz, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"systemd-run", "--scope", "--quiet", "--", "gzip", "-c"},
+		gz.WithSystemdScope(nil).Plan().CompressStream)
+	assert.Equal(t, []string{"gzip", "-c"}, gz.Plan().CompressStream)
+}
+
+func TestSystemdScopeRoundTrip(t *testing.T) {
+	logFile := fakeSystemd(t, "gzip", "sh", "dirname")
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	h = h.WithSystemdScope(map[string]string{"MemoryMax": "64M"})
+
+	original := seekableTestData(100000)
+	proc, err := h.CompressStream(bytes.NewReader(original))
+	assert.Nil(t, err)
+	compressed, err := ioutil.ReadAll(proc)
+	assert.Nil(t, err)
+	assert.Nil(t, processErr(proc))
+	proc, err = h.DecompressStream(bytes.NewReader(compressed))
+	assert.Nil(t, err)
+	out, err := ioutil.ReadAll(proc)
+	assert.Nil(t, err)
+	assert.Nil(t, processErr(proc))
+	assert.Equal(t, original, out)
+
+	runs, err := ioutil.ReadFile(logFile)
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(string(runs)), "\n")
+	assert.Equal(t, 2, len(lines))
+	for _, line := range lines {
+		assert.True(t, strings.HasPrefix(line, "--scope --quiet -p MemoryMax=64M -- "), line)
+	}
+}
+
+func TestSystemdScopeSignals(t *testing.T) {
+	fakeSystemd(t, "sleep", "sh", "dirname")
+	h := NewFilter("sleep", CompressFlags("60")).WithSystemdScope(map[string]string{"CPUQuota": "10%"})
+
+	proc, err := h.CompressStream(strings.NewReader(""))
+	assert.Nil(t, err)
+	job := proc.(*CompressionJob)
+	assert.Equal(t, "sleep 60", job.command)
+
+	// The process started is the tool itself, leading its own group, once
+	// systemd-run has handed over to it
+	pid := job.cmd.Process.Pid
+	deadline := time.Now().Add(5 * time.Second)
+	var p procInfo
+	for time.Now().Before(deadline) {
+		if p, err = readProcess(pid); err == nil && len(p.argv) > 0 && path.Base(p.argv[0]) == "sleep" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if errors.Is(err, ErrNotSupported) {
+		t.Skip("processes can't be inspected here")
+	}
+	assert.Nil(t, err)
+	assert.Equal(t, pid, p.pgid)
+
+	// So closing the job stops the tool promptly
+	started := time.Now()
+	assert.Nil(t, proc.Close())
+	assert.True(t, time.Since(started) < 5*time.Second)
+	assert.False(t, processAlive(pid))
+}
+
+func TestSystemdScopeUnavailable(t *testing.T) {
+	fakeSystemd(t, "gzip")
+	systemdRuntimeDir = path.Join(systemdRuntimeDir, "missing")
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	h = h.WithSystemdScope(nil)
+
+	err = h.Plan().Err
+	assert.True(t, errors.Is(err, ErrSystemdUnavailable))
+	assert.Equal(t, SystemdUnavailableError{"gzip", "systemd is not running"}, err)
+	_, err = h.CompressStream(bytes.NewReader([]byte(data)))
+	assert.True(t, errors.Is(err, ErrSystemdUnavailable))
+
+	forgetSystemdProbes(t)
+	pathWith(t, "gzip")
+	assert.Equal(t, SystemdUnavailableError{"gzip", "systemd-run not found"}, h.Plan().Err)
+}
+
+func TestSystemdScopeInvalidProperty(t *testing.T) {
+	fakeSystemd(t, "gzip")
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	assert.IsType(t, InvalidOption{}, h.WithSystemdScope(map[string]string{"A=B": "1"}).Plan().Err)
+	assert.IsType(t, InvalidOption{}, h.WithSystemdScope(map[string]string{"": "1"}).Plan().Err)
+	err = h.WithSystemdScope(map[string]string{"MemoryMax": "1G\n-p Delegate=yes"}).Plan().Err
+	assert.True(t, errors.Is(err, ErrInvalidArgument))
+}
+
+func TestSystemdScopeLive(t *testing.T) {
+	forgetSystemdProbes(t)
+	if _, reason := probeSystemdRun(); reason != "" {
+		t.Skip(reason)
+	}
+	if os.Geteuid() != 0 {
+		t.Skip("transient scopes need privileges")
+	}
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	h = h.WithSystemdScope(map[string]string{"CPUAccounting": "yes"})
+	compressed := compressBytes(t, h, []byte(data))
+	proc, err := h.DecompressStream(bytes.NewReader(compressed))
+	assert.Nil(t, err)
+	out, err := ioutil.ReadAll(proc)
+	assert.Nil(t, err)
+	assert.Nil(t, processErr(proc))
+	assert.Equal(t, data, string(out))
+}
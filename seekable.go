@@ -0,0 +1,431 @@
+package extcompress
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// Returned by operations which the handler's format cannot support.
+var ErrNotSupported = errors.New("operation not supported for this format")
+
+// Returned when a format index could not be parsed from the file.
+var ErrBadIndex = errors.New("file index is missing or corrupt")
+
+// A span of a compressed file which can be decompressed independently of the
+// rest of the file.
+type seekBlock struct {
+	compressedOffset int64
+	compressedSize   int64
+
+	uncompressedOffset int64
+	uncompressedSize   int64
+
+	// Builds a standalone compressed stream for this block from the file.
+	extract func(f io.ReaderAt) io.Reader
+}
+
+// Implements io.ReaderAt over a compressed file by decompressing only the
+// blocks which cover the requested range. The block index is read once when
+// the reader is opened.
+type blockReaderAt struct {
+	handler  ExternalHandler
+	filePath string
+	blocks   []seekBlock
+	size     int64
+}
+
+// Returns the uncompressed size of the file.
+func (r *blockReaderAt) Size() int64 {
+	return r.size
+}
+
+func (r *blockReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("extcompress: negative offset")
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+
+	f, err := os.Open(r.filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	// Find the first block containing the offset
+	i := sort.Search(len(r.blocks), func(i int) bool {
+		b := r.blocks[i]
+		return b.uncompressedOffset+b.uncompressedSize > off
+	})
+
+	n := 0
+	for ; i < len(r.blocks) && n < len(p); i++ {
+		b := r.blocks[i]
+		if b.uncompressedSize == 0 {
+			continue
+		}
+		read, err := r.readBlock(f, b, p[n:], off+int64(n)-b.uncompressedOffset)
+		n += read
+		if err != nil {
+			return n, err
+		}
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Spawns a decompressor for a single block, skips to skip and fills as much of
+// p as the block can provide.
+func (r *blockReaderAt) readBlock(f io.ReaderAt, b seekBlock, p []byte, skip int64) (int, error) {
+	want := b.uncompressedSize - skip
+	if want > int64(len(p)) {
+		want = int64(len(p))
+	}
+
+	job, err := r.handler.DecompressStream(b.extract(f))
+	if err != nil {
+		return 0, err
+	}
+	defer job.Close()
+
+	if _, err := io.CopyN(ioutil.Discard, job, skip); err != nil {
+		return 0, unexpectedEOF(err)
+	}
+
+	n, err := io.ReadFull(job, p[:want])
+	return n, unexpectedEOF(err)
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func newBlockReaderAt(handler ExternalHandler, filePath string, blocks []seekBlock) *blockReaderAt {
+	var size int64
+	for i := range blocks {
+		blocks[i].uncompressedOffset = size
+		size += blocks[i].uncompressedSize
+	}
+	return &blockReaderAt{
+		handler:  handler,
+		filePath: filePath,
+		blocks:   blocks,
+		size:     size,
+	}
+}
+
+// Opens a random-access reader over the uncompressed contents of filePath.
+// Only formats which carry a block index (xz, and zstd in the seekable
+// format) are supported, all others return ErrNotSupported.
+func (c Filter) OpenReaderAt(filePath string) (io.ReaderAt, error) {
+	var indexer func(f *os.File, size int64) ([]seekBlock, error)
+	switch c.Command {
+	case "xz":
+		indexer = xzSeekBlocks
+	case "zstd":
+		indexer = zstdSeekBlocks
+	default:
+		return nil, ErrNotSupported
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	blocks, err := indexer(f, st.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	return newBlockReaderAt(c, filePath, blocks), nil
+}
+
+// xz format constants. See https://tukaani.org/xz/xz-file-format.txt
+const (
+	xzHeaderSize = 12
+	xzFooterSize = 12
+)
+
+var (
+	xzHeaderMagic = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	xzFooterMagic = []byte{'Y', 'Z'}
+)
+
+type xzBlock struct {
+	offset           int64
+	unpaddedSize     int64
+	uncompressedSize int64
+}
+
+// Padded size of the block as stored in the file.
+func (b xzBlock) size() int64 {
+	return (b.unpaddedSize + 3) &^ 3
+}
+
+type xzStream struct {
+	offset    int64
+	header    []byte
+	blocks    []xzBlock
+	indexSize int64
+}
+
+// Walks the file backwards from the end, reading the index of every
+// concatenated stream. Streams are returned in file order.
+func xzStreams(f io.ReaderAt, size int64) ([]xzStream, error) {
+	var streams []xzStream
+
+	end := size
+	for end > 0 {
+		// Skip stream padding
+		pad := make([]byte, 4)
+		for end >= 4 {
+			if _, err := f.ReadAt(pad, end-4); err != nil {
+				return nil, err
+			}
+			if !bytes.Equal(pad, []byte{0, 0, 0, 0}) {
+				break
+			}
+			end -= 4
+		}
+		if end == 0 {
+			break
+		}
+		if end < xzHeaderSize+xzFooterSize {
+			return nil, ErrBadIndex
+		}
+
+		footer := make([]byte, xzFooterSize)
+		if _, err := f.ReadAt(footer, end-xzFooterSize); err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(footer[10:], xzFooterMagic) ||
+			crc32.ChecksumIEEE(footer[4:10]) != binary.LittleEndian.Uint32(footer[0:4]) {
+			return nil, ErrBadIndex
+		}
+
+		indexSize := (int64(binary.LittleEndian.Uint32(footer[4:8])) + 1) * 4
+		indexStart := end - xzFooterSize - indexSize
+		if indexStart < xzHeaderSize {
+			return nil, ErrBadIndex
+		}
+
+		index := make([]byte, indexSize)
+		if _, err := f.ReadAt(index, indexStart); err != nil {
+			return nil, err
+		}
+		records, err := parseXzIndex(index)
+		if err != nil {
+			return nil, err
+		}
+
+		var blocksSize int64
+		for _, b := range records {
+			blocksSize += b.size()
+		}
+
+		stream := xzStream{
+			offset:    indexStart - blocksSize - xzHeaderSize,
+			indexSize: indexSize,
+		}
+		if stream.offset < 0 {
+			return nil, ErrBadIndex
+		}
+
+		stream.header = make([]byte, xzHeaderSize)
+		if _, err := f.ReadAt(stream.header, stream.offset); err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(stream.header[:6], xzHeaderMagic) ||
+			!bytes.Equal(stream.header[6:8], footer[8:10]) {
+			return nil, ErrBadIndex
+		}
+
+		offset := stream.offset + xzHeaderSize
+		for _, b := range records {
+			b.offset = offset
+			offset += b.size()
+			stream.blocks = append(stream.blocks, b)
+		}
+
+		streams = append([]xzStream{stream}, streams...)
+		end = stream.offset
+	}
+
+	return streams, nil
+}
+
+// Parses the records from an xz index field.
+func parseXzIndex(index []byte) ([]xzBlock, error) {
+	if len(index) < 8 || index[0] != 0x00 {
+		return nil, ErrBadIndex
+	}
+
+	body := index[:len(index)-4]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(index[len(index)-4:]) {
+		return nil, ErrBadIndex
+	}
+
+	rd := bytes.NewReader(body[1:])
+	count, err := binary.ReadUvarint(rd)
+	// Each record takes at least two bytes, so a larger count is corrupt
+	if err != nil || count > uint64(len(body)/2) {
+		return nil, ErrBadIndex
+	}
+
+	blocks := make([]xzBlock, 0, count)
+	for i := uint64(0); i < count; i++ {
+		unpadded, err := binary.ReadUvarint(rd)
+		if err != nil {
+			return nil, ErrBadIndex
+		}
+		uncompressed, err := binary.ReadUvarint(rd)
+		if err != nil {
+			return nil, ErrBadIndex
+		}
+		blocks = append(blocks, xzBlock{
+			unpaddedSize:     int64(unpadded),
+			uncompressedSize: int64(uncompressed),
+		})
+	}
+
+	return blocks, nil
+}
+
+// Builds a complete single-block xz stream around an existing block, so it can
+// be handed to xz on its own.
+func xzSingleBlockStream(f io.ReaderAt, header []byte, b xzBlock) io.Reader {
+	var index bytes.Buffer
+	var varint [binary.MaxVarintLen64]byte
+	index.WriteByte(0x00)
+	index.Write(varint[:binary.PutUvarint(varint[:], 1)])
+	index.Write(varint[:binary.PutUvarint(varint[:], uint64(b.unpaddedSize))])
+	index.Write(varint[:binary.PutUvarint(varint[:], uint64(b.uncompressedSize))])
+	for index.Len()%4 != 0 {
+		index.WriteByte(0x00)
+	}
+	binary.Write(&index, binary.LittleEndian, crc32.ChecksumIEEE(index.Bytes()))
+
+	footer := make([]byte, xzFooterSize)
+	binary.LittleEndian.PutUint32(footer[4:8], uint32(index.Len()/4-1))
+	copy(footer[8:10], header[6:8])
+	binary.LittleEndian.PutUint32(footer[0:4], crc32.ChecksumIEEE(footer[4:10]))
+	copy(footer[10:], xzFooterMagic)
+
+	return io.MultiReader(
+		bytes.NewReader(header),
+		io.NewSectionReader(f, b.offset, b.size()),
+		&index,
+		bytes.NewReader(footer),
+	)
+}
+
+func xzSeekBlocks(f *os.File, size int64) ([]seekBlock, error) {
+	streams, err := xzStreams(f, size)
+	if err != nil {
+		return nil, err
+	}
+
+	var blocks []seekBlock
+	for _, s := range streams {
+		header := s.header
+		for _, b := range s.blocks {
+			b := b
+			blocks = append(blocks, seekBlock{
+				compressedOffset: b.offset,
+				compressedSize:   b.size(),
+				uncompressedSize: b.uncompressedSize,
+				extract: func(f io.ReaderAt) io.Reader {
+					return xzSingleBlockStream(f, header, b)
+				},
+			})
+		}
+	}
+	return blocks, nil
+}
+
+// zstd seekable format constants. See the zstd contrib/seekable_format spec.
+const (
+	zstdSkippableMagic   = 0x184D2A5E
+	zstdSeekableMagic    = 0x8F92EAB1
+	zstdSeekFooterSize   = 9
+	zstdSkippableHdrSize = 8
+)
+
+func zstdSeekBlocks(f *os.File, size int64) ([]seekBlock, error) {
+	if size < zstdSkippableHdrSize+zstdSeekFooterSize {
+		return nil, ErrBadIndex
+	}
+
+	footer := make([]byte, zstdSeekFooterSize)
+	if _, err := f.ReadAt(footer, size-zstdSeekFooterSize); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(footer[5:9]) != zstdSeekableMagic {
+		return nil, ErrBadIndex
+	}
+
+	numFrames := int64(binary.LittleEndian.Uint32(footer[0:4]))
+	entrySize := int64(8)
+	if footer[4]&0x80 != 0 {
+		entrySize = 12
+	}
+
+	tableSize := numFrames*entrySize + zstdSeekFooterSize
+	tableStart := size - tableSize - zstdSkippableHdrSize
+	if tableStart < 0 {
+		return nil, ErrBadIndex
+	}
+
+	table := make([]byte, tableSize+zstdSkippableHdrSize)
+	if _, err := f.ReadAt(table, tableStart); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(table[0:4]) != zstdSkippableMagic ||
+		int64(binary.LittleEndian.Uint32(table[4:8])) != tableSize {
+		return nil, ErrBadIndex
+	}
+
+	blocks := make([]seekBlock, 0, numFrames)
+	var offset int64
+	entries := table[zstdSkippableHdrSize:]
+	for i := int64(0); i < numFrames; i++ {
+		entry := entries[i*entrySize:]
+		b := seekBlock{
+			compressedOffset: offset,
+			compressedSize:   int64(binary.LittleEndian.Uint32(entry[0:4])),
+			uncompressedSize: int64(binary.LittleEndian.Uint32(entry[4:8])),
+		}
+		offset += b.compressedSize
+		if offset > tableStart {
+			return nil, ErrBadIndex
+		}
+
+		start, length := b.compressedOffset, b.compressedSize
+		b.extract = func(f io.ReaderAt) io.Reader {
+			return io.NewSectionReader(f, start, length)
+		}
+		blocks = append(blocks, b)
+	}
+
+	return blocks, nil
+}
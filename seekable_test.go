@@ -0,0 +1,151 @@
+package extcompress
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Generates non-repeating but compressible test data.
+func seekableTestData(size int) []byte {
+	var b bytes.Buffer
+	for i := 0; b.Len() < size; i++ {
+		fmt.Fprintf(&b, "line %08d of the seekable test data\n", i)
+	}
+	return b.Bytes()[:size]
+}
+
+func checkRandomReads(t *testing.T, ra io.ReaderAt, original []byte) {
+	offsets := []struct{ off, length int }{
+		{0, 100},
+		{16383, 2},     // Spans a block boundary
+		{20000, 40000}, // Spans several blocks
+		{len(original) - 10, 10},
+		{123457, 1},
+	}
+
+	for _, o := range offsets {
+		buf := make([]byte, o.length)
+		n, err := ra.ReadAt(buf, int64(o.off))
+		assert.Nil(t, err, "offset %d", o.off)
+		assert.Equal(t, o.length, n)
+		assert.Equal(t, original[o.off:o.off+o.length], buf, "offset %d", o.off)
+	}
+
+	// Reading off the end is short with EOF
+	buf := make([]byte, 20)
+	n, err := ra.ReadAt(buf, int64(len(original)-5))
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, original[len(original)-5:], buf[:n])
+}
+
+func TestOpenReaderAtXz(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	original := seekableTestData(200000)
+	filename := path.Join(tmpdir, "multiblock")
+	assert.Nil(t, ioutil.WriteFile(filename, original, os.FileMode(0644)))
+
+	out, err := exec.Command("xz", "--block-size=16384", "-c", filename).Output()
+	assert.Nil(t, err)
+	// Two concatenated streams exercise the multi-stream index walk
+	second, err := exec.Command("xz", "--block-size=16384", "-c", filename).Output()
+	assert.Nil(t, err)
+	assert.Nil(t, ioutil.WriteFile(filename+".xz", append(out, second...), os.FileMode(0644)))
+	original = append(original, original...)
+
+	h, err := GetExternalHandlerFromMimeType("application/x-xz")
+	assert.Nil(t, err)
+
+	ra, err := h.OpenReaderAt(filename + ".xz")
+	assert.Nil(t, err)
+	checkRandomReads(t, ra, original)
+}
+
+func TestOpenReaderAtZstdSeekable(t *testing.T) {
+	if _, err := exec.LookPath("zstd"); err != nil {
+		t.Skip("zstd not available")
+	}
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	original := seekableTestData(200000)
+
+	// Build a seekable archive by hand: independent frames plus a seek table.
+	var archive, table bytes.Buffer
+	var frames uint32
+	for off := 0; off < len(original); off += 16384 {
+		end := off + 16384
+		if end > len(original) {
+			end = len(original)
+		}
+		cmd := exec.Command("zstd", "-q", "-c")
+		cmd.Stdin = bytes.NewReader(original[off:end])
+		frame, err := cmd.Output()
+		assert.Nil(t, err)
+		archive.Write(frame)
+		binary.Write(&table, binary.LittleEndian, uint32(len(frame)))
+		binary.Write(&table, binary.LittleEndian, uint32(end-off))
+		frames++
+	}
+	binary.Write(&archive, binary.LittleEndian, uint32(zstdSkippableMagic))
+	binary.Write(&archive, binary.LittleEndian, uint32(table.Len()+zstdSeekFooterSize))
+	archive.Write(table.Bytes())
+	binary.Write(&archive, binary.LittleEndian, frames)
+	archive.WriteByte(0)
+	binary.Write(&archive, binary.LittleEndian, uint32(zstdSeekableMagic))
+
+	filename := path.Join(tmpdir, "seekable.zst")
+	assert.Nil(t, ioutil.WriteFile(filename, archive.Bytes(), os.FileMode(0644)))
+
+	h, err := GetExternalHandlerFromMimeType("application/zstd")
+	assert.Nil(t, err)
+
+	ra, err := h.OpenReaderAt(filename)
+	assert.Nil(t, err)
+	checkRandomReads(t, ra, original)
+}
+
+func TestOpenReaderAtUnsupported(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	_, err = h.OpenReaderAt(path.Join(tmpdir, "pipechaining"))
+	assert.Equal(t, ErrNotSupported, err)
+
+	// A plain zstd file has no seek table
+	zh, err := GetExternalHandlerFromMimeType("application/zstd")
+	assert.Nil(t, err)
+	_, err = zh.OpenReaderAt(path.Join(tmpdir, "pipechaining"))
+	assert.Equal(t, ErrBadIndex, err)
+}
+
+func TestParseXzIndexBadCount(t *testing.T) {
+	// A well formed index claiming far more records than it holds
+	var index bytes.Buffer
+	var varint [binary.MaxVarintLen64]byte
+	index.WriteByte(0x00)
+	index.Write(varint[:binary.PutUvarint(varint[:], 1<<62)])
+	for index.Len()%4 != 0 {
+		index.WriteByte(0x00)
+	}
+	binary.Write(&index, binary.LittleEndian, crc32.ChecksumIEEE(index.Bytes()))
+
+	blocks, err := parseXzIndex(index.Bytes())
+	assert.Nil(t, blocks)
+	assert.Equal(t, ErrBadIndex, err)
+}
@@ -0,0 +1,157 @@
+package extcompress
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"sort"
+)
+
+// The step of a self-test which failed.
+type SelfTestStage string
+
+const (
+	// Finding the command on PATH
+	SelfTestLookup SelfTestStage = "lookup"
+	// Checking the command's version against the handler's MinVersion
+	SelfTestVersion SelfTestStage = "version"
+	// Compressing the sample
+	SelfTestCompress SelfTestStage = "compress"
+	// Decompressing what the compressor produced
+	SelfTestDecompress SelfTestStage = "decompress"
+	// Comparing the round-tripped data with the sample
+	SelfTestCompare SelfTestStage = "compare"
+)
+
+// Describes a failed self-test. Wraps the error from the failing stage.
+type SelfTestError struct {
+	Command string
+	Stage   SelfTestStage
+	Err     error
+}
+
+func (r SelfTestError) Error() string {
+	return fmt.Sprintf("%s: self-test failed at %s: %v", r.Command, r.Stage, r.Err)
+}
+
+func (r SelfTestError) Unwrap() error {
+	return r.Err
+}
+
+// Returns the data self-tests round-trip: some text, which every format
+// compresses, and every byte value.
+func selfTestSample() []byte {
+	var b bytes.Buffer
+	for i := 0; i < 64; i++ {
+		fmt.Fprintf(&b, "extcompress self-test line %d\n", i)
+	}
+	for i := 0; i < 256; i++ {
+		b.WriteByte(byte(i))
+	}
+	return b.Bytes()
+}
+
+// Checks the handler works before it is relied on: that its command is
+// installed and recent enough, and that a small sample compressed and
+// decompressed through the stream operations comes back unchanged with
+// both runs succeeding. Everything has to finish before ctx is done. The
+// error is a SelfTestError naming the stage which failed.
+func (c Filter) SelfTest(ctx context.Context) error {
+	c.ctx = ctx
+	fail := func(stage SelfTestStage, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			err = ctxErr
+		}
+		return SelfTestError{c.Command, stage, err}
+	}
+
+	if c.err != nil {
+		return fail(SelfTestLookup, c.err)
+	}
+	path, err := exec.LookPath(c.Command)
+	if err != nil {
+		return fail(SelfTestLookup, CommandNotFound{c.Command, err})
+	}
+	if len(c.VersionFlags) > 0 && c.MinVersion != "" {
+		version, err := probeVersion(path, c.VersionFlags)
+		if err == nil && compareVersions(version, c.MinVersion) < 0 {
+			err = fmt.Errorf("version %s is older than %s", version, c.MinVersion)
+		}
+		if err != nil {
+			return fail(SelfTestVersion, err)
+		}
+	}
+
+	sample := selfTestSample()
+	compressed, err := c.selfTestRun(c.CompressStream, sample)
+	if err != nil {
+		return fail(SelfTestCompress, err)
+	}
+	out, err := c.selfTestRun(c.DecompressStream, compressed)
+	if err != nil {
+		return fail(SelfTestDecompress, err)
+	}
+	if !bytes.Equal(out, sample) {
+		return fail(SelfTestCompare, fmt.Errorf("%d bytes came back as %d different ones", len(sample), len(out)))
+	}
+	return nil
+}
+
+// Runs one half of the round trip over input.
+func (c Filter) selfTestRun(start func(rd io.Reader) (CompressionProcess, error), input []byte) ([]byte, error) {
+	proc, err := start(bytes.NewReader(input))
+	if err != nil {
+		return nil, err
+	}
+	out, err := ioutil.ReadAll(proc)
+	if err != nil {
+		proc.Close()
+		return nil, err
+	}
+	if status := statusOf(proc); status != 0 {
+		return nil, ExitStatusError{c.Command, status, proc.ID()}
+	}
+	return out, nil
+}
+
+// How VerifyHandlers checks each handler.
+type VerifyOptions struct {
+	// Runs each handler's SelfTest, rather than only looking for its
+	// command
+	SelfTest bool
+}
+
+// Checks every registered handler can be used, returning an error naming
+// each which can't, or nil if all can. Unlike CheckHandlers nothing is
+// fatal, and with opts.SelfTest the handlers are proven to work within ctx
+// rather than just found on PATH.
+func VerifyHandlers(ctx context.Context, opts VerifyOptions) error {
+	registryMtx.RLock()
+	var names []string
+	filters := map[string]Filter{}
+	for name, f := range filtersMap {
+		names = append(names, name)
+		filters[name] = f
+	}
+	registryMtx.RUnlock()
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		f := filters[name]
+		var err error
+		if opts.SelfTest {
+			err = f.SelfTest(ctx)
+		} else if _, lookErr := exec.LookPath(f.Command); lookErr != nil {
+			err = CommandNotFound{f.Command, lookErr}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,77 @@
+package extcompress
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelfTest(t *testing.T) {
+	ctx := context.Background()
+	for _, mimeType := range []string{"application/gzip", "application/x-bzip2", "application/x-xz", "application/zstd", "text/plain"} {
+		h, err := GetExternalHandlerFromMimeType(mimeType)
+		assert.Nil(t, err)
+		assert.Nil(t, h.SelfTest(ctx), mimeType)
+	}
+
+	// A missing binary
+	err := NewFilter("extcompress-no-such-tool").SelfTest(ctx)
+	var selfErr SelfTestError
+	assert.True(t, errors.As(err, &selfErr))
+	assert.Equal(t, SelfTestLookup, selfErr.Stage)
+	assert.IsType(t, CommandNotFound{}, selfErr.Err)
+
+	// Decompress flags which compress again
+	err = NewFilter("gzip", CompressFlags("-c"), DecompressFlags("-c")).SelfTest(ctx)
+	assert.True(t, errors.As(err, &selfErr))
+	assert.Equal(t, SelfTestCompare, selfErr.Stage)
+
+	// And ones the tool rejects
+	err = NewFilter("gzip", CompressFlags("-c"), DecompressFlags("-d", "--no-such-flag")).SelfTest(ctx)
+	assert.True(t, errors.As(err, &selfErr))
+	assert.Equal(t, SelfTestDecompress, selfErr.Stage)
+	assert.IsType(t, ExitStatusError{}, selfErr.Err)
+	assert.Contains(t, err.Error(), "gzip: self-test failed at decompress")
+}
+
+func TestSelfTestDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	err := NewFilter("sh", CompressFlags("-c", "sleep 30")).SelfTest(ctx)
+	assert.True(t, time.Since(started) < 10*time.Second)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "%v", err)
+	assert.Equal(t, SelfTestCompress, err.(SelfTestError).Stage)
+}
+
+func TestSelfTestVersion(t *testing.T) {
+	dir := pathWith(t)
+	writeVersionTool(t, dir, "xz", "xz (XZ Utils) 5.1.0alpha")
+	h, err := GetExternalHandlerFromMimeType("application/x-xz")
+	assert.Nil(t, err)
+	err = h.SelfTest(context.Background())
+	assert.Equal(t, SelfTestVersion, err.(SelfTestError).Stage)
+	assert.Contains(t, err.Error(), "older than 5.2.0")
+}
+
+func TestVerifyHandlers(t *testing.T) {
+	pathWith(t, "gzip", "cat")
+	ctx := context.Background()
+	for _, opts := range []VerifyOptions{{}, {SelfTest: true}} {
+		err := VerifyHandlers(ctx, opts)
+		assert.True(t, errors.As(err, &CommandNotFound{}))
+		failed := "\n" + err.Error()
+		assert.Contains(t, failed, "\nbzip2: ")
+		assert.NotContains(t, failed, "\ngzip: ")
+		assert.NotContains(t, failed, "\ncat: ")
+	}
+
+	// Self-tests catch handlers which are present but broken
+	unregisterFilter(t, "broken")
+	assert.Nil(t, RegisterFilter("broken", NewFilter("gzip", CompressFlags("-c"), DecompressFlags("-c")), "application/x-test-broken"))
+	assert.NotContains(t, "\n"+VerifyHandlers(ctx, VerifyOptions{}).Error(), "\nbroken: ")
+	assert.Contains(t, "\n"+VerifyHandlers(ctx, VerifyOptions{SelfTest: true}).Error(), "\nbroken: ")
+}
@@ -0,0 +1,207 @@
+package extcompress
+
+import (
+	"io"
+	"os"
+)
+
+// Block size used for sparse output when Options.SparseBlockSize is unset.
+const defaultSparseBlockSize = 4096
+
+// Writes to a file, seeking over blocks of zeros instead of writing them so
+// they become holes. Close sets the file's final length, since a trailing
+// hole is never written.
+type sparseWriter struct {
+	f    *os.File
+	buf  []byte
+	fill int
+	size int64
+}
+
+func newSparseWriter(f *os.File, blockSize int) *sparseWriter {
+	return &sparseWriter{f: f, buf: make([]byte, blockSize)}
+}
+
+func (w *sparseWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(w.buf[w.fill:], p)
+		w.fill += n
+		p = p[n:]
+		if w.fill == len(w.buf) {
+			if err := w.flush(); err != nil {
+				return written, err
+			}
+		}
+		written += n
+	}
+	return written, nil
+}
+
+func (w *sparseWriter) flush() error {
+	block := w.buf[:w.fill]
+	w.size += int64(len(block))
+	w.fill = 0
+	if isZero(block) {
+		_, err := w.f.Seek(int64(len(block)), io.SeekCurrent)
+		return err
+	}
+	_, err := w.f.Write(block)
+	return err
+}
+
+// Writes any partial block and sets the file's length. Does not close the
+// file.
+func (w *sparseWriter) Close() error {
+	if err := w.flush(); err != nil {
+		return err
+	}
+	return w.f.Truncate(w.size)
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Decompresses filePath into dstPath, creating or truncating it. With
+// Options.Sparse set and a regular file as the destination, runs of zero
+// blocks become holes rather than being written.
+func (c Filter) DecompressToFile(filePath string, dstPath string) error {
+	return c.toFile(filePath, dstPath, false)
+}
+
+// Compresses filePath into dstPath, creating or truncating it.
+func (c Filter) CompressToFile(filePath string, dstPath string) error {
+	return c.toFile(filePath, dstPath, true)
+}
+
+func (c Filter) toFile(filePath string, dstPath string, compress bool) error {
+	fromStdio := isStdioPath(filePath)
+	var srcMode *os.FileMode
+	var owner *fileOwner
+	if !fromStdio {
+		srcSt, err := os.Stat(filePath)
+		if err != nil {
+			return err
+		}
+		m := srcSt.Mode()
+		srcMode, owner = &m, c.ownerFor(srcSt)
+	}
+	mode := c.outputMode(srcMode)
+
+	var proc CompressionProcess
+	var err error
+	offload := c.canOffload() && !fromStdio
+	if !offload {
+		if compress {
+			proc, err = c.Compress(filePath)
+		} else {
+			proc, err = c.Decompress(filePath)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	closeProc := func() {
+		if proc != nil {
+			proc.Close()
+		}
+	}
+
+	// Stdout is written as it is, whatever it has been redirected to
+	f := os.Stdout
+	toStdout := isStdoutPath(dstPath)
+	if !toStdout {
+		if err = c.makeParentDirs(dstPath); err == nil {
+			f, err = c.createOutput(dstPath, mode)
+		}
+		if err != nil {
+			closeProc()
+			return err
+		}
+	}
+	st, err := f.Stat()
+	regular := err == nil && st.Mode().IsRegular() && !toStdout
+	if regular {
+		// Before any data is written, since the file is already in place
+		if err = f.Chmod(mode); err == nil {
+			err = owner.applyFile(f)
+		}
+	}
+	if err != nil {
+		closeProc()
+		if !toStdout {
+			f.Close()
+		}
+		return err
+	}
+
+	var w io.Writer = f
+	var sparse *sparseWriter
+	if enabled(c.opts.Sparse) && regular {
+		blockSize := c.opts.SparseBlockSize
+		if blockSize == 0 {
+			blockSize = defaultSparseBlockSize
+		}
+		sparse = newSparseWriter(f, blockSize)
+		w = sparse
+	}
+
+	err = func() error {
+		var transfer Transfer
+		if offload {
+			if transfer, err = c.offloadCopy(filePath, f); err != nil {
+				return err
+			}
+		} else {
+			n, err := copyPooled(w, proc)
+			if err != nil {
+				proc.Close()
+				return err
+			}
+			if status := statusOf(proc); status != 0 {
+				command := c.CommandStreamDecompress()
+				if compress {
+					command = c.CommandStreamCompress()
+				}
+				return ExitStatusError{command, status, proc.ID()}
+			}
+			if err := warningsFailure(proc); err != nil {
+				return err
+			}
+			transfer.Bytes = n
+		}
+		if sparse != nil {
+			if err := sparse.Close(); err != nil {
+				return err
+			}
+		}
+		if regular {
+			if err := c.syncOutput(f); err != nil {
+				return err
+			}
+			if err := c.syncParent(dstPath); err != nil {
+				return err
+			}
+		}
+		if c.opts.OnTransfer != nil {
+			c.opts.OnTransfer(transfer)
+		}
+		return nil
+	}()
+	if toStdout {
+		return err
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil && regular {
+		os.Remove(dstPath)
+	}
+	return err
+}
@@ -0,0 +1,91 @@
+package extcompress
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Writes a gzipped image which is mostly zeros, with data scattered through
+// it, and returns the uncompressed content.
+func writeZerosImage(t *testing.T, filename string) []byte {
+	image := make([]byte, 32*mib)
+	data := seekableTestData(64 << 10)
+	for off := 0; off < len(image); off += 4 * mib {
+		copy(image[off+123:], data)
+	}
+	assert.Nil(t, ioutil.WriteFile(filename, image, os.FileMode(0644)))
+	assert.Nil(t, exec.Command("gzip", filename).Run())
+	return image
+}
+
+func diskUsage(t *testing.T, filename string) int64 {
+	var st syscall.Stat_t
+	assert.Nil(t, syscall.Stat(filename, &st))
+	return st.Blocks * 512
+}
+
+func TestDecompressToFileSparse(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	image := writeZerosImage(t, path.Join(tmpdir, "image"))
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	dense := path.Join(tmpdir, "dense")
+	assert.Nil(t, h.DecompressToFile(path.Join(tmpdir, "image.gz"), dense))
+	sparse := path.Join(tmpdir, "sparse")
+	assert.Nil(t, h.WithOptions(Options{Sparse: Bool(true)}).DecompressToFile(path.Join(tmpdir, "image.gz"), sparse))
+
+	for _, filename := range []string{dense, sparse} {
+		content, err := ioutil.ReadFile(filename)
+		assert.Nil(t, err)
+		assert.Equal(t, len(image), len(content))
+		assert.Equal(t, image, content)
+	}
+
+	if diskUsage(t, dense) < int64(len(image))/2 {
+		t.Skip("filesystem compresses or deduplicates zeros itself")
+	}
+	assert.True(t, diskUsage(t, sparse) < int64(len(image))/8,
+		"sparse file uses %d bytes", diskUsage(t, sparse))
+}
+
+func TestDecompressToFileSparseTrailingHole(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	// Data followed by zeros which don't fill the final block
+	image := append(seekableTestData(1000), make([]byte, 10000)...)
+	filename := path.Join(tmpdir, "image")
+	assert.Nil(t, ioutil.WriteFile(filename, image, os.FileMode(0644)))
+	assert.Nil(t, exec.Command("gzip", filename).Run())
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	out := path.Join(tmpdir, "out")
+	assert.Nil(t, h.WithOptions(Options{Sparse: Bool(true), SparseBlockSize: 512}).DecompressToFile(filename+".gz", out))
+
+	content, err := ioutil.ReadFile(out)
+	assert.Nil(t, err)
+	assert.Equal(t, image, content)
+}
+
+func TestDecompressToFileNonRegular(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	writeZerosImage(t, path.Join(tmpdir, "image"))
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	// Devices can't seek to make holes, so sparse output is bypassed
+	assert.Nil(t, h.WithOptions(Options{Sparse: Bool(true)}).DecompressToFile(path.Join(tmpdir, "image.gz"), os.DevNull))
+	_, err = os.Stat(os.DevNull)
+	assert.Nil(t, err)
+}
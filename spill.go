@@ -0,0 +1,152 @@
+package extcompress
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Stands for the output file in the flags of a tool which can't write to
+// stdout (see Filter.RequiresTempOutput).
+const OutputPlaceholder = "{output}"
+
+// Disk usage and latency of a job whose tool can't stream its output.
+type SpillStats struct {
+	// Size of the temp file the output was written to
+	Bytes int64
+	// From starting the tool until its output could be read, which for a
+	// streaming tool would be almost nothing
+	FirstByte time.Duration
+}
+
+// The output of a RequiresTempOutput tool: a file in a private temp
+// directory, read once the tool has finished.
+type spillOutput struct {
+	dir     string
+	path    string
+	started time.Time
+	job     *CompressionJob
+
+	mtx    sync.Mutex
+	f      *os.File
+	closed bool
+	stats  SpillStats
+}
+
+// Creates the private directory the tool writes its output into.
+func (c Filter) newSpill(compress bool) (*spillOutput, error) {
+	dir, err := ioutil.TempDir(c.opts.TempDir, "extcompress-spill-")
+	if err != nil {
+		return nil, err
+	}
+	name := "output"
+	if compress && len(c.Extensions) > 0 {
+		// Some tools insist on adding their extension otherwise
+		name += c.Extensions[0]
+	}
+	return &spillOutput{dir: dir, path: filepath.Join(dir, name), started: time.Now()}, nil
+}
+
+// Substitutes the output file for OutputPlaceholder in args, or appends it
+// if there is no placeholder.
+func (s *spillOutput) substitute(args []string) []string {
+	out, found := substitutePlaceholder(args, OutputPlaceholder, s.path)
+	if !found {
+		out = append(out, s.path)
+	}
+	return out
+}
+
+// Blocks until the tool has finished, then serves what it wrote. Reports
+// the tool's failure rather than a partial file.
+func (s *spillOutput) Read(p []byte) (int, error) {
+	s.mtx.Lock()
+	opened := s.f != nil
+	s.mtx.Unlock()
+	if !opened {
+		if err := s.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.closed {
+		return 0, os.ErrClosed
+	}
+	n, err := s.f.Read(p)
+	if err == io.EOF {
+		s.f.Close()
+		s.closed = true
+	}
+	return n, err
+}
+
+func (s *spillOutput) open() error {
+	// Not under mtx, so Close can still tear the job down meanwhile
+	s.job.getResult()
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.closed {
+		return os.ErrClosed
+	}
+	if s.f != nil {
+		return nil
+	}
+	if err := s.job.failure(); err != nil {
+		s.closeLocked()
+		return err
+	}
+	f, err := os.Open(s.path)
+	if err != nil {
+		s.closeLocked()
+		return err
+	}
+	if st, err := f.Stat(); err == nil {
+		s.stats.Bytes = st.Size()
+	}
+	s.stats.FirstByte = time.Since(s.started)
+	s.f = f
+	// The open file is all that's needed now, and the space is freed once
+	// it's closed even if the job never is
+	s.removeDir()
+	return nil
+}
+
+// Deletes the output, whether or not it has been read.
+func (s *spillOutput) Close() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.closeLocked()
+	return nil
+}
+
+func (s *spillOutput) closeLocked() {
+	if s.f != nil && !s.closed {
+		s.f.Close()
+	}
+	s.closed = true
+	s.removeDir()
+}
+
+func (s *spillOutput) removeDir() {
+	if err := os.RemoveAll(s.dir); err != nil {
+		s.job.log.WithField("error", err.Error()).Warn("Error removing spilled output")
+	}
+}
+
+// Reports the disk usage and latency of a job whose tool can't stream (see
+// Filter.RequiresTempOutput). ok is false for other jobs, and until the
+// output can be read.
+func (this *CompressionJob) SpillStats() (stats SpillStats, ok bool) {
+	if this.spill == nil {
+		return SpillStats{}, false
+	}
+	this.spill.mtx.Lock()
+	defer this.spill.mtx.Unlock()
+	return this.spill.stats, this.spill.f != nil
+}
@@ -0,0 +1,130 @@
+package extcompress
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A tool which only writes to a named output file: fakezip <-c|-d> <out> [in]
+const fakeZipScript = `#!/bin/sh
+mode=$1
+out=$2
+shift 2
+exec gzip $mode -c "$@" > "$out"
+`
+
+// Returns a RequiresTempOutput handler around the fake tool, spilling into
+// its own temp directory.
+func setupSpillFilter(t *testing.T, tmpdir string) (Filter, string) {
+	tool := path.Join(tmpdir, "fakezip")
+	assert.Nil(t, ioutil.WriteFile(tool, []byte(fakeZipScript), os.FileMode(0755)))
+	spillDir := path.Join(tmpdir, "spill")
+	assert.Nil(t, os.Mkdir(spillDir, os.FileMode(0755)))
+
+	f := NewFilter(tool,
+		CompressFlags("-c", OutputPlaceholder),
+		DecompressFlags("-d", OutputPlaceholder),
+		InPlaceFlags([]string{"-c", OutputPlaceholder}, []string{"-d", OutputPlaceholder}),
+		Suffix(".gz", ""),
+	).(Filter)
+	f.RequiresTempOutput = true
+	f.Extensions = []string{".gz"}
+	return f.WithTempDir(spillDir).(Filter), spillDir
+}
+
+func assertSpillCleaned(t *testing.T, spillDir string) {
+	entries, err := ioutil.ReadDir(spillDir)
+	assert.Nil(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestSpillStreams(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	h, spillDir := setupSpillFilter(t, tmpdir)
+
+	original := seekableTestData(200000)
+	proc, err := h.CompressStream(bytes.NewReader(original))
+	compressed := readJob(t, proc, err, "compress")
+	assert.Equal(t, []byte{0x1f, 0x8b}, compressed[:2])
+
+	stats, ok := proc.(*CompressionJob).SpillStats()
+	assert.True(t, ok)
+	assert.Equal(t, int64(len(compressed)), stats.Bytes)
+	assert.True(t, stats.FirstByte > 0)
+	assertSpillCleaned(t, spillDir)
+
+	// Read in small pieces to check it is served as a stream
+	proc, err = h.DecompressStream(bytes.NewReader(compressed))
+	assert.Nil(t, err)
+	var out bytes.Buffer
+	buf := make([]byte, 1000)
+	for {
+		n, err := proc.Read(buf)
+		out.Write(buf[:n])
+		if err != nil {
+			break
+		}
+	}
+	assert.Equal(t, original, out.Bytes())
+	assert.Equal(t, 0, proc.Result())
+	assert.Nil(t, proc.Close())
+	assertSpillCleaned(t, spillDir)
+}
+
+func TestSpillFiles(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	h, spillDir := setupSpillFilter(t, tmpdir)
+	filename := path.Join(tmpdir, "pipechaining")
+
+	out, err := h.DecompressToBytes(writeCompressed(t, tmpdir, "gzip", []byte(data)), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, data, string(out))
+
+	// In place goes through the package
+	assert.Nil(t, h.CompressFileInPlace(filename))
+	assert.Nil(t, h.DecompressFileInPlace(filename+".gz"))
+	out, err = ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, data, string(out))
+	assertSpillCleaned(t, spillDir)
+
+	_, err = h.CompressIntoFile(filepath.Join(tmpdir, "into.gz"))
+	assert.Equal(t, ErrNotSupported, err)
+}
+
+func TestSpillFailure(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	h, spillDir := setupSpillFilter(t, tmpdir)
+
+	proc, err := h.DecompressStream(bytes.NewBufferString("not gzip"))
+	assert.Nil(t, err)
+	_, err = ioutil.ReadAll(proc)
+	assert.IsType(t, CorruptInputError{}, err)
+	_, ok := proc.(*CompressionJob).SpillStats()
+	assert.False(t, ok)
+	assertSpillCleaned(t, spillDir)
+}
+
+func TestSpillCloseUnread(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	h, spillDir := setupSpillFilter(t, tmpdir)
+
+	proc, err := h.CompressStream(bytes.NewBufferString(data))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, proc.Result())
+	assert.Nil(t, proc.Close())
+	assertSpillCleaned(t, spillDir)
+
+	_, err = proc.Read(make([]byte, 10))
+	assert.NotNil(t, err)
+}
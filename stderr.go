@@ -0,0 +1,27 @@
+package extcompress
+
+import (
+	"io"
+)
+
+// Returns where the stderr of job id should go for operation.
+func (c Filter) stderr(id string, operation string) io.Writer {
+	var w io.Writer = NewLogWriter(c.jobLog(id).WithField("extcompress", operation).Debug)
+	if c.opts.Stderr != nil {
+		w = c.opts.Stderr
+	}
+	if c.stderrCapture != nil {
+		w = io.MultiWriter(w, c.stderrCapture)
+	}
+	if c.warningCapture != nil {
+		w = io.MultiWriter(w, c.warningCapture)
+	}
+	if c.opts.Progress != nil && len(c.ProgressParsers) > 0 {
+		w = &progressWriter{parsers: c.ProgressParsers, fn: c.opts.Progress, next: w, id: id}
+	}
+	return pooledWriter{w}
+}
+
+func (c Filter) WithStderr(w io.Writer) ExternalHandler {
+	return c.WithOptions(Options{Stderr: w})
+}
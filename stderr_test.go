@@ -0,0 +1,42 @@
+package extcompress
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithStderrInPlace(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	h, err := GetExternalHandlerFromMimeType("application/x-xz")
+	assert.Nil(t, err)
+
+	var stderr bytes.Buffer
+	filename := path.Join(tmpdir, "pipechaining")
+	h = h.WithOptions(Options{Args: []string{"-v"}}).WithStderr(&stderr)
+	assert.Nil(t, h.CompressFileInPlace(filename))
+	// xz -v reports the file name and its ratio
+	assert.Contains(t, stderr.String(), "pipechaining")
+
+	stderr.Reset()
+	assert.Nil(t, h.DecompressFileInPlace(filename+".xz"))
+	assert.Contains(t, stderr.String(), "pipechaining")
+}
+
+func TestWithStderrStream(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	var stderr bytes.Buffer
+	proc, err := h.WithStderr(&stderr).DecompressStream(ioutil.NopCloser(bytes.NewReader([]byte("not gzip"))))
+	assert.Nil(t, err)
+	ioutil.ReadAll(proc)
+	assert.NotEqual(t, 0, proc.Result())
+	assert.Contains(t, stderr.String(), "not in gzip format")
+}
@@ -0,0 +1,206 @@
+package extcompress
+
+import (
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Wraps a reader given to a streaming job as its stdin. exec copies it to
+// the child on a goroutine which Wait blocks on, so a reader which never
+// returns would hang the job's teardown. Reads happen on a goroutine of
+// their own which is abandoned once the job cancels the reader, after which
+// it reports EOF so the copy finishes and the child sees its input end.
+type cancelableReader struct {
+	r         io.Reader
+	buf       []byte
+	abandoned bool // Set once a read is left running, which may still use buf
+	cancelled chan struct{}
+	once      sync.Once
+	// Bytes passed on to the child, updated atomically
+	consumed int64
+	// Bytes accepted by writes to the child's stdin, updated atomically
+	delivered int64
+	// The pipe being written to, while the copy runs, and the bytes left
+	// unread in it when the copy ended
+	pipeMtx sync.Mutex
+	pipe    *os.File
+	unread  int64
+}
+
+type readResult struct {
+	n   int
+	err error
+}
+
+// Returns what to give a streaming command as its stdin, and the reader the
+// job should cancel on teardown, if any. Files are inherited by the child
+// directly, so need no copying.
+func cancelableStdin(rd io.Reader) (io.Reader, *cancelableReader) {
+	if _, ok := rd.(*os.File); ok {
+		return rd, nil
+	}
+	cr := &cancelableReader{r: rd, cancelled: make(chan struct{})}
+	return cr, cr
+}
+
+func (cr *cancelableReader) Read(p []byte) (int, error) {
+	select {
+	case <-cr.cancelled:
+		return 0, io.EOF
+	default:
+	}
+
+	// Only an abandoned read can still be using buf, and nothing reads
+	// after that, so it can be reused
+	if cap(cr.buf) < len(p) {
+		cr.buf = make([]byte, len(p))
+	}
+	buf := cr.buf[:len(p)]
+	result := make(chan readResult, 1)
+	go func() {
+		n, err := cr.r.Read(buf)
+		result <- readResult{n, err}
+	}()
+
+	select {
+	case res := <-result:
+		atomic.AddInt64(&cr.consumed, int64(res.n))
+		return copy(p, buf[:res.n]), res.err
+	case <-cr.cancelled:
+		cr.abandoned = true
+		return 0, io.EOF
+	}
+}
+
+// Copies the reader to w, which is the child's stdin pipe, counting what
+// the writes accept. io.Copy, as exec uses to feed the child, prefers this
+// to Read. Once the reader ends the copy waits for the child to read what
+// is still in the pipe, so what it consumed is known exactly, before
+// letting the pipe be closed.
+func (cr *cancelableReader) WriteTo(w io.Writer) (int64, error) {
+	f, _ := w.(*os.File)
+	cr.setPipe(f)
+	defer cr.setPipe(nil)
+
+	pool := currentBufferPool()
+	buf := pool.Get()
+	defer pool.Put(buf)
+	if cr.buf == nil {
+		readBuf := pool.Get()
+		cr.buf = readBuf
+		defer func() {
+			if !cr.abandoned {
+				pool.Put(readBuf)
+				cr.buf = nil
+			}
+		}()
+	}
+	var total int64
+	for {
+		n, err := cr.Read(buf)
+		if n > 0 {
+			written, werr := w.Write(buf[:n])
+			atomic.AddInt64(&cr.delivered, int64(written))
+			total += int64(written)
+			if werr != nil {
+				return total, werr
+			}
+		}
+		if err == io.EOF {
+			cr.drain(f)
+			return total, nil
+		}
+		if err != nil {
+			cr.drain(f)
+			return total, err
+		}
+	}
+}
+
+func (cr *cancelableReader) setPipe(f *os.File) {
+	cr.pipeMtx.Lock()
+	defer cr.pipeMtx.Unlock()
+	if f == nil && cr.pipe != nil {
+		cr.unread, _ = pipeBuffered(cr.pipe)
+	}
+	cr.pipe = f
+}
+
+// Longest wait between checks of whether the child has emptied its stdin
+const maxDrainInterval = 10 * time.Millisecond
+
+// Waits for the child to read everything left in f, or to be done with it.
+func (cr *cancelableReader) drain(f *os.File) {
+	if f == nil {
+		return
+	}
+	interval := 100 * time.Microsecond
+	for {
+		if n, ok := pipeBuffered(f); !ok || n == 0 {
+			return
+		}
+		select {
+		case <-cr.cancelled:
+			return
+		case <-time.After(interval):
+		}
+		if interval < maxDrainInterval {
+			interval *= 2
+		}
+	}
+}
+
+// Returns the bytes which have entered the child: accepted by its stdin
+// pipe and no longer waiting in the pipe's buffer.
+func (cr *cancelableReader) inputConsumed() int64 {
+	cr.pipeMtx.Lock()
+	defer cr.pipeMtx.Unlock()
+	unread := cr.unread
+	if cr.pipe != nil {
+		unread, _ = pipeBuffered(cr.pipe)
+	}
+	return atomic.LoadInt64(&cr.delivered) - unread
+}
+
+// Returns how many bytes of its input stream the tool has actually read,
+// counted where they enter its stdin pipe, not where they were read from
+// the source. After a failed transfer this is the offset to resume the
+// compressed stream from, e.g. with an HTTP Range request. -1 for jobs
+// whose input isn't copied by the package: file operations, and streams
+// from an *os.File, which the tool reads directly.
+func (this *CompressionJob) InputBytesConsumed() int64 {
+	if this.stdin == nil {
+		return -1
+	}
+	return this.stdin.inputConsumed()
+}
+
+// Releases any Read blocked on the wrapped reader. Safe to call more than
+// once, and on a nil reader.
+func (cr *cancelableReader) cancel() {
+	if cr == nil {
+		return
+	}
+	cr.once.Do(func() { close(cr.cancelled) })
+}
+
+// Cancels the job's stdin once the process has exited, since nothing will
+// read it after that, so reaping isn't held up by a stuck source. Returns
+// when the job is reaped.
+func (this *CompressionJob) releaseStdinOnExit() {
+	for {
+		select {
+		case <-this.done:
+			return
+		default:
+		}
+		if exited, err := processExited(this.cmd.Process.Pid); err != nil || exited {
+			this.stdin.cancel()
+			return
+		}
+		time.Sleep(undrainedPollInterval)
+	}
+}
@@ -0,0 +1,25 @@
+package extcompress
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Returns how many bytes are waiting to be read from the pipe f is an end
+// of.
+func pipeBuffered(f *os.File) (int64, bool) {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return 0, false
+	}
+	var n int32
+	var errno syscall.Errno
+	err = rc.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TIOCINQ, uintptr(unsafe.Pointer(&n)))
+	})
+	if err != nil || errno != 0 {
+		return 0, false
+	}
+	return int64(n), true
+}
@@ -0,0 +1,191 @@
+package extcompress
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A reader which never returns until the test ends, like a wedged network
+// source.
+type stuckReader struct {
+	release chan struct{}
+}
+
+func newStuckReader(t *testing.T) *stuckReader {
+	r := &stuckReader{make(chan struct{})}
+	t.Cleanup(func() { close(r.release) })
+	return r
+}
+
+func (r *stuckReader) Read(p []byte) (int, error) {
+	<-r.release
+	return 0, context.Canceled
+}
+
+// Fails the test if fn doesn't return promptly.
+func assertReturns(t *testing.T, what string, fn func()) {
+	returned := make(chan struct{})
+	go func() {
+		fn()
+		close(returned)
+	}()
+	select {
+	case <-returned:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s hung on a stuck input", what)
+	}
+}
+
+func TestCompressStreamStuckReaderClose(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	proc, err := h.CompressStream(newStuckReader(t))
+	assert.Nil(t, err)
+	assertReturns(t, "Close", func() { proc.Close() })
+	assert.True(t, proc.(*CompressionJob).isReaped())
+}
+
+func TestCompressStreamStuckReaderCancel(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	proc, err := h.WithContext(ctx).CompressStream(newStuckReader(t))
+	assert.Nil(t, err)
+	cancel()
+	assertReturns(t, "Result", func() { proc.Result() })
+}
+
+func TestStreamStuckReaderEarlyExit(t *testing.T) {
+	// The child gives up without reading its input at all
+	h := NewFilter("sh", DecompressFlags("-c", "exit 3"))
+	proc, err := h.DecompressStream(ioutil.NopCloser(newStuckReader(t)))
+	assert.Nil(t, err)
+	assertReturns(t, "Result", func() { assert.Equal(t, 3, proc.Result()) })
+}
+
+// Counts how many times the reader is closed.
+type closeCounter struct {
+	io.Reader
+	closed int32
+}
+
+func (c *closeCounter) Close() error {
+	atomic.AddInt32(&c.closed, 1)
+	return nil
+}
+
+func TestStreamReaders(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	// Plain readers work both ways
+	proc, err := h.CompressStream(bytes.NewReader([]byte(data)))
+	assert.Nil(t, err)
+	compressed, err := ioutil.ReadAll(proc)
+	assert.Nil(t, err)
+	assert.Zero(t, proc.Result())
+	proc, err = h.DecompressStream(bytes.NewReader(compressed))
+	assert.Nil(t, err)
+	out, err := ioutil.ReadAll(proc)
+	assert.Nil(t, err)
+	assert.Zero(t, proc.Result())
+	assert.Equal(t, data, string(out))
+
+	// Closable ones are closed once, when the job completes
+	for _, input := range [][]byte{[]byte(data), compressed} {
+		src := &closeCounter{Reader: bytes.NewReader(input)}
+		if len(input) == len(data) {
+			proc, err = h.CompressStream(src)
+		} else {
+			proc, err = h.DecompressStream(src)
+		}
+		assert.Nil(t, err)
+		_, err = ioutil.ReadAll(proc)
+		assert.Nil(t, err)
+		assert.Zero(t, proc.Result())
+		assert.Equal(t, int32(1), atomic.LoadInt32(&src.closed))
+		proc.Close()
+		assert.Equal(t, int32(1), atomic.LoadInt32(&src.closed))
+	}
+
+	// Including when the job is closed early
+	src := &closeCounter{Reader: newStuckReader(t)}
+	proc, err = h.DecompressStream(src)
+	assert.Nil(t, err)
+	assertReturns(t, "Close", func() { proc.Close() })
+	assert.Equal(t, int32(1), atomic.LoadInt32(&src.closed))
+}
+
+// Hands out the first n bytes of a stream, then fails like a dropped
+// connection.
+type cutReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *cutReader) Read(p []byte) (int, error) {
+	if c.n <= 0 {
+		return 0, errors.New("connection reset by peer")
+	}
+	if int64(len(p)) > c.n {
+		p = p[:c.n]
+	}
+	n, err := c.r.Read(p)
+	c.n -= int64(n)
+	return n, err
+}
+
+func TestInputBytesConsumed(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	compressed := compressBytes(t, h, seekableTestData(1<<20))
+
+	// Complete streams are consumed entirely
+	proc, err := h.DecompressStream(bytes.NewReader(compressed))
+	assert.Nil(t, err)
+	_, err = io.Copy(ioutil.Discard, proc)
+	assert.Nil(t, err)
+	assert.Zero(t, proc.Result())
+	assert.Equal(t, int64(len(compressed)), proc.(*CompressionJob).InputBytesConsumed())
+
+	// The source dies partway, more than a pipe's worth in
+	cut := int64(len(compressed) / 2)
+	proc, err = h.DecompressStream(&cutReader{bytes.NewReader(compressed), cut})
+	assert.Nil(t, err)
+	io.Copy(ioutil.Discard, proc)
+	assert.NotZero(t, proc.Result())
+	assert.Equal(t, cut, proc.(*CompressionJob).InputBytesConsumed())
+
+	// The tool stops reading early: what was left in the pipe isn't counted
+	dd := NewFilter("dd", DecompressFlags("bs=1000", "count=3", "iflag=fullblock", "status=none"))
+	proc, err = dd.DecompressStream(bytes.NewReader(compressed))
+	assert.Nil(t, err)
+	out, err := ioutil.ReadAll(proc)
+	assert.Nil(t, err)
+	assert.Zero(t, proc.Result())
+	assert.Len(t, out, 3000)
+	assert.Equal(t, int64(3000), proc.(*CompressionJob).InputBytesConsumed())
+
+	// Nothing is copied for jobs reading files
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	filename := path.Join(tmpdir, "input.gz")
+	assert.Nil(t, ioutil.WriteFile(filename, compressed, os.FileMode(0644)))
+	proc, err = h.Decompress(filename)
+	assert.Nil(t, err)
+	io.Copy(ioutil.Discard, proc)
+	assert.Zero(t, proc.Result())
+	assert.Equal(t, int64(-1), proc.(*CompressionJob).InputBytesConsumed())
+}
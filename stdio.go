@@ -0,0 +1,111 @@
+package extcompress
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Stands for stdin, or stdout as a destination, where a path is expected.
+const StdioPath = "-"
+
+// Paths which name this process's stdin and stdout.
+var (
+	stdinPaths  = map[string]bool{StdioPath: true, "/dev/stdin": true, "/dev/fd/0": true, "/proc/self/fd/0": true}
+	stdoutPaths = map[string]bool{StdioPath: true, "/dev/stdout": true, "/dev/fd/1": true, "/proc/self/fd/1": true}
+)
+
+// Returned by in-place operations given stdin or a device, which have no
+// file to replace.
+type StdioPathError struct {
+	FilePath string
+}
+
+func (r StdioPathError) Error() string {
+	return fmt.Sprintf("%s is a stream, not a file, so can't be replaced in place", r.FilePath)
+}
+
+// True if file operations treat filePath as a stream: StdioPath, a name for
+// stdin, or a character device.
+func isStdioPath(filePath string) bool {
+	if stdinPaths[filePath] {
+		return true
+	}
+	st, err := os.Stat(filePath)
+	return err == nil && st.Mode()&os.ModeCharDevice != 0
+}
+
+func isStdoutPath(filePath string) bool {
+	return stdoutPaths[filePath]
+}
+
+// Opens the stream filePath stands for (see isStdioPath).
+func openStdio(filePath string) (io.Reader, error) {
+	if stdinPaths[filePath] {
+		return stdinReader(), nil
+	}
+	return os.Open(filePath)
+}
+
+// What detection has read from stdin, which is given back before the rest of
+// it by stdinReader.
+var (
+	stdinMtx    sync.Mutex
+	stdinPrefix []byte
+)
+
+// How much of a stream is read to detect its type.
+const sniffSize = 4096
+
+// Returns stdin, as read by file operations given StdioPath.
+func stdinReader() io.Reader {
+	stdinMtx.Lock()
+	prefix := stdinPrefix
+	stdinPrefix = nil
+	stdinMtx.Unlock()
+	if len(prefix) == 0 {
+		return os.Stdin
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(prefix), os.Stdin), os.Stdin}
+}
+
+// Reads the start of the stream filePath stands for, to detect its type
+// without libmagic guessing from the path. What is read from stdin is kept
+// for the next operation on it, so detecting then compressing StdioPath
+// sees the whole input.
+func sniffStdio(filePath string) ([]byte, error) {
+	if !stdinPaths[filePath] {
+		f, err := os.Open(filePath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return readSniff(f, nil)
+	}
+
+	stdinMtx.Lock()
+	defer stdinMtx.Unlock()
+	buf, err := readSniff(os.Stdin, stdinPrefix)
+	stdinPrefix = buf
+	return buf, err
+}
+
+// Reads r until buf, which may already hold some of it, has sniffSize bytes
+// or r ends.
+func readSniff(r io.Reader, buf []byte) ([]byte, error) {
+	if len(buf) >= sniffSize {
+		return buf, nil
+	}
+	more := make([]byte, sniffSize-len(buf))
+	n, err := io.ReadFull(r, more)
+	buf = append(buf, more[:n]...)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return buf, err
+}
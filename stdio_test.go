@@ -0,0 +1,129 @@
+package extcompress
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Replaces os.Stdin with a pipe fed input, restoring it after the test.
+func setStdin(t *testing.T, input []byte) {
+	r, w, err := os.Pipe()
+	assert.Nil(t, err)
+	go func() {
+		w.Write(input)
+		w.Close()
+	}()
+	old := os.Stdin
+	os.Stdin = r
+	t.Cleanup(func() {
+		os.Stdin = old
+		r.Close()
+		stdinMtx.Lock()
+		stdinPrefix = nil
+		stdinMtx.Unlock()
+	})
+}
+
+func TestCompressStdioPath(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	// Compress("-") does what CompressStream(os.Stdin) does
+	setStdin(t, []byte(data))
+	proc, err := h.CompressStream(os.Stdin)
+	viaStream := readJob(t, proc, err, "CompressStream")
+	setStdin(t, []byte(data))
+	proc, err = h.Compress(StdioPath)
+	viaPath := readJob(t, proc, err, "Compress")
+	assert.Equal(t, viaStream, viaPath)
+
+	for _, stdin := range []string{StdioPath, "/dev/stdin"} {
+		setStdin(t, viaPath)
+		proc, err = h.Decompress(stdin)
+		assert.Equal(t, data, string(readJob(t, proc, err, stdin)))
+	}
+
+	// Devices are read as streams too
+	proc, err = Identity().Compress("/dev/null")
+	assert.Empty(t, readJob(t, proc, err, "/dev/null"))
+}
+
+func TestStdioPathDetection(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	original := seekableTestData(100000)
+	proc, err := h.CompressStream(bytes.NewReader(original))
+	compressed := readJob(t, proc, err, "compress")
+
+	// Detection sniffs stdin, and what it read is replayed to the tool
+	setStdin(t, compressed)
+	detected, err := GetFileTypeExternalHandler(StdioPath)
+	assert.Nil(t, err)
+	assert.True(t, SameFormat(h, detected))
+	proc, err = detected.Decompress(StdioPath)
+	assert.Equal(t, original, readJob(t, proc, err, "decompress"))
+
+	setStdin(t, compressed)
+	isCompressed, _, err := IsCompressed(StdioPath)
+	assert.Nil(t, err)
+	assert.True(t, isCompressed)
+	out, err := h.DecompressToBytes(StdioPath, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, original, out)
+
+	setStdin(t, []byte(data))
+	isCompressed, _, err = IsCompressed("/dev/stdin")
+	assert.Nil(t, err)
+	assert.False(t, isCompressed)
+}
+
+func TestStdioPathInPlace(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	for _, p := range []string{StdioPath, "/dev/stdin", "/dev/null"} {
+		assert.Equal(t, StdioPathError{p}, h.CompressFileInPlace(p))
+		assert.Equal(t, StdioPathError{p}, h.DecompressFileInPlace(p))
+		_, err := h.CompressFileInPlaceWithOptions(p, InPlaceOptions{})
+		assert.Equal(t, StdioPathError{p}, err)
+		_, err = h.DecompressFileInPlaceWithOptions(p, InPlaceOptions{})
+		assert.Equal(t, StdioPathError{p}, err)
+	}
+
+	results, err := h.CompressFilesInPlace([]string{StdioPath}, InPlaceOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, StdioPathError{StdioPath}, results[0].Err)
+}
+
+func TestStdioPathToFile(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	setStdin(t, []byte(data))
+	dst := path.Join(tmpdir, "stdin.gz")
+	assert.Nil(t, h.CompressToFile(StdioPath, dst))
+	out, err := h.DecompressToBytes(dst, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, data, string(out))
+
+	// Writing to stdout leaves whatever it is pointed at alone
+	stdout := path.Join(tmpdir, "stdout")
+	f, err := os.Create(stdout)
+	assert.Nil(t, err)
+	old := os.Stdout
+	os.Stdout = f
+	err = h.DecompressToFile(dst, StdioPath)
+	os.Stdout = old
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+	out, err = ioutil.ReadFile(stdout)
+	assert.Nil(t, err)
+	assert.Equal(t, data, string(out))
+}
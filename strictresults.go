@@ -0,0 +1,116 @@
+package extcompress
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// What Result does, under SetStrictResults, for a job its exit status can't
+// describe faithfully.
+type StrictResultsAction int
+
+const (
+	// Logs the use at error level
+	StrictResultsLog StrictResultsAction = iota
+	// Panics with an UnfaithfulResultError
+	StrictResultsPanic
+)
+
+// Matched by the UnfaithfulResultError strict results report.
+var ErrUnfaithfulResult = errors.New("extcompress: Result cannot describe how the job ended")
+
+// Describes a call to Result on a job which was killed by a signal or
+// aborted by the package, which the int it returns can't tell apart from
+// the tool failing. Wraps ErrUnfaithfulResult.
+type UnfaithfulResultError struct {
+	// The command line run
+	Command string
+	JobID   string
+	// What Result returned
+	Result int
+	// The signal the tool died of, if it did
+	Signal syscall.Signal
+	// Why the package aborted the job, if it did
+	Aborted error
+}
+
+func (r UnfaithfulResultError) Error() string {
+	how := fmt.Sprintf("killed by %s", r.Signal)
+	if r.Aborted != nil {
+		how = "aborted: " + r.Aborted.Error()
+	}
+	return fmt.Sprintf("%s: Result returned %d for job %s, which was %s; use Err or Wait instead",
+		r.Command, r.Result, r.JobID, how)
+}
+
+func (r UnfaithfulResultError) Unwrap() error {
+	return ErrUnfaithfulResult
+}
+
+var (
+	// Set to check calls to Result, and the action to take, atomically
+	strictResults       int32
+	strictResultsAction int32
+)
+
+// Makes Result report being called on a job which died of a signal or was
+// aborted (by its context, a guard, or a failed check), as the action set by
+// SetStrictResultsAction does. Err and Wait are unaffected. This finds
+// callers which still rely on Result while migrating to Err.
+func SetStrictResults(strict bool) {
+	var flag int32
+	if strict {
+		flag = 1
+	}
+	atomic.StoreInt32(&strictResults, flag)
+}
+
+// Sets what strict results do. The default is StrictResultsLog.
+func SetStrictResultsAction(action StrictResultsAction) {
+	atomic.StoreInt32(&strictResultsAction, int32(action))
+}
+
+// Returns why result doesn't describe how the finished job ended, or nil.
+// Jobs stopped by Close report 0 by design, so only count if they were
+// aborted too.
+func (this *CompressionJob) unfaithfulResult(result int) error {
+	r := UnfaithfulResultError{Command: this.command, JobID: this.id, Result: result}
+	if r.Aborted = this.aborted(); r.Aborted != nil {
+		return r
+	}
+	if sig, ok := exitSignal(this.cmd); ok && atomic.LoadInt32(&this.termFlag) == 0 {
+		r.Signal = sig
+		return r
+	}
+	return nil
+}
+
+// Reports a call to Result returning result if strict results are on and
+// it is unfaithful.
+func (this *CompressionJob) checkStrictResult(result int) {
+	if atomic.LoadInt32(&strictResults) == 0 {
+		return
+	}
+	err := this.unfaithfulResult(result)
+	if err == nil {
+		return
+	}
+	if StrictResultsAction(atomic.LoadInt32(&strictResultsAction)) == StrictResultsPanic {
+		panic(err)
+	}
+	this.log.WithFields(log.Fields{"compressCmd": printable(this.command), "error": err.Error()}).
+		Error("Deprecated use of Result on a job it can't describe")
+}
+
+// Returns proc's exit status as Result does, without the strict results
+// check, for the package's own use.
+func statusOf(proc CompressionProcess) int {
+	if job, ok := proc.(*CompressionJob); ok {
+		return job.status()
+	}
+	return proc.Result()
+}
@@ -0,0 +1,93 @@
+package extcompress
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setStrictResults(t *testing.T, action StrictResultsAction) {
+	SetStrictResults(true)
+	SetStrictResultsAction(action)
+	t.Cleanup(func() {
+		SetStrictResults(false)
+		SetStrictResultsAction(StrictResultsLog)
+	})
+}
+
+// Starts a job whose tool reads its input and then dies of SIGKILL.
+func killedJob(t *testing.T) *CompressionJob {
+	h := NewFilter("sh", CompressFlags("-c", "cat >/dev/null; kill -9 $$"))
+	proc, err := h.CompressStream(bytes.NewReader([]byte(data)))
+	assert.Nil(t, err)
+	_, err = ioutil.ReadAll(proc)
+	assert.Nil(t, err)
+	return proc.(*CompressionJob)
+}
+
+// Starts a job which is aborted by cancelling its context.
+func cancelledJob(t *testing.T) *CompressionJob {
+	ctx, cancel := context.WithCancel(context.Background())
+	h := NewFilter("sh", CompressFlags("-c", "sleep 10")).WithContext(ctx)
+	proc, err := h.CompressStream(bytes.NewReader([]byte(data)))
+	assert.Nil(t, err)
+	cancel()
+	ioutil.ReadAll(proc)
+	return proc.(*CompressionJob)
+}
+
+func TestStrictResultsOff(t *testing.T) {
+	assert.Equal(t, -1, killedJob(t).Result())
+	assert.NotZero(t, cancelledJob(t).Result())
+}
+
+func TestStrictResultsPanic(t *testing.T) {
+	setStrictResults(t, StrictResultsPanic)
+
+	job := killedJob(t)
+	assert.PanicsWithValue(t, UnfaithfulResultError{"sh -c cat >/dev/null; kill -9 $$", job.ID(), -1, syscall.SIGKILL, nil}, func() { job.Result() })
+	// Callers which have moved to Err are left alone
+	assert.NotPanics(t, func() { assert.NotNil(t, job.Err()) })
+	assert.NotPanics(t, func() { job.Wait() })
+
+	job = cancelledJob(t)
+	func() {
+		defer func() {
+			err, _ := recover().(error)
+			assert.True(t, errors.Is(err, ErrUnfaithfulResult))
+			assert.True(t, errors.Is(err.(UnfaithfulResultError).Aborted, context.Canceled))
+			assert.Contains(t, err.Error(), "aborted: context canceled")
+		}()
+		job.Result()
+		t.Error("Result did not panic")
+	}()
+	assert.NotPanics(t, func() { assert.True(t, errors.Is(job.Err(), context.Canceled)) })
+
+	// Jobs the int describes, including ones stopped by Close, still get it
+	proc, err := NewFilter("cat").CompressStream(bytes.NewReader([]byte(data)))
+	assert.Nil(t, err)
+	ioutil.ReadAll(proc)
+	assert.NotPanics(t, func() { assert.Zero(t, proc.Result()) })
+	proc, err = NewFilter("sleep", CompressFlags("10")).CompressStream(bytes.NewReader(nil))
+	assert.Nil(t, err)
+	proc.Close()
+	assert.NotPanics(t, func() { assert.Zero(t, proc.Result()) })
+}
+
+func TestStrictResultsLog(t *testing.T) {
+	setStrictResults(t, StrictResultsLog)
+	logs := captureLogs(t)
+
+	job := killedJob(t)
+	assert.Equal(t, -1, job.Result())
+	assert.Contains(t, messages(logs.forJob(job.ID())), "Deprecated use of Result on a job it can't describe")
+
+	job = cancelledJob(t)
+	assert.NotPanics(t, func() { job.Result() })
+	assert.Contains(t, messages(logs.forJob(job.ID())), "Deprecated use of Result on a job it can't describe")
+}
@@ -0,0 +1,76 @@
+package extcompress
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Matched by the error from compressing a file in place which already has
+// the name of a compressed file, so was left alone. Callers can treat it as
+// a skip rather than a failure.
+var ErrAlreadyCompressedName = errors.New("extcompress: file already has a compressed suffix")
+
+// Describes a file left alone for already having a compressed suffix. Wraps
+// ErrAlreadyCompressedName.
+type AlreadyCompressedNameError struct {
+	Path string
+	// The suffix found before running the tool, or empty if it was the tool
+	// which refused the file
+	Suffix string
+}
+
+func (r AlreadyCompressedNameError) Error() string {
+	if r.Suffix == "" {
+		return fmt.Sprintf("%s: %s", r.Path, ErrAlreadyCompressedName.Error())
+	}
+	return fmt.Sprintf("%s: %s (%s)", r.Path, ErrAlreadyCompressedName.Error(), r.Suffix)
+}
+
+func (r AlreadyCompressedNameError) Unwrap() error {
+	return ErrAlreadyCompressedName
+}
+
+// Returns the suffix compressing filePath in place would add, or one of the
+// handler's registered extensions, if filePath already ends in it. Nothing
+// is returned for handlers which don't compress, or if AllowRecompression
+// is set.
+func (c Filter) compressedSuffix(filePath string, opts InPlaceOptions) string {
+	if c.Passthrough || enabled(c.opts.AllowRecompression) {
+		return ""
+	}
+	if suffix, err := c.inPlaceSuffix(opts); err == nil && suffix != "" && strings.HasSuffix(filePath, suffix) {
+		return suffix
+	}
+	for _, ext := range c.Extensions {
+		if ext = normalizeExtension(ext); hasSuffixFold(filePath, ext) {
+			return ext
+		}
+	}
+	return ""
+}
+
+// Checks filePath doesn't already have a compressed suffix before it is
+// compressed in place.
+func (c Filter) checkCompressedSuffix(filePath string, opts InPlaceOptions) error {
+	if suffix := c.compressedSuffix(filePath, opts); suffix != "" {
+		return AlreadyCompressedNameError{filePath, suffix}
+	}
+	return nil
+}
+
+// True if the tool's stderr says it left filePath alone for already having
+// a compressed suffix, in a line naming the file.
+func (c Filter) refusedSuffixed(stderr string, filePath string) bool {
+	for _, line := range strings.Split(stderr, "\n") {
+		if !strings.Contains(line, filePath) {
+			continue
+		}
+		for _, msg := range c.AlreadySuffixedMessages {
+			if strings.Contains(line, msg) {
+				return true
+			}
+		}
+	}
+	return false
+}
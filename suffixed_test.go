@@ -0,0 +1,80 @@
+package extcompress
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAlreadyCompressedName(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	for _, mimeType := range []string{"application/x-bzip2", "application/gzip", "application/x-xz", "application/zstd"} {
+		h, err := GetExternalHandlerFromMimeType(mimeType)
+		assert.Nil(t, err)
+		f := h.(Filter)
+		filename := path.Join(tmpdir, "twice"+f.suffix())
+		assert.Nil(t, ioutil.WriteFile(filename, []byte(data), os.FileMode(0644)))
+
+		// Caught before the tool runs
+		_, err = h.CompressFileInPlaceWithOptions(filename, InPlaceOptions{})
+		assert.Equal(t, AlreadyCompressedNameError{filename, f.suffix()}, err, mimeType)
+		assert.Equal(t, AlreadyCompressedNameError{filename, f.suffix()}, h.CompressFileInPlace(filename), mimeType)
+
+		// Tools which refuse the name themselves are recognised
+		_, err = h.WithOptions(Options{AllowRecompression: Bool(true)}).CompressFileInPlaceWithOptions(filename, InPlaceOptions{})
+		if len(f.AlreadySuffixedMessages) == 0 {
+			// zstd doesn't mind
+			assert.Nil(t, err, mimeType)
+			os.Remove(filename + f.suffix())
+			continue
+		}
+		assert.Equal(t, AlreadyCompressedNameError{filename, ""}, err, mimeType)
+		assert.True(t, errors.Is(err, ErrAlreadyCompressedName))
+		out, err := ioutil.ReadFile(filename)
+		assert.Nil(t, err)
+		assert.Equal(t, data, string(out))
+		os.Remove(filename)
+	}
+}
+
+func TestAlreadyCompressedNameBatch(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	h = h.WithOptions(Options{AllowRecompression: Bool(true)})
+
+	paths := writeSmallFiles(t, tmpdir, 2)
+	named := path.Join(tmpdir, "named.gz")
+	assert.Nil(t, ioutil.WriteFile(named, []byte(data), os.FileMode(0644)))
+	paths = append(paths, named, path.Join(tmpdir, "missing.log"))
+
+	results, summary, err := h.CompressFilesInPlaceSummary(paths, InPlaceOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, AlreadyCompressedNameError{named, ""}, results[2].Err)
+	// A genuine failure in the same run isn't mistaken for one
+	assert.False(t, errors.Is(results[3].Err, ErrAlreadyCompressedName))
+	assert.Equal(t, 2, summary.Succeeded)
+	assert.Equal(t, 1, summary.Skipped)
+	assert.Equal(t, 1, summary.Failed)
+}
+
+func TestRefusedSuffixed(t *testing.T) {
+	for command, stderr := range map[string]string{
+		"gzip":  "gzip: /tmp/x/f.tgz already has .tgz suffix -- unchanged\n",
+		"bzip2": "bzip2: Input file /tmp/x/f.tgz already has .tbz suffix.\n",
+		"xz":    "xz: /tmp/x/f.tgz: File already has `.txz' suffix, skipping\n",
+	} {
+		f := filtersMap[command]
+		assert.True(t, f.refusedSuffixed(stderr, "/tmp/x/f.tgz"), command)
+		assert.False(t, f.refusedSuffixed(stderr, "/tmp/x/other"), command)
+		assert.False(t, f.refusedSuffixed("gzip: /tmp/x/f.tgz: No such file or directory\n", "/tmp/x/f.tgz"), command)
+	}
+}
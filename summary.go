@@ -0,0 +1,226 @@
+package extcompress
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// Totals over the files of a batch in-place operation. Every file considered
+// is counted as exactly one of skipped, succeeded or failed, matching its
+// FileOpResult.
+type Summary struct {
+	Considered int
+	// Files left alone for already carrying the output suffix, or for having
+	// other hard links under HardLinkSkip
+	Skipped   int
+	Succeeded int
+	Failed    int
+	// Sizes of the files which succeeded, before and after
+	InputBytes  int64
+	OutputBytes int64
+	// OutputBytes over InputBytes, or 0 if nothing succeeded
+	Ratio    float64
+	WallTime time.Duration
+	// Failed files by the class of their error, see ErrorClass
+	Errors map[string]int
+	// Warnings the tool gave, as "path: warning", whether or not the file
+	// succeeded
+	Warnings []string
+}
+
+// Receives summaries of a batch while it runs.
+type SummaryFunc func(Summary)
+
+// How often Options.OnSummary is called when SummaryInterval isn't set
+const defaultSummaryInterval = time.Second
+
+// Returns a short, stable name for the kind of err, for grouping failures
+// in reports: "canceled", "corrupt_input", "incompressible",
+// "not_processed", "warnings", "exit_status", "not_found", "permission",
+// "invalid_path" or "other".
+func ErrorClass(err error) string {
+	var exitErr ExitStatusError
+	var cmdErr *exec.ExitError
+	var stdioErr StdioPathError
+	var suffixErr InvalidSuffix
+	var unknownErr UnknownSuffix
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "canceled"
+	case errors.Is(err, ErrCorruptInput):
+		return "corrupt_input"
+	case errors.Is(err, ErrIncompressible):
+		return "incompressible"
+	case errors.Is(err, ErrFileNotProcessed):
+		return "not_processed"
+	case errors.Is(err, ErrWarnings):
+		return "warnings"
+	case errors.As(err, &exitErr), errors.As(err, &cmdErr):
+		return "exit_status"
+	case os.IsNotExist(err):
+		return "not_found"
+	case os.IsPermission(err):
+		return "permission"
+	case errors.As(err, &stdioErr), errors.As(err, &suffixErr), errors.As(err, &unknownErr):
+		return "invalid_path"
+	}
+	return "other"
+}
+
+// Adds res to the totals.
+func (s *Summary) add(res FileOpResult) {
+	for _, warning := range res.Warnings {
+		s.Warnings = append(s.Warnings, res.OriginalPath+": "+warning)
+	}
+	switch {
+	case res.Err == nil:
+		s.Succeeded++
+		s.InputBytes += res.InputBytes
+		s.OutputBytes += res.OutputBytes
+		if s.InputBytes > 0 {
+			s.Ratio = float64(s.OutputBytes) / float64(s.InputBytes)
+		}
+	case errors.Is(res.Err, ErrAlreadyCompressedName), errors.Is(res.Err, ErrHardLinked):
+		s.Skipped++
+	default:
+		s.Failed++
+		if s.Errors == nil {
+			s.Errors = map[string]int{}
+		}
+		s.Errors[ErrorClass(res.Err)]++
+	}
+}
+
+// Totals results. WallTime is left unset, as the results don't record when
+// the batch started.
+func Summarize(results []FileOpResult) Summary {
+	s := Summary{Considered: len(results)}
+	for _, res := range results {
+		s.add(res)
+	}
+	return s
+}
+
+// Encodes the summary with snake_case keys and the wall time in seconds.
+func (s Summary) MarshalJSON() ([]byte, error) {
+	errs := s.Errors
+	if errs == nil {
+		errs = map[string]int{}
+	}
+	warnings := s.Warnings
+	if warnings == nil {
+		warnings = []string{}
+	}
+	return json.Marshal(struct {
+		Considered  int            `json:"considered"`
+		Skipped     int            `json:"skipped"`
+		Succeeded   int            `json:"succeeded"`
+		Failed      int            `json:"failed"`
+		InputBytes  int64          `json:"input_bytes"`
+		OutputBytes int64          `json:"output_bytes"`
+		Ratio       float64        `json:"ratio"`
+		WallTime    float64        `json:"wall_time_seconds"`
+		Errors      map[string]int `json:"errors"`
+		Warnings    []string       `json:"warnings"`
+	}{s.Considered, s.Skipped, s.Succeeded, s.Failed, s.InputBytes, s.OutputBytes,
+		s.Ratio, s.WallTime.Seconds(), errs, warnings})
+}
+
+// Keeps running totals of a batch, passing them to the handler's OnSummary
+// every SummaryInterval and once more when the batch ends.
+type summaryTracker struct {
+	fn      SummaryFunc
+	started time.Time
+	stopped chan struct{}
+	wg      sync.WaitGroup
+
+	mtx sync.Mutex
+	sum Summary
+}
+
+// Starts tracking a batch of n files, or returns nil if nothing is
+// listening.
+func (c Filter) trackSummary(n int) *summaryTracker {
+	if c.opts.OnSummary == nil {
+		return nil
+	}
+	interval := c.opts.SummaryInterval
+	if interval <= 0 {
+		interval = defaultSummaryInterval
+	}
+	t := &summaryTracker{
+		fn:      c.opts.OnSummary,
+		started: time.Now(),
+		stopped: make(chan struct{}),
+		sum:     Summary{Considered: n},
+	}
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				t.fn(t.snapshot())
+			case <-t.stopped:
+				return
+			}
+		}
+	}()
+	return t
+}
+
+// Counts the results at indexes, which are finished.
+func (t *summaryTracker) add(results []FileOpResult, indexes ...int) {
+	if t == nil {
+		return
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	for _, i := range indexes {
+		t.sum.add(results[i])
+	}
+}
+
+// Returns a copy of the totals so far.
+func (t *summaryTracker) snapshot() Summary {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	s := t.sum
+	s.WallTime = time.Since(t.started)
+	if s.Errors != nil {
+		s.Errors = make(map[string]int, len(t.sum.Errors))
+		for k, v := range t.sum.Errors {
+			s.Errors[k] = v
+		}
+	}
+	s.Warnings = append([]string(nil), t.sum.Warnings...)
+	return s
+}
+
+// Stops the ticker and reports the final totals.
+func (t *summaryTracker) stop() {
+	if t == nil {
+		return
+	}
+	close(t.stopped)
+	t.wg.Wait()
+	t.fn(t.snapshot())
+}
+
+// Compresses many files in place as for CompressFilesInPlaceResult, also
+// returning their totals.
+func (c Filter) CompressFilesInPlaceSummary(paths []string, opts InPlaceOptions) ([]FileOpResult, Summary, error) {
+	started := time.Now()
+	results, err := c.CompressFilesInPlaceResult(paths, opts)
+	s := Summarize(results)
+	s.Considered = len(paths)
+	s.WallTime = time.Since(started)
+	return results, s, err
+}
@@ -0,0 +1,115 @@
+package extcompress
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressFilesInPlaceSummary(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	var mtx sync.Mutex
+	var live []Summary
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	h = h.WithOptions(Options{
+		OnSummary: func(s Summary) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			live = append(live, s)
+		},
+		SummaryInterval: time.Millisecond,
+	})
+
+	paths := writeSmallFiles(t, tmpdir, 3)
+	done := path.Join(tmpdir, "done.log.gz")
+	assert.Nil(t, ioutil.WriteFile(done, []byte(data), os.FileMode(0644)))
+	paths = append(paths, done, path.Join(tmpdir, "missing.log"), "-")
+
+	results, summary, err := h.CompressFilesInPlaceSummary(paths, InPlaceOptions{})
+	assert.Nil(t, err)
+	assert.Len(t, results, len(paths))
+	assert.Equal(t, AlreadyCompressedNameError{done, ".gz"}, results[3].Err)
+	assert.Equal(t, -1, results[3].ExitCode)
+	_, err = os.Stat(done + ".gz")
+	assert.True(t, os.IsNotExist(err))
+
+	var input, output int64
+	for _, res := range results[:3] {
+		assert.Nil(t, res.Err)
+		input += res.InputBytes
+		output += res.OutputBytes
+	}
+	assert.Equal(t, 6, summary.Considered)
+	assert.Equal(t, 1, summary.Skipped)
+	assert.Equal(t, 3, summary.Succeeded)
+	assert.Equal(t, 2, summary.Failed)
+	assert.Equal(t, input, summary.InputBytes)
+	assert.Equal(t, output, summary.OutputBytes)
+	assert.InDelta(t, float64(output)/float64(input), summary.Ratio, 1e-9)
+	assert.NotZero(t, summary.WallTime)
+	assert.Equal(t, map[string]int{"exit_status": 1, "invalid_path": 1}, summary.Errors)
+	assert.Equal(t, summary.Errors, Summarize(results).Errors)
+
+	// The last live summary is the final one
+	mtx.Lock()
+	defer mtx.Unlock()
+	assert.NotEmpty(t, live)
+	last := live[len(live)-1]
+	last.WallTime = summary.WallTime
+	assert.Equal(t, summary, last)
+}
+
+func TestAllowRecompressionBatch(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	h, err := GetExternalHandlerFromMimeType("application/zstd")
+	assert.Nil(t, err)
+	filename := path.Join(tmpdir, "twice.zst")
+	assert.Nil(t, ioutil.WriteFile(filename, []byte(data), os.FileMode(0644)))
+
+	_, summary, err := h.WithOptions(Options{AllowRecompression: Bool(true)}).
+		CompressFilesInPlaceSummary([]string{filename}, InPlaceOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, summary.Succeeded)
+	_, err = os.Stat(filename + ".zst")
+	assert.Nil(t, err)
+}
+
+func TestSummaryJSON(t *testing.T) {
+	s := Summary{
+		Considered: 4, Skipped: 1, Succeeded: 2, Failed: 1,
+		InputBytes: 200, OutputBytes: 50, Ratio: 0.25,
+		WallTime: 1500 * time.Millisecond,
+		Errors:   map[string]int{"corrupt_input": 1},
+	}
+	out, err := json.Marshal(s)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"considered":4,"skipped":1,"succeeded":2,"failed":1,
+		"input_bytes":200,"output_bytes":50,"ratio":0.25,"wall_time_seconds":1.5,
+		"errors":{"corrupt_input":1},"warnings":[]}`, string(out))
+
+	// No failures still gives an object
+	out, err = json.Marshal(Summary{})
+	assert.Nil(t, err)
+	assert.Contains(t, string(out), `"errors":{}`)
+}
+
+func TestErrorClass(t *testing.T) {
+	assert.Equal(t, "corrupt_input", ErrorClass(CorruptInputError{Command: "gzip"}))
+	assert.Equal(t, "exit_status", ErrorClass(ExitStatusError{"gzip", 1, ""}))
+	assert.Equal(t, "not_processed", ErrorClass(ErrFileNotProcessed))
+	assert.Equal(t, "invalid_path", ErrorClass(StdioPathError{"-"}))
+	_, err := os.Open("/nonexistent/extcompress")
+	assert.Equal(t, "not_found", ErrorClass(err))
+	assert.Equal(t, "other", ErrorClass(ErrBadIndex))
+}
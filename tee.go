@@ -0,0 +1,125 @@
+package extcompress
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Returned when reading the source of a tee compression fails.
+type TeeReadError struct {
+	Err error
+}
+
+func (r TeeReadError) Error() string {
+	return fmt.Sprintf("tee source read failed: %v", r.Err)
+}
+
+// Returned when writing the raw copy of a tee compression fails.
+type TeeWriteError struct {
+	Err error
+}
+
+func (r TeeWriteError) Error() string {
+	return fmt.Sprintf("tee raw destination write failed: %v", r.Err)
+}
+
+// Feeds the compressor's stdin while copying everything to the raw
+// destination. Errors are held here rather than passed to exec, which would
+// otherwise report them from Wait as a failed command.
+type teeReader struct {
+	r   io.Reader
+	w   io.Writer
+	mtx sync.Mutex
+	err error
+}
+
+func (t *teeReader) Read(p []byte) (int, error) {
+	if t.Err() != nil {
+		return 0, io.EOF
+	}
+
+	n, err := t.r.Read(p)
+	if n > 0 {
+		// Writing synchronously means a slow rawDst throttles the compressor.
+		wn, werr := t.w.Write(p[:n])
+		if werr == nil && wn != n {
+			werr = io.ErrShortWrite
+		}
+		if werr != nil {
+			t.setErr(TeeWriteError{werr})
+			return 0, io.EOF
+		}
+	}
+	if err != nil && err != io.EOF {
+		t.setErr(TeeReadError{err})
+		return n, io.EOF
+	}
+	return n, err
+}
+
+func (t *teeReader) setErr(err error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.err = err
+}
+
+func (t *teeReader) Err() error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	return t.err
+}
+
+// Compression process which reports any tee failure at the end of the
+// compressed stream, so a truncated input is never mistaken for a clean EOF.
+type teeJob struct {
+	CompressionProcess
+	tee *teeReader
+}
+
+func (j *teeJob) Read(p []byte) (int, error) {
+	n, err := j.CompressionProcess.Read(p)
+	if err == io.EOF {
+		if terr := j.tee.Err(); terr != nil {
+			return n, terr
+		}
+	}
+	return n, err
+}
+
+// Returns the compressor's exit status, or 1 if it finished cleanly on an
+// input cut short by a tee failure.
+func (j *teeJob) Result() int {
+	status := j.CompressionProcess.Result()
+	if status == 0 && j.tee.Err() != nil {
+		return 1
+	}
+	return status
+}
+
+// Waits for the compressor and returns the TeeReadError or TeeWriteError
+// which cut its input short, if there was one, or else why it failed.
+func (j *teeJob) Err() error {
+	err := processErr(j.CompressionProcess)
+	if terr := j.tee.Err(); terr != nil {
+		return terr
+	}
+	return err
+}
+
+// Compresses r while simultaneously writing the uncompressed bytes to rawDst.
+// Each chunk is written to rawDst before it is handed to the compressor, so a
+// slow rawDst slows the whole pipeline rather than dropping data. If reading r
+// or writing rawDst fails, reading the returned process yields a TeeReadError
+// or TeeWriteError respectively in place of io.EOF, its Result is non-zero,
+// and its Err method returns the error.
+func CompressTee(r io.Reader, rawDst io.Writer, h ExternalHandler) (CompressionProcess, error) {
+	tee := &teeReader{r: r, w: rawDst}
+
+	job, err := h.CompressStream(tee)
+	if err != nil {
+		return nil, err
+	}
+
+	return &teeJob{job, tee}, nil
+}
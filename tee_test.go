@@ -0,0 +1,111 @@
+package extcompress
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressTee(t *testing.T) {
+	original := seekableTestData(300000)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	var raw, compressed bytes.Buffer
+	job, err := CompressTee(bytes.NewReader(original), &raw, h)
+	assert.Nil(t, err)
+	_, err = io.Copy(&compressed, job)
+	assert.Nil(t, err)
+	assert.Zero(t, job.Result())
+
+	assert.Equal(t, original, raw.Bytes())
+
+	dr, err := h.DecompressStream(ioutil.NopCloser(&compressed))
+	assert.Nil(t, err)
+	out, err := ioutil.ReadAll(dr)
+	assert.Nil(t, err)
+	assert.Zero(t, dr.Result())
+	assert.Equal(t, original, out)
+}
+
+// Accepts a fixed number of bytes then fails.
+type failingWriter struct {
+	remaining int
+	written   bytes.Buffer
+}
+
+var errSinkFull = errors.New("sink full")
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.remaining {
+		return 0, errSinkFull
+	}
+	w.remaining -= len(p)
+	return w.written.Write(p)
+}
+
+func TestCompressTeeRawFailure(t *testing.T) {
+	original := seekableTestData(300000)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	raw := &failingWriter{remaining: 100000}
+	job, err := CompressTee(bytes.NewReader(original), raw, h)
+	assert.Nil(t, err)
+	_, err = io.Copy(ioutil.Discard, job)
+	assert.Equal(t, TeeWriteError{errSinkFull}, err)
+	assert.NotZero(t, job.Result())
+	assert.Equal(t, TeeWriteError{errSinkFull}, job.(interface{ Err() error }).Err())
+
+	// Whatever reached the raw sink is an exact prefix of the input
+	assert.Equal(t, original[:raw.written.Len()], raw.written.Bytes())
+}
+
+type failingReader struct{}
+
+var errSourceBroken = errors.New("source broken")
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errSourceBroken
+}
+
+func TestCompressTeeSourceFailure(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	var raw bytes.Buffer
+	src := io.MultiReader(bytes.NewReader([]byte("some data")), failingReader{})
+	job, err := CompressTee(src, &raw, h)
+	assert.Nil(t, err)
+	_, err = io.Copy(ioutil.Discard, job)
+	assert.Equal(t, TeeReadError{errSourceBroken}, err)
+	assert.NotZero(t, job.Result())
+	assert.Equal(t, "some data", raw.String())
+}
+
+func TestCompressTeeFailureResult(t *testing.T) {
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	// A caller which never reads to the end still learns of the failure
+	src := io.MultiReader(bytes.NewReader([]byte("some data")), failingReader{})
+	job, err := CompressTee(src, ioutil.Discard, h)
+	assert.Nil(t, err)
+	assert.NotZero(t, job.Result())
+	assert.Equal(t, TeeReadError{errSourceBroken}, job.(interface{ Err() error }).Err())
+	assert.Equal(t, TeeReadError{errSourceBroken}, processErr(job))
+
+	// And a clean run still succeeds
+	job, err = CompressTee(bytes.NewReader([]byte(data)), ioutil.Discard, h)
+	assert.Nil(t, err)
+	_, err = io.Copy(ioutil.Discard, job)
+	assert.Nil(t, err)
+	assert.Zero(t, job.Result())
+	assert.Nil(t, job.(interface{ Err() error }).Err())
+}
@@ -0,0 +1,201 @@
+package extcompress
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Most that is preallocated on the word of a size hint, which may come from
+// a corrupt or hostile file. Larger outputs grow as usual.
+var maxPreallocate = 256 << 20
+
+// Decompresses filePath into memory. The buffer is allocated up front for
+// sizeHint bytes, or if that is zero for the size the format's metadata
+// gives (gzip's ISIZE, the xz index, or a zstd frame's content size), and
+// trimmed if the output turns out much smaller.
+func (c Filter) DecompressToBytes(filePath string, sizeHint int) ([]byte, error) {
+	if sizeHint <= 0 {
+		sizeHint = c.metadataSize(filePath)
+	}
+	proc, err := c.Decompress(filePath)
+	if err != nil {
+		return nil, err
+	}
+	out, err := readAppend(make([]byte, 0, preallocation(sizeHint)), proc)
+	if err != nil {
+		proc.Close()
+		return nil, err
+	}
+	if err := processErr(proc); err != nil {
+		return nil, err
+	}
+	return trimmed(out), nil
+}
+
+// Decompresses r, appending the output to dst, and returns the extended
+// slice. Spare capacity in dst is filled before it is grown, so callers
+// knowing the output size can allocate for it. On error dst is returned
+// with whatever was appended.
+func (c Filter) DecompressAppend(dst []byte, r io.Reader) ([]byte, error) {
+	proc, err := c.DecompressStream(r)
+	if err != nil {
+		return dst, err
+	}
+	dst, err = readAppend(dst, proc)
+	if err != nil {
+		proc.Close()
+		return dst, err
+	}
+	return dst, processErr(proc)
+}
+
+// Reads r to EOF into the spare capacity of dst, growing it only once it is
+// full and more output follows.
+func readAppend(dst []byte, r io.Reader) ([]byte, error) {
+	var probe [512]byte
+	for {
+		if len(dst) == cap(dst) {
+			// Check for EOF before growing, so an exact fit isn't doubled
+			n, err := r.Read(probe[:])
+			dst = append(dst, probe[:n]...)
+			if err == io.EOF {
+				return dst, nil
+			}
+			if err != nil {
+				return dst, err
+			}
+			continue
+		}
+		n, err := r.Read(dst[len(dst):cap(dst)])
+		dst = dst[:len(dst)+n]
+		if err == io.EOF {
+			return dst, nil
+		}
+		if err != nil {
+			return dst, err
+		}
+	}
+}
+
+// Returns why a finished process failed, or nil.
+func processErr(proc CompressionProcess) error {
+	if job, ok := proc.(*CompressionJob); ok {
+		return job.Err()
+	}
+	if job, ok := proc.(*teeJob); ok {
+		return job.Err()
+	}
+	if job, ok := proc.(*fallbackJob); ok {
+		job.Result()
+		return job.Err()
+	}
+	if status := statusOf(proc); status != 0 {
+		return ExitStatusError{Command: "decompressor", ExitStatus: status, JobID: proc.ID()}
+	}
+	return nil
+}
+
+func preallocation(sizeHint int) int {
+	if sizeHint < 0 {
+		return 0
+	}
+	if sizeHint > maxPreallocate {
+		return maxPreallocate
+	}
+	return sizeHint
+}
+
+// Copies b into a buffer of its size if most of its capacity is unused.
+func trimmed(b []byte) []byte {
+	if cap(b)-len(b) <= len(b)/8+4096 {
+		return b
+	}
+	return append(make([]byte, 0, len(b)), b...)
+}
+
+// Returns the uncompressed size the format's metadata claims for filePath,
+// or 0 if it has none or it can't be read.
+func (c Filter) metadataSize(filePath string) int {
+	f, st, err := c.openSource(filePath)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	if !st.Mode().IsRegular() {
+		return 0
+	}
+
+	var size int64
+	switch FormatOf(c) {
+	case FormatGzip:
+		size = gzipISize(f, st.Size())
+	case FormatXz:
+		streams, err := xzStreams(f, st.Size())
+		if err != nil {
+			return 0
+		}
+		for _, s := range streams {
+			for _, b := range s.blocks {
+				size += b.uncompressedSize
+			}
+		}
+	case FormatZstd:
+		size = zstdContentSize(f)
+	}
+	if size < 0 || int64(int(size)) != size {
+		return 0
+	}
+	return int(size)
+}
+
+// Returns the size recorded in the trailer of the last gzip member, which is
+// the whole size for single member files smaller than 4GiB.
+func gzipISize(f io.ReaderAt, size int64) int64 {
+	const minGzipSize = 18
+	if size < minGzipSize {
+		return 0
+	}
+	trailer := make([]byte, 4)
+	if _, err := f.ReadAt(trailer, size-4); err != nil {
+		return 0
+	}
+	return int64(binary.LittleEndian.Uint32(trailer))
+}
+
+const zstdFrameMagic = 0xFD2FB528
+
+// Returns the content size from the header of the first zstd frame, or 0 if
+// it isn't recorded. See RFC 8878 section 3.1.1.1.
+func zstdContentSize(f io.ReaderAt) int64 {
+	header := make([]byte, 18)
+	n, _ := f.ReadAt(header, 0)
+	header = header[:n]
+	if len(header) < 5 || binary.LittleEndian.Uint32(header) != zstdFrameMagic {
+		return 0
+	}
+	desc := header[4]
+	singleSegment := desc&0x20 != 0
+	pos := 5
+	if !singleSegment {
+		pos++ // Window descriptor
+	}
+	pos += []int{0, 1, 2, 4}[desc&0x3] // Dictionary ID
+
+	fcsSize := []int{0, 2, 4, 8}[desc>>6]
+	if fcsSize == 0 && singleSegment {
+		fcsSize = 1
+	}
+	if fcsSize == 0 || len(header) < pos+fcsSize {
+		return 0
+	}
+	field := header[pos : pos+fcsSize]
+	switch fcsSize {
+	case 1:
+		return int64(field[0])
+	case 2:
+		return int64(binary.LittleEndian.Uint16(field)) + 256
+	case 4:
+		return int64(binary.LittleEndian.Uint32(field))
+	}
+	return int64(binary.LittleEndian.Uint64(field))
+}
@@ -0,0 +1,181 @@
+package extcompress
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Compresses original into dir with command, returning the path.
+func writeCompressed(t testing.TB, dir string, command string, original []byte) string {
+	filename := path.Join(dir, command+"-input")
+	assert.Nil(t, ioutil.WriteFile(filename, original, os.FileMode(0644)))
+	out, err := exec.Command(command, "-q", "-c", filename).Output()
+	assert.Nil(t, err)
+	compressed := filename + "." + command
+	assert.Nil(t, ioutil.WriteFile(compressed, out, os.FileMode(0644)))
+	return compressed
+}
+
+func TestDecompressToBytes(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	original := seekableTestData(300000)
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	filename := writeCompressed(t, tmpdir, "gzip", original)
+
+	for _, hint := range []int{len(original), 1000, 0, -1, len(original) * 100} {
+		out, err := h.DecompressToBytes(filename, hint)
+		assert.Nil(t, err, "hint %d", hint)
+		assert.Equal(t, original, out, "hint %d", hint)
+		assert.True(t, cap(out) <= len(out)+len(out)/8+4096, "hint %d left cap %d", hint, cap(out))
+	}
+
+	// An exact hint is used as is
+	out, err := h.DecompressToBytes(filename, len(original))
+	assert.Nil(t, err)
+	assert.Equal(t, len(original), cap(out))
+}
+
+func TestDecompressToBytesCorrupt(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	filename := path.Join(tmpdir, "corrupt.gz")
+	assert.Nil(t, ioutil.WriteFile(filename, []byte("\x1f\x8b\x08\x00 not really gzip data"), os.FileMode(0644)))
+
+	_, err = h.DecompressToBytes(filename, 0)
+	assert.NotNil(t, err)
+
+	_, err = h.DecompressToBytes(path.Join(tmpdir, "missing.gz"), 0)
+	assert.NotNil(t, err)
+}
+
+func TestDecompressAppend(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	original := seekableTestData(100000)
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	compressed, err := ioutil.ReadFile(writeCompressed(t, tmpdir, "gzip", original))
+	assert.Nil(t, err)
+
+	prefix := []byte("prefix:")
+	dst := make([]byte, len(prefix), len(prefix)+len(original))
+	copy(dst, prefix)
+	out, err := h.DecompressAppend(dst, bytes.NewReader(compressed))
+	assert.Nil(t, err)
+	assert.Equal(t, append(append([]byte{}, prefix...), original...), out)
+	// Filled in place without growing
+	assert.Equal(t, cap(dst), cap(out))
+	assert.Equal(t, &dst[0], &out[0])
+
+	// A nil dst grows as needed
+	out, err = h.DecompressAppend(nil, bytes.NewReader(compressed))
+	assert.Nil(t, err)
+	assert.Equal(t, original, out)
+
+	_, err = h.DecompressAppend(nil, bytes.NewReader(compressed[:len(compressed)/2]))
+	assert.NotNil(t, err)
+}
+
+func TestMetadataSize(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	original := seekableTestData(123457)
+	for _, mimeType := range []string{"application/gzip", "application/x-xz", "application/zstd"} {
+		h, err := GetExternalHandlerFromMimeType(mimeType)
+		assert.Nil(t, err)
+		f := h.(Filter)
+		filename := writeCompressed(t, tmpdir, f.Command, original)
+		assert.Equal(t, len(original), f.metadataSize(filename), mimeType)
+
+		out, err := h.DecompressToBytes(filename, 0)
+		assert.Nil(t, err, mimeType)
+		assert.Equal(t, original, out, mimeType)
+		assert.Equal(t, len(original), cap(out), mimeType)
+	}
+
+	// Formats without a recorded size give no hint
+	h, err := GetExternalHandlerFromMimeType("application/x-bzip2")
+	assert.Nil(t, err)
+	filename := writeCompressed(t, tmpdir, "bzip2", original)
+	assert.Equal(t, 0, h.(Filter).metadataSize(filename))
+}
+
+func TestZstdContentSize(t *testing.T) {
+	cases := []struct {
+		header []byte
+		size   int64
+	}{
+		// Single segment, 1 byte size
+		{[]byte{0x28, 0xb5, 0x2f, 0xfd, 0x20, 200}, 200},
+		// 2 byte size is offset by 256
+		{[]byte{0x28, 0xb5, 0x2f, 0xfd, 0x60, 0x00, 0x01}, 512},
+		// Window descriptor and a 1 byte dictionary ID before a 4 byte size
+		{[]byte{0x28, 0xb5, 0x2f, 0xfd, 0x81, 0x50, 0x07, 0x40, 0x42, 0x0f, 0x00}, 1000000},
+		// No size recorded
+		{[]byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, 0x50}, 0},
+		// Truncated
+		{[]byte{0x28, 0xb5, 0x2f, 0xfd, 0x81, 0x50}, 0},
+		// Not zstd
+		{[]byte("not a zstd frame"), 0},
+	}
+	for i, c := range cases {
+		assert.Equal(t, c.size, zstdContentSize(bytes.NewReader(c.header)), "case %d", i)
+	}
+}
+
+func benchmarkToBytes(b *testing.B, read func(h ExternalHandler, filename string) ([]byte, error)) {
+	for _, mb := range []int{1, 10, 50} {
+		b.Run(fmt.Sprintf("%dMB", mb), func(b *testing.B) {
+			tmpdir, err := ioutil.TempDir("", "extcompress_bench")
+			assert.Nil(b, err)
+			defer os.RemoveAll(tmpdir)
+			h, err := GetExternalHandlerFromMimeType("application/gzip")
+			assert.Nil(b, err)
+			filename := writeCompressed(b, tmpdir, "gzip", seekableTestData(mb<<20))
+
+			b.SetBytes(int64(mb << 20))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := read(h, filename); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDecompressToBytes(b *testing.B) {
+	benchmarkToBytes(b, func(h ExternalHandler, filename string) ([]byte, error) {
+		return h.DecompressToBytes(filename, 0)
+	})
+}
+
+func BenchmarkDecompressReadAll(b *testing.B) {
+	benchmarkToBytes(b, func(h ExternalHandler, filename string) ([]byte, error) {
+		proc, err := h.Decompress(filename)
+		if err != nil {
+			return nil, err
+		}
+		out, err := ioutil.ReadAll(proc)
+		if err != nil {
+			return nil, err
+		}
+		return out, processErr(proc)
+	})
+}
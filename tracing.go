@@ -0,0 +1,172 @@
+package extcompress
+
+import (
+	"context"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Creates spans for the package's operations. Kept minimal so tracing
+// systems such as OpenTelemetry can be adapted to it without the package
+// depending on them.
+type Tracer interface {
+	// Starts a span called name, parented from any span carried by ctx
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// A span started by a Tracer. Attribute values are strings, ints or int64s.
+type Span interface {
+	SetAttribute(key string, value interface{})
+	// Marks the span as failed
+	SetError(err error)
+	End()
+}
+
+// Attributes set on operation spans. Bytes and the exit status are left out
+// where they aren't known.
+const (
+	AttrMimeType   = "extcompress.mimetype"
+	AttrCommand    = "extcompress.command"
+	AttrJobID      = "extcompress.job_id"
+	AttrBytesIn    = "extcompress.bytes_in"
+	AttrBytesOut   = "extcompress.bytes_out"
+	AttrExitStatus = "extcompress.exit_status"
+	AttrDurationMs = "extcompress.duration_ms"
+	// The handler's Provenance, in its compact form
+	AttrProvenance = "extcompress.provenance"
+)
+
+var (
+	tracerMtx sync.RWMutex
+	tracer    Tracer
+)
+
+// Sets the tracer every operation started afterwards gets a span from,
+// parented from the context given to WithContext. Nil (the default)
+// disables tracing.
+func SetTracer(t Tracer) {
+	tracerMtx.Lock()
+	defer tracerMtx.Unlock()
+	tracer = t
+}
+
+func currentTracer() Tracer {
+	tracerMtx.RLock()
+	defer tracerMtx.RUnlock()
+	return tracer
+}
+
+// The span of one run of a tool, which also holds the tool's slot under the
+// process limit until it ends. span is nil when tracing is off, and a nil
+// opSpan does nothing.
+type opSpan struct {
+	span    Span
+	started time.Time
+	release func()
+	// Logs the job's failure if sampling left the rest of it out
+	quiet *log.Entry
+}
+
+// Starts the span for job id running cmd for operation.
+func (c Filter) startSpan(operation string, id string, cmd *exec.Cmd) *opSpan {
+	t := currentTracer()
+	if t == nil {
+		return nil
+	}
+	ctx := c.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	_, span := t.Start(ctx, "extcompress."+operation)
+	span.SetAttribute(AttrMimeType, c.mimeType)
+	span.SetAttribute(AttrCommand, c.displayCommand(c.toolArgs(cmd)))
+	span.SetAttribute(AttrJobID, id)
+	span.SetAttribute(AttrProvenance, c.prov.String())
+	return &opSpan{span: span, started: time.Now()}
+}
+
+// Starts cmd for operation under a new span, once the process limit allows
+// (see SetProcessLimit), with the handler's ExtraInputs attached. A failure
+// to start ends the span with the error. Arguments or environment which
+// can't be passed to the tool fail with an InvalidArgumentError.
+func (c Filter) spawn(operation string, id string, cmd *exec.Cmd) (*opSpan, error) {
+	if err := c.checkExec(cmd); err != nil {
+		return nil, err
+	}
+	release, err := scheduler.acquire(c.ctx, c.opts.Priority)
+	if err != nil {
+		return nil, err
+	}
+	extra, err := c.attachExtraInputs(cmd)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	c.jobLog(id).WithField("provenance", c.prov.String()).Debug("Spawning " + operation)
+	span := c.startSpan(operation, id, cmd)
+	if span == nil {
+		span = &opSpan{started: time.Now()}
+	}
+	span.release = release
+	if !logSampled(id) {
+		span.quiet = c.jobLog(id).WithField("compressCmd", printable(c.Command))
+	}
+	if err := cmd.Start(); err != nil {
+		err = c.classifyStartError(cmd, err)
+		extra.abort()
+		span.end(-1, -1, -1, err)
+		return nil, err
+	}
+	extra.started()
+	return span, nil
+}
+
+// Records how the operation went and ends the span. Negative values are
+// unknown and left out.
+func (s *opSpan) end(status int, bytesIn int64, bytesOut int64, err error) {
+	if s == nil {
+		return
+	}
+	if s.release != nil {
+		s.release()
+	}
+	countJob(bytesIn, bytesOut, err)
+	if err != nil && s.quiet != nil {
+		s.quiet.WithFields(log.Fields{"status": status, "error": err.Error()}).Warn("Job failed")
+	}
+	if s.span == nil {
+		return
+	}
+	if status >= 0 {
+		s.span.SetAttribute(AttrExitStatus, status)
+	}
+	if bytesIn >= 0 {
+		s.span.SetAttribute(AttrBytesIn, bytesIn)
+	}
+	if bytesOut >= 0 {
+		s.span.SetAttribute(AttrBytesOut, bytesOut)
+	}
+	s.span.SetAttribute(AttrDurationMs, time.Since(s.started).Milliseconds())
+	if err != nil {
+		s.span.SetError(err)
+	}
+	s.span.End()
+}
+
+// Returns the exit status of a command which Wait returned err for, or -1
+// if it didn't exit normally.
+func exitStatusOf(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exiterr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
+			return status.ExitStatus()
+		}
+	}
+	return -1
+}
@@ -0,0 +1,199 @@
+package extcompress
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A span kept in memory by spanRecorder.
+type recordedSpan struct {
+	name   string
+	parent *recordedSpan
+
+	mtx   sync.Mutex
+	attrs map[string]interface{}
+	err   error
+	ended bool
+}
+
+func (s *recordedSpan) SetAttribute(key string, value interface{}) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.attrs[key] = value
+}
+
+func (s *recordedSpan) SetError(err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.err = err
+}
+
+func (s *recordedSpan) End() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.ended = true
+}
+
+type spanKey struct{}
+
+// Records every span started, in order.
+type spanRecorder struct {
+	mtx   sync.Mutex
+	spans []*recordedSpan
+}
+
+func (r *spanRecorder) Start(ctx context.Context, name string) (context.Context, Span) {
+	parent, _ := ctx.Value(spanKey{}).(*recordedSpan)
+	s := &recordedSpan{name: name, parent: parent, attrs: map[string]interface{}{}}
+	r.mtx.Lock()
+	r.spans = append(r.spans, s)
+	r.mtx.Unlock()
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Returns the one span called name.
+func (r *spanRecorder) span(t *testing.T, name string) *recordedSpan {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	var found []*recordedSpan
+	for _, s := range r.spans {
+		if s.name == name {
+			found = append(found, s)
+		}
+	}
+	if !assert.Len(t, found, 1, name) {
+		t.FailNow()
+	}
+	return found[0]
+}
+
+func recordSpans(t *testing.T) *spanRecorder {
+	r := &spanRecorder{}
+	SetTracer(r)
+	t.Cleanup(func() { SetTracer(nil) })
+	return r
+}
+
+func TestTracingPipeline(t *testing.T) {
+	gz, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	xz, err := GetExternalHandlerFromMimeType("application/x-xz")
+	assert.Nil(t, err)
+	compressed := compressBytes(t, gz, []byte(data))
+
+	r := recordSpans(t)
+	ctx, root := r.Start(context.Background(), "pipeline")
+
+	dec, err := gz.WithContext(ctx).DecompressStream(bytes.NewReader(compressed))
+	assert.Nil(t, err)
+	comp, err := xz.WithContext(ctx).CompressStream(dec)
+	assert.Nil(t, err)
+	out, err := ioutil.ReadAll(comp)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, comp.Result())
+	assert.Equal(t, 0, dec.Result())
+	root.End()
+
+	decSpan := r.span(t, "extcompress.DecompressStream")
+	compSpan := r.span(t, "extcompress.CompressStream")
+	for _, s := range []*recordedSpan{decSpan, compSpan} {
+		assert.Equal(t, root, s.parent, s.name)
+		assert.True(t, s.ended, s.name)
+		assert.Nil(t, s.err, s.name)
+		assert.Equal(t, 0, s.attrs[AttrExitStatus], s.name)
+		assert.Contains(t, s.attrs, AttrDurationMs, s.name)
+	}
+
+	assert.Equal(t, "application/gzip", decSpan.attrs[AttrMimeType])
+	assert.Equal(t, gz.CommandStreamDecompress(), decSpan.attrs[AttrCommand])
+	assert.Equal(t, dec.ID(), decSpan.attrs[AttrJobID])
+	assert.Equal(t, int64(len(compressed)), decSpan.attrs[AttrBytesIn])
+	assert.Equal(t, int64(len(data)), decSpan.attrs[AttrBytesOut])
+
+	assert.Equal(t, "application/x-xz", compSpan.attrs[AttrMimeType])
+	assert.Equal(t, xz.CommandStreamCompress(), compSpan.attrs[AttrCommand])
+	assert.Equal(t, comp.ID(), compSpan.attrs[AttrJobID])
+	assert.Equal(t, int64(len(data)), compSpan.attrs[AttrBytesIn])
+	assert.Equal(t, int64(len(out)), compSpan.attrs[AttrBytesOut])
+}
+
+func TestTracingErrors(t *testing.T) {
+	gz, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	r := recordSpans(t)
+	proc, err := gz.DecompressStream(bytes.NewReader([]byte("not gzip data")))
+	assert.Nil(t, err)
+	_, err = ioutil.ReadAll(proc)
+	assert.Nil(t, err)
+	assert.NotEqual(t, 0, proc.Result())
+
+	s := r.span(t, "extcompress.DecompressStream")
+	assert.Nil(t, s.parent)
+	assert.True(t, errors.Is(s.err, ErrCorruptInput))
+	assert.Equal(t, proc.Result(), s.attrs[AttrExitStatus])
+
+	// A tool which can't be started still gets a span
+	dir := pathWith(t)
+	script := path.Join(dir, "extcompress-test-tool")
+	assert.Nil(t, ioutil.WriteFile(script, []byte("#!/bin/sh\nexec cat\n"), os.FileMode(0755)))
+	h := NewFilter("extcompress-test-tool")
+	assert.Nil(t, os.Remove(script))
+	_, err = h.CompressStream(bytes.NewReader(nil))
+	assert.NotNil(t, err)
+
+	s = r.span(t, "extcompress.CompressStream")
+	assert.True(t, s.ended)
+	assert.Equal(t, err, s.err)
+	assert.NotContains(t, s.attrs, AttrExitStatus)
+}
+
+func TestTracingFileOperations(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	gz, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	r := recordSpans(t)
+	ctx, root := r.Start(context.Background(), "root")
+	gz = gz.WithContext(ctx)
+
+	assert.Nil(t, gz.CompressFileInPlace(path.Join(tmpdir, "pipechaining")))
+	s := r.span(t, "extcompress.CompressFileInPlace")
+	assert.Equal(t, root, s.parent)
+	assert.Equal(t, 0, s.attrs[AttrExitStatus])
+	assert.Equal(t, "application/gzip", s.attrs[AttrMimeType])
+
+	dst := path.Join(tmpdir, "into.gz")
+	w, err := gz.CompressIntoFile(dst)
+	assert.Nil(t, err)
+	_, err = w.Write([]byte(data))
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+	st, err := os.Stat(dst)
+	assert.Nil(t, err)
+
+	s = r.span(t, "extcompress.CompressIntoFile")
+	assert.Equal(t, root, s.parent)
+	assert.True(t, s.ended)
+	assert.Equal(t, int64(len(data)), s.attrs[AttrBytesIn])
+	assert.Equal(t, st.Size(), s.attrs[AttrBytesOut])
+
+	proc, err := gz.Decompress(dst)
+	assert.Nil(t, err)
+	_, err = ioutil.ReadAll(proc)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, proc.Result())
+	s = r.span(t, "extcompress.Decompress")
+	assert.Equal(t, st.Size(), s.attrs[AttrBytesIn])
+	assert.Equal(t, int64(len(data)), s.attrs[AttrBytesOut])
+}
@@ -0,0 +1,157 @@
+package extcompress
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// Which way Transform runs its handler.
+type Mode int
+
+const (
+	ModeCompress Mode = iota
+	ModeDecompress
+)
+
+// Records the first error from the source of a Transform, other than EOF.
+type sourceErrReader struct {
+	r   io.Reader
+	mtx sync.Mutex
+	err error
+}
+
+func (s *sourceErrReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if err != nil && err != io.EOF {
+		s.mtx.Lock()
+		if s.err == nil {
+			s.err = err
+		}
+		s.mtx.Unlock()
+	}
+	return n, err
+}
+
+// Passes on the job closing its source once the tool exits.
+func (s *sourceErrReader) Close() error {
+	if c, ok := s.r.(io.Closer); ok {
+		return closeSource(c)
+	}
+	return nil
+}
+
+func (s *sourceErrReader) failure() error {
+	if s == nil {
+		return nil
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.err
+}
+
+// The reader returned by Transform.
+type transformReader struct {
+	job    CompressionProcess
+	source *sourceErrReader
+	// Set once the output has ended or failed, with why it failed
+	done bool
+	err  error
+	// Set once the job has been torn down
+	closed bool
+}
+
+// Feeds r through h in either direction and returns a reader of the result,
+// taking care of the copy into the tool. Reading returns EOF only if the
+// whole transform succeeded; otherwise the error joins (errors.Join)
+// whatever went wrong: reading the source, the tool's exit status, and
+// reading its output. Close returns the same error, or nil if the output was
+// abandoned before it ended, and always tears the job down, so no goroutine
+// is left behind whichever side fails first. A source which blocks forever
+// can only be abandoned, not interrupted, unless it is an io.Closer, which is
+// closed once the tool exits.
+func Transform(r io.Reader, h ExternalHandler, mode Mode) (io.ReadCloser, error) {
+	var source *sourceErrReader
+	input := r
+	// Files go to the tool directly, with nothing to copy
+	if _, ok := r.(*os.File); !ok {
+		source = &sourceErrReader{r: r}
+		input = source
+	}
+
+	var job CompressionProcess
+	var err error
+	switch mode {
+	case ModeCompress:
+		job, err = h.CompressStream(input)
+	case ModeDecompress:
+		job, err = h.DecompressStream(input)
+	default:
+		return nil, ErrInvalidOperation
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &transformReader{job: job, source: source}, nil
+}
+
+func (t *transformReader) Read(p []byte) (int, error) {
+	if t.done {
+		if t.err != nil {
+			return 0, t.err
+		}
+		return 0, io.EOF
+	}
+	n, err := t.job.Read(p)
+	if err == nil {
+		return n, nil
+	}
+	t.done = true
+	var readErr error
+	if err != io.EOF {
+		readErr = err
+		t.job.Close()
+	}
+	t.closed = true
+	t.err = joinDistinct(t.source.failure(), processErr(t.job), readErr)
+	if t.err != nil {
+		return n, t.err
+	}
+	return n, io.EOF
+}
+
+func (t *transformReader) Close() error {
+	if !t.closed {
+		t.closed = true
+		t.job.Close()
+		if !t.done {
+			// Abandoned early, which is only a failure if the source was
+			t.done = true
+			t.err = t.source.failure()
+		}
+	}
+	return t.err
+}
+
+// Joins the errors which aren't nil, leaving out any already matched by one
+// before it, e.g. a source error also reported by the job's Wait.
+func joinDistinct(errs ...error) error {
+	var kept []error
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		duplicate := false
+		for _, k := range kept {
+			if errors.Is(err, k) || errors.Is(k, err) {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			kept = append(kept, err)
+		}
+	}
+	return errors.Join(kept...)
+}
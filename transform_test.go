@@ -0,0 +1,102 @@
+package extcompress
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Produces incompressible data for ever, so compressors have output early.
+type endlessReader struct{}
+
+func (endlessReader) Read(p []byte) (int, error) {
+	return rand.Read(p)
+}
+
+// Checks the number of goroutines gets back to where it was before. Polled
+// by hand, since assert.Eventually has goroutines of its own.
+func assertNoGoroutineLeak(t *testing.T) func() {
+	before := runtime.NumGoroutine()
+	return func() {
+		deadline := time.Now().Add(5 * time.Second)
+		for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.LessOrEqual(t, runtime.NumGoroutine(), before, "goroutines left running")
+	}
+}
+
+func TestTransformRoundTrip(t *testing.T) {
+	defer assertNoGoroutineLeak(t)()
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	input := seekableTestData(1 << 20)
+
+	compressed, err := Transform(bytes.NewReader(input), h, ModeCompress)
+	assert.Nil(t, err)
+	plain, err := Transform(compressed, h, ModeDecompress)
+	assert.Nil(t, err)
+	out, err := ioutil.ReadAll(plain)
+	assert.Nil(t, err)
+	assert.Equal(t, input, out)
+	assert.Nil(t, plain.Close())
+	assert.Nil(t, compressed.Close())
+
+	_, err = Transform(bytes.NewReader(input), h, Mode(7))
+	assert.Equal(t, ErrInvalidOperation, err)
+}
+
+func TestTransformSourceError(t *testing.T) {
+	defer assertNoGoroutineLeak(t)()
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	srcErr := errSourceBroken
+	rc, err := Transform(io.MultiReader(strings.NewReader(data), failingReader{}), h, ModeCompress)
+	assert.Nil(t, err)
+	_, err = ioutil.ReadAll(rc)
+	assert.True(t, errors.Is(err, srcErr), "%v", err)
+	// Reported once, though the job saw it too
+	assert.Equal(t, 1, strings.Count(err.Error(), srcErr.Error()))
+	closeErr := rc.Close()
+	assert.True(t, errors.Is(closeErr, srcErr))
+}
+
+func TestTransformChildCrash(t *testing.T) {
+	defer assertNoGoroutineLeak(t)()
+
+	// Gives up part way through its input
+	h := NewFilter("sh", CompressFlags("-c", "head -c 100; exit 3"))
+	rc, err := Transform(endlessReader{}, h, ModeCompress)
+	assert.Nil(t, err)
+	out, err := ioutil.ReadAll(rc)
+	assert.Len(t, out, 100)
+	var exitErr ExitStatusError
+	assert.True(t, errors.As(err, &exitErr), "%v", err)
+	assert.Equal(t, 3, exitErr.ExitStatus)
+	assert.Equal(t, err, rc.Close())
+}
+
+func TestTransformAbandoned(t *testing.T) {
+	defer assertNoGoroutineLeak(t)()
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	rc, err := Transform(endlessReader{}, h, ModeCompress)
+	assert.Nil(t, err)
+	buf := make([]byte, 10)
+	_, err = io.ReadFull(rc, buf)
+	assert.Nil(t, err)
+	assert.Nil(t, rc.Close())
+	assert.Nil(t, rc.Close())
+	_, err = rc.Read(buf)
+	assert.Equal(t, io.EOF, err)
+}
@@ -0,0 +1,143 @@
+package extcompress
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Returned when waiting on a job whose output nobody is reading: the process
+// has filled its pipe and can't exit until the output is read or the job is
+// closed.
+var ErrOutputNotConsumed = errors.New("extcompress: job output is not being consumed")
+
+// How often a job's process is checked for having exited while something
+// waits on it.
+var undrainedPollInterval = 10 * time.Millisecond
+
+// How long a job's pipe must stay full, with nothing ever read from it,
+// before the output is taken to be abandoned.
+var undrainedAbandonAfter = time.Second
+
+// How long a job which has exited is left unreaped while output which was
+// being read stays unread.
+var undrainedExitGrace = time.Second
+
+// Waits for the process to exit and returns its exit status. If the job's
+// output has never been read and the process has stayed blocked writing it
+// for a second, returns ErrOutputNotConsumed instead of waiting forever,
+// leaving the job running so it can still be read or closed. Output which
+// was read, but has stopped being read, is waited on for
+// Options.UndrainedStall, or indefinitely if that is unset, and once the
+// process has exited the pipe is only closed after the reader reaches the
+// end or leaves it for a second. Jobs from handlers with
+// Options.DrainUnread discard their remaining output instead.
+func (this *CompressionJob) Wait() (int, error) {
+	if this.isReaped() || atomic.LoadInt32(&this.eof) != 0 {
+		this.getResult()
+		return this.result, nil
+	}
+
+	if this.drainUnread {
+		if _, err := io.Copy(ioutil.Discard, this); err != nil {
+			this.log.WithField("error", err.Error()).Debug("Error discarding unread job output")
+		}
+		this.getResult()
+		return this.result, nil
+	}
+
+	pipe, ok := this.pipe.(*os.File)
+	if b, isBridge := this.pipe.(*fifoBridge); isBridge {
+		pipe, ok = b.file()
+	}
+	if !ok {
+		this.getResult()
+		return this.result, nil
+	}
+
+	// The exit is waited for here, while the pipe is checked on from the
+	// shared monitor loop
+	pid := this.cmd.Process.Pid
+	exited := make(chan error, 1)
+	go func() { exited <- awaitExit(pid) }()
+	stop := make(chan struct{})
+	defer close(stop)
+	verdict := make(chan bool, 1)
+	monitor.watch(this.undrainedCheck(pipe, stop, verdict))
+
+	for {
+		select {
+		case <-this.done:
+			return this.result, nil
+		case err := <-exited:
+			exited = nil
+			if err != nil || atomic.LoadInt64(&this.produced) == 0 || atomic.LoadInt32(&this.eof) != 0 {
+				// Unsupported, already reaped elsewhere, or done: safe to block.
+				this.getResult()
+				return this.result, nil
+			}
+			// Otherwise a reader is partway through the rest of the output
+		case abandoned := <-verdict:
+			if abandoned {
+				return -1, ErrOutputNotConsumed
+			}
+			this.getResult()
+			return this.result, nil
+		}
+	}
+}
+
+// Returns a monitor check which sends true on verdict once the job's output
+// has been left unread for too long, or false once the process has exited
+// and its reader has left the rest of the output for undrainedExitGrace.
+// Only a pipe which stays full with no reads in between or in flight counts,
+// so a slow or late reader isn't mistaken for none.
+func (this *CompressionJob) undrainedCheck(pipe *os.File, stop <-chan struct{}, verdict chan<- bool) monitorCheck {
+	pid := this.cmd.Process.Pid
+	lastProduced, lastExited := int64(-1), false
+	var stalledSince time.Time
+	return func() bool {
+		select {
+		case <-stop:
+			return false
+		default:
+		}
+		if this.isReaped() {
+			return false
+		}
+		exited, err := processExited(pid)
+		if err != nil {
+			return false
+		}
+		produced := atomic.LoadInt64(&this.produced)
+		reading := atomic.LoadInt32(&this.reading) != 0
+
+		var stalled bool
+		var limit time.Duration
+		if exited {
+			// Reaping closes the pipe under any reader
+			stalled, limit = !reading, undrainedExitGrace
+		} else if full, err := pipeFull(pipe); err == nil && full && !reading {
+			stalled, limit = true, undrainedAbandonAfter
+			if produced > 0 {
+				limit = this.undrainedStall
+			}
+		}
+		if !stalled {
+			lastProduced = -1
+			return true
+		}
+		if produced != lastProduced || exited != lastExited {
+			lastProduced, lastExited, stalledSince = produced, exited, time.Now()
+			return true
+		}
+		if limit <= 0 || time.Since(stalledSince) < limit {
+			return true
+		}
+		verdict <- !exited
+		return false
+	}
+}
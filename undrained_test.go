@@ -0,0 +1,154 @@
+package extcompress
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Writes a gzip file which decompresses to far more than a pipe buffer.
+func writeLargeGzip(t *testing.T, dir string) string {
+	filename := path.Join(dir, "large")
+	assert.Nil(t, ioutil.WriteFile(filename, seekableTestData(8*mib), os.FileMode(0644)))
+	assert.Nil(t, exec.Command("gzip", filename).Run())
+	return filename + ".gz"
+}
+
+func TestResultUndrainedJob(t *testing.T) {
+	setMonitorInterval(t, 20*time.Millisecond)
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	filename := writeLargeGzip(t, tmpdir)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	proc, err := h.Decompress(filename)
+	assert.Nil(t, err)
+	job := proc.(*CompressionJob)
+
+	started := time.Now()
+	result := make(chan int, 1)
+	go func() { result <- job.Result() }()
+	select {
+	case r := <-result:
+		assert.Equal(t, -1, r)
+		assert.True(t, time.Since(started) >= undrainedAbandonAfter)
+	case <-time.After(10 * time.Second):
+		job.kill()
+		t.Fatal("Result blocked on an undrained job")
+	}
+
+	_, err = job.Wait()
+	assert.Equal(t, ErrOutputNotConsumed, err)
+
+	// The job is left running, so it can still be read in full
+	out, err := ioutil.ReadAll(job)
+	assert.Nil(t, err)
+	assert.Equal(t, 8*mib, len(out))
+	assert.Equal(t, 0, job.Result())
+}
+
+func TestResultUndrainedJobDiscards(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	filename := writeLargeGzip(t, tmpdir)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	proc, err := h.WithOptions(Options{DrainUnread: Bool(true)}).Decompress(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, proc.Result())
+}
+
+func TestResultSmallUndrainedJob(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	// Output which fits in the pipe buffer lets the process exit unread
+	proc, err := h.Compress(path.Join(tmpdir, "pipechaining"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, proc.Result())
+}
+
+func TestResultSlowReader(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	filename := writeLargeGzip(t, tmpdir)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	proc, err := h.Decompress(filename)
+	assert.Nil(t, err)
+	_, err = io.ReadFull(proc, make([]byte, 100))
+	assert.Nil(t, err)
+
+	// A reader which pauses isn't taken for one which has gone away
+	result := make(chan int, 1)
+	go func() { result <- proc.Result() }()
+	select {
+	case r := <-result:
+		t.Fatalf("Result returned %d while the output was still being read", r)
+	case <-time.After(500 * time.Millisecond):
+	}
+	out, err := ioutil.ReadAll(proc)
+	assert.Nil(t, err)
+	assert.Equal(t, 8*mib-100, len(out))
+	assert.Equal(t, 0, <-result)
+}
+
+func TestResultLateReader(t *testing.T) {
+	setMonitorInterval(t, 20*time.Millisecond)
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	filename := writeLargeGzip(t, tmpdir)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	// A reader which only gets going after Result is called still counts
+	proc, err := h.Decompress(filename)
+	assert.Nil(t, err)
+	read := make(chan int, 1)
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		n, _ := io.Copy(ioutil.Discard, proc)
+		read <- int(n)
+	}()
+	assert.Equal(t, 0, proc.Result())
+	assert.Equal(t, 8*mib, <-read)
+	assert.Eventually(t, func() bool { return monitor.watching() == 0 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestResultStalledReader(t *testing.T) {
+	setMonitorInterval(t, 20*time.Millisecond)
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+	filename := writeLargeGzip(t, tmpdir)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+
+	proc, err := h.WithOptions(Options{UndrainedStall: 200 * time.Millisecond}).Decompress(filename)
+	assert.Nil(t, err)
+	job := proc.(*CompressionJob)
+	_, err = io.ReadFull(job, make([]byte, 100))
+	assert.Nil(t, err)
+
+	started := time.Now()
+	_, err = job.Wait()
+	assert.Equal(t, ErrOutputNotConsumed, err)
+	assert.True(t, time.Since(started) >= 200*time.Millisecond)
+	assert.Nil(t, job.Close())
+}
@@ -0,0 +1,32 @@
+package extcompress
+
+import (
+	"context"
+)
+
+// Like GetFileTypeExternalHandler, but returns the identity handler rather
+// than UnknownFileType for a type no handler is registered for, so callers
+// can pass such files through unchanged. The flag is true only if a real
+// handler matched. The identity handler's MimeType still reports the type
+// detected. Only failures to detect the type at all, like an unreadable
+// file, are returned as errors.
+func GetFileTypeExternalHandlerOrIdentity(filePath string) (ExternalHandler, bool, error) {
+	mimetype, detector, err := detectFileType(context.Background(), filePath)
+	if err != nil {
+		return nil, false, err
+	}
+	if h, err := GetExternalHandlerFromMimeType(mimetype); err == nil {
+		f := h.(Filter)
+		f.prov.Detector = detector
+		return f, true, nil
+	}
+
+	registryMtx.RLock()
+	f := filtersMap["cat"]
+	registryMtx.RUnlock()
+	f.mimeType = mimetype
+	f.stampLookup("cat", mimetype, MatchNone, SelectedExplicit)
+	f.prov.Detector = detector
+	f.applyRegisteredOptions("cat")
+	return f, false, nil
+}
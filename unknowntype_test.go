@@ -0,0 +1,50 @@
+package extcompress
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetFileTypeExternalHandlerOrIdentity(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	// An archive format there's no handler for passes through
+	rar := path.Join(tmpdir, "archive.rar")
+	assert.Nil(t, ioutil.WriteFile(rar, append([]byte("Rar!\x1a\x07\x00"), make([]byte, 100)...), os.FileMode(0644)))
+	_, err := GetFileTypeExternalHandler(rar)
+	assert.IsType(t, UnknownFileType{}, err)
+	h, matched, err := GetFileTypeExternalHandlerOrIdentity(rar)
+	assert.Nil(t, err)
+	assert.False(t, matched)
+	assert.Equal(t, "cat", h.(Filter).Command)
+	assert.True(t, h.(Filter).Passthrough)
+	assert.Equal(t, "application/x-rar", h.MimeType())
+	assert.Equal(t, "application/x-rar", h.Provenance().MimeType)
+	assert.Equal(t, "cat", h.Provenance().Handler)
+
+	job, err := h.Decompress(rar)
+	assert.Nil(t, err)
+	out, err := ioutil.ReadAll(job)
+	assert.Nil(t, err)
+	assert.Zero(t, job.Result())
+	assert.Equal(t, 107, len(out))
+
+	// Known types are matched as usual
+	compressed := writeCompressed(t, tmpdir, "gzip", []byte(data))
+	h, matched, err = GetFileTypeExternalHandlerOrIdentity(compressed)
+	assert.Nil(t, err)
+	assert.True(t, matched)
+	assert.Equal(t, "gzip", h.(Filter).Command)
+	assert.Equal(t, "application/gzip", h.MimeType())
+
+	// Detection failures are still errors
+	h, matched, err = GetFileTypeExternalHandlerOrIdentity(path.Join(tmpdir, "missing.rar"))
+	assert.NotNil(t, err)
+	assert.Nil(t, h)
+	assert.False(t, matched)
+}
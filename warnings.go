@@ -0,0 +1,195 @@
+package extcompress
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Matched by the error from an operation whose tool succeeded with
+// warnings, when Options.TreatWarningsAsErrors is set. Otherwise warnings
+// are only reported, by CompressionJob.Warnings and FileOpResult.Warnings.
+var ErrWarnings = errors.New("extcompress: tool reported warnings")
+
+// Describes a run which warnings made fail. Wraps ErrWarnings.
+type WarningsError struct {
+	Command  string
+	Warnings []string
+	JobID    string
+}
+
+func (r WarningsError) Error() string {
+	return fmt.Sprintf("%s: %s: %s (job %s)", r.Command, ErrWarnings.Error(), strings.Join(r.Warnings, "; "), r.JobID)
+}
+
+func (r WarningsError) Unwrap() error {
+	return ErrWarnings
+}
+
+// Sets the exit statuses and stderr messages which mean the tool succeeded
+// with warnings.
+func Warnings(statuses []int, messages ...string) FilterOption {
+	return func(f *Filter) {
+		f.WarningExitStatuses = statuses
+		f.WarningMessages = messages
+	}
+}
+
+// Longest stderr line kept for matching against warning messages
+const maxWarningLine = 4096
+
+// Collects the warnings of a tool run: stderr lines containing one of the
+// handler's WarningMessages, and exit statuses in its WarningExitStatuses.
+// Warnings are passed on to parent as well, which collects them for a
+// whole operation.
+type warningLog struct {
+	messages []string
+	statuses []int
+	strict   bool
+	parent   *warningLog
+
+	mtx      sync.Mutex
+	partial  []byte
+	lastLine string
+	warnings []string
+}
+
+// Returns a log for a run of the handler's tool, feeding any the handler is
+// already collecting warnings in.
+func (c Filter) newWarningLog() *warningLog {
+	return &warningLog{
+		messages: c.WarningMessages,
+		statuses: c.WarningExitStatuses,
+		strict:   enabled(c.opts.TreatWarningsAsErrors),
+		parent:   c.warningCapture,
+	}
+}
+
+func (w *warningLog) Write(p []byte) (int, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.line(string(w.partial[:i]))
+		w.partial = w.partial[i+1:]
+	}
+	if len(w.partial) > maxWarningLine {
+		w.partial = w.partial[:maxWarningLine]
+	}
+	return len(p), nil
+}
+
+// Takes a complete line of stderr. Called with mtx held.
+func (w *warningLog) line(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	w.lastLine = line
+	lower := strings.ToLower(line)
+	for _, msg := range w.messages {
+		if strings.Contains(lower, strings.ToLower(msg)) {
+			w.addLocked(line)
+			return
+		}
+	}
+}
+
+func (w *warningLog) add(warning string) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.addLocked(warning)
+}
+
+func (w *warningLog) addLocked(warning string) {
+	w.warnings = append(w.warnings, warning)
+	if w.parent != nil {
+		w.parent.add(warning)
+	}
+}
+
+// Returns the warnings collected so far.
+func (w *warningLog) list() []string {
+	if w == nil {
+		return nil
+	}
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return append([]string(nil), w.warnings...)
+}
+
+// Classifies how a run ended, once its stderr is complete. A warning exit
+// status is recorded as a warning and, unless warnings are errors, turned
+// into success. Returns the status and error the run should be taken to
+// have ended with.
+func (w *warningLog) exited(status int, err error) (int, error) {
+	if w == nil {
+		return status, err
+	}
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if len(w.partial) > 0 {
+		w.line(string(w.partial))
+		w.partial = nil
+	}
+	if err == nil || !w.warningStatus(status) {
+		return status, err
+	}
+	if len(w.warnings) == 0 {
+		// Nothing said why, beyond the tool's last words
+		warning := fmt.Sprintf("exit status %d", status)
+		if w.lastLine != "" {
+			warning += ": " + w.lastLine
+		}
+		w.addLocked(warning)
+	}
+	if w.strict {
+		return status, err
+	}
+	return 0, nil
+}
+
+func (w *warningLog) warningStatus(status int) bool {
+	for _, s := range w.statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns a WarningsError for a run which otherwise succeeded if warnings
+// are errors and it gave any, and nil otherwise.
+func (w *warningLog) failure(command string, id string) error {
+	warnings := w.list()
+	if len(warnings) == 0 || !w.strict {
+		return nil
+	}
+	return WarningsError{command, warnings, id}
+}
+
+// Returns the warnings the tool gave, from its stderr and exit status (see
+// Filter.WarningMessages), once the job has finished. The job still
+// succeeds with warnings unless Options.TreatWarningsAsErrors is set.
+func (this *CompressionJob) Warnings() []string {
+	if !this.isReaped() {
+		return nil
+	}
+	return this.warnings.list()
+}
+
+// Returns a WarningsError if proc exited successfully but gave warnings
+// which are to be treated as errors, for callers which check its Result.
+func warningsFailure(proc CompressionProcess) error {
+	job, ok := proc.(*CompressionJob)
+	if !ok {
+		return nil
+	}
+	return job.warnings.failure(job.command, job.id)
+}
@@ -0,0 +1,177 @@
+package extcompress
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A tool which copies its input, warning on stderr and exiting with status.
+func warningFilter(status string) ExternalHandler {
+	script := "cat; echo 'tool: warning: something odd' >&2; exit " + status
+	return NewFilter("sh", CompressFlags("-c", script), Warnings([]int{3}, "warning:"))
+}
+
+func TestWarningsExitStatus(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	h, err := GetExternalHandlerFromMimeType("application/gzip")
+	assert.Nil(t, err)
+	garbage := append(compressBytes(t, h, []byte(data)), "trailing"...)
+
+	// gzip's exit status 2 for trailing garbage still decompresses
+	job, err := h.DecompressStream(bytes.NewReader(garbage))
+	assert.Nil(t, err)
+	out, err := ioutil.ReadAll(job)
+	assert.Nil(t, err)
+	assert.Equal(t, data, string(out))
+	assert.Zero(t, job.Result())
+	assert.Nil(t, job.(*CompressionJob).Err())
+	warnings := job.(*CompressionJob).Warnings()
+	assert.Len(t, warnings, 1)
+	assert.Contains(t, warnings[0], "trailing garbage ignored")
+
+	// Unless warnings are errors
+	job, err = h.WithOptions(Options{TreatWarningsAsErrors: Bool(true)}).DecompressStream(bytes.NewReader(garbage))
+	assert.Nil(t, err)
+	ioutil.ReadAll(job)
+	assert.Equal(t, 2, job.Result())
+	assert.Len(t, job.(*CompressionJob).Warnings(), 1)
+
+	// A status with nothing recognised on stderr is a warning itself
+	job, err = NewFilter("sh", CompressFlags("-c", "cat; echo odd >&2; exit 3"), Warnings([]int{3})).
+		CompressStream(bytes.NewReader([]byte(data)))
+	assert.Nil(t, err)
+	ioutil.ReadAll(job)
+	assert.Zero(t, job.Result())
+	assert.Equal(t, []string{"exit status 3: odd"}, job.(*CompressionJob).Warnings())
+
+	// Other statuses still fail
+	job, err = warningFilter("1").CompressStream(bytes.NewReader([]byte(data)))
+	assert.Nil(t, err)
+	ioutil.ReadAll(job)
+	assert.Equal(t, 1, job.Result())
+}
+
+func TestWarningsStderr(t *testing.T) {
+	job, err := warningFilter("0").CompressStream(bytes.NewReader([]byte(data)))
+	assert.Nil(t, err)
+	out, err := ioutil.ReadAll(job)
+	assert.Nil(t, err)
+	assert.Equal(t, data, string(out))
+	assert.Zero(t, job.Result())
+	assert.Equal(t, []string{"tool: warning: something odd"}, job.(*CompressionJob).Warnings())
+
+	// Exiting 0 with warnings only fails in strict mode
+	h := warningFilter("0").WithOptions(Options{TreatWarningsAsErrors: Bool(true)})
+	job, err = h.CompressStream(bytes.NewReader([]byte(data)))
+	assert.Nil(t, err)
+	ioutil.ReadAll(job)
+	assert.Zero(t, job.Result())
+	err = job.(*CompressionJob).Err()
+	assert.True(t, errors.Is(err, ErrWarnings))
+	var warnErr WarningsError
+	assert.True(t, errors.As(err, &warnErr))
+	assert.Equal(t, []string{"tool: warning: something odd"}, warnErr.Warnings)
+}
+
+func TestWarningsFileResult(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	script := `cat "$1" > "$1.w" && rm "$1"; echo 'tool: warning: something odd' >&2; exit 3`
+	h := NewFilter("sh", InPlaceFlags([]string{"-c", script, "sh"}, nil), Suffix(".w", ""),
+		Warnings([]int{3}, "warning:"))
+	filename := path.Join(tmpdir, "input.log")
+	assert.Nil(t, ioutil.WriteFile(filename, []byte(data), os.FileMode(0644)))
+
+	res, err := h.CompressFileInPlaceResult(filename, InPlaceOptions{})
+	assert.Nil(t, err)
+	assert.Nil(t, res.Err)
+	assert.Equal(t, filename+".w", res.ResultPath)
+	assert.Equal(t, []string{"tool: warning: something odd"}, res.Warnings)
+
+	// Strict mode fails the file on the tool's exit status
+	assert.Nil(t, ioutil.WriteFile(filename, []byte(data), os.FileMode(0644)))
+	os.Remove(filename + ".w")
+	strict := h.WithOptions(Options{TreatWarningsAsErrors: Bool(true)})
+	res, err = strict.CompressFileInPlaceResult(filename, InPlaceOptions{})
+	assert.NotNil(t, err)
+	assert.Equal(t, 3, res.ExitCode)
+	assert.Equal(t, []string{"tool: warning: something odd"}, res.Warnings)
+
+	// And for warnings from a run which exited 0
+	assert.Nil(t, ioutil.WriteFile(filename, []byte(data), os.FileMode(0644)))
+	os.Remove(filename + ".w")
+	script = `cat "$1" > "$1.w" && rm "$1"; echo 'tool: warning: something odd' >&2`
+	strict = NewFilter("sh", InPlaceFlags([]string{"-c", script, "sh"}, nil), Suffix(".w", ""),
+		Warnings(nil, "warning:")).WithOptions(Options{TreatWarningsAsErrors: Bool(true)})
+	res, err = strict.CompressFileInPlaceResult(filename, InPlaceOptions{})
+	assert.True(t, errors.Is(err, ErrWarnings))
+	assert.Equal(t, "warnings", ErrorClass(res.Err))
+}
+
+func TestWarningsBatch(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	// A tool warning about one of the files it was given
+	script := `for f; do cat "$f" > "$f.w" && rm "$f"; done; echo "warning: $1 is odd" >&2`
+	h := NewFilter("sh", InPlaceFlags([]string{"-c", script, "sh"}, nil), Suffix(".w", ""),
+		Warnings(nil, "warning:"))
+	first := path.Join(tmpdir, "first.log")
+	second := path.Join(tmpdir, "second.log")
+	for _, p := range []string{first, second} {
+		assert.Nil(t, ioutil.WriteFile(p, []byte(data), os.FileMode(0644)))
+	}
+
+	results, summary, err := h.CompressFilesInPlaceSummary([]string{first, second}, InPlaceOptions{})
+	assert.Nil(t, err)
+	assert.Nil(t, results[0].Err)
+	assert.Equal(t, []string{"warning: " + first + " is odd"}, results[0].Warnings)
+	assert.Nil(t, results[1].Err)
+	assert.Empty(t, results[1].Warnings)
+	assert.Equal(t, 2, summary.Succeeded)
+	assert.Equal(t, []string{first + ": warning: " + first + " is odd"}, summary.Warnings)
+	out, err := json.Marshal(summary)
+	assert.Nil(t, err)
+	assert.Contains(t, string(out), `"warnings":["`+first+`: warning: `+first+` is odd"]`)
+
+	// Strict mode fails only the file warned about
+	for _, p := range []string{first, second} {
+		os.Remove(p + ".w")
+		assert.Nil(t, ioutil.WriteFile(p, []byte(data), os.FileMode(0644)))
+	}
+	results, err = h.WithOptions(Options{TreatWarningsAsErrors: Bool(true)}).
+		CompressFilesInPlaceResult([]string{first, second}, InPlaceOptions{})
+	assert.Nil(t, err)
+	assert.True(t, errors.Is(results[0].Err, ErrWarnings))
+	assert.Nil(t, results[1].Err)
+}
+
+func TestWarningsIntoFile(t *testing.T) {
+	tmpdir := setupTestDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	dest := path.Join(tmpdir, "out.w")
+	h := warningFilter("0").WithOptions(Options{TreatWarningsAsErrors: Bool(true)})
+	w, err := h.CompressIntoFile(dest)
+	assert.Nil(t, err)
+	w.Write([]byte(data))
+	assert.True(t, errors.Is(w.Close(), ErrWarnings))
+
+	w, err = warningFilter("0").CompressIntoFile(dest)
+	assert.Nil(t, err)
+	w.Write([]byte(data))
+	assert.Nil(t, w.Close())
+	out, err := ioutil.ReadFile(dest)
+	assert.Nil(t, err)
+	assert.Equal(t, data, string(out))
+}